@@ -22,6 +22,7 @@ type allocator struct {
 	follow int
 	low    int
 	high   int
+	limit  int // high grows up to limit once all numbers are allocated
 }
 
 // NewAllocator reserves and frees integers out of a range between low and
@@ -35,9 +36,18 @@ func newAllocator(low, high int) *allocator {
 		follow: low,
 		low:    low,
 		high:   high,
+		limit:  high,
 	}
 }
 
+// newGrowableAllocator reserves and frees integers like newAllocator, doubling
+// the range between low and high up to limit when no number is available.
+func newGrowableAllocator(low, high, limit int) *allocator {
+	a := newAllocator(low, high)
+	a.limit = limit
+	return a
+}
+
 // String returns a string describing the contents of the allocator like
 // "allocator[low..high] reserved..until"
 //
@@ -95,6 +105,17 @@ func (a *allocator) next() (int, bool) {
 		}
 	}
 
+	// Grow the range
+	if a.high < a.limit {
+		a.follow = a.high + 1
+		a.high = a.low + 2*(a.high-a.low+1) - 1
+		if a.high > a.limit {
+			a.high = a.limit
+		}
+		a.reserve(a.follow)
+		return a.follow, true
+	}
+
 	return 0, false
 }
 
@@ -35,6 +35,24 @@ func TestAllocatorShouldBeBoundByHigh(t *testing.T) {
 	}
 }
 
+func TestGrowableAllocatorShouldGrowUpToLimit(t *testing.T) {
+	a := newGrowableAllocator(1, 2, 5)
+
+	for want := 1; want <= 5; want++ {
+		if n, ok := a.next(); n != want || !ok {
+			t.Fatalf("expected to allocate %d, got %d, %v", want, n, ok)
+		}
+	}
+	if _, ok := a.next(); ok {
+		t.Fatalf("expected not to allocate beyond the limit of 5")
+	}
+
+	a.release(2)
+	if n, ok := a.next(); n != 2 || !ok {
+		t.Fatalf("expected to reuse the released 2, got %d, %v", n, ok)
+	}
+}
+
 func TestAllocatorStringShouldIncludeAllocatedRanges(t *testing.T) {
 	a := newAllocator(1, 10)
 	a.reserve(1)
@@ -0,0 +1,353 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPublishNacked is the error of a PublishResult or
+	// DeferredConfirmation negatively acknowledged by the server on its last
+	// attempt.
+	ErrPublishNacked = errors.New("publishing negatively acknowledged by the server")
+
+	// ErrPublishReturned is the error of a mandatory PublishResult returned
+	// as unroutable by the server on its last attempt.
+	ErrPublishReturned = errors.New("publishing returned by the server")
+
+	// ErrPublisherClosed is returned by Publisher.Publish once the Publisher
+	// is closed.
+	ErrPublisherClosed = errors.New("publisher closed")
+)
+
+// PublisherSeqHeader is the header a Publisher stamps on mandatory
+// publishings to match the messages returned by the server.
+const PublisherSeqHeader = "x-publisher-seq"
+
+// PublisherOptions configures a Publisher.
+type PublisherOptions struct {
+	// QueueSize is the number of messages waiting to be published above
+	// which Publish blocks, 256 when not greater than 0.
+	QueueSize int
+
+	// MaxAttempts is how many times a message nacked or returned by the
+	// server is published before its PublishResult fails, 1 when not greater
+	// than 0.
+	MaxAttempts int
+
+	// Backoff returns how long to wait before the given attempt, from 2, of
+	// a message.  Messages are retried right away when nil.
+	Backoff func(attempt int) time.Duration
+
+	// OnComplete, when not nil, is called with every PublishResult once it
+	// is done, from a goroutine of the Publisher.
+	OnComplete func(r *PublishResult)
+}
+
+/*
+PublishResult is the outcome of a message published with Publisher.Publish, a
+future completed once the server confirmed the message or the Publisher gave
+up on it.
+*/
+type PublishResult struct {
+	Exchange  string
+	Key       string
+	Mandatory bool
+	Msg       Publishing
+
+	// Attempts is the number of times the message was published, and Return
+	// is set when the last attempt was returned as unroutable.  Only read
+	// them once Done is closed.
+	Attempts int
+	Return   *Return
+
+	seq  int64
+	done chan struct{}
+	err  error
+}
+
+// Done is closed once the result is known.
+func (r *PublishResult) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns nil when the message was confirmed by the server, or why it was
+// not, such as ErrPublishNacked or ErrPublishReturned.  It is nil until Done
+// is closed.
+func (r *PublishResult) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// Wait waits until the result is known and returns Err, or until ctx is done
+// and returns context.Cause(ctx).
+func (r *PublishResult) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		return r.err
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+/*
+Publisher publishes messages asynchronously on a channel in confirm mode, and
+publishes again the messages negatively acknowledged or, when mandatory,
+returned by the server, up to PublisherOptions.MaxAttempts:
+
+	p, err := amqp.NewPublisher(ch, amqp.PublisherOptions{MaxAttempts: 3})
+
+	res, err := p.Publish(ctx, "orders", "eu.created", true, msg)
+
+	if err := res.Wait(ctx); err != nil {
+		log.Printf("order lost: %v", err)
+	}
+
+Messages are published in the order Publish is called, but retried messages
+are published again after the messages queued in the meantime.  The channel is
+owned by the Publisher: nothing else must publish on it, nor listen to its
+confirmations and returns.  When the channel closes, the messages not
+confirmed yet fail with the reason.
+*/
+type Publisher struct {
+	ch   *Channel
+	opts PublisherOptions
+
+	queue chan *PublishResult
+	stop  chan struct{}
+
+	m          sync.Mutex
+	closed     bool
+	broken     error // the channel closed, see events
+	seq        int64
+	inflight   map[*PublishResult]struct{} // published and not confirmed
+	returnable map[int64]*PublishResult    // mandatory and in flight, by seq
+	pending    sync.WaitGroup              // results not done
+}
+
+// NewPublisher puts ch in confirm mode and returns a Publisher publishing on
+// it.
+func NewPublisher(ch *Channel, opts PublisherOptions) (*Publisher, error) {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 256
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 1
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+
+	p := &Publisher{
+		ch:         ch,
+		opts:       opts,
+		queue:      make(chan *PublishResult, opts.QueueSize),
+		stop:       make(chan struct{}),
+		inflight:   make(map[*PublishResult]struct{}),
+		returnable: make(map[int64]*PublishResult),
+	}
+
+	go p.events(ch.Notifications(make(chan Notification, opts.QueueSize)))
+	go p.publishing()
+
+	return p, nil
+}
+
+// Publish queues msg to be published to exchange with key, and returns the
+// PublishResult of the message.  It blocks while the queue is full, until ctx
+// is done.
+func (p *Publisher) Publish(ctx context.Context, exchange, key string, mandatory bool, msg Publishing) (*PublishResult, error) {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil, ErrPublisherClosed
+	}
+	p.seq++
+	r := &PublishResult{
+		Exchange:  exchange,
+		Key:       key,
+		Mandatory: mandatory,
+		Msg:       msg,
+		seq:       p.seq,
+		done:      make(chan struct{}),
+	}
+	p.pending.Add(1)
+	p.m.Unlock()
+
+	if mandatory {
+		headers := make(Table, len(msg.Headers)+1)
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		headers[PublisherSeqHeader] = r.seq
+		r.Msg.Headers = headers
+	}
+
+	select {
+	case p.queue <- r:
+		return r, nil
+	case <-ctx.Done():
+		p.pending.Done()
+		return nil, context.Cause(ctx)
+	}
+}
+
+// Close stops accepting messages and waits until the results of the messages
+// published before are known, or until ctx is done.  The channel is left
+// open.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.m.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.pending.Wait()
+		close(p.stop)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// publishing publishes the queued messages until the Publisher is closed.
+func (p *Publisher) publishing() {
+	for {
+		select {
+		case r := <-p.queue:
+			p.publish(r)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *Publisher) publish(r *PublishResult) {
+	p.m.Lock()
+	if p.broken != nil {
+		p.m.Unlock()
+		p.complete(r, p.broken)
+		return
+	}
+	r.Attempts++
+	r.Return = nil
+	p.inflight[r] = struct{}{}
+	if r.Mandatory {
+		p.returnable[r.seq] = r
+	}
+	p.m.Unlock()
+
+	ctx := WithConfirmData(context.Background(), r)
+	if _, err := p.ch.PublishWithDeferredConfirmWithContext(ctx, r.Exchange, r.Key, r.Mandatory, false, r.Msg); err != nil {
+		p.m.Lock()
+		_, found := p.inflight[r] // or already failed by events
+		delete(p.inflight, r)
+		delete(p.returnable, r.seq)
+		p.m.Unlock()
+
+		if found {
+			p.complete(r, err)
+		}
+	}
+}
+
+// events settles the messages with the returns and confirmations of the
+// channel, received in the order the server sent them: the return of a
+// message comes before its confirmation.
+func (p *Publisher) events(events chan Notification) {
+	for n := range events {
+		switch n := n.(type) {
+		case Return:
+			seq, _ := n.Headers[PublisherSeqHeader].(int64)
+			p.m.Lock()
+			if r, found := p.returnable[seq]; found {
+				ret := n
+				r.Return = &ret
+			}
+			p.m.Unlock()
+
+		case Confirmation:
+			r, ok := n.Data.(*PublishResult)
+			if !ok {
+				continue
+			}
+
+			p.m.Lock()
+			delete(p.inflight, r)
+			delete(p.returnable, r.seq)
+			p.m.Unlock()
+
+			switch {
+			case !n.Ack:
+				p.retry(r, ErrPublishNacked)
+			case r.Return != nil:
+				p.retry(r, ErrPublishReturned)
+			default:
+				p.complete(r, nil)
+			}
+		}
+	}
+
+	// The channel is closed, fail what is in flight and what comes next.
+	p.m.Lock()
+	p.broken = ErrClosed
+	inflight := p.inflight
+	p.inflight = make(map[*PublishResult]struct{})
+	p.m.Unlock()
+
+	for r := range inflight {
+		p.complete(r, p.broken)
+	}
+}
+
+// retry publishes r again after its backoff, or fails it with err once it was
+// attempted MaxAttempts times.
+func (p *Publisher) retry(r *PublishResult, err error) {
+	if r.Attempts >= p.opts.MaxAttempts {
+		p.complete(r, err)
+		return
+	}
+
+	var backoff time.Duration
+	if p.opts.Backoff != nil {
+		backoff = p.opts.Backoff(r.Attempts + 1)
+	}
+
+	// Queued from another goroutine, as the events must keep being received.
+	go func() {
+		if backoff > 0 {
+			timer := p.ch.connection.clock().NewTimer(backoff)
+			<-timer.C()
+		}
+		p.queue <- r
+	}()
+}
+
+func (p *Publisher) complete(r *PublishResult, err error) {
+	r.err = err
+	close(r.done)
+	if p.opts.OnComplete != nil {
+		p.opts.OnComplete(r)
+	}
+	p.pending.Done()
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPublisherRetriesReturnedAndNacked(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		// Returned on the first attempt, confirmed on the second.
+		pub := srv.recv(1, &basicPublish{}).(*basicPublish)
+		srv.send(1, &basicReturn{ReplyCode: NoRoute, Exchange: pub.Exchange, RoutingKey: pub.RoutingKey, Properties: pub.Properties})
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 2})
+
+		// Nacked on every attempt.
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 3})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 4})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	var attempts []int
+	completed := make(chan *PublishResult, 2)
+	p, err := NewPublisher(ch, PublisherOptions{
+		MaxAttempts: 2,
+		Backoff: func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return time.Millisecond
+		},
+		OnComplete: func(r *PublishResult) { completed <- r },
+	})
+	if err != nil {
+		t.Fatalf("could not create publisher: %v", err)
+	}
+
+	ctx := context.Background()
+
+	returned, err := p.Publish(ctx, "orders", "unroutable", true, Publishing{Headers: Table{"k": "v"}, Body: []byte("a")})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if err := returned.Wait(ctx); err != nil || returned.Attempts != 2 {
+		t.Errorf("expected the returned message to be confirmed on the second attempt, got %v after %d", err, returned.Attempts)
+	}
+
+	nacked, err := p.Publish(ctx, "orders", "key", false, Publishing{Body: []byte("b")})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if err := nacked.Wait(ctx); !errors.Is(err, ErrPublishNacked) || nacked.Attempts != 2 {
+		t.Errorf("expected the nacked message to fail with ErrPublishNacked after 2 attempts, got %v after %d", err, nacked.Attempts)
+	}
+
+	if err := p.Close(ctx); err != nil {
+		t.Fatalf("could not close the publisher: %v", err)
+	}
+	if _, err := p.Publish(ctx, "orders", "key", false, Publishing{}); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("expected ErrPublisherClosed after Close, got %v", err)
+	}
+
+	if want, got := []int{2, 2}, attempts; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected backoffs before attempts %v, got %v", want, got)
+	}
+	for _, want := range []*PublishResult{returned, nacked} {
+		if got := <-completed; want != got {
+			t.Errorf("expected %+v to complete, got %+v", want, got)
+		}
+	}
+	if want, got := "v", returned.Msg.Headers["k"]; want != got {
+		t.Errorf("expected the headers of the message to be kept, got %v", returned.Msg.Headers)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestPublisherFailsInFlightWhenChannelCloses(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "gone"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	p, err := NewPublisher(ch, PublisherOptions{})
+	if err != nil {
+		t.Fatalf("could not create publisher: %v", err)
+	}
+
+	r, err := p.Publish(context.Background(), "", "q", false, Publishing{})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var amqpErr *Error
+	if err := r.Wait(ctx); !errors.As(err, &amqpErr) {
+		t.Errorf("expected the channel close to fail the message, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Batch is a group of deliveries received from Channel.ConsumeBatches, in the
+// order they were delivered.
+type Batch []Delivery
+
+// Ack acknowledges every delivery of the batch with a single basic.ack of the
+// last delivery tag, see Delivery.AckMultipleThrough.
+func (b Batch) Ack() error {
+	if len(b) == 0 {
+		return nil
+	}
+	return b[len(b)-1].AckMultipleThrough()
+}
+
+// Nack negatively acknowledges every delivery of the batch with a single
+// basic.nack of the last delivery tag.
+func (b Batch) Nack(requeue bool) error {
+	if len(b) == 0 {
+		return nil
+	}
+	return b[len(b)-1].Nack(true, requeue)
+}
+
+/*
+ConsumeBatches consumes queue like ConsumeWithContext, without autoAck, and
+groups the deliveries in batches of up to maxSize deliveries, for consumers
+writing to databases or object storage in bulk.  A batch is sent once it holds
+maxSize deliveries, or maxWait after its first delivery when maxWait is greater
+than 0.  The remaining deliveries are sent as a last batch before the chan is
+closed, when ctx is done or the consumer stops.
+
+	batches, err := ch.ConsumeBatches(ctx, "events", 500, time.Second)
+	for batch := range batches {
+		if err := insert(batch); err != nil {
+			batch.Nack(true)
+			continue
+		}
+		batch.Ack()
+	}
+
+Batch.Ack and Batch.Nack settle every unacknowledged delivery of the channel up
+to the last one of the batch, so the batches must be settled in order and the
+channel must not be shared with other consumers.  Set a Channel.Qos prefetch
+count of at least maxSize, or the server stops delivering before a batch is
+full and batches are only sent after maxWait.
+*/
+func (ch *Channel) ConsumeBatches(ctx context.Context, queue string, maxSize int, maxWait time.Duration, opts ...ConsumeOption) (<-chan Batch, error) {
+	if maxSize < 1 {
+		return nil, fmt.Errorf("invalid batch size %d, must be at least 1", maxSize)
+	}
+
+	deliveries, err := ch.ConsumeWithOptions(ctx, queue, "", false, false, false, false, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	batches := make(chan Batch)
+	go ch.batch(deliveries, batches, maxSize, maxWait)
+
+	return batches, nil
+}
+
+func (ch *Channel) batch(deliveries <-chan Delivery, batches chan<- Batch, maxSize int, maxWait time.Duration) {
+	defer close(batches)
+
+	var batch Batch
+	var timer Timer
+	var expired <-chan time.Time
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, expired = nil, nil
+		}
+		batches <- batch
+		batch = nil
+	}
+
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				if len(batch) > 0 {
+					flush()
+				}
+				return
+			}
+
+			batch = append(batch, d)
+			if len(batch) == 1 && maxWait > 0 {
+				timer = ch.connection.clock().NewTimer(maxWait)
+				expired = timer.C()
+			}
+			if len(batch) >= maxSize {
+				flush()
+			}
+
+		case <-expired:
+			timer, expired = nil, nil
+			flush()
+		}
+	}
+}
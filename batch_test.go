@@ -0,0 +1,91 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConsumeBatchesBySizeAndTime(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	acks := make(chan *basicAck, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		consume := srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: tag})
+		}
+
+		acks <- srv.recv(1, &basicAck{}).(*basicAck)
+		acks <- srv.recv(1, &basicAck{}).(*basicAck)
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: consume.ConsumerTag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	if _, err := ch.ConsumeBatches(ctx, "q", 0, time.Second); err == nil {
+		t.Error("expected an error for a batch size of 0")
+	}
+
+	batches, err := ch.ConsumeBatches(ctx, "q", 2, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	full := <-batches
+	if want, got := 2, len(full); want != got {
+		t.Fatalf("expected a full batch of %d deliveries, got %d", want, got)
+	}
+	if err := full.Ack(); err != nil {
+		t.Fatalf("ack error: %v", err)
+	}
+
+	partial := <-batches
+	if want, got := 1, len(partial); want != got {
+		t.Fatalf("expected a batch of %d delivery after the wait, got %d", want, got)
+	}
+	if err := partial.Ack(); err != nil {
+		t.Fatalf("ack error: %v", err)
+	}
+
+	for _, want := range []uint64{2, 3} {
+		if ack := <-acks; ack.DeliveryTag != want || !ack.Multiple {
+			t.Errorf("expected a multiple ack of %d, got %+v", want, ack)
+		}
+	}
+
+	cancel()
+	if _, ok := <-batches; ok {
+		t.Error("expected the batches chan to be closed once cancelled")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+)
+
+/*
+BoundPublisher publishes bodies to a single exchange and routing key with the
+same properties, for high rate publishers to a single destination.  The
+properties are encoded once by Channel.BoundPublisher instead of for every
+publishing:
+
+	events, err := ch.BoundPublisher("events", "order.created", amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+	})
+
+	err = events.Send(ctx, body)
+
+Publishings go through the middleware of the channel like any other, in which
+case their properties are encoded again as the middleware may change them, as
+they are when Config.StampPublishedAt or a Stamping stamps them.  A
+BoundPublisher is safe for concurrent use.
+*/
+type BoundPublisher struct {
+	ch       *Channel
+	exchange string
+	key      string
+	defaults Publishing
+	encoded  []byte // properties of defaults
+}
+
+// encodedPublish is a basic.publish whose content header properties are
+// already encoded, see BoundPublisher.
+type encodedPublish struct {
+	*basicPublish
+	properties []byte
+}
+
+// BoundPublisher returns a BoundPublisher publishing to exchange with
+// routingKey and the properties of defaults, whose Body is ignored.  It
+// returns an error when the headers of defaults are invalid, or their
+// EncodedHeaders.
+func (ch *Channel) BoundPublisher(exchange, routingKey string, defaults Publishing) (*BoundPublisher, error) {
+	if err := defaults.Headers.Validate(); err != nil {
+		return nil, err
+	}
+	if err := defaults.EncodedHeaders.Err(); err != nil {
+		return nil, err
+	}
+
+	// Copied, so that changes to the headers after the call do not differ
+	// from the encoded ones.
+	if defaults.Headers != nil {
+		headers := make(Table, len(defaults.Headers))
+		for k, v := range defaults.Headers {
+			headers[k] = v
+		}
+		defaults.Headers = headers
+	}
+	defaults.Body = nil
+
+	headers, encodedHeaders := defaults.headers()
+
+	var encoded bytes.Buffer
+	if err := writeProperties(&encoded, properties{
+		Headers:         headers,
+		encodedHeaders:  encodedHeaders,
+		ContentType:     defaults.ContentType,
+		ContentEncoding: defaults.ContentEncoding,
+		DeliveryMode:    defaults.DeliveryMode,
+		Priority:        defaults.Priority,
+		CorrelationId:   defaults.CorrelationId,
+		ReplyTo:         defaults.ReplyTo,
+		Expiration:      defaults.Expiration,
+		MessageId:       defaults.MessageId,
+		Timestamp:       defaults.Timestamp,
+		Type:            defaults.Type,
+		UserId:          defaults.UserId,
+		AppId:           defaults.AppId,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &BoundPublisher{
+		ch:       ch,
+		exchange: exchange,
+		key:      routingKey,
+		defaults: defaults,
+		encoded:  encoded.Bytes(),
+	}, nil
+}
+
+// Send publishes body like Channel.PublishWithContext.
+func (p *BoundPublisher) Send(ctx context.Context, body []byte) error {
+	_, err := p.SendWithDeferredConfirm(ctx, body)
+	return err
+}
+
+// SendWithDeferredConfirm publishes body like
+// Channel.PublishWithDeferredConfirmWithContext.
+func (p *BoundPublisher) SendWithDeferredConfirm(ctx context.Context, body []byte) (*DeferredConfirmation, error) {
+	msg := p.defaults
+	msg.Body = body
+
+	if mw := p.ch.middlewares(); len(mw) > 0 {
+		return mw.publish(p.ch.sendPublish)(ctx, p.exchange, p.key, false, false, msg)
+	}
+	return p.ch.sendPublishing(ctx, p.exchange, p.key, false, false, msg, p.encoded, nil)
+}
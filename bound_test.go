@@ -0,0 +1,153 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+var boundDefaults = Publishing{
+	Headers:      Table{"tenant": "acme", "version": int32(2)},
+	ContentType:  "application/json",
+	DeliveryMode: Persistent,
+	Priority:     3,
+	Expiration:   "60000",
+	Timestamp:    time.Unix(1700000000, 0),
+	Type:         "order.created",
+	AppId:        "orders",
+}
+
+func TestBoundPublisherSendsWithDefaults(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 3)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for i := 0; i < 3; i++ {
+			published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		}
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	if _, err := ch.BoundPublisher("events", "created", Publishing{Headers: Table{"bad": struct{}{}}}); err == nil {
+		t.Error("expected invalid headers to be refused")
+	}
+
+	p, err := ch.BoundPublisher("events", "created", boundDefaults)
+	if err != nil {
+		t.Fatalf("could not create the bound publisher: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := p.Send(ctx, []byte(`{"id":1}`)); err != nil {
+		t.Fatalf("could not send: %v", err)
+	}
+	if err := p.Send(ctx, nil); err != nil {
+		t.Fatalf("could not send: %v", err)
+	}
+
+	ch.Use(Middleware{
+		Publish: func(next PublishHandler) PublishHandler {
+			return func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+				msg.AppId = "middleware"
+				return next(ctx, exchange, key, mandatory, immediate, msg)
+			}
+		},
+	})
+	if err := p.Send(ctx, []byte("mw")); err != nil {
+		t.Fatalf("could not send: %v", err)
+	}
+
+	for _, body := range []string{`{"id":1}`, ""} {
+		pub := <-published
+		if pub.Exchange != "events" || pub.RoutingKey != "created" || string(pub.Body) != body {
+			t.Errorf("expected %q published to events with created, got %+v", body, pub)
+		}
+		props := pub.Properties
+		if props.ContentType != "application/json" || props.DeliveryMode != Persistent || props.Priority != 3 ||
+			props.Expiration != "60000" || !props.Timestamp.Equal(boundDefaults.Timestamp) || props.Type != "order.created" ||
+			props.AppId != "orders" || props.Headers["tenant"] != "acme" || props.Headers["version"] != int32(2) {
+			t.Errorf("expected the default properties, got %+v", props)
+		}
+	}
+
+	if want, got := "middleware", (<-published).Properties.AppId; want != got {
+		t.Errorf("expected the middleware to change the properties, got app id %q", got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestHeaderFrameEncodedProperties(t *testing.T) {
+	// A single header, as tables are written in map order.
+	props := properties{
+		Headers:      Table{"tenant": "acme"},
+		ContentType:  boundDefaults.ContentType,
+		DeliveryMode: boundDefaults.DeliveryMode,
+		Timestamp:    boundDefaults.Timestamp,
+	}
+
+	var encoded bytes.Buffer
+	if err := writeProperties(&encoded, props); err != nil {
+		t.Fatalf("could not write the properties: %v", err)
+	}
+
+	var want, got bytes.Buffer
+	if err := (&headerFrame{ChannelId: 1, ClassId: 60, Size: 10, Properties: props}).write(&want); err != nil {
+		t.Fatalf("could not write the header frame: %v", err)
+	}
+	if err := (&headerFrame{ChannelId: 1, ClassId: 60, Size: 10, encoded: encoded.Bytes()}).write(&got); err != nil {
+		t.Fatalf("could not write the encoded header frame: %v", err)
+	}
+	if !bytes.Equal(want.Bytes(), got.Bytes()) {
+		t.Errorf("expected the encoded header frame to match\n%x\ngot\n%x", want.Bytes(), got.Bytes())
+	}
+}
+
+func BenchmarkHeaderFrameWrite(b *testing.B) {
+	props := properties{
+		Headers:      boundDefaults.Headers,
+		ContentType:  boundDefaults.ContentType,
+		DeliveryMode: boundDefaults.DeliveryMode,
+		Type:         boundDefaults.Type,
+		AppId:        boundDefaults.AppId,
+	}
+	var encoded bytes.Buffer
+	if err := writeProperties(&encoded, props); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("properties", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = (&headerFrame{ChannelId: 1, ClassId: 60, Size: 10, Properties: props}).write(io.Discard)
+		}
+	})
+	b.Run("encoded", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = (&headerFrame{ChannelId: 1, ClassId: 60, Size: 10, encoded: encoded.Bytes()}).write(io.Discard)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// memoryBudget bounds the bytes of the bodies of the deliveries to consumers
+// that are not acknowledged yet, see Config.MaxUnackedBytes.
+type memoryBudget struct {
+	limit atomic.Int64 // no limit when not greater than 0
+	used  atomic.Int64
+}
+
+func (b *memoryBudget) exceeded() bool {
+	limit := b.limit.Load()
+	return limit > 0 && b.used.Load() > limit
+}
+
+/*
+SetMaxUnackedBytes bounds the bytes of the bodies of the deliveries to the
+consumers of the channel that are not acknowledged yet, like
+Config.MaxUnackedBytes bounds them for the whole connection, and with the same
+caveats.  Zero or less removes the bound.  The deliveries received before a
+bound is set are not counted.
+*/
+func (ch *Channel) SetMaxUnackedBytes(n int64) {
+	ch.budget.limit.Store(n)
+	if ch.connection != nil {
+		ch.connection.wakeBudgets()
+	}
+}
+
+// UnackedBytes returns the bytes of the bodies of the deliveries to the
+// consumers of the channel that are not acknowledged yet.  Only the deliveries
+// received while a memory budget bounds the channel or the connection are
+// counted, and deliveries to consumers started with autoAck are not.
+func (ch *Channel) UnackedBytes() int64 {
+	return ch.budget.used.Load()
+}
+
+// budgeted reports whether a memory budget bounds the deliveries of the
+// channel, so that their bytes are charged.
+func (ch *Channel) budgeted() bool {
+	if ch.budget.limit.Load() > 0 {
+		return true
+	}
+	return ch.connection != nil && ch.connection.budget.limit.Load() > 0
+}
+
+// charge records n bytes of deliveries received, or acknowledged when n is
+// negative.
+func (ch *Channel) charge(n int64) {
+	ch.budget.used.Add(n)
+	if c := ch.connection; c != nil {
+		c.budget.used.Add(n)
+		if n < 0 {
+			c.wakeBudgets()
+		}
+	}
+}
+
+// wakeBudgets wakes the reader waiting for the memory budgets, if any.
+func (c *Connection) wakeBudgets() {
+	c.budgetM.Lock()
+	if c.budgetWait != nil {
+		close(c.budgetWait)
+		c.budgetWait = nil
+	}
+	c.budgetM.Unlock()
+}
+
+// budgetsExceeded reports whether the reader must wait before reading the
+// frames following a delivery on ch.
+func (c *Connection) budgetsExceeded(ch *Channel) bool {
+	return !c.closing.Load() && (c.budget.exceeded() || ch.budget.exceeded())
+}
+
+/*
+waitBudgets stops reading frames, and so lets TCP apply backpressure on the
+server, while the unacknowledged deliveries exceed the memory budget of the
+connection or of ch, and the connection is not being closed.  Called from the
+reader goroutine.
+*/
+func (c *Connection) waitBudgets(ch *Channel) {
+	if !c.budgetsExceeded(ch) {
+		return
+	}
+
+	// Not reading, the read deadline would expire, and the heartbeater would
+	// miss the heartbeats of the server.  The deadline is cleared again once
+	// done, in case the heartbeater renewed it meanwhile, and is renewed by
+	// the reader once the frame is handled.
+	conn, _ := c.conn.(readDeadliner)
+	c.stalled.Store(true)
+	defer func() {
+		if conn != nil {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+		c.stalled.Store(false)
+	}()
+	if conn != nil {
+		_ = conn.SetReadDeadline(time.Time{})
+	}
+
+	for {
+		c.budgetM.Lock()
+		if !c.budgetsExceeded(ch) {
+			c.budgetM.Unlock()
+			return
+		}
+		if c.budgetWait == nil {
+			c.budgetWait = make(chan struct{})
+		}
+		released := c.budgetWait
+		c.budgetM.Unlock()
+
+		select {
+		case <-released:
+		case <-c.close:
+			return
+		}
+	}
+}
+
+// release returns n bytes of acknowledged deliveries to the memory budgets of
+// the channel.
+func (subs *consumers) release(n int64) {
+	if n > 0 && subs.charge != nil {
+		subs.charge(-n)
+	}
+}
+
+// abandon releases the bytes of the unacknowledged deliveries, which the
+// server requeues as the channel is closing.
+func (subs *consumers) abandon() {
+	subs.Lock()
+	defer subs.Unlock()
+
+	subs.release(subs.unacked.uncharge())
+}
+
+// stopWaitingBudgets has the reader read the frames regardless of the memory
+// budgets, so that it reads the response to connection.close.
+func (c *Connection) stopWaitingBudgets() {
+	c.closing.Store(true)
+	c.wakeBudgets()
+}
+
+// unackedDelivery is a delivery to a consumer not acknowledged yet.
+type unackedDelivery struct {
+	tag      uint64
+	consumer string
+	size     int64 // charged to the memory budgets, 0 without a budget
+	settled  bool  // acknowledged before the deliveries preceding it
+}
+
+/*
+unackedDeliveries keeps the deliveries to consumers not acknowledged yet in
+delivery tag order, which is the order they arrive in, so that a multiple
+acknowledgement settles a prefix and a single one is found by binary search.
+*/
+type unackedDeliveries struct {
+	queue    []unackedDelivery // from head, settled ones included
+	head     int
+	inFlight map[string]int // unsettled deliveries by consumer tag
+}
+
+func (u *unackedDeliveries) push(tag uint64, consumer string, size int64) {
+	if u.inFlight == nil {
+		u.inFlight = make(map[string]int)
+	}
+	u.queue = append(u.queue, unackedDelivery{tag: tag, consumer: consumer, size: size})
+	u.inFlight[consumer]++
+}
+
+// settle calls fn with the delivery of tag, or with every delivery up to tag
+// when multiple is true, and forgets them.  A multiple settle of tag 0 covers
+// all deliveries.
+func (u *unackedDeliveries) settle(tag uint64, multiple bool, fn func(d unackedDelivery)) {
+	pending := u.queue[u.head:]
+
+	if multiple {
+		n := len(pending)
+		if tag != 0 {
+			n = sort.Search(len(pending), func(i int) bool { return pending[i].tag > tag })
+		}
+		for i := 0; i < n; i++ {
+			if !pending[i].settled {
+				u.done(pending[i], fn)
+			}
+		}
+		u.head += n
+	} else {
+		i := sort.Search(len(pending), func(i int) bool { return pending[i].tag >= tag })
+		if i == len(pending) || pending[i].tag != tag || pending[i].settled {
+			return
+		}
+		u.done(pending[i], fn)
+		pending[i].settled = true
+	}
+
+	u.compact()
+}
+
+func (u *unackedDeliveries) done(d unackedDelivery, fn func(d unackedDelivery)) {
+	if u.inFlight[d.consumer]--; u.inFlight[d.consumer] <= 0 {
+		delete(u.inFlight, d.consumer)
+	}
+	fn(d)
+}
+
+// compact drops the settled deliveries at the head, and reuses the start of
+// the queue once most of it is settled.
+func (u *unackedDeliveries) compact() {
+	for u.head < len(u.queue) && u.queue[u.head].settled {
+		u.head++
+	}
+
+	switch {
+	case u.head == len(u.queue):
+		u.queue, u.head = u.queue[:0], 0
+	case u.head > len(u.queue)/2:
+		n := copy(u.queue, u.queue[u.head:])
+		u.queue, u.head = u.queue[:n], 0
+	}
+}
+
+// uncharge returns the bytes charged for the unacknowledged deliveries, and
+// no longer counts them.
+func (u *unackedDeliveries) uncharge() (n int64) {
+	for i := u.head; i < len(u.queue); i++ {
+		if !u.queue[i].settled {
+			n += u.queue[i].size
+		}
+		u.queue[i].size = 0
+	}
+	return n
+}
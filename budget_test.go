@@ -0,0 +1,261 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+// openBudgeted opens a connection with config whose server delivers the
+// bodies to a consumer on channel 1, one after the other, and then expects
+// their acknowledgements unless autoAck.
+func openBudgeted(t *testing.T, config Config, autoAck bool, bodies ...string) (*Connection, *Channel, <-chan Delivery) {
+	t.Helper()
+
+	const tag = "budgeted"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	consuming := make(chan struct{})
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		<-consuming
+
+		// The pipes do not buffer like sockets, the acknowledgements are
+		// received while delivering.
+		delivered := make(chan struct{})
+		go func() {
+			defer close(delivered)
+			for i, body := range bodies {
+				srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: uint64(i + 1), Body: []byte(body)})
+			}
+		}()
+		if !autoAck {
+			for range bodies {
+				srv.recv(1, &basicAck{})
+			}
+		}
+		<-delivered
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	deliveries, err := ch.Consume("q", tag, autoAck, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+	close(consuming)
+
+	return c, ch, deliveries
+}
+
+// expectStalled fails unless nothing is delivered for a while.
+func expectStalled(t *testing.T, deliveries <-chan Delivery) {
+	t.Helper()
+
+	select {
+	case d := <-deliveries:
+		t.Fatalf("expected the reader to stall, got delivery %d", d.DeliveryTag)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMaxUnackedBytesStallsTheReader(t *testing.T) {
+	config := defaultConfig()
+	config.MaxUnackedBytes = 4
+
+	c, ch, deliveries := openBudgeted(t, config, false, "12345", "678")
+
+	first := <-deliveries
+	if want, got := int64(5), ch.UnackedBytes(); want != got {
+		t.Errorf("expected %d unacked bytes, got %d", want, got)
+	}
+	expectStalled(t, deliveries)
+
+	if err := first.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	second := <-deliveries
+	if want, got := "678", string(second.Body); want != got {
+		t.Errorf("expected the second delivery %q once the first is acked, got %q", want, got)
+	}
+	if err := second.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	if got := ch.UnackedBytes(); got != 0 {
+		t.Errorf("expected no unacked bytes once acked, got %d", got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestSetMaxUnackedBytesStallsTheReader(t *testing.T) {
+	c, ch, deliveries := openBudgeted(t, defaultConfig(), false, "12", "34", "56")
+
+	ch.SetMaxUnackedBytes(3)
+
+	first, second := <-deliveries, <-deliveries
+	expectStalled(t, deliveries)
+
+	if err := first.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	if err := second.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	third := <-deliveries
+	if err := third.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestMaxUnackedBytesIgnoresAutoAck(t *testing.T) {
+	config := defaultConfig()
+	config.MaxUnackedBytes = 1
+
+	c, ch, deliveries := openBudgeted(t, config, true, "12345", "678")
+
+	for range []int{1, 2} {
+		select {
+		case <-deliveries:
+		case <-time.After(time.Second):
+			t.Fatalf("expected autoAck deliveries not to stall the reader")
+		}
+	}
+	if got := ch.UnackedBytes(); got != 0 {
+		t.Errorf("expected autoAck deliveries not to be counted, got %d bytes", got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestCloseWhileStalledOnMaxUnackedBytes(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: "stalled"})
+		srv.send(1, &basicDeliver{ConsumerTag: "stalled", DeliveryTag: 1, Body: []byte("12345")})
+
+		srv.recv(0, &connectionClose{})
+		srv.send(0, &connectionCloseOk{})
+	}()
+
+	config := defaultConfig()
+	config.MaxUnackedBytes = 4
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	deliveries, err := ch.Consume("q", "stalled", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+	<-deliveries
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("expected to close while stalled, got %v", err)
+	}
+}
+
+func TestUnackedDeliveriesSettleInTagOrder(t *testing.T) {
+	var u unackedDeliveries
+	for tag := uint64(1); tag <= 5; tag++ {
+		consumer := "a"
+		if tag%2 == 0 {
+			consumer = "b"
+		}
+		u.push(tag, consumer, int64(tag))
+	}
+
+	settled := func(tag uint64, multiple bool) (tags []uint64, size int64) {
+		u.settle(tag, multiple, func(d unackedDelivery) {
+			tags = append(tags, d.tag)
+			size += d.size
+		})
+		return tags, size
+	}
+
+	if tags, size := settled(3, false); len(tags) != 1 || tags[0] != 3 || size != 3 {
+		t.Errorf("expected to settle delivery 3 alone, got %v of %d bytes", tags, size)
+	}
+	if tags, _ := settled(3, false); len(tags) != 0 {
+		t.Errorf("expected delivery 3 to be settled once, got %v", tags)
+	}
+	if tags, size := settled(4, true); len(tags) != 3 || tags[0] != 1 || tags[1] != 2 || tags[2] != 4 || size != 7 {
+		t.Errorf("expected to settle the deliveries up to 4 except 3, got %v of %d bytes", tags, size)
+	}
+	if want, got := 1, u.inFlight["a"]; want != got {
+		t.Errorf("expected %d delivery in flight for a, got %d", want, got)
+	}
+	if _, found := u.inFlight["b"]; found {
+		t.Errorf("expected no delivery in flight for b, got %d", u.inFlight["b"])
+	}
+
+	u.push(6, "b", 6)
+	if want, got := int64(11), u.uncharge(); want != got {
+		t.Errorf("expected %d bytes charged, got %d", want, got)
+	}
+	if tags, size := settled(0, true); len(tags) != 2 || tags[0] != 5 || tags[1] != 6 || size != 0 {
+		t.Errorf("expected to settle all deliveries once uncharged, got %v of %d bytes", tags, size)
+	}
+	if len(u.queue) != 0 || len(u.inFlight) != 0 {
+		t.Errorf("expected nothing left, got %v and %v", u.queue, u.inFlight)
+	}
+}
+
+func TestUnackedBytesNotChargedWithoutBudget(t *testing.T) {
+	c, ch, deliveries := openBudgeted(t, defaultConfig(), false, "12345")
+
+	d := <-deliveries
+	if got := ch.UnackedBytes(); got != 0 {
+		t.Errorf("expected no unacked bytes charged without a budget, got %d", got)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -7,9 +7,13 @@ package amqp091
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // 0      1         3             7                  size+7 size+8
@@ -33,15 +37,47 @@ type Channel struct {
 
 	connection *Connection
 
-	rpc       chan message
 	consumers *consumers
 
+	// Responses to synchronous methods, queued by dispatch so that the
+	// connection reader never waits for the caller, see respond.
+	rpcM      sync.Mutex
+	responses []message
+	responded chan struct{} // closed on the next response, see response
+	abandoned int           // responses of abandoned calls to drop
+
 	id uint16
 
 	// closed is set to 1 when the channel has been closed - see Channel.send()
 	closed int32
 	close  chan struct{}
 
+	// closedBy holds why the channel closed, see CloseReason.
+	closedBy atomic.Value
+
+	// middleware holds the middlewares added with Use.
+	middleware atomic.Value
+
+	// ctx holds the context set with WithContext.
+	ctx atomic.Value
+
+	// delivered is the highest delivery tag received, see AckUpTo.
+	delivered atomic.Uint64
+
+	// prefetchCount is the per consumer prefetch count set with Qos, see
+	// ConsumeStream.
+	prefetchCount atomic.Uint32
+
+	// pendingWrites counts the methods waiting to be written, see DispatchDepth.
+	pendingWrites atomic.Int64
+
+	// mandatory holds the chan Return of the PublishMandatory calls waiting
+	// for their confirmation, by mandatorySeq.
+	mandatory    sync.Map
+	mandatorySeq int64
+
+	stats *channelStats // nil unless Config.EnableStats
+
 	// true when we will never notify again
 	noNotify bool
 
@@ -52,6 +88,10 @@ type Channel struct {
 	// publishing should pause until false is sent to listeners.
 	flows []chan bool
 
+	// resumed is closed when the server reactivates a flow it paused with
+	// channel.flow, nil while the flow is active.  Protected by notifyM.
+	resumed chan struct{}
+
 	// Listeners for returned publishings for unroutable messages on mandatory
 	// publishings or undeliverable messages on immediate publishings.
 	returns []chan Return
@@ -60,6 +100,9 @@ type Channel struct {
 	// a consumer has been cancelled.
 	cancels []chan string
 
+	// Listeners for every event of the channel, see Notifications.
+	notifications []chan Notification
+
 	// Allocated when in confirm mode in order to track publish counter and order confirms
 	confirms   *confirms
 	confirming bool
@@ -71,23 +114,47 @@ type Channel struct {
 	recv func(*Channel, frame)
 
 	// Current state for frame re-assembly, only mutated from recv
-	message messageWithContent
-	header  *headerFrame
-	body    []byte
+	message   messageWithContent
+	header    *headerFrame
+	body      []byte
+	slab      slabRef // body when assembled in a pooled buffer
+	discarded uint64
+
+	// stream is the body of the delivery being streamed, see
+	// WithStreamedBody.  Closed by shutdown, so that it does not block.
+	stream   atomic.Pointer[io.PipeWriter]
+	streamed uint64
+
+	// stamping overrides Config.Stamping, see SetStamping.
+	stamping atomic.Pointer[Stamping]
+
+	// budget bounds the unacknowledged deliveries, see SetMaxUnackedBytes.
+	budget memoryBudget
 }
 
 // Constructs a new channel with the given framing rules
 func newChannel(c *Connection, id uint16) *Channel {
-	return &Channel{
+	ch := &Channel{
 		connection: c,
 		id:         id,
-		rpc:        make(chan message),
 		consumers:  makeConsumers(),
-		confirms:   newConfirms(),
+		confirms:   newConfirms(c.strictNotify()),
 		recv:       (*Channel).recvMethod,
 		errors:     make(chan *Error, 1),
 		close:      make(chan struct{}),
 	}
+
+	if c != nil && c.Config.EnableStats {
+		ch.stats = &channelStats{}
+		ch.confirms.stats = ch.stats
+	}
+	ch.consumers.charge, ch.consumers.budgeted = ch.charge, ch.budgeted
+	if c != nil {
+		ch.consumers.pool = c.dispatch
+		ch.consumers.clock = c.clock()
+	}
+
+	return ch
 }
 
 // Signal that from now on, Channel.send() should call Channel.sendClosed()
@@ -95,12 +162,50 @@ func (ch *Channel) setClosed() {
 	atomic.StoreInt32(&ch.closed, 1)
 }
 
+// setClosedBy records why the channel closed, the first reason wins.
+func (ch *Channel) setClosedBy(reason *Error) {
+	ch.closedBy.CompareAndSwap(nil, reason)
+}
+
+/*
+CloseReason returns why the channel closed: ErrChannelClosedByServer,
+ErrClosedByClient or ErrConnectionLost.  It returns nil while the channel is
+open, or until the reason is known.
+
+Operations on a closed channel return ErrClosed whatever the reason, so that
+checks like err == ErrClosed keep working: call CloseReason to tell the
+reasons apart.
+*/
+func (ch *Channel) CloseReason() error {
+	if reason, ok := ch.closedBy.Load().(*Error); ok {
+		return reason
+	}
+	return nil
+}
+
 // shutdown is called by Connection after the channel has been removed from the
-// connection registry.
-func (ch *Channel) shutdown(e *Error) {
+// connection registry.  It returns true only for the call that performed the
+// shutdown.
+func (ch *Channel) shutdown(e *Error) (done bool) {
+	switch {
+	case ch.connection != nil && ch.connection.IsClosed():
+		if reason, ok := ch.connection.closedBy.Load().(*Error); ok {
+			ch.setClosedBy(reason)
+		}
+	case e != nil:
+		ch.setClosedBy(ErrChannelClosedByServer)
+	default:
+		ch.setClosedBy(ErrClosedByClient)
+	}
 	ch.setClosed()
 
+	if pw := ch.stream.Swap(nil); pw != nil {
+		pw.CloseWithError(ErrClosed)
+	}
+
 	ch.destructor.Do(func() {
+		done = true
+
 		ch.m.Lock()
 		defer ch.m.Unlock()
 
@@ -108,11 +213,21 @@ func (ch *Channel) shutdown(e *Error) {
 		ch.notifyM.Lock()
 		defer ch.notifyM.Unlock()
 
+		strict := ch.connection.strictNotify()
+
+		// Listeners are notified and closed in a fixed order: the error is
+		// broadcast to NotifyClose, then consumer chans are closed, then the
+		// NotifyClose, NotifyFlow, NotifyReturn, NotifyCancel, NotifyPublish
+		// and finally Notifications listeners.  Everything dispatched before
+		// the shutdown has already been sent, as dispatch runs on the same
+		// goroutine.
+
 		// Broadcast abnormal shutdown
 		if e != nil {
 			for _, c := range ch.closes {
-				c <- e
+				notify(strict, c, e, "NotifyClose")
 			}
+			ch.notifyAll(CloseNotification{Err: e})
 			// Notify RPC if we're selecting
 			ch.errors <- e
 		}
@@ -146,10 +261,18 @@ func (ch *Channel) shutdown(e *Error) {
 			ch.confirms.Close()
 		}
 
+		// Closed after the confirms stopped sending to them.
+		for _, c := range ch.notifications {
+			close(c)
+		}
+		ch.notifications = nil
+
 		close(ch.errors)
 		close(ch.close)
 		ch.noNotify = true
 	})
+
+	return done
 }
 
 // send calls Channel.sendOpen() during normal operation.
@@ -162,21 +285,77 @@ func (ch *Channel) send(msg message) (err error) {
 		return ch.sendClosed(msg)
 	}
 
+	ch.pendingWrites.Add(1)
+	defer ch.pendingWrites.Add(-1)
+
 	return ch.sendOpen(msg)
 }
 
 func (ch *Channel) open() error {
-	return ch.call(&channelOpen{}, &channelOpenOk{})
+	return ch.callContext(context.Background(), &channelOpen{}, &channelOpenOk{})
 }
 
 // Performs a request/response call for when the message is not NoWait and is
-// specified as Synchronous.
+// specified as Synchronous, honouring the context set with WithContext.
 func (ch *Channel) call(req message, res ...message) error {
-	if err := ch.send(req); err != nil {
+	return ch.callContext(ch.context(), req, res...)
+}
+
+/*
+WithContext sets the context of the synchronous methods of the channel, such as
+QueueDeclare, QueueBind, Qos or Consume, and returns the channel.  When ctx is
+done, a method waiting for the server to answer returns context.Cause(ctx)
+instead of waiting indefinitely, and the following methods return it without
+sending anything.
+
+The answer to an abandoned method can no longer be matched to its request, so
+the channel is then closed, see GetWithContext.  Close is not affected by the
+context, and methods taking their own context, like GetWithContext, use that
+one instead.
+
+	ch, err := conn.Channel()
+	if err != nil {
 		return err
 	}
+	ch.WithContext(ctx)
+*/
+func (ch *Channel) WithContext(ctx context.Context) *Channel {
+	ch.ctx.Store(ctxHolder{ctx})
+	return ch
+}
+
+// ctxHolder keeps the contexts of different types stored by WithContext in
+// the same atomic.Value.
+type ctxHolder struct{ ctx context.Context }
+
+func (ch *Channel) context() context.Context {
+	if h, ok := ch.ctx.Load().(ctxHolder); ok {
+		return h.ctx
+	}
+	return context.Background()
+}
+
+// errAbandoned is returned by await when it stopped waiting for a response.
+var errAbandoned = errors.New("response abandoned")
+
+// await receives the response to a call into the first of res of the same
+// type.  It returns errAbandoned when done is closed first.
+func (ch *Channel) await(done <-chan struct{}, res []message) error {
+	for {
+		msg, responded := ch.response()
+		if responded == nil {
+			for _, try := range res {
+				if reflect.TypeOf(msg) == reflect.TypeOf(try) {
+					// *res = *msg
+					vres := reflect.ValueOf(try).Elem()
+					vmsg := reflect.ValueOf(msg).Elem()
+					vres.Set(vmsg)
+					return nil
+				}
+			}
+			return ErrCommandInvalid
+		}
 
-	if req.wait() {
 		select {
 		case e, ok := <-ch.errors:
 			if ok {
@@ -184,27 +363,125 @@ func (ch *Channel) call(req message, res ...message) error {
 			}
 			return ErrClosed
 
-		case msg := <-ch.rpc:
-			if msg != nil {
-				for _, try := range res {
-					if reflect.TypeOf(msg) == reflect.TypeOf(try) {
-						// *res = *msg
-						vres := reflect.ValueOf(try).Elem()
-						vmsg := reflect.ValueOf(msg).Elem()
-						vres.Set(vmsg)
-						return nil
-					}
-				}
-				return ErrCommandInvalid
-			}
-			// RPC channel has been closed without an error, likely due to a hard
-			// error on the Connection.  This indicates we have already been
-			// shutdown and if were waiting, will have returned from the errors chan.
-			return ErrClosed
+		case <-responded:
+
+		case <-done:
+			ch.abandon()
+			return errAbandoned
 		}
 	}
+}
 
-	return nil
+// respond queues the response to a synchronous method for await.  The
+// connection reader does not wait for the caller to receive it, so that the
+// frames of the other channels are not held up behind a slow caller.  The
+// response to an abandoned call is dropped instead, so that it is not taken
+// for the response to the following call.
+func (ch *Channel) respond(msg message) {
+	ch.rpcM.Lock()
+	defer ch.rpcM.Unlock()
+
+	if ch.abandoned > 0 {
+		ch.abandoned--
+		return
+	}
+
+	ch.responses = append(ch.responses, msg)
+	if ch.responded != nil {
+		close(ch.responded)
+		ch.responded = nil
+	}
+}
+
+// response returns the first queued response, or a chan closed once there is
+// one when none is queued.
+func (ch *Channel) response() (message, <-chan struct{}) {
+	ch.rpcM.Lock()
+	defer ch.rpcM.Unlock()
+
+	if len(ch.responses) > 0 {
+		msg := ch.responses[0]
+		ch.responses[0] = nil
+		ch.responses = ch.responses[1:]
+		return msg, nil
+	}
+
+	if ch.responded == nil {
+		ch.responded = make(chan struct{})
+	}
+	return nil, ch.responded
+}
+
+// abandon drops the response to the call await stopped waiting for, already
+// queued or still to come.
+func (ch *Channel) abandon() {
+	ch.rpcM.Lock()
+	defer ch.rpcM.Unlock()
+
+	if len(ch.responses) > 0 {
+		ch.responses[0] = nil
+		ch.responses = ch.responses[1:]
+		return
+	}
+	ch.abandoned++
+}
+
+// callContext is call returning context.Cause(ctx) when ctx is done before the
+// response is received.  The response still arrives later and could no longer
+// be told apart from the response to a following call, so the channel is then
+// closed.
+func (ch *Channel) callContext(ctx context.Context, req message, res ...message) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	if err := ch.send(req); err != nil {
+		return err
+	}
+
+	if !req.wait() {
+		return nil
+	}
+
+	if err := ch.await(ctx.Done(), res); err != errAbandoned {
+		return err
+	}
+
+	ch.setClosed()
+	go ch.closeAbandoned()
+
+	return context.Cause(ctx)
+}
+
+// closeAbandoned closes the channel after callContext stopped waiting for a
+// response, discarding the responses received until channel.close-ok.  The
+// server requeues the messages left unacknowledged, including one returned by
+// an abandoned basic.get.
+func (ch *Channel) closeAbandoned() {
+	defer ch.connection.closeChannel(ch, nil)
+
+	if err := ch.connection.send(&methodFrame{
+		ChannelId: ch.id,
+		Method:    &channelClose{ReplyCode: replySuccess, ReplyText: "abandoned request"},
+	}); err != nil {
+		return
+	}
+
+	for {
+		msg, responded := ch.response()
+		if _, ok := msg.(*channelCloseOk); ok {
+			return
+		}
+		if responded == nil {
+			continue
+		}
+
+		select {
+		case <-ch.errors:
+			return
+		case <-responded:
+		}
+	}
 }
 
 func (ch *Channel) sendClosed(msg message) (err error) {
@@ -239,54 +516,46 @@ func (ch *Channel) sendOpen(msg message) (err error) {
 			return ch.sendClosed(msg)
 		}
 
-		// Flush the buffer only after all the Frames that comprise the Message
-		// have been written to maximise benefits of using a buffered writer.
-		defer func() {
-			if endError := ch.connection.endSendUnflushed(); endError != nil {
-				if err == nil {
-					err = endError
-				}
-			}
-		}()
-
-		// We use sendUnflushed() in this method as sending the message requires
-		// sending multiple Frames (methodFrame, headerFrame, N x bodyFrame).
-		// Flushing after each Frame is inefficient, as it negates much of the
-		// benefit of using a buffered writer and results in more syscalls than
-		// necessary. Flushing buffers after every frame can have a significant
-		// performance impact when sending (e.g. basicPublish) small messages,
-		// so sendUnflushed() performs an *Unflushed* write, but is otherwise
-		// equivalent to the send() method. We later use the separate flush
-		// method to explicitly flush the buffer after all Frames are written.
-		if err = ch.connection.sendUnflushed(&methodFrame{
-			ChannelId: ch.id,
-			Method:    content,
-		}); err != nil {
-			return
+		var encoded []byte
+		if pub, ok := msg.(*encodedPublish); ok {
+			encoded = pub.properties
 		}
 
-		if err = ch.connection.sendUnflushed(&headerFrame{
-			ChannelId:  ch.id,
-			ClassId:    class,
-			Size:       uint64(len(body)),
-			Properties: props,
-		}); err != nil {
-			return
+		n := 2
+		if size > 0 {
+			n += (len(body) + size - 1) / size
 		}
 
+		frames := make([]frame, 0, n)
+		frames = append(frames,
+			&methodFrame{
+				ChannelId: ch.id,
+				Method:    content,
+			},
+			&headerFrame{
+				ChannelId:  ch.id,
+				ClassId:    class,
+				Size:       uint64(len(body)),
+				Properties: props,
+				encoded:    encoded,
+			})
+
 		// chunk body into size (max frame size - frame header size)
 		for i, j := 0, size; i < len(body); i, j = j, j+size {
 			if j > len(body) {
 				j = len(body)
 			}
 
-			if err = ch.connection.sendUnflushed(&bodyFrame{
+			frames = append(frames, &bodyFrame{
 				ChannelId: ch.id,
 				Body:      body[i:j],
-			}); err != nil {
-				return
-			}
+			})
 		}
+
+		// The frames of the message are written together, with a single
+		// vectored write where the transport supports it, rather than a
+		// write per frame.
+		err = ch.connection.sendContent(frames)
 	} else {
 		// If the channel is closed, use Channel.sendClosed()
 		if ch.IsClosed() {
@@ -315,67 +584,98 @@ func (ch *Channel) dispatch(msg message) {
 		// publishing is happening concurrently
 		ch.m.Lock()
 		if err := ch.send(&channelCloseOk{}); err != nil {
-			Logger.Printf("error sending channelCloseOk, channel id: %d error: %+v", ch.id, err)
+			ch.log(LevelWarn, "error sending channelCloseOk", "method", "channel.close-ok", "error", err)
 		}
 		ch.m.Unlock()
 		ch.connection.closeChannel(ch, newError(m.ReplyCode, m.ReplyText))
 
 	case *channelFlow:
+		ch.setFlow(m.Active)
+
 		ch.notifyM.RLock()
 		for _, c := range ch.flows {
-			c <- m.Active
+			notify(ch.connection.strictNotify(), c, m.Active, "NotifyFlow")
 		}
+		ch.notifyAll(FlowNotification{Active: m.Active})
 		ch.notifyM.RUnlock()
 		if err := ch.send(&channelFlowOk{Active: m.Active}); err != nil {
-			Logger.Printf("error sending channelFlowOk, channel id: %d error: %+v", ch.id, err)
+			ch.log(LevelWarn, "error sending channelFlowOk", "method", "channel.flow-ok", "error", err)
+		}
+
+		if ch.connection.Config.OnChannelFlow != nil {
+			ch.connection.Config.OnChannelFlow(ch, m.Active)
 		}
 
 	case *basicCancel:
 		ch.notifyM.RLock()
 		for _, c := range ch.cancels {
-			c <- m.ConsumerTag
+			notify(ch.connection.strictNotify(), c, m.ConsumerTag, "NotifyCancel")
 		}
+		ch.notifyAll(CancelNotification{ConsumerTag: m.ConsumerTag})
 		ch.notifyM.RUnlock()
-		ch.consumers.cancel(m.ConsumerTag)
+		if opts, ok := ch.consumers.options(m.ConsumerTag); ok && opts.resubscribe != nil {
+			go ch.resubscribe(m.ConsumerTag, opts.resubscribe)
+		} else {
+			ch.consumers.cancel(m.ConsumerTag)
+		}
 
 	case *basicReturn:
 		ret := newReturn(*m)
 		ch.notifyM.RLock()
 		for _, c := range ch.returns {
-			c <- *ret
+			notify(ch.connection.strictNotify(), c, *ret, "NotifyReturn")
 		}
+		ch.notifyAll(*ret)
 		ch.notifyM.RUnlock()
+		if ch.stats != nil {
+			atomic.AddUint64(&ch.stats.returned, 1)
+		}
+		ch.returned(*ret)
 
 	case *basicAck:
 		if ch.confirming {
 			if m.Multiple {
-				ch.confirms.Multiple(Confirmation{m.DeliveryTag, true})
+				ch.confirms.Multiple(Confirmation{DeliveryTag: m.DeliveryTag, Ack: true})
 			} else {
-				ch.confirms.One(Confirmation{m.DeliveryTag, true})
+				ch.confirms.One(Confirmation{DeliveryTag: m.DeliveryTag, Ack: true})
 			}
 		}
 
 	case *basicNack:
 		if ch.confirming {
 			if m.Multiple {
-				ch.confirms.Multiple(Confirmation{m.DeliveryTag, false})
+				ch.confirms.Multiple(Confirmation{DeliveryTag: m.DeliveryTag, Ack: false})
 			} else {
-				ch.confirms.One(Confirmation{m.DeliveryTag, false})
+				ch.confirms.One(Confirmation{DeliveryTag: m.DeliveryTag, Ack: false})
 			}
 		}
 
 	case *basicDeliver:
-		ch.consumers.send(m.ConsumerTag, newDelivery(ch, m))
-		// TODO log failed consumer and close channel, this can happen when
-		// deliveries are in flight and a no-wait cancel has happened
+		delivery := newDelivery(ch, m)
+		delivery.slab, ch.slab = ch.slab, slabRef{}
+		ch.deliver(m, delivery)
 
 	default:
-		select {
-		case <-ch.close:
-			return
-		case ch.rpc <- msg:
-		}
+		ch.respond(msg)
+	}
+}
+
+// deliver hands a delivery to its consumer, and returns false when no
+// consumer received it.
+func (ch *Channel) deliver(m *basicDeliver, delivery *Delivery) (sent bool) {
+	ch.delivered.Store(m.DeliveryTag)
+	if ch.stats != nil {
+		atomic.AddUint64(&ch.stats.delivered, 1)
 	}
+	if mw := ch.middlewares(); len(mw) > 0 {
+		mw.deliver(func(d Delivery) {
+			sent = ch.consumers.send(d.ConsumerTag, &d)
+		})(*delivery)
+		return sent
+	}
+	// TODO log failed consumer and close channel, this can happen when
+	// deliveries are in flight and a no-wait cancel has happened
+	return ch.consumers.send(m.ConsumerTag, delivery)
 }
 
 func (ch *Channel) transition(f func(*Channel, frame)) {
@@ -386,6 +686,9 @@ func (ch *Channel) recvMethod(f frame) {
 	switch frame := f.(type) {
 	case *methodFrame:
 		if msg, ok := frame.Method.(messageWithContent); ok {
+			// The body of an interrupted delivery was never handed out.
+			ch.slab.release()
+			ch.slab = slabRef{}
 			ch.body = make([]byte, 0)
 			ch.message = msg
 			ch.transition((*Channel).recvHeader)
@@ -418,12 +721,24 @@ func (ch *Channel) recvHeader(f frame) {
 		// start collecting if we expect body frames
 		ch.header = frame
 
+		if ch.oversized() {
+			ch.discarded = 0
+			ch.transition((*Channel).recvDiscard)
+			return
+		}
+
 		if frame.Size == 0 {
 			ch.message.setContent(ch.header.Properties, ch.body)
 			ch.dispatch(ch.message) // termination state
 			ch.transition((*Channel).recvMethod)
 			return
 		}
+
+		if ch.streamedBody() {
+			ch.startStream()
+			ch.transition((*Channel).recvStream)
+			return
+		}
 		ch.transition((*Channel).recvContent)
 
 	case *bodyFrame:
@@ -449,7 +764,7 @@ func (ch *Channel) recvContent(f frame) {
 
 	case *bodyFrame:
 		if cap(ch.body) == 0 {
-			ch.body = make([]byte, 0, ch.header.Size)
+			ch.allocBody()
 		}
 		ch.body = append(ch.body, frame.Body...)
 
@@ -467,6 +782,152 @@ func (ch *Channel) recvContent(f frame) {
 	}
 }
 
+// state after the header of a rejected delivery and before the length defined
+// by the header has been reached, body frames are dropped without buffering
+func (ch *Channel) recvDiscard(f frame) {
+	switch frame := f.(type) {
+	case *methodFrame:
+		// interrupt content and handle method
+		ch.recvMethod(f)
+
+	case *headerFrame:
+		// drop and reset
+		ch.transition((*Channel).recvMethod)
+
+	case *bodyFrame:
+		ch.discarded += uint64(len(frame.Body))
+
+		if ch.discarded >= ch.header.Size {
+			ch.rejectDiscarded()
+			ch.transition((*Channel).recvMethod)
+			return
+		}
+
+		ch.transition((*Channel).recvDiscard)
+
+	default:
+		panic("unexpected frame type")
+	}
+}
+
+// state after the header of a streamed delivery and before the length defined
+// by the header has been reached, body frames are written to its BodyReader
+func (ch *Channel) recvStream(f frame) {
+	switch frame := f.(type) {
+	case *methodFrame:
+		// interrupt content and handle method
+		ch.endStream(io.ErrUnexpectedEOF)
+		ch.recvMethod(f)
+
+	case *headerFrame:
+		// drop and reset
+		ch.endStream(io.ErrUnexpectedEOF)
+		ch.transition((*Channel).recvMethod)
+
+	case *bodyFrame:
+		ch.streamed += uint64(len(frame.Body))
+
+		if pw := ch.stream.Load(); pw != nil {
+			// Fails once the application closed the BodyReader, the rest
+			// of the body is then dropped.
+			_, _ = pw.Write(frame.Body)
+		}
+
+		if ch.streamed >= ch.header.Size {
+			ch.endStream(nil)
+			ch.transition((*Channel).recvMethod)
+			return
+		}
+
+		ch.transition((*Channel).recvStream)
+
+	default:
+		panic("unexpected frame type")
+	}
+}
+
+// allocBody allocates the buffer the body of the message being received is
+// assembled into.  The bodies of deliveries to consumers are taken from the
+// pool, to be released when they are acknowledged, when
+// Config.PoolDeliveryBodies is set.
+func (ch *Channel) allocBody() {
+	_, deliver := ch.message.(*basicDeliver)
+	if !deliver || ch.header.Size > maxPooledBuffer || ch.connection == nil || !ch.connection.Config.PoolDeliveryBodies {
+		ch.body = make([]byte, 0, ch.header.Size)
+		return
+	}
+
+	ch.body, ch.slab = getSlab(int(ch.header.Size))
+	ch.body = ch.body[:0]
+}
+
+// streamedBody returns true when the body of the delivery being received
+// exceeds the size its consumer streams, see WithStreamedBody.
+func (ch *Channel) streamedBody() bool {
+	deliver, ok := ch.message.(*basicDeliver)
+	if !ok {
+		return false
+	}
+
+	opts, found := ch.consumers.options(deliver.ConsumerTag)
+	return found && opts.streamSize > 0 && ch.header.Size > opts.streamSize
+}
+
+// startStream hands the delivery being received to its consumer with a
+// BodyReader fed by recvStream.
+func (ch *Channel) startStream() {
+	deliver := ch.message.(*basicDeliver)
+	deliver.setContent(ch.header.Properties, nil)
+
+	pr, pw := io.Pipe()
+	ch.streamed = 0
+	ch.stream.Store(pw)
+
+	delivery := newDelivery(ch, deliver)
+	delivery.BodyReader = pr
+	if !ch.deliver(deliver, delivery) {
+		// Nobody reads the body, drop it.
+		pr.Close()
+	}
+}
+
+// endStream closes the BodyReader of the delivery being streamed with err, or
+// io.EOF when nil.
+func (ch *Channel) endStream(err error) {
+	if pw := ch.stream.Swap(nil); pw != nil {
+		pw.CloseWithError(err)
+	}
+}
+
+// oversized returns true when the announced body size of the delivery being
+// received exceeds the limit of its consumer.
+func (ch *Channel) oversized() bool {
+	deliver, ok := ch.message.(*basicDeliver)
+	if !ok {
+		return false
+	}
+
+	opts, found := ch.consumers.options(deliver.ConsumerTag)
+	return found && opts.maxBodySize > 0 && ch.header.Size > opts.maxBodySize
+}
+
+// rejectDiscarded nacks the delivery whose content has been discarded, unless
+// its consumer does not acknowledge deliveries.
+func (ch *Channel) rejectDiscarded() {
+	deliver := ch.message.(*basicDeliver)
+
+	ch.log(LevelWarn, "rejected delivery exceeding the body size limit of its consumer", "method", "basic.deliver",
+		"delivery", deliver.DeliveryTag, "size", ch.header.Size, "consumer", deliver.ConsumerTag)
+
+	if opts, found := ch.consumers.options(deliver.ConsumerTag); found && opts.noAck {
+		return
+	}
+
+	if err := ch.Nack(deliver.DeliveryTag, false, false); err != nil {
+		ch.log(LevelWarn, "error sending basicNack for oversized delivery", "method", "basic.nack", "error", err)
+	}
+}
+
 /*
 Close initiate a clean channel closure by sending a close message with the error
 code set to '200'.
@@ -474,13 +935,26 @@ code set to '200'.
 It is safe to call this method multiple times.
 */
 func (ch *Channel) Close() error {
+	return ch.CloseWithCode(replySuccess, "")
+}
+
+/*
+CloseWithCode closes the channel like Close, sending the given reply code and
+reason to the server instead of 200.  The reason shows up in the broker logs.
+
+It is safe to call this method multiple times.
+*/
+func (ch *Channel) CloseWithCode(code int, reason string) error {
 	if ch.IsClosed() {
 		return nil
 	}
 
+	// The reader must read the close-ok.
+	ch.consumers.abandon()
+
 	defer ch.connection.closeChannel(ch, nil)
-	return ch.call(
-		&channelClose{ReplyCode: replySuccess},
+	return ch.callContext(context.Background(),
+		&channelClose{ReplyCode: uint16(code), ReplyText: reason},
 		&channelCloseOk{},
 	)
 }
@@ -539,6 +1013,11 @@ messages.
 basic.flow-ok methods will always be returned to the server regardless of
 the number of listeners there are.
 
+Publishing methods wait while the server has paused the flow, until it resumes
+it, the context given to PublishWithContext is done, or the channel closes, so
+publishers do not need a listener to gate themselves.  See also
+Config.OnChannelFlow.
+
 To control the flow of deliveries from the server, use the Channel.Flow()
 method instead.
 
@@ -562,6 +1041,41 @@ func (ch *Channel) NotifyFlow(c chan bool) chan bool {
 	return c
 }
 
+// setFlow records whether the server lets the channel publish.
+func (ch *Channel) setFlow(active bool) {
+	ch.notifyM.Lock()
+	defer ch.notifyM.Unlock()
+
+	switch {
+	case !active && ch.resumed == nil:
+		ch.resumed = make(chan struct{})
+	case active && ch.resumed != nil:
+		close(ch.resumed)
+		ch.resumed = nil
+	}
+}
+
+// waitFlow waits for the server to reactivate a flow paused with
+// channel.flow.
+func (ch *Channel) waitFlow(ctx context.Context) error {
+	ch.notifyM.RLock()
+	resumed := ch.resumed
+	ch.notifyM.RUnlock()
+
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ch.close:
+		return ErrClosed
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
 /*
 NotifyReturn registers a listener for basic.return methods.  These can be sent
 from the server when a publish is undeliverable either from the mandatory or
@@ -667,6 +1181,28 @@ func (ch *Channel) NotifyPublish(confirm chan Confirmation) chan Confirmation {
 	return confirm
 }
 
+/*
+OnConfirm registers fn to be called for every publish after Channel.Confirm,
+as an alternative to NotifyPublish that needs no goroutine to drain a chan.
+
+Like with NotifyPublish, fn is called once per publishing, in order starting
+with DeliveryTag 1, even when the server acknowledges multiple publishings at
+once or out of order.
+
+fn is called synchronously from the goroutine reading from the connection, so
+it must return quickly and must not call methods of the Channel or Connection
+that wait for a response from the server.  fn is no longer called once the
+Channel is closed.
+*/
+func (ch *Channel) OnConfirm(fn func(Confirmation)) {
+	ch.notifyM.Lock()
+	defer ch.notifyM.Unlock()
+
+	if !ch.noNotify {
+		ch.confirms.OnConfirm(fn)
+	}
+}
+
 /*
 Qos controls how many messages or how many bytes the server will try to keep on
 the network for consumers before receiving delivery acks.  The intent of Qos is
@@ -703,14 +1239,21 @@ greater as described by benchmarks on RabbitMQ.
 http://www.rabbitmq.com/blog/2012/04/25/rabbitmq-performance-measurements-part-2/
 */
 func (ch *Channel) Qos(prefetchCount, prefetchSize int, global bool) error {
-	return ch.call(
+	if err := ch.call(
 		&basicQos{
 			PrefetchCount: uint16(prefetchCount),
 			PrefetchSize:  uint32(prefetchSize),
 			Global:        global,
 		},
 		&basicQosOk{},
-	)
+	); err != nil {
+		return err
+	}
+
+	if !global {
+		ch.prefetchCount.Store(uint32(uint16(prefetchCount)))
+	}
+	return nil
 }
 
 /*
@@ -809,6 +1352,10 @@ func (ch *Channel) QueueDeclare(name string, durable, autoDelete, exclusive, noW
 		return Queue{}, err
 	}
 
+	if err := ch.validateName("queue", name, false); err != nil {
+		return Queue{}, err
+	}
+
 	req := &queueDeclare{
 		Queue:      name,
 		Passive:    false,
@@ -847,6 +1394,10 @@ func (ch *Channel) QueueDeclarePassive(name string, durable, autoDelete, exclusi
 		return Queue{}, err
 	}
 
+	if err := ch.validateName("queue", name, true); err != nil {
+		return Queue{}, err
+	}
+
 	req := &queueDeclare{
 		Queue:      name,
 		Passive:    true,
@@ -1097,41 +1648,12 @@ messages in this way won't be lost.
 
 When the consumer tag is cancelled, all inflight messages will be delivered until
 the returned chan is closed.
+
+Use Channel.ConsumeWithOptions to configure client side behaviour of the
+consumer, like WithMaxBodySize.
 */
 func (ch *Channel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table) (<-chan Delivery, error) {
-	// When we return from ch.call, there may be a delivery already for the
-	// consumer that hasn't been added to the consumer hash yet.  Because of
-	// this, we never rely on the server picking a consumer tag for us.
-
-	if err := args.Validate(); err != nil {
-		return nil, err
-	}
-
-	if consumer == "" {
-		consumer = uniqueConsumerTag()
-	}
-
-	req := &basicConsume{
-		Queue:       queue,
-		ConsumerTag: consumer,
-		NoLocal:     noLocal,
-		NoAck:       autoAck,
-		Exclusive:   exclusive,
-		NoWait:      noWait,
-		Arguments:   args,
-	}
-	res := &basicConsumeOk{}
-
-	deliveries := make(chan Delivery)
-
-	ch.consumers.add(consumer, deliveries)
-
-	if err := ch.call(req, res); err != nil {
-		ch.consumers.cancel(consumer)
-		return nil, err
-	}
-
-	return deliveries, nil
+	return ch.ConsumeWithOptions(context.Background(), queue, consumer, autoAck, exclusive, noLocal, noWait, args)
 }
 
 /*
@@ -1153,6 +1675,8 @@ Continues deliveries to the returned chan Delivery until Channel.Cancel,
 Connection.Close, Channel.Close, context is cancelled, or an AMQP exception
 occurs. Consumers must range over the chan to ensure all deliveries are
 received. Unreceived deliveries will block all methods on the same connection.
+Use WithOnContextCancel with Channel.ConsumeWithOptions to observe
+context.Cause when the consumer stops because the context is cancelled.
 
 All deliveries in AMQP must be acknowledged.  It is expected of the consumer to
 call Delivery.Ack after it has successfully processed the delivery.  If the
@@ -1195,8 +1719,21 @@ the returned chan.
 When the Channel or Connection is closed, all buffered and inflight messages will
 be dropped. RabbitMQ will requeue messages not acknowledged. In other words, dropped
 messages in this way won't be lost.
+
+Use Channel.ConsumeWithOptions to configure client side behaviour of the
+consumer, like WithMaxBodySize.
 */
 func (ch *Channel) ConsumeWithContext(ctx context.Context, queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table) (<-chan Delivery, error) {
+	return ch.ConsumeWithOptions(ctx, queue, consumer, autoAck, exclusive, noLocal, noWait, args)
+}
+
+/*
+ConsumeWithOptions behaves like Channel.ConsumeWithContext, with ConsumeOption
+values configuring client side behaviour of the consumer, like WithMaxBodySize.
+With a context that is never done, such as context.Background(), it behaves
+like Channel.Consume.
+*/
+func (ch *Channel) ConsumeWithOptions(ctx context.Context, queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table, opts ...ConsumeOption) (<-chan Delivery, error) {
 	// When we return from ch.call, there may be a delivery already for the
 	// consumer that hasn't been added to the consumer hash yet.  Because of
 	// this, we never rely on the server picking a consumer tag for us.
@@ -1223,18 +1760,34 @@ func (ch *Channel) ConsumeWithContext(ctx context.Context, queue, consumer strin
 	select {
 	default:
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return nil, context.Cause(ctx)
 	}
 
 	deliveries := make(chan Delivery)
+	o := newConsumeOptions(ch, queue, autoAck, opts)
+	if o.resubscribe != nil {
+		r := *o.resubscribe
+		r.ctx, r.req = ctx, *req
+		o.resubscribe = &r
+	}
+	if o.nackOnCancel && !autoAck {
+		o.cancelled = ctx.Done()
+	}
+	if o.ackDeadline > 0 && !autoAck {
+		o.clock, o.expire = ch.connection.clock(), ch.expire
+	}
 
-	ch.consumers.add(consumer, deliveries)
+	ch.consumers.add(consumer, deliveries, o)
 
 	if err := ch.call(req, res); err != nil {
 		ch.consumers.cancel(consumer)
 		return nil, err
 	}
 
+	if ctx.Done() == nil {
+		return deliveries, nil
+	}
+
 	go func() {
 		select {
 		case <-ch.consumers.closed:
@@ -1243,12 +1796,69 @@ func (ch *Channel) ConsumeWithContext(ctx context.Context, queue, consumer strin
 			if ch != nil {
 				_ = ch.Cancel(consumer, false)
 			}
+			if o.onCancel != nil {
+				o.onCancel(context.Cause(ctx))
+			}
 		}
 	}()
 
 	return deliveries, nil
 }
 
+// resubscribe consumes again with the consumer cancelled by the server, once
+// its queue exists, see WithResubscribe.
+func (ch *Channel) resubscribe(consumer string, r *resubscription) {
+	if r.onCancel != nil {
+		r.onCancel(consumer)
+	}
+
+	for {
+		timer := ch.connection.clock().NewTimer(r.interval)
+		select {
+		case <-r.ctx.Done():
+			timer.Stop()
+			return
+		case <-ch.close:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		if _, found := ch.consumers.options(consumer); !found {
+			return
+		}
+		if !ch.queueExists(r.req.Queue) {
+			continue
+		}
+
+		req := r.req
+		err := ch.call(&req, &basicConsumeOk{})
+		if err == nil {
+			return
+		}
+		if ch.IsClosed() {
+			ch.log(LevelError, "could not resubscribe consumer", "method", "basic.consume", "consumer", consumer, "error", err)
+			return
+		}
+	}
+}
+
+// queueExists declares the queue passively on a temporary channel, as this
+// closes the channel when the queue does not exist.
+func (ch *Channel) queueExists(queue string) bool {
+	tmp, err := ch.connection.Channel()
+	if err != nil {
+		return false
+	}
+
+	if _, err := tmp.QueueDeclarePassive(queue, false, false, false, false, nil); err != nil {
+		return false
+	}
+
+	_ = tmp.Close()
+	return true
+}
+
 /*
 ExchangeDeclare declares an exchange on the server. If the exchange does not
 already exist, the server will create it.  If the exchange exists, the server
@@ -1306,6 +1916,10 @@ func (ch *Channel) ExchangeDeclare(name, kind string, durable, autoDelete, inter
 		return err
 	}
 
+	if err := ch.validateName("exchange", name, false); err != nil {
+		return err
+	}
+
 	return ch.call(
 		&exchangeDeclare{
 			Exchange:   name,
@@ -1333,6 +1947,10 @@ func (ch *Channel) ExchangeDeclarePassive(name, kind string, durable, autoDelete
 		return err
 	}
 
+	if err := ch.validateName("exchange", name, true); err != nil {
+		return err
+	}
+
 	return ch.call(
 		&exchangeDeclare{
 			Exchange:   name,
@@ -1483,16 +2101,22 @@ confirmations start at 1.  Exit when all publishings are confirmed.
 
 When Publish does not return an error and the channel is in confirm mode, the
 internal counter for DeliveryTags with the first confirmation starts at 1.
+
+Deprecated: Use PublishWithContext instead, or NewContextPublisher to migrate
+code depending on this method.
 */
 func (ch *Channel) Publish(exchange, key string, mandatory, immediate bool, msg Publishing) error {
-	_, err := ch.PublishWithDeferredConfirm(exchange, key, mandatory, immediate, msg)
+	_, err := ch.publish(context.Background(), exchange, key, mandatory, immediate, msg)
 	return err
 }
 
 /*
 PublishWithContext sends a Publishing from the client to an exchange on the server.
 
-NOTE: this function is equivalent to [Channel.Publish]. Context is not honoured.
+NOTE: the context is only honoured until the publishing is written: when ctx is
+done before publishing, while waiting on a connection blocked by the server, or
+while waiting on a channel paused by the server with channel.flow,
+context.Cause(ctx) is returned and nothing is published.
 
 When you want a single message to be delivered to a single queue, you can
 publish to the default exchange with the routingKey of the queue name.  This is
@@ -1523,8 +2147,9 @@ confirmations start at 1.  Exit when all publishings are confirmed.
 When Publish does not return an error and the channel is in confirm mode, the
 internal counter for DeliveryTags with the first confirmation starts at 1.
 */
-func (ch *Channel) PublishWithContext(_ context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) error {
-	return ch.Publish(exchange, key, mandatory, immediate, msg)
+func (ch *Channel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) error {
+	_, err := ch.publish(ctx, exchange, key, mandatory, immediate, msg)
+	return err
 }
 
 /*
@@ -1532,28 +2157,102 @@ PublishWithDeferredConfirm behaves identically to Publish, but additionally
 returns a DeferredConfirmation, allowing the caller to wait on the publisher
 confirmation for this message. If the channel has not been put into confirm
 mode, the DeferredConfirmation will be nil.
+
+When Config.BlockedPublishTimeout is set and the server has blocked the
+connection, the publish waits up to that timeout for the connection to be
+unblocked, and otherwise returns an error wrapping ErrPublishBlocked.
+
+Deprecated: Use PublishWithDeferredConfirmWithContext instead, or
+NewContextPublisher to migrate code depending on this method.
 */
 func (ch *Channel) PublishWithDeferredConfirm(exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
-	if err := msg.Headers.Validate(); err != nil {
+	return ch.publish(context.Background(), exchange, key, mandatory, immediate, msg)
+}
+
+func (ch *Channel) publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	if mw := ch.middlewares(); len(mw) > 0 {
+		return mw.publish(ch.sendPublish)(ctx, exchange, key, mandatory, immediate, msg)
+	}
+	return ch.sendPublish(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func (ch *Channel) sendPublish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	return ch.sendPublishing(ctx, exchange, key, mandatory, immediate, msg, nil, nil)
+}
+
+// sendPublishing publishes msg, as another attempt of prev when not nil.  The
+// properties of msg are written as encoded when not nil, see BoundPublisher.
+func (ch *Channel) sendPublishing(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing, encoded []byte, prev *DeferredConfirmation) (*DeferredConfirmation, error) {
+	// Encoded properties have been validated once.
+	if encoded == nil {
+		if err := msg.Headers.Validate(); err != nil {
+			return nil, err
+		}
+		if err := msg.EncodedHeaders.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+
+	if timeout := ch.connection.Config.BlockedPublishTimeout; timeout > 0 {
+		if err := ch.connection.waitUnblocked(ctx, timeout); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ch.waitFlow(ctx); err != nil {
 		return nil, err
 	}
 
+	if ch.connection.Config.StampPublishedAt {
+		msg.Headers = stampPublishedAt(msg.Headers, ch.connection.clock().Now())
+		encoded = nil
+	}
+
+	if s := ch.stampingOf(); s != nil && s.stamp(&msg, ch.connection.clock().Now()) {
+		encoded = nil
+	}
+
+	ch.confirmM.Lock()
+	confirming := ch.confirming
+	ch.confirmM.Unlock()
+
+	if confirming {
+		if err := ch.confirms.acquire(ctx, ch.close); err != nil {
+			return nil, err
+		}
+	}
+
+	// Publishings waiting for the previous one to be written are pending too.
+	ch.pendingWrites.Add(1)
 	ch.m.Lock()
+	ch.pendingWrites.Add(-1)
 	defer ch.m.Unlock()
 
 	var dc *DeferredConfirmation
-	if ch.confirming {
-		dc = ch.confirms.publish()
+	switch {
+	case ch.confirming && prev != nil:
+		ch.confirms.republish(prev)
+		dc = prev
+	case ch.confirming:
+		if dc = ch.confirms.publish(confirmData(ctx)); dc != nil {
+			ch.confirms.deferredConfirmations.retain(dc, exchange, key, mandatory, immediate, msg, encoded)
+		}
 	}
 
-	if err := ch.send(&basicPublish{
+	headers, encodedHeaders := msg.headers()
+	pub := &basicPublish{
 		Exchange:   exchange,
 		RoutingKey: key,
 		Mandatory:  mandatory,
 		Immediate:  immediate,
 		Body:       msg.Body,
 		Properties: properties{
-			Headers:         msg.Headers,
+			Headers:         headers,
+			encodedHeaders:  encodedHeaders,
 			ContentType:     msg.ContentType,
 			ContentEncoding: msg.ContentEncoding,
 			DeliveryMode:    msg.DeliveryMode,
@@ -1567,27 +2266,74 @@ func (ch *Channel) PublishWithDeferredConfirm(exchange, key string, mandatory, i
 			UserId:          msg.UserId,
 			AppId:           msg.AppId,
 		},
-	}); err != nil {
+	}
+
+	var publishing message = pub
+	if encoded != nil {
+		publishing = &encodedPublish{basicPublish: pub, properties: encoded}
+	}
+
+	if err := ch.send(publishing); err != nil {
 		if ch.confirming {
-			ch.confirms.unpublish()
+			ch.confirms.unpublish(err)
 		}
 		return nil, err
 	}
 
+	if ch.stats != nil {
+		atomic.AddUint64(&ch.stats.published, 1)
+	}
+
 	return dc, nil
 }
 
+// stampPublishedAt returns headers with PublishedAtHeader set to now unless it
+// is already set, without modifying the table of the caller.
+func stampPublishedAt(headers Table, now time.Time) Table {
+	if _, ok := headers[PublishedAtHeader]; ok {
+		return headers
+	}
+
+	stamped := make(Table, len(headers)+1)
+	for k, v := range headers {
+		stamped[k] = v
+	}
+	stamped[PublishedAtHeader] = now.UnixMilli()
+
+	return stamped
+}
+
 /*
 PublishWithDeferredConfirmWithContext behaves identically to Publish but additionally returns a
 DeferredConfirmation, allowing the caller to wait on the publisher confirmation
 for this message. If the channel has not been put into confirm mode,
 the DeferredConfirmation will be nil.
 
-NOTE: PublishWithDeferredConfirmWithContext is equivalent to its non-context variant. The context passed
-to this function is not honoured.
+NOTE: the context is honoured as in PublishWithContext, returning
+context.Cause(ctx) when it is done before the publishing is written.
+*/
+func (ch *Channel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	return ch.publish(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+/*
+PublishWithSeqNo behaves like PublishWithContext and returns the sequence number
+of the publishing, the DeliveryTag of its confirmation.  The number is reserved
+under the same lock as the publishing is written, so unlike calling
+Channel.GetNextPublishSeqNo before publishing, it is the right one when other
+goroutines publish on the channel concurrently.
+
+The sequence number is 0 when the channel is not in confirm mode, when a
+Publish middleware added with Channel.Use did not publish the message, or when
+the channel was put in confirm mode with Channel.ConfirmSampled and the
+publishing is not part of the tracked sample.
 */
-func (ch *Channel) PublishWithDeferredConfirmWithContext(_ context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
-	return ch.PublishWithDeferredConfirm(exchange, key, mandatory, immediate, msg)
+func (ch *Channel) PublishWithSeqNo(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (uint64, error) {
+	dc, err := ch.publish(ctx, exchange, key, mandatory, immediate, msg)
+	if err != nil || dc == nil {
+		return 0, err
+	}
+	return dc.DeliveryTag, nil
 }
 
 /*
@@ -1606,23 +2352,79 @@ delivery.
 When autoAck is true, the server will automatically acknowledge this message so
 you don't have to.  But if you are unable to fully process this message before
 the channel or connection is closed, the message will not get requeued.
+
+The message is passed through the Deliver middleware added with Channel.Use,
+and ok is false when the middleware drops it.
 */
 func (ch *Channel) Get(queue string, autoAck bool) (msg Delivery, ok bool, err error) {
+	return ch.GetWithContext(context.Background(), queue, autoAck)
+}
+
+/*
+GetWithContext behaves like Get, returning context.Cause(ctx) when ctx is done
+before the server answers.  The answer can then no longer be matched to the
+request, so the channel is closed: a message the server sent meanwhile is
+requeued, unless autoAck is true, in which case it is lost.
+*/
+func (ch *Channel) GetWithContext(ctx context.Context, queue string, autoAck bool) (msg Delivery, ok bool, err error) {
 	req := &basicGet{Queue: queue, NoAck: autoAck}
 	res := &basicGetOk{}
 	empty := &basicGetEmpty{}
 
-	if err := ch.call(req, res, empty); err != nil {
+	if err := ch.callContext(ctx, req, res, empty); err != nil {
 		return Delivery{}, false, err
 	}
 
 	if res.DeliveryTag > 0 {
-		return *(newDelivery(ch, res)), true, nil
+		ch.delivered.Store(res.DeliveryTag)
+		if ch.stats != nil {
+			atomic.AddUint64(&ch.stats.delivered, 1)
+		}
+		delivery := *newDelivery(ch, res)
+		if mw := ch.middlewares(); len(mw) > 0 {
+			var kept bool
+			mw.deliver(func(d Delivery) {
+				delivery, kept = d, true
+			})(delivery)
+			if !kept {
+				return Delivery{}, false, nil
+			}
+		}
+		return delivery, true, nil
 	}
 
 	return Delivery{}, false, nil
 }
 
+/*
+PollUntilMessage calls GetWithContext every interval until it returns a
+message, for consumers pulling from a queue without waiting on their own
+timers.  It returns context.Cause(ctx) when ctx is done first, and the error of
+GetWithContext when it fails.
+*/
+func (ch *Channel) PollUntilMessage(ctx context.Context, queue string, autoAck bool, interval time.Duration) (Delivery, error) {
+	for {
+		msg, ok, err := ch.GetWithContext(ctx, queue, autoAck)
+		if err != nil {
+			return Delivery{}, err
+		}
+		if ok {
+			return msg, nil
+		}
+
+		timer := ch.connection.clock().NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Delivery{}, context.Cause(ctx)
+		case <-ch.close:
+			timer.Stop()
+			return Delivery{}, ErrClosed
+		case <-timer.C():
+		}
+	}
+}
+
 /*
 Tx puts the channel into transaction mode on the server.  All publishings and
 acknowledgments following this method will be atomically committed or rolled
@@ -1671,6 +2473,44 @@ func (ch *Channel) TxRollback() error {
 	)
 }
 
+/*
+TxRun puts the channel in transactional mode and runs fn on it, committing the
+publishings and acknowledgments made by fn when it returns nil.  When fn
+returns an error or panics the transaction is rolled back, and the error is
+returned joined with any rollback error, or the panic resumed.
+
+The context applies to selecting and committing the transaction.  Rolling back
+uses the context of the channel, see Channel.WithContext, so that a transaction
+abandoned because ctx is done is still rolled back.
+
+	err := ch.TxRun(ctx, func(ch *amqp.Channel) error {
+		if err := ch.PublishWithContext(ctx, "", "audit", false, false, msg); err != nil {
+			return err
+		}
+		return delivery.Ack(false)
+	})
+
+The channel remains in transactional mode afterwards, as with Channel.Tx.
+*/
+func (ch *Channel) TxRun(ctx context.Context, fn func(*Channel) error) error {
+	if err := ch.callContext(ctx, &txSelect{}, &txSelectOk{}); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			_ = ch.TxRollback()
+			panic(r)
+		}
+	}()
+
+	if err := fn(ch); err != nil {
+		return errors.Join(err, ch.TxRollback())
+	}
+
+	return ch.callContext(ctx, &txCommit{}, &txCommitOk{})
+}
+
 /*
 Flow pauses the delivery of messages to consumers on this channel.  Channels
 are opened with flow control active, to open a channel with paused
@@ -1739,6 +2579,46 @@ func (ch *Channel) Confirm(noWait bool) error {
 	return nil
 }
 
+/*
+SetConfirmWindow limits the number of publishings awaiting their confirmation
+on a channel in confirm mode to n, so that a publisher cannot overrun the
+memory of the server.  Once n publishings are unconfirmed, publishing blocks
+until the server confirms one, returning context.Cause(ctx) if the context of
+the publishing is done first, or ErrClosed if the channel closes.  Give the
+publishings a context with a deadline to have them fail instead of waiting
+indefinitely.
+
+A window of 0, the default, does not limit the publishings.  Publishings made
+before the channel is put in confirm mode are not counted.
+*/
+func (ch *Channel) SetConfirmWindow(n int) {
+	ch.confirms.setWindow(n)
+}
+
+/*
+ConfirmSampled puts the channel into confirm mode like Channel.Confirm, but
+only tracks the confirmation of one in every sampleRate publishings.
+
+The server confirms every publishing once the channel is in confirm mode, as
+AMQP has no way to request a confirmation for a single message.  Sampling
+removes the client side cost of tracking every publishing instead:
+PublishWithDeferredConfirm returns a DeferredConfirmation for every
+sampleRate-th publishing and nil for the others.  Waiting on the sampled
+confirmations gives visibility into broker health and confirm latency at
+throughputs where tracking each publishing would be too costly.
+
+The publishings left out of the sample have no sequence number either:
+PublishWithSeqNo returns 0 for them.  An OutboxRelay needs the confirmation of
+every publishing and returns ErrConfirmSampled on a sampled channel.
+
+Listeners added with Channel.NotifyPublish still receive every confirmation.
+A sampleRate of 0 or 1 tracks every publishing.
+*/
+func (ch *Channel) ConfirmSampled(noWait bool, sampleRate uint64) error {
+	ch.confirms.setSample(sampleRate)
+	return ch.Confirm(noWait)
+}
+
 /*
 Recover redelivers all unacknowledged deliveries on this channel.
 
@@ -1774,13 +2654,25 @@ is true.
 See also Delivery.Ack
 */
 func (ch *Channel) Ack(tag uint64, multiple bool) error {
+	if err := ch.consumers.checkExpired(tag); err != nil {
+		return err
+	}
+
 	ch.m.Lock()
 	defer ch.m.Unlock()
 
-	return ch.send(&basicAck{
+	if err := ch.send(&basicAck{
 		DeliveryTag: tag,
 		Multiple:    multiple,
-	})
+	}); err != nil {
+		return err
+	}
+
+	ch.consumers.acked(tag, multiple)
+	if ch.stats != nil {
+		atomic.AddUint64(&ch.stats.acked, 1)
+	}
+	return nil
 }
 
 /*
@@ -1791,14 +2683,26 @@ it must be redelivered or dropped.
 See also Delivery.Nack
 */
 func (ch *Channel) Nack(tag uint64, multiple, requeue bool) error {
+	if err := ch.consumers.checkExpired(tag); err != nil {
+		return err
+	}
+
 	ch.m.Lock()
 	defer ch.m.Unlock()
 
-	return ch.send(&basicNack{
+	if err := ch.send(&basicNack{
 		DeliveryTag: tag,
 		Multiple:    multiple,
 		Requeue:     requeue,
-	})
+	}); err != nil {
+		return err
+	}
+
+	ch.consumers.acked(tag, multiple)
+	if ch.stats != nil {
+		atomic.AddUint64(&ch.stats.acked, 1)
+	}
+	return nil
 }
 
 /*
@@ -1809,17 +2713,101 @@ multiple messages, reducing the amount of protocol messages to exchange.
 See also Delivery.Reject
 */
 func (ch *Channel) Reject(tag uint64, requeue bool) error {
+	if err := ch.consumers.checkExpired(tag); err != nil {
+		return err
+	}
+
 	ch.m.Lock()
 	defer ch.m.Unlock()
 
-	return ch.send(&basicReject{
+	if err := ch.send(&basicReject{
 		DeliveryTag: tag,
 		Requeue:     requeue,
-	})
+	}); err != nil {
+		return err
+	}
+
+	ch.consumers.acked(tag, false)
+	if ch.stats != nil {
+		atomic.AddUint64(&ch.stats.acked, 1)
+	}
+	return nil
+}
+
+// ErrUnknownDeliveryTag is returned by Channel.AckUpTo and Channel.NackUpTo
+// for a delivery tag the channel has not received yet, which the server would
+// answer by closing the channel.
+var ErrUnknownDeliveryTag = errors.New("unknown delivery tag")
+
+// checkDelivered returns an error unless tag has been delivered on the
+// channel.
+func (ch *Channel) checkDelivered(tag uint64) error {
+	if last := ch.delivered.Load(); tag == 0 || tag > last {
+		return fmt.Errorf("%w: %d, last delivery tag is %d", ErrUnknownDeliveryTag, tag, last)
+	}
+	return nil
+}
+
+/*
+AckUpTo acknowledges every unacknowledged delivery of the channel up to and
+including the delivery tag, which is Ack with multiple true.
+
+An error wrapping ErrUnknownDeliveryTag is returned without sending anything
+when no delivery with the tag has been received on the channel yet.
+
+See also Delivery.AckMultipleThrough
+*/
+func (ch *Channel) AckUpTo(tag uint64) error {
+	if err := ch.checkDelivered(tag); err != nil {
+		return err
+	}
+	return ch.Ack(tag, true)
+}
+
+/*
+NackUpTo negatively acknowledges every unacknowledged delivery of the channel
+up to and including the delivery tag, which is Nack with multiple true.
+
+An error wrapping ErrUnknownDeliveryTag is returned without sending anything
+when no delivery with the tag has been received on the channel yet.
+*/
+func (ch *Channel) NackUpTo(tag uint64, requeue bool) error {
+	if err := ch.checkDelivered(tag); err != nil {
+		return err
+	}
+	return ch.Nack(tag, true, requeue)
+}
+
+// ErrMultipleRejectUnsupported is returned by Channel.NackOrReject when
+// multiple deliveries are to be rejected and the server does not support
+// basic.nack.
+var ErrMultipleRejectUnsupported = errors.New("multiple reject requires basic.nack, which the server does not support")
+
+/*
+NackOrReject negatively acknowledges a delivery by its delivery tag with
+basic.nack when the server advertises the basic.nack capability, and falls
+back to basic.reject otherwise, so that callers do not have to probe
+Connection.Capabilities themselves.
+
+basic.reject cannot reject multiple deliveries at once, so
+ErrMultipleRejectUnsupported is returned without sending anything when
+multiple is true and the server does not support basic.nack.
+
+See also Delivery.NackOrReject
+*/
+func (ch *Channel) NackOrReject(tag uint64, multiple, requeue bool) error {
+	if ch.connection.Capabilities.BasicNack {
+		return ch.Nack(tag, multiple, requeue)
+	}
+	if multiple {
+		return ErrMultipleRejectUnsupported
+	}
+	return ch.Reject(tag, requeue)
 }
 
 // GetNextPublishSeqNo returns the sequence number of the next message to be
-// published, when in confirm mode.
+// published, when in confirm mode.  Another goroutine may publish before the
+// caller does, use Channel.PublishWithSeqNo to learn the number of a publishing.
 func (ch *Channel) GetNextPublishSeqNo() uint64 {
 	ch.confirms.publishedMut.Lock()
 	defer ch.confirms.publishedMut.Unlock()
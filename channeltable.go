@@ -0,0 +1,99 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "sync"
+
+// channelShards is the number of shards of a channelTable.  Channel ids are
+// allocated in sequence, so consecutive ids fall in different shards.
+const channelShards = 32
+
+/*
+channelTable maps the ids of the open channels of a connection to their
+Channel.  It is sharded by channel id, each shard with its own lock, so that
+the connection reader looking up the channel of every frame does not contend
+with the channels being opened and closed, nor serialize on the connection
+mutex.
+
+The id allocator stays under the connection mutex: a channel is added to the
+table after its id is allocated, and removed before its id is released.
+*/
+type channelTable struct {
+	shards [channelShards]channelShard
+}
+
+type channelShard struct {
+	sync.RWMutex
+	channels map[uint16]*Channel
+}
+
+func newChannelTable() *channelTable {
+	t := &channelTable{}
+	for i := range t.shards {
+		t.shards[i].channels = make(map[uint16]*Channel)
+	}
+	return t
+}
+
+func (t *channelTable) shard(id uint16) *channelShard {
+	return &t.shards[id%channelShards]
+}
+
+// get returns the channel with id, false when it is not open.
+func (t *channelTable) get(id uint16) (*Channel, bool) {
+	s := t.shard(id)
+	s.RLock()
+	ch, ok := s.channels[id]
+	s.RUnlock()
+	return ch, ok
+}
+
+func (t *channelTable) add(ch *Channel) {
+	s := t.shard(ch.id)
+	s.Lock()
+	s.channels[ch.id] = ch
+	s.Unlock()
+}
+
+// remove removes ch and returns true when it was in the table, not another
+// channel with its id.
+func (t *channelTable) remove(ch *Channel) bool {
+	s := t.shard(ch.id)
+	s.Lock()
+	defer s.Unlock()
+
+	if got, ok := s.channels[ch.id]; !ok || got != ch {
+		return false
+	}
+	delete(s.channels, ch.id)
+	return true
+}
+
+// clear removes and returns every channel.
+func (t *channelTable) clear() []*Channel {
+	var all []*Channel
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.Lock()
+		for id, ch := range s.channels {
+			all = append(all, ch)
+			delete(s.channels, id)
+		}
+		s.Unlock()
+	}
+	return all
+}
+
+// len returns the number of channels in the table.
+func (t *channelTable) len() int {
+	var n int
+	for i := range t.shards {
+		s := &t.shards[i]
+		s.RLock()
+		n += len(s.channels)
+		s.RUnlock()
+	}
+	return n
+}
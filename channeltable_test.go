@@ -0,0 +1,93 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestChannelTable(t *testing.T) {
+	table := newChannelTable()
+
+	a := &Channel{id: 1}
+	b := &Channel{id: 1 + channelShards} // same shard as a
+	table.add(a)
+	table.add(b)
+
+	if got, ok := table.get(1); !ok || got != a {
+		t.Errorf("expected channel 1, got %v, %v", got, ok)
+	}
+	if got, ok := table.get(b.id); !ok || got != b {
+		t.Errorf("expected channel %d, got %v, %v", b.id, got, ok)
+	}
+	if _, ok := table.get(2); ok {
+		t.Errorf("expected channel 2 not to be found")
+	}
+
+	// A stale channel whose id was reused must not remove the new one.
+	if table.remove(&Channel{id: 1}) {
+		t.Errorf("expected a stale channel not to be removed")
+	}
+	if !table.remove(a) {
+		t.Errorf("expected channel 1 to be removed")
+	}
+	if _, ok := table.get(1); ok {
+		t.Errorf("expected channel 1 to be gone")
+	}
+
+	if want, got := 1, table.len(); want != got {
+		t.Errorf("expected %d channel, got %d", want, got)
+	}
+	if all := table.clear(); len(all) != 1 || all[0] != b {
+		t.Errorf("expected clear to return the remaining channel, got %v", all)
+	}
+	if want, got := 0, table.len(); want != got {
+		t.Errorf("expected an empty table, got %d channels", got)
+	}
+}
+
+func TestChannelTableConcurrentAccess(t *testing.T) {
+	table := newChannelTable()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				ch := &Channel{id: uint16(g*1000 + i + 1)}
+				table.add(ch)
+				if got, ok := table.get(ch.id); !ok || got != ch {
+					t.Errorf("expected channel %d, got %v, %v", ch.id, got, ok)
+					return
+				}
+				table.remove(ch)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if n := table.len(); n != 0 {
+		t.Errorf("expected an empty table, got %d channels", n)
+	}
+}
+
+func BenchmarkChannelTableGet(b *testing.B) {
+	table := newChannelTable()
+	for id := uint16(1); id <= 512; id++ {
+		table.add(&Channel{id: id})
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		var id uint16
+		for pb.Next() {
+			id = id%512 + 1
+			if _, ok := table.get(id); !ok {
+				b.Fatal("missing channel")
+			}
+		}
+	})
+}
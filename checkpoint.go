@@ -0,0 +1,288 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Headers stamped on the copies published by a Checkpoint.
+const (
+	CheckpointIDHeader    = "x-checkpoint-id"
+	CheckpointCountHeader = "x-checkpoint-count"
+	CheckpointTimeHeader  = "x-checkpoint-timestamp"
+	CheckpointStateHeader = "x-checkpoint-state"
+)
+
+var (
+	// ErrCheckpointDone is returned when a Checkpoint is used after it has been
+	// acknowledged or rejected.
+	ErrCheckpointDone = errors.New("checkpoint already completed")
+
+	// ErrCheckpointLost is returned when the copy published by a Checkpoint
+	// could not be fetched back from the processing queue.
+	ErrCheckpointLost = errors.New("checkpoint copy not found in the processing queue")
+)
+
+// CheckpointOptions configures a Checkpoint.
+type CheckpointOptions struct {
+	// Queue is the processing queue receiving the checkpoint copies, published
+	// through the default exchange.  The queue must exist and must not be
+	// shared with other checkpoints running concurrently, declare one durable
+	// queue per worker.
+	Queue string
+
+	// Interval between checkpoints.  It must be shorter than the consumer
+	// timeout of the broker.
+	Interval time.Duration
+
+	// OnError is called from the checkpoint goroutine when a checkpoint fails.
+	// Checkpointing stops after the first error.
+	OnError func(error)
+}
+
+/*
+Checkpoint keeps a long running delivery alive past the broker consumer
+timeout using the checkpoint pattern.
+
+Every interval, a copy of the in-progress message carrying the current state
+in the checkpoint headers is published to the processing queue and fetched
+back unacknowledged with basic.get, after which the previously held delivery
+is acknowledged.  At any time exactly one copy of the message is held
+unacknowledged, and it is never older than the interval, so the broker does not
+close the channel with a delivery acknowledgement timeout.
+
+Should the process die, the held copy is requeued on the processing queue with
+its last state.  Drain the processing queue on startup to resume interrupted
+work from the CheckpointStateHeader.
+
+Complete the work with Checkpoint.Ack or Checkpoint.Nack.
+*/
+type Checkpoint struct {
+	m       sync.Mutex
+	ch      *Channel
+	opts    CheckpointOptions
+	id      string
+	current Delivery
+	count   int32
+	state   string
+	done    bool
+	stop    chan struct{}
+}
+
+/*
+StartCheckpoint starts checkpointing the delivery d on this channel until
+Checkpoint.Ack or Checkpoint.Nack is called.
+
+The delivery can have been received on a different channel.  Checkpoint copies
+are published and fetched on this channel, so prefer a channel dedicated to
+checkpoints: synchronous methods issued concurrently on the same channel from
+other goroutines would interleave with the basic.get of the checkpoint.
+
+The channel is put in confirm mode, so that each copy is confirmed by the
+server, and thus enqueued, before it is fetched back.  A channel put in confirm
+mode with Channel.ConfirmSampled returns ErrConfirmSampled.
+*/
+func (ch *Channel) StartCheckpoint(d Delivery, opts CheckpointOptions) (*Checkpoint, error) {
+	if opts.Interval <= 0 {
+		return nil, errors.New("checkpoint interval must be positive")
+	}
+	if ch.confirms.sampled() {
+		return nil, ErrConfirmSampled
+	}
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{
+		ch:      ch,
+		opts:    opts,
+		id:      hex.EncodeToString(id[:]),
+		current: d,
+		stop:    make(chan struct{}),
+	}
+
+	go cp.run()
+
+	return cp, nil
+}
+
+// SetState records the progress of the work.  It is published with the next
+// checkpoint in the CheckpointStateHeader.
+func (cp *Checkpoint) SetState(state string) {
+	cp.m.Lock()
+	defer cp.m.Unlock()
+
+	cp.state = state
+}
+
+// Delivery returns the delivery currently held by the checkpoint, either the
+// original delivery or the latest checkpoint copy.
+func (cp *Checkpoint) Delivery() Delivery {
+	cp.m.Lock()
+	defer cp.m.Unlock()
+
+	return cp.current
+}
+
+// Ack stops checkpointing and acknowledges the held delivery, completing the
+// work.
+func (cp *Checkpoint) Ack() error {
+	cp.m.Lock()
+	defer cp.m.Unlock()
+
+	if cp.done {
+		return ErrCheckpointDone
+	}
+	cp.finish()
+
+	return cp.current.Ack(false)
+}
+
+// Nack stops checkpointing and negatively acknowledges the held delivery.  When
+// requeue is true and a checkpoint was taken, the message stays on the
+// processing queue with its last recorded state.
+func (cp *Checkpoint) Nack(requeue bool) error {
+	cp.m.Lock()
+	defer cp.m.Unlock()
+
+	if cp.done {
+		return ErrCheckpointDone
+	}
+	cp.finish()
+
+	return cp.current.Nack(false, requeue)
+}
+
+func (cp *Checkpoint) finish() {
+	cp.done = true
+	close(cp.stop)
+}
+
+func (cp *Checkpoint) run() {
+	ticker := cp.ch.connection.clock().NewTicker(cp.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cp.stop:
+			return
+		case <-ticker.C():
+			if err := cp.checkpoint(); err != nil {
+				if err != ErrCheckpointDone && cp.opts.OnError != nil {
+					cp.opts.OnError(err)
+				}
+				return
+			}
+		}
+	}
+}
+
+// checkpoint publishes a copy of the held delivery, takes hold of the copy and
+// then acknowledges the previously held delivery.  The mutex is only held to
+// read and swap the held delivery, so that SetState, Ack and Nack are not held
+// up by the round trips to the server.
+func (cp *Checkpoint) checkpoint() error {
+	cp.m.Lock()
+	if cp.done {
+		cp.m.Unlock()
+		return ErrCheckpointDone
+	}
+
+	d := cp.current
+
+	headers := Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[CheckpointIDHeader] = cp.id
+	headers[CheckpointCountHeader] = cp.count + 1
+	headers[CheckpointTimeHeader] = cp.ch.connection.clock().Now()
+	headers[CheckpointStateHeader] = cp.state
+	cp.m.Unlock()
+
+	dc, err := cp.ch.PublishWithDeferredConfirmWithContext(context.Background(), DefaultExchange, cp.opts.Queue, false, false, Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    Persistent,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	})
+	if err != nil {
+		return err
+	}
+	if dc == nil {
+		return ErrConfirmSampled
+	}
+	if !dc.Wait() {
+		if err := dc.Err(); err != nil {
+			return err
+		}
+		return ErrPublishNacked
+	}
+
+	held, err := cp.fetch()
+	if err != nil {
+		return err
+	}
+
+	cp.m.Lock()
+	defer cp.m.Unlock()
+
+	// Completed while checkpointing: the delivery settled by Ack or Nack
+	// stands for the work, so the copy is dropped.
+	if cp.done {
+		_ = held.Ack(false)
+		return ErrCheckpointDone
+	}
+
+	// The handler may still be reading the body, which must not be returned
+	// to the pool, see Config.PoolDeliveryBodies.
+	d.slab = slabRef{}
+	if err := d.Ack(false); err != nil {
+		return err
+	}
+
+	cp.current = held
+	cp.count++
+
+	return nil
+}
+
+// fetch gets the copy just published, and confirmed, back from the processing
+// queue.
+func (cp *Checkpoint) fetch() (Delivery, error) {
+	held, ok, err := cp.ch.Get(cp.opts.Queue, false)
+	if err != nil {
+		return Delivery{}, err
+	}
+	if !ok {
+		return Delivery{}, ErrCheckpointLost
+	}
+
+	if id, _ := held.Headers[CheckpointIDHeader].(string); id != cp.id {
+		_ = held.Nack(false, true)
+		return Delivery{}, ErrCheckpointLost
+	}
+
+	return held, nil
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckpointRepublishesAndAcksHeldDelivery(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 1)
+	acks := make(chan *basicAck, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		pub := &basicPublish{}
+		srv.recv(1, pub)
+		published <- pub
+
+		// The copy is only fetched once confirmed.
+		srv.send(1, &basicAck{DeliveryTag: 1})
+
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetOk{
+			DeliveryTag: 2,
+			Properties:  pub.Properties,
+			Body:        pub.Body,
+		})
+
+		ack := &basicAck{}
+		srv.recv(1, ack)
+		acks <- ack
+
+		ack = &basicAck{}
+		srv.recv(1, ack)
+		acks <- ack
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	// A pooled body, see Config.PoolDeliveryBodies.
+	body, slab := getSlab(len("work"))
+	copy(body, "work")
+
+	cp, err := ch.StartCheckpoint(Delivery{
+		Acknowledger: ch,
+		DeliveryTag:  1,
+		Body:         body,
+		slab:         slab,
+	}, CheckpointOptions{
+		Queue:    "processing",
+		Interval: 50 * time.Millisecond,
+		OnError:  func(err error) { t.Errorf("unexpected checkpoint error: %v", err) },
+	})
+	if err != nil {
+		t.Fatalf("could not start checkpoint: %v", err)
+	}
+	cp.SetState("halfway")
+
+	pub := <-published
+	if want, got := "processing", pub.RoutingKey; want != got {
+		t.Errorf("expected checkpoint to be published to %q, got %q", want, got)
+	}
+	if want, got := "halfway", pub.Properties.Headers[CheckpointStateHeader]; want != got {
+		t.Errorf("expected checkpoint state %q, got %q", want, got)
+	}
+	if want, got := int32(1), pub.Properties.Headers[CheckpointCountHeader]; want != got {
+		t.Errorf("expected checkpoint count %d, got %v", want, got)
+	}
+
+	if ack := <-acks; ack.DeliveryTag != 1 {
+		t.Errorf("expected the original delivery to be acked after the checkpoint, got tag %d", ack.DeliveryTag)
+	}
+
+	if err := cp.Ack(); err != nil {
+		t.Fatalf("could not ack checkpoint: %v", err)
+	}
+	if slab.s.gen.Load() != slab.gen {
+		t.Error("expected the body the handler is reading not to be returned to the pool")
+	}
+
+	if ack := <-acks; ack.DeliveryTag != 2 {
+		t.Errorf("expected the checkpoint copy to be acked on completion, got tag %d", ack.DeliveryTag)
+	}
+
+	if err := cp.Ack(); err != ErrCheckpointDone {
+		t.Errorf("expected ErrCheckpointDone on a completed checkpoint, got %v", err)
+	}
+}
+
+func TestCheckpointStopsWhenCopyNacked(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 1})
+
+		// No basic.get for a copy that was not enqueued, only the ack
+		// completing the work.
+		srv.recv(1, &basicAck{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	failed := make(chan error, 1)
+
+	cp, err := ch.StartCheckpoint(Delivery{Acknowledger: ch, DeliveryTag: 1}, CheckpointOptions{
+		Queue:    "processing",
+		Interval: 10 * time.Millisecond,
+		OnError:  func(err error) { failed <- err },
+	})
+	if err != nil {
+		t.Fatalf("could not start checkpoint: %v", err)
+	}
+
+	select {
+	case err := <-failed:
+		if !errors.Is(err, ErrPublishNacked) {
+			t.Errorf("expected the nacked copy to fail the checkpoint, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the checkpoint to fail")
+	}
+
+	if err := cp.Ack(); err != nil {
+		t.Fatalf("could not ack checkpoint: %v", err)
+	}
+	if want, got := uint64(1), cp.Delivery().DeliveryTag; want != got {
+		t.Errorf("expected the original delivery %d to stay held, got %d", want, got)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+)
+
+// Headers of the chunks of a publishing split by ChunkPublishings.
+const (
+	ChunkIdHeader    = "x-chunk-id"    // identifies the chunks of a publishing
+	ChunkIndexHeader = "x-chunk-index" // 0 based position of the chunk
+	ChunkCountHeader = "x-chunk-count" // number of chunks of the publishing
+)
+
+/*
+ChunkPublishings returns a Middleware splitting the body of the publishings
+larger than size bytes into chunks of at most size bytes, published in order as
+messages of their own, for servers with a max_message_size policy.  Each chunk
+keeps the properties of the publishing and carries the ChunkIdHeader,
+ChunkIndexHeader and ChunkCountHeader headers.  Publishings of size bytes or
+less are published unchanged.
+
+	ch.Use(amqp.ChunkPublishings(16 << 20))
+
+On a channel in confirm mode the DeferredConfirmation returned is only done
+once every chunk is confirmed, and acknowledged when every chunk is.  When a
+chunk fails to publish, the chunks already published are not recalled: the
+consumers drop the incomplete publishing, see ReassembleChunks.
+*/
+func ChunkPublishings(size int) Middleware {
+	return Middleware{
+		Publish: func(next PublishHandler) PublishHandler {
+			return func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+				if size <= 0 || len(msg.Body) <= size {
+					return next(ctx, exchange, key, mandatory, immediate, msg)
+				}
+
+				var id [16]byte
+				if _, err := rand.Read(id[:]); err != nil {
+					return nil, err
+				}
+
+				count := (len(msg.Body) + size - 1) / size
+				dcs := make([]*DeferredConfirmation, 0, count)
+				for i := 0; i < count; i++ {
+					chunk := msg
+					chunk.Headers = make(Table, len(msg.Headers)+3)
+					for k, v := range msg.Headers {
+						chunk.Headers[k] = v
+					}
+					chunk.Headers[ChunkIdHeader] = hex.EncodeToString(id[:])
+					chunk.Headers[ChunkIndexHeader] = int64(i)
+					chunk.Headers[ChunkCountHeader] = int64(count)
+
+					end := (i + 1) * size
+					if end > len(msg.Body) {
+						end = len(msg.Body)
+					}
+					chunk.Body = msg.Body[i*size : end]
+
+					dc, err := next(ctx, exchange, key, mandatory, immediate, chunk)
+					if err != nil {
+						return nil, err
+					}
+					dcs = append(dcs, dc)
+				}
+				return joinConfirmations(dcs), nil
+			}
+		},
+	}
+}
+
+// joinConfirmations returns a DeferredConfirmation done once all of dcs are,
+// and acknowledged when all of them are.  It returns nil when any is nil, as
+// the channel is not in confirm mode.
+func joinConfirmations(dcs []*DeferredConfirmation) *DeferredConfirmation {
+	for _, dc := range dcs {
+		if dc == nil {
+			return nil
+		}
+	}
+
+	joined := &DeferredConfirmation{
+		DeliveryTag: dcs[0].DeliveryTag,
+		Data:        dcs[0].Data,
+		done:        make(chan struct{}),
+	}
+	go func() {
+		ack := true
+		for _, dc := range dcs {
+			if !dc.Wait() && ack {
+				ack = false
+				joined.err = dc.Err()
+			}
+		}
+		joined.setAck(ack)
+	}()
+	return joined
+}
+
+/*
+ReassembleChunks returns a Middleware joining the chunks of the publishings
+split by ChunkPublishings into a single delivery, handed to the consumer once
+its last chunk arrives.  The delivery has the body of the publishing and the
+properties of its last chunk without the chunk headers.  Acknowledging,
+negatively acknowledging or rejecting it settles every chunk.
+
+The chunks are kept in memory until the last one arrives, and must arrive in
+order: use it on queues with a single consumer, or with the single active
+consumer feature, and a single channel.  A chunk out of sequence drops the
+incomplete publishing: its chunks are rejected without requeue, so that they
+can be dead lettered.  The first chunk of a publishing already in progress,
+redelivered after the channel closed, starts it over.  Deliveries that are not
+chunks are handed over unchanged.
+
+The chunks stay unacknowledged until the last one arrives, so the prefetch
+count of the channel, see Channel.Qos, must be at least the number of chunks
+of a publishing, plus the deliveries the application holds: the server stops
+sending the remaining chunks otherwise, and the consumer waits for them
+forever.  Chunks of a publishing with more chunks than the prefetch count of
+the channel are rejected without requeue, and logged, rather than held.  A
+global prefetch count is not checked.
+
+The Middleware keeps the chunks of the publishings in progress, so use a
+Middleware returned by ReassembleChunks on a single channel.
+*/
+func ReassembleChunks() Middleware {
+	var (
+		m       sync.Mutex
+		pending = make(map[string][]Delivery) // chunks by id
+	)
+
+	return Middleware{
+		Deliver: func(next DeliveryHandler) DeliveryHandler {
+			return func(d Delivery) {
+				id, ok := d.Headers[ChunkIdHeader].(string)
+				if !ok {
+					next(d)
+					return
+				}
+				index, _ := d.Headers[ChunkIndexHeader].(int64)
+				count, _ := d.Headers[ChunkCountHeader].(int64)
+
+				var stale []Delivery
+				m.Lock()
+				chunks := pending[id]
+				if prefetch := chunksPrefetch(d); prefetch > 0 && count > prefetch {
+					delete(pending, id)
+					m.Unlock()
+					logAt(LevelWarn, "rejecting chunks of a publishing exceeding the prefetch count",
+						"chunk_id", id, "chunks", count, "prefetch", prefetch)
+					rejectChunks(append(chunks, d))
+					return
+				}
+				if index == 0 {
+					stale, chunks = chunks, nil
+				}
+				if index != int64(len(chunks)) || count <= index {
+					delete(pending, id)
+					m.Unlock()
+					rejectChunks(append(chunks, d))
+					return
+				}
+				chunks = append(chunks, d)
+				complete := int64(len(chunks)) == count
+				if complete {
+					delete(pending, id)
+				} else {
+					pending[id] = chunks
+				}
+				m.Unlock()
+
+				rejectChunks(stale)
+				if complete {
+					next(joinChunks(chunks))
+				}
+			}
+		},
+	}
+}
+
+// chunksPrefetch returns the prefetch count of the channel d was delivered on,
+// or 0 when there is none or it is unknown.
+func chunksPrefetch(d Delivery) int64 {
+	ch, ok := d.Acknowledger.(*Channel)
+	if !ok {
+		return 0
+	}
+	return int64(ch.prefetchCount.Load())
+}
+
+// rejectChunks rejects, without requeue, the chunks of a publishing that
+// cannot be reassembled.
+func rejectChunks(chunks []Delivery) {
+	for _, chunk := range chunks {
+		if chunk.Acknowledger != nil {
+			_ = chunk.Reject(false)
+		}
+	}
+}
+
+// joinChunks returns the delivery of the publishing split in chunks.
+func joinChunks(chunks []Delivery) Delivery {
+	var size int
+	for _, chunk := range chunks {
+		size += len(chunk.Body)
+	}
+
+	d := chunks[len(chunks)-1]
+	d.Body = make([]byte, 0, size)
+	tags := make([]uint64, len(chunks))
+	for i, chunk := range chunks {
+		d.Body = append(d.Body, chunk.Body...)
+		tags[i] = chunk.DeliveryTag
+		d.Redelivered = d.Redelivered || chunk.Redelivered
+	}
+
+	d.Headers = make(Table, len(d.Headers))
+	for k, v := range chunks[len(chunks)-1].Headers {
+		switch k {
+		case ChunkIdHeader, ChunkIndexHeader, ChunkCountHeader:
+		default:
+			d.Headers[k] = v
+		}
+	}
+
+	if d.Acknowledger != nil {
+		d.Acknowledger = chunksAcknowledger{Acknowledger: d.Acknowledger, tags: tags}
+	}
+	return d
+}
+
+// chunksAcknowledger settles every chunk of a reassembled delivery, whose
+// delivery tag is the one of its last chunk.
+type chunksAcknowledger struct {
+	Acknowledger
+	tags []uint64
+}
+
+func (a chunksAcknowledger) Ack(tag uint64, multiple bool) error {
+	return a.each(tag, func(tag uint64, last bool) error {
+		return a.Acknowledger.Ack(tag, multiple && last)
+	})
+}
+
+func (a chunksAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	return a.each(tag, func(tag uint64, last bool) error {
+		return a.Acknowledger.Nack(tag, multiple && last, requeue)
+	})
+}
+
+func (a chunksAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.each(tag, func(tag uint64, last bool) error {
+		return a.Acknowledger.Reject(tag, requeue)
+	})
+}
+
+// each calls settle with the tags of the chunks when tag is the tag of the
+// reassembled delivery, and with tag alone otherwise.
+func (a chunksAcknowledger) each(tag uint64, settle func(tag uint64, last bool) error) error {
+	if tag != a.tags[len(a.tags)-1] {
+		return settle(tag, true)
+	}
+	for i, chunkTag := range a.tags {
+		if err := settle(chunkTag, i == len(a.tags)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
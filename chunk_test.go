@@ -0,0 +1,205 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+)
+
+func TestChunkPublishingsReassembledByConsumer(t *testing.T) {
+	const tag = "chunks"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 3)
+	settled := make(chan message, 4)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		var chunks []*basicPublish
+		for i := 0; i < 3; i++ {
+			pub := srv.recv(1, &basicPublish{}).(*basicPublish)
+			chunks = append(chunks, pub)
+			published <- pub
+		}
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		for i, pub := range chunks {
+			srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: uint64(i + 1), Properties: pub.Properties, Body: pub.Body})
+		}
+		for i := 0; i < 3; i++ {
+			settled <- srv.recv(1, &basicAck{})
+		}
+
+		// The second chunk of a publishing whose first chunk never came.
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 4, Properties: properties{
+			Headers: Table{ChunkIdHeader: "lost", ChunkIndexHeader: int64(1), ChunkCountHeader: int64(2)},
+		}, Body: []byte("x")})
+		settled <- srv.recv(1, &basicReject{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	ch.Use(ChunkPublishings(4), ReassembleChunks())
+
+	msg := Publishing{ContentType: "text/plain", Headers: Table{"k": "v"}, Body: []byte("0123456789")}
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, msg); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	var id interface{}
+	for i, want := range []string{"0123", "4567", "89"} {
+		pub := <-published
+		if got := string(pub.Body); want != got {
+			t.Errorf("expected chunk %d to be %q, got %q", i, want, got)
+		}
+		headers := pub.Properties.Headers
+		if headers[ChunkIndexHeader] != int64(i) || headers[ChunkCountHeader] != int64(3) || headers["k"] != "v" {
+			t.Errorf("expected the headers of chunk %d of 3 with the publishing headers, got %v", i, headers)
+		}
+		if i > 0 && headers[ChunkIdHeader] != id {
+			t.Errorf("expected the chunks to share their id %v, got %v", id, headers[ChunkIdHeader])
+		}
+		id = headers[ChunkIdHeader]
+		if want, got := "text/plain", pub.Properties.ContentType; want != got {
+			t.Errorf("expected the chunks to keep the content type %q, got %q", want, got)
+		}
+	}
+
+	deliveries, err := ch.Consume("q", tag, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	d := <-deliveries
+	if want, got := "0123456789", string(d.Body); want != got {
+		t.Errorf("expected the reassembled body %q, got %q", want, got)
+	}
+	if _, found := d.Headers[ChunkIdHeader]; found || d.Headers["k"] != "v" {
+		t.Errorf("expected the publishing headers without the chunk headers, got %v", d.Headers)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if ack := (<-settled).(*basicAck); ack.DeliveryTag != uint64(i) || ack.Multiple {
+			t.Errorf("expected chunk %d to be acked alone, got %+v", i, ack)
+		}
+	}
+	if reject := (<-settled).(*basicReject); reject.DeliveryTag != 4 || reject.Requeue {
+		t.Errorf("expected the chunk out of sequence to be rejected without requeue, got %+v", reject)
+	}
+
+	select {
+	case d := <-deliveries:
+		t.Errorf("expected no delivery for the incomplete publishing, got %+v", d)
+	default:
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestReassembleChunksRejectsPublishingsExceedingPrefetch(t *testing.T) {
+	const tag = "chunks"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	rejected := make(chan message, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicQos{})
+		srv.send(1, &basicQosOk{})
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		for i := 0; i < 2; i++ {
+			srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: uint64(i + 1), Properties: properties{
+				Headers: Table{ChunkIdHeader: "big", ChunkIndexHeader: int64(i), ChunkCountHeader: int64(3)},
+			}, Body: []byte("x")})
+			rejected <- srv.recv(1, &basicReject{})
+		}
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	ch.Use(ReassembleChunks())
+
+	if err := ch.Qos(2, 0, false); err != nil {
+		t.Fatalf("could not set the prefetch count: %v", err)
+	}
+
+	deliveries, err := ch.Consume("q", tag, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	for i := 1; i <= 2; i++ {
+		if reject := (<-rejected).(*basicReject); reject.DeliveryTag != uint64(i) || reject.Requeue {
+			t.Errorf("expected chunk %d to be rejected without requeue, got %+v", i, reject)
+		}
+	}
+
+	select {
+	case d := <-deliveries:
+		t.Errorf("expected no delivery for the publishing exceeding the prefetch count, got %+v", d)
+	default:
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestJoinConfirmationsAcksOnceAllAcked(t *testing.T) {
+	c := newConfirms(false)
+	dcs := []*DeferredConfirmation{c.publish(nil), c.publish(nil)}
+
+	joined := joinConfirmations(dcs)
+	c.One(Confirmation{DeliveryTag: 1, Ack: true})
+	select {
+	case <-joined.Done():
+		t.Fatal("expected to wait for every confirmation")
+	default:
+	}
+
+	c.One(Confirmation{DeliveryTag: 2, Ack: false})
+	if joined.Wait() || joined.Err() != ErrPublishNacked {
+		t.Errorf("expected a nacked chunk to nack the publishing, got %v", joined.Err())
+	}
+
+	if joinConfirmations([]*DeferredConfirmation{nil}) != nil {
+		t.Error("expected no confirmation outside of confirm mode")
+	}
+}
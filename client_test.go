@@ -6,10 +6,17 @@
 package amqp091
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -907,3 +914,1481 @@ func TestLeakClosedConsumersIssue264(t *testing.T) {
 		t.Fatalf("expected deliveries channel to be closed immediately when the connection is closed so not to leak the bufferDeliveries goroutine")
 	}
 }
+
+func TestLifecycleHooks(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.channelOpen(2)
+		srv.connectionClose()
+	}()
+
+	var events []string
+	config := defaultConfig()
+	config.OnConnected = func(c *Connection) { events = append(events, "connected") }
+	config.OnClosed = func(c *Connection, err *Error) { events = append(events, "closed") }
+	config.OnChannelOpen = func(ch *Channel) { events = append(events, "channel open") }
+	config.OnChannelClose = func(ch *Channel, err *Error) { events = append(events, "channel close") }
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.Close(); err != nil {
+		t.Fatalf("could not close channel: %v (%s)", ch, err)
+	}
+
+	if _, err := c.Channel(); err != nil {
+		t.Fatalf("could not open channel: %s", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %s", err)
+	}
+
+	want := []string{"connected", "channel open", "channel close", "channel open", "channel close", "closed"}
+	if !reflect.DeepEqual(want, events) {
+		t.Errorf("expected lifecycle events %v, got %v", want, events)
+	}
+}
+
+func TestServerCapabilities(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.expectAMQP()
+		srv.send(0, &connectionStart{
+			VersionMajor: 0,
+			VersionMinor: 9,
+			Mechanisms:   "PLAIN",
+			Locales:      defaultLocale,
+			ServerProperties: Table{
+				"product": "RabbitMQ",
+				"capabilities": Table{
+					"publisher_confirms":     true,
+					"basic.nack":             true,
+					"consumer_cancel_notify": false,
+					"per_consumer_qos":       true,
+				},
+			},
+		})
+		srv.recv(0, &srv.start)
+		srv.connectionTune()
+		srv.recv(0, &connectionOpen{})
+		srv.send(0, &connectionOpenOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	want := ServerCapabilities{
+		PublisherConfirms: true,
+		BasicNack:         true,
+		PerConsumerQos:    true,
+	}
+	if want != c.Capabilities {
+		t.Errorf("expected capabilities %+v, got %+v", want, c.Capabilities)
+	}
+}
+
+func TestBlockedPublishTimeout(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	unblock := make(chan bool)
+	done := make(chan bool)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.send(0, &connectionBlocked{Reason: "low on memory"})
+		<-unblock
+		srv.send(0, &connectionUnblocked{})
+
+		srv.recv(1, &basicPublish{})
+		done <- true
+	}()
+
+	cfg := defaultConfig()
+	cfg.BlockedPublishTimeout = 10 * time.Millisecond
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	blockings := c.NotifyBlocked(make(chan Blocking, 1))
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if b := <-blockings; !b.Active {
+		t.Fatalf("expected the connection to be blocked, got %+v", b)
+	}
+
+	err = ch.PublishWithContext(context.TODO(), "", "q", false, false, Publishing{})
+	if !errors.Is(err, ErrPublishBlocked) {
+		t.Fatalf("expected ErrPublishBlocked, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "low on memory") {
+		t.Errorf("expected the blocking reason in the error, got %q", err)
+	}
+
+	unblock <- true
+	if b := <-blockings; b.Active {
+		t.Fatalf("expected the connection to be unblocked, got %+v", b)
+	}
+
+	if err := ch.PublishWithContext(context.TODO(), "", "q", false, false, Publishing{}); err != nil {
+		t.Fatalf("expected publish to succeed once unblocked, got %v", err)
+	}
+
+	<-done
+}
+
+func TestPublishWaitsForPausedFlow(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	resume := make(chan bool)
+	done := make(chan bool)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.send(1, &channelFlow{Active: false})
+		srv.recv(1, &channelFlowOk{})
+		<-resume
+		srv.send(1, &channelFlow{Active: true})
+		srv.recv(1, &channelFlowOk{})
+
+		srv.recv(1, &basicPublish{})
+		done <- true
+	}()
+
+	flows := make(chan bool, 2)
+
+	cfg := defaultConfig()
+	cfg.OnChannelFlow = func(ch *Channel, active bool) { flows <- active }
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if active := <-flows; active {
+		t.Fatal("expected the flow to be paused")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the paused publish to wait for the context, got %v", err)
+	}
+
+	published := make(chan error, 1)
+	go func() {
+		published <- ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{})
+	}()
+
+	select {
+	case err := <-published:
+		t.Fatalf("expected the publish to wait for the flow to resume, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	resume <- true
+	if active := <-flows; !active {
+		t.Fatal("expected the flow to be resumed")
+	}
+
+	if err := <-published; err != nil {
+		t.Fatalf("expected publish to succeed once resumed, got %v", err)
+	}
+
+	<-done
+}
+
+func TestFrameInterceptors(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		pub := &basicPublish{}
+		srv.recv(1, pub)
+		published <- pub
+	}()
+
+	var read []Frame
+
+	cfg := defaultConfig()
+	cfg.ReadFrameInterceptors = []FrameInterceptor{
+		func(f Frame) (Frame, bool) {
+			read = append(read, f)
+			return f, true
+		},
+	}
+	cfg.WriteFrameInterceptors = []FrameInterceptor{
+		func(f Frame) (Frame, bool) {
+			if f.Type == FrameBody {
+				f.Payload = bytes.ToUpper(f.Payload)
+			}
+			return f, true
+		},
+	}
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.PublishWithContext(context.TODO(), "", "q", false, false, Publishing{Body: []byte("hello")}); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+
+	if want, got := "HELLO", string((<-published).Body); want != got {
+		t.Errorf("expected the write interceptor to rewrite the body to %q, got %q", want, got)
+	}
+
+	// connection.start, connection.tune, connection.open-ok and channel.open-ok
+	if want, got := 4, len(read); want != got {
+		t.Fatalf("expected %d frames to be read, got %d", want, got)
+	}
+	if last := read[len(read)-1]; last.Type != FrameMethod || last.Channel != 1 {
+		t.Errorf("expected channel.open-ok on channel 1, got %+v", last)
+	}
+}
+
+// methodPayload returns the wire payload of m for a Frame.
+func methodPayload(t *testing.T, m message) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := (&methodFrame{Method: m}).write(&buf); err != nil {
+		t.Fatalf("could not encode %T: %v", m, err)
+	}
+	raw := buf.Bytes()
+	return raw[7 : len(raw)-1]
+}
+
+func TestWriteFrameInterceptorRewritesMethods(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		pub := &basicPublish{}
+		srv.recv(1, pub)
+		published <- pub
+	}()
+
+	rewritten := methodPayload(t, &basicPublish{Exchange: "audit", RoutingKey: "rewritten"})
+
+	cfg := defaultConfig()
+	cfg.WriteFrameInterceptors = []FrameInterceptor{
+		func(f Frame) (Frame, bool) {
+			// basic.publish is class 60, method 40.
+			if f.Type == FrameMethod && bytes.HasPrefix(f.Payload, []byte{0, 60, 0, 40}) {
+				f.Payload = rewritten
+			}
+			return f, true
+		},
+	}
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.PublishWithContext(context.TODO(), "", "q", false, false, Publishing{Body: []byte("hello")}); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+
+	pub := <-published
+	if pub.Exchange != "audit" || pub.RoutingKey != "rewritten" {
+		t.Errorf("expected the rewritten basic.publish on the wire, got exchange %q and routing key %q", pub.Exchange, pub.RoutingKey)
+	}
+	if want, got := "hello", string(pub.Body); want != got {
+		t.Errorf("expected the body %q to follow the rewritten method, got %q", want, got)
+	}
+}
+
+func TestReadFrameInterceptorRewritesDeliveries(t *testing.T) {
+	const tag = "intercepted"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, RoutingKey: "q", Body: []byte("hello")})
+	}()
+
+	rewritten := methodPayload(t, &basicDeliver{ConsumerTag: tag, DeliveryTag: 42, Redelivered: true, RoutingKey: "rewritten"})
+
+	cfg := defaultConfig()
+	cfg.ReadFrameInterceptors = []FrameInterceptor{
+		func(f Frame) (Frame, bool) {
+			switch {
+			// basic.deliver is class 60, method 60.
+			case f.Type == FrameMethod && bytes.HasPrefix(f.Payload, []byte{0, 60, 0, 60}):
+				f.Payload = rewritten
+			case f.Type == FrameBody:
+				f.Payload = bytes.ToUpper(f.Payload)
+			}
+			return f, true
+		},
+	}
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.Consume("q", tag, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	select {
+	case d := <-deliveries:
+		if d.DeliveryTag != 42 || !d.Redelivered || d.RoutingKey != "rewritten" {
+			t.Errorf("expected the rewritten basic.deliver to be dispatched, got tag %d, redelivered %v and routing key %q", d.DeliveryTag, d.Redelivered, d.RoutingKey)
+		}
+		if want, got := "HELLO", string(d.Body); want != got {
+			t.Errorf("expected the read interceptor to rewrite the body to %q, got %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the intercepted delivery")
+	}
+}
+
+func TestWarmChannels(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	warmed := make(chan bool)
+	refilled := make(chan bool)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.channelOpen(2)
+		close(warmed)
+
+		srv.channelOpen(3)
+		close(refilled)
+	}()
+
+	cfg := defaultConfig()
+	cfg.WarmChannels = 2
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	<-warmed
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not get warm channel: %v (%s)", ch, err)
+	}
+	if want, got := uint16(1), ch.id; want != got {
+		t.Errorf("expected warm channel %d, got %d", want, got)
+	}
+
+	select {
+	case <-refilled:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the warm pool to be refilled")
+	}
+}
+
+// acceptChannelOpens opens the next n channels the client opens, in whatever
+// order they come.
+func (t *server) acceptChannelOpens(n int) {
+	for i := 0; i < n; i++ {
+		f, err := t.r.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame err, read: %s", err)
+		}
+		if _, ok := f.(*heartbeatFrame); ok {
+			i--
+			continue
+		}
+		mf, ok := f.(*methodFrame)
+		if !ok {
+			t.Fatalf("expected a method frame, got %T", f)
+		}
+		if _, ok := mf.Method.(*channelOpen); !ok {
+			t.Fatalf("expected channel.open, got %T", mf.Method)
+		}
+		t.send(int(mf.ChannelId), &channelOpenOk{})
+	}
+}
+
+// waitWarmChannels waits until the warm pool of c holds n channels.
+func waitWarmChannels(t *testing.T, c *Connection, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for len(c.warm) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d warm channels, got %d", n, len(c.warm))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWarmChannelsReplacesClosedChannel(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	closed := make(chan bool)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.send(1, &channelClose{ReplyCode: InternalError})
+		srv.recv(1, &channelCloseOk{})
+		close(closed)
+
+		// The replacement of the closed warm channel and the one returned.
+		srv.acceptChannelOpens(2)
+	}()
+
+	cfg := defaultConfig()
+	cfg.WarmChannels = 1
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	<-closed
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+	if ch.IsClosed() {
+		t.Error("expected the closed warm channel not to be returned")
+	}
+
+	waitWarmChannels(t, c, 1)
+}
+
+func TestWarmChannelsRetriesFailedOpen(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	failed := make(chan bool)
+
+	go func() {
+		srv.connectionOpen()
+
+		srv.recv(1, &channelOpen{})
+		srv.send(1, &channelClose{ReplyCode: ResourceError})
+		srv.recv(1, &channelCloseOk{})
+		close(failed)
+
+		// The retried warm channel and the one returned.
+		srv.acceptChannelOpens(2)
+	}()
+
+	cfg := defaultConfig()
+	cfg.WarmChannels = 1
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	<-failed
+
+	if _, err := c.Channel(); err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	waitWarmChannels(t, c, 1)
+}
+
+func TestDialUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "amqp.sock")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan bool)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		close(accepted)
+	}()
+
+	var network, addr string
+	_, err = DialConfig("amqp+unix://"+path, Config{
+		OnDialing: func(n, a string) { network, addr = n, a },
+	})
+	if err == nil {
+		t.Fatal("expected the handshake to fail against a closed socket")
+	}
+
+	<-accepted
+	if network != "unix" || addr != path {
+		t.Errorf("expected to dial unix %q, dialed %s %q", path, network, addr)
+	}
+}
+
+type deadlineRecorder struct {
+	io.ReadWriteCloser
+	writeDeadlines chan time.Time
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(t time.Time) error {
+	select {
+	case d.writeDeadlines <- t:
+	default:
+	}
+	return nil
+}
+
+func TestWriteTimeoutSetsWriteDeadline(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.recv(1, &basicPublish{})
+	}()
+
+	cfg := defaultConfig()
+	cfg.WriteTimeout = time.Minute
+
+	conn := &deadlineRecorder{ReadWriteCloser: rwc, writeDeadlines: make(chan time.Time, 1)}
+	c, err := Open(conn, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	// Drain the deadlines set during the handshake.
+	select {
+	case <-conn.writeDeadlines:
+	default:
+	}
+
+	before := time.Now()
+	if err := ch.PublishWithContext(context.TODO(), "", "q", false, false, Publishing{}); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+
+	select {
+	case deadline := <-conn.writeDeadlines:
+		if deadline.Before(before.Add(cfg.WriteTimeout)) {
+			t.Errorf("expected the write deadline to be at least %s ahead, got %s", cfg.WriteTimeout, deadline.Sub(before))
+		}
+	default:
+		t.Fatal("expected a write deadline to be set before publishing")
+	}
+}
+
+func TestCloseContextTearsDownUnresponsiveServer(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+
+		// Never answer with connection.close-ok
+		srv.recv(0, &connectionClose{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := c.CloseContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if !c.IsClosed() {
+		t.Fatal("expected connection to be closed")
+	}
+}
+
+func TestPublishWithContextReturnsCancelCause(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deadline := errors.New("request deadline")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(deadline)
+
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{}); err != deadline {
+		t.Errorf("expected publish to return the cancel cause, got %v", err)
+	}
+}
+
+func TestDialMultipleHostsTriesInOrder(t *testing.T) {
+	var dialed []string
+	refused := errors.New("connection refused")
+
+	_, err := DialConfig("amqp://host1,host2:5673,host3/", Config{
+		Dial: func(network, addr string) (net.Conn, error) {
+			dialed = append(dialed, addr)
+			return nil, refused
+		},
+	})
+	if err != refused {
+		t.Fatalf("expected the last dial error, got %v", err)
+	}
+
+	want := []string{"host1:5672", "host2:5673", "host3:5672"}
+	if !reflect.DeepEqual(dialed, want) {
+		t.Errorf("expected to dial %v in order, dialed %v", want, dialed)
+	}
+}
+
+func TestChannelResponseDoesNotBlockOtherChannels(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.channelOpen(2)
+
+		// A response nobody on channel 1 is waiting for yet must not hold up
+		// the response to channel 2.
+		srv.send(1, &queueDeclareOk{Queue: "q1"})
+
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &queueDeclareOk{Queue: "q2"})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	if _, err := c.Channel(); err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	ch2, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	declared := make(chan error, 1)
+	go func() {
+		_, err := ch2.QueueDeclare("q2", false, false, false, false, nil)
+		declared <- err
+	}()
+
+	select {
+	case err := <-declared:
+		if err != nil {
+			t.Fatalf("could not declare queue: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("response to channel 2 was blocked behind channel 1")
+	}
+}
+
+func TestChannelDropsResponsesOfAbandonedCalls(t *testing.T) {
+	ch := &Channel{}
+
+	// Abandoned before its response arrives.
+	ch.abandon()
+	ch.respond(&queueDeclareOk{Queue: "late"})
+	if msg, responded := ch.response(); responded == nil {
+		t.Fatalf("expected the late response to be dropped, got %#v", msg)
+	}
+
+	// Abandoned once its response is already queued.
+	ch.respond(&queueDeclareOk{Queue: "queued"})
+	ch.abandon()
+
+	ch.respond(&queueDeclareOk{Queue: "next"})
+	msg, responded := ch.response()
+	if responded != nil {
+		t.Fatal("expected the response to the following call to be queued")
+	}
+	if ok, _ := msg.(*queueDeclareOk); ok == nil || ok.Queue != "next" {
+		t.Errorf("expected the response to the following call, got %#v", msg)
+	}
+}
+
+func TestCloseWithCode(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	channelClosed := make(chan *channelClose, 1)
+	connectionClosed := make(chan *connectionClose, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		chClose := &channelClose{}
+		srv.recv(1, chClose)
+		srv.send(1, &channelCloseOk{})
+		channelClosed <- chClose
+
+		connClose := &connectionClose{}
+		srv.recv(0, connClose)
+		srv.send(0, &connectionCloseOk{})
+		connectionClosed <- connClose
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.CloseWithCode(PreconditionFailed, "invalid state"); err != nil {
+		t.Fatalf("could not close channel: %v", err)
+	}
+	if m := <-channelClosed; m.ReplyCode != PreconditionFailed || m.ReplyText != "invalid state" {
+		t.Errorf("expected channel.close with the given code and reason, got %d %q", m.ReplyCode, m.ReplyText)
+	}
+
+	if err := c.CloseWithCode(ConnectionForced, "shutting down for deploy"); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	if m := <-connectionClosed; m.ReplyCode != ConnectionForced || m.ReplyText != "shutting down for deploy" {
+		t.Errorf("expected connection.close with the given code and reason, got %d %q", m.ReplyCode, m.ReplyText)
+	}
+}
+
+func TestCloseReasonTellsReasonsApart(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+
+		srv.channelOpen(1)
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "no queue 'q'"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(2)
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+
+		srv.channelOpen(3)
+		srv.S.Close()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	byServer, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	<-byServer.NotifyClose(make(chan *Error, 1))
+
+	byClient, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := byClient.Close(); err != nil {
+		t.Fatalf("could not close channel: %v", err)
+	}
+
+	lost, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	<-c.NotifyClose(make(chan *Error, 1))
+
+	tests := []struct {
+		name   string
+		err    error
+		reason error
+		want   error
+	}{
+		{"channel closed by server", byServer.Qos(1, 0, false), byServer.CloseReason(), ErrChannelClosedByServer},
+		{"channel closed by client", byClient.Qos(1, 0, false), byClient.CloseReason(), ErrClosedByClient},
+		{"channel of lost connection", lost.Qos(1, 0, false), lost.CloseReason(), ErrConnectionLost},
+		{"lost connection", c.UpdateSecret("s", "r"), c.CloseReason(), ErrConnectionLost},
+	}
+
+	for _, tt := range tests {
+		if tt.err != ErrClosed {
+			t.Errorf("%s: expected ErrClosed, got %v", tt.name, tt.err)
+		}
+		if tt.reason != tt.want {
+			t.Errorf("%s: expected the close reason %v, got %v", tt.name, tt.want, tt.reason)
+		}
+		if !errors.Is(tt.reason, ErrClosed) {
+			t.Errorf("%s: expected %v to match ErrClosed", tt.name, tt.reason)
+		}
+	}
+}
+
+func TestPollUntilMessage(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetEmpty{})
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetEmpty{})
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetOk{DeliveryTag: 1, Body: []byte("ready")})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	msg, err := ch.PollUntilMessage(context.Background(), "q", true, time.Millisecond)
+	if err != nil {
+		t.Fatalf("poll error: %v", err)
+	}
+	if want, got := "ready", string(msg.Body); want != got {
+		t.Errorf("expected body %q, got %q", want, got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestGetWithContextClosesChannelWhenAbandoned(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicGet{})
+		srv.recv(1, &channelClose{})
+
+		// The answer to the abandoned basic.get crosses the channel.close.
+		srv.send(1, &basicGetOk{DeliveryTag: 1, Body: []byte("late")})
+		srv.send(1, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	closes := ch.NotifyClose(make(chan *Error, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, _, err := ch.GetWithContext(ctx, "q", false); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if _, ok := <-closes; ok {
+		t.Error("expected the channel to be closed gracefully")
+	}
+	if _, _, err := ch.Get("q", false); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected the channel to be closed, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestChannelWithContextAbortsPendingCalls(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &queueDeclare{})
+		cancel()
+
+		srv.recv(1, &channelClose{})
+		srv.send(1, &queueDeclareOk{Queue: "late"})
+		srv.send(1, &channelCloseOk{})
+
+		srv.channelOpen(2)
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	closes := ch.WithContext(ctx).NotifyClose(make(chan *Error, 1))
+
+	if _, err := ch.QueueDeclare("q", false, false, false, false, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the declaration to be cancelled, got %v", err)
+	}
+	<-closes
+
+	ch, err = c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	ch.WithContext(ctx)
+
+	if err := ch.Qos(1, 0, false); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled context to be returned without a call, got %v", err)
+	}
+	if err := ch.Close(); err != nil {
+		t.Errorf("expected Close to ignore the cancelled context, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestOpenUnlimitedChannelMax(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.expectAMQP()
+		srv.connectionStart()
+		srv.send(0, &connectionTune{FrameMax: 20000, Heartbeat: 10})
+		srv.recv(0, &srv.tune)
+		srv.recv(0, &connectionOpen{})
+		srv.send(0, &connectionOpenOk{})
+
+		for id := uint16(1); id <= defaultChannelMax+1; id++ {
+			srv.channelOpen(int(id))
+		}
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	if want, got := uint16(0), srv.tune.ChannelMax; want != got {
+		t.Errorf("expected the unlimited channel-max to be tuned, got %d", got)
+	}
+	if want, got := maxChannelMax, c.ChannelMax(); want != got {
+		t.Errorf("expected an effective limit of %d channels, got %d", want, got)
+	}
+	if want, got := uint16(maxChannelMax), c.Config.ChannelMax; want != got {
+		t.Errorf("expected Config.ChannelMax to report the effective limit %d, got %d", want, got)
+	}
+
+	for i := 0; i <= int(defaultChannelMax); i++ {
+		if _, err := c.Channel(); err != nil {
+			t.Fatalf("expected channel %d to be opened beyond the default limit, got %v", i+1, err)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConsumeWithResubscribeAfterServerCancel(t *testing.T) {
+	const tag = "resubscribed"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	received := make(chan struct{})
+	consumes := make(chan *basicConsume, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		consumes <- srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+		<-received
+
+		srv.send(1, &basicCancel{ConsumerTag: tag, NoWait: true})
+
+		// The queue does not exist yet.
+		srv.channelOpen(2)
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &channelClose{ReplyCode: NotFound, ReplyText: "no queue 'q'"})
+		srv.recv(2, &channelCloseOk{})
+
+		srv.channelOpen(3)
+		srv.recv(3, &queueDeclare{})
+		srv.send(3, &queueDeclareOk{Queue: "q"})
+		srv.recv(3, &channelClose{})
+		srv.send(3, &channelCloseOk{})
+
+		consumes <- srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	cancels := make(chan string, 1)
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "q", tag, false, false, false, false, Table{"x-priority": 1},
+		WithResubscribe(5*time.Millisecond, func(consumer string) { cancels <- consumer }))
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	<-deliveries
+	close(received)
+
+	second, ok := <-deliveries
+	if !ok || second.DeliveryTag != 2 {
+		t.Fatalf("expected the deliveries to resume on the same chan, got %+v, %v", second, ok)
+	}
+	if want, got := tag, <-cancels; want != got {
+		t.Errorf("expected the cancel of %q to be observed, got %q", want, got)
+	}
+
+	before, after := <-consumes, <-consumes
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("expected the consumer to be re-registered with %+v, got %+v", before, after)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestPublishWithSeqNoConcurrently(t *testing.T) {
+	const publishers = 20
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	written := make(chan []string, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		var bodies []string
+		for i := 0; i < publishers; i++ {
+			bodies = append(bodies, string(srv.recv(1, &basicPublish{}).(*basicPublish).Body))
+		}
+		written <- bodies
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("confirm error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	seqNos := make(map[uint64]string)
+
+	for i := 0; i < publishers; i++ {
+		body := fmt.Sprint("msg-", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := ch.PublishWithSeqNo(context.Background(), "", "q", false, false, Publishing{Body: []byte(body)})
+			if err != nil {
+				t.Errorf("publish error: %v", err)
+				return
+			}
+			m.Lock()
+			seqNos[n] = body
+			m.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for i, body := range <-written {
+		if want, got := body, seqNos[uint64(i+1)]; want != got {
+			t.Errorf("expected sequence number %d to be returned for %q, got it for %q", i+1, want, got)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestTxRunCommitsOrRollsBack(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &txSelect{})
+		srv.send(1, &txSelectOk{})
+		srv.recv(1, &basicPublish{})
+		srv.recv(1, &txCommit{})
+		srv.send(1, &txCommitOk{})
+
+		srv.recv(1, &txSelect{})
+		srv.send(1, &txSelectOk{})
+		srv.recv(1, &txRollback{})
+		srv.send(1, &txRollbackOk{})
+
+		srv.recv(1, &txSelect{})
+		srv.send(1, &txSelectOk{})
+		srv.recv(1, &txRollback{})
+		srv.send(1, &txRollbackOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	ctx := context.Background()
+
+	err = ch.TxRun(ctx, func(ch *Channel) error {
+		return ch.PublishWithContext(ctx, "", "q", false, false, Publishing{Body: []byte("tx")})
+	})
+	if err != nil {
+		t.Fatalf("expected the transaction to be committed, got %v", err)
+	}
+
+	failed := errors.New("failed")
+	if err := ch.TxRun(ctx, func(*Channel) error { return failed }); !errors.Is(err, failed) {
+		t.Errorf("expected the error of the callback after rolling back, got %v", err)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("expected the panic to be resumed after rolling back, got %v", r)
+			}
+		}()
+		_ = ch.TxRun(ctx, func(*Channel) error { panic("boom") })
+	}()
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestNackOrRejectFollowsServerCapabilities(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	rejects := make(chan *basicReject, 1)
+	nacks := make(chan *basicNack, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		rejects <- srv.recv(1, &basicReject{}).(*basicReject)
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	if err := ch.NackOrReject(1, true, false); !errors.Is(err, ErrMultipleRejectUnsupported) {
+		t.Errorf("expected ErrMultipleRejectUnsupported without basic.nack, got %v", err)
+	}
+
+	if err := (Delivery{Acknowledger: ch, DeliveryTag: 1}).NackOrReject(false, true); err != nil {
+		t.Fatalf("reject error: %v", err)
+	}
+	if r := <-rejects; r.DeliveryTag != 1 || !r.Requeue {
+		t.Errorf("expected basic.reject of delivery 1 with requeue, got %+v", r)
+	}
+
+	c.Capabilities.BasicNack = true
+
+	if err := ch.NackOrReject(2, true, false); err != nil {
+		t.Fatalf("nack error: %v", err)
+	}
+	if n := <-nacks; n.DeliveryTag != 2 || !n.Multiple || n.Requeue {
+		t.Errorf("expected basic.nack of deliveries up to 2, got %+v", n)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestAckUpToValidatesDeliveryTag(t *testing.T) {
+	const tag = "batch"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	acks := make(chan *basicAck, 1)
+	nacks := make(chan *basicNack, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		for i := uint64(1); i <= 3; i++ {
+			srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: i})
+		}
+
+		acks <- srv.recv(1, &basicAck{}).(*basicAck)
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	if err := ch.AckUpTo(1); !errors.Is(err, ErrUnknownDeliveryTag) {
+		t.Errorf("expected ErrUnknownDeliveryTag before any delivery, got %v", err)
+	}
+
+	deliveries, err := ch.Consume("q", tag, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	var batch []Delivery
+	for i := 0; i < 3; i++ {
+		batch = append(batch, <-deliveries)
+	}
+
+	if err := ch.NackUpTo(4, true); !errors.Is(err, ErrUnknownDeliveryTag) {
+		t.Errorf("expected ErrUnknownDeliveryTag past the last delivery, got %v", err)
+	}
+	if err := ch.AckUpTo(0); !errors.Is(err, ErrUnknownDeliveryTag) {
+		t.Errorf("expected ErrUnknownDeliveryTag for tag 0, got %v", err)
+	}
+
+	if err := batch[1].AckMultipleThrough(); err != nil {
+		t.Fatalf("ack error: %v", err)
+	}
+	if a := <-acks; a.DeliveryTag != 2 || !a.Multiple {
+		t.Errorf("expected a multiple ack through 2, got %+v", a)
+	}
+
+	if err := ch.NackUpTo(3, false); err != nil {
+		t.Fatalf("nack error: %v", err)
+	}
+	if n := <-nacks; n.DeliveryTag != 3 || !n.Multiple || n.Requeue {
+		t.Errorf("expected a multiple nack through 3, got %+v", n)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConfigBufferSizes(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &queueDeclareOk{Queue: "q"})
+
+		srv.connectionClose()
+	}()
+
+	config := defaultConfig()
+	config.ReadBufferSize = 16
+	config.WriteBufferSize = 64 * 1024
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	if want, got := 64*1024, c.writer.w.(*bufio.Writer).Size(); want != got {
+		t.Errorf("expected a write buffer of %d bytes, got %d", want, got)
+	}
+
+	// Frames larger than the read buffer are read through it.
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if _, err := ch.QueueDeclare("q", false, false, false, false, nil); err != nil {
+		t.Fatalf("could not declare queue: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+
+	if want, got := defaultBufferSize, bufferSize(0); want != got {
+		t.Errorf("expected the default buffer size %d, got %d", want, got)
+	}
+}
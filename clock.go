@@ -0,0 +1,68 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "time"
+
+/*
+Clock is the source of time used by a Connection to schedule heartbeats,
+detect missed server heartbeats and time out blocked publishings, and by its
+channels to expire ack deadlines, schedule reports, checkpoints and recovery
+attempts, and measure the age and wait of deliveries, see Config.Clock.
+
+Tests can provide a Clock they advance by hand to simulate heartbeat expiry
+and recovery deterministically, without waiting for real time to pass.
+*/
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+}
+
+// Ticker delivers the ticks of a Clock, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer delivers a single tick of a Clock, like time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// SystemClock is the Clock used when Config.Clock is nil, backed by the time
+// package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTicker struct{ t *time.Ticker }
+
+func (t systemTicker) C() <-chan time.Time { return t.t.C }
+func (t systemTicker) Stop()               { t.t.Stop() }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+func (t systemTimer) Stop() bool          { return t.t.Stop() }
+
+// clock returns the configured Clock of the connection.
+func (c *Connection) clock() Clock {
+	if c.Config.Clock != nil {
+		return c.Config.Clock
+	}
+	return SystemClock
+}
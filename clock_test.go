@@ -0,0 +1,241 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when advanced, firing the tickers and timers that are
+// due at most once per advance, like time.Ticker drops ticks.
+type fakeClock struct {
+	m      sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c       chan time.Time
+	next    time.Time
+	period  time.Duration // zero for timers
+	stopped bool
+	clock   *fakeClock
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) add(d, period time.Duration) *fakeTimer {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	t := &fakeTimer{c: make(chan time.Time, 1), next: f.now.Add(d), period: period, clock: f}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+func (f *fakeClock) NewTicker(d time.Duration) Ticker { return fakeTicker{f.add(d, d)} }
+func (f *fakeClock) NewTimer(d time.Duration) Timer   { return f.add(d, 0) }
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	f.now = f.now.Add(d)
+	for _, t := range f.timers {
+		if t.stopped || t.next.After(f.now) {
+			continue
+		}
+
+		select {
+		case t.c <- f.now:
+		default:
+		}
+
+		if t.period == 0 {
+			t.stopped = true
+			continue
+		}
+		for !t.next.After(f.now) {
+			t.next = t.next.Add(t.period)
+		}
+	}
+}
+
+// waitTimers waits until n tickers or timers have been created.
+func (f *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		f.m.Lock()
+		created := len(f.timers)
+		f.m.Unlock()
+
+		if created >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d timers", n)
+}
+
+type fakeTicker struct{ *fakeTimer }
+
+func (t fakeTicker) Stop() { t.fakeTimer.Stop() }
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.m.Lock()
+	defer t.clock.m.Unlock()
+
+	active := !t.stopped
+	t.stopped = true
+	return active
+}
+
+func TestHeartbeatSentWhenIdleWithClock(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	heartbeat := make(chan frame, 1)
+
+	go func() {
+		srv.connectionOpen()
+
+		f, err := srv.r.ReadFrame()
+		if err != nil {
+			t.Errorf("read error: %v", err)
+		}
+		heartbeat <- f
+
+		srv.connectionClose()
+	}()
+
+	clock := newFakeClock()
+	config := defaultConfig()
+	config.Clock = clock
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	// The server tunes a 10s heartbeat, sent every 5s when idle.
+	clock.waitTimers(t, 1)
+	clock.Advance(5 * time.Second)
+
+	select {
+	case f := <-heartbeat:
+		if _, ok := f.(*heartbeatFrame); !ok {
+			t.Errorf("expected a heartbeat frame, got %T", f)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the heartbeat")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestMissedHeartbeatsCloseConnectionWithClock(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+
+		// A silent server that drains the heartbeats of the client.
+		for {
+			if _, err := srv.r.ReadFrame(); err != nil {
+				return
+			}
+		}
+	}()
+
+	clock := newFakeClock()
+	config := defaultConfig()
+	config.Clock = clock
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+	closes := c.NotifyClose(make(chan *Error, 1))
+
+	clock.waitTimers(t, 1)
+
+	// Three missed 5s heartbeats close the connection.  Advancing more than
+	// once covers the last read from the handshake being observed late.
+	for i := 0; i < 5; i++ {
+		clock.Advance(20 * time.Second)
+
+		select {
+		case err := <-closes:
+			if err == nil || !strings.Contains(err.Reason, "missed heartbeats") {
+				t.Fatalf("expected a missed heartbeats error, got %v", err)
+			}
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	t.Fatal("expected the connection to close after missed heartbeats")
+}
+
+func TestBlockedPublishTimeoutWithClock(t *testing.T) {
+	clock := newFakeClock()
+	c := &Connection{
+		Config:    Config{Clock: clock},
+		close:     make(chan struct{}),
+		unblocked: make(chan struct{}),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.waitUnblocked(context.Background(), time.Minute)
+	}()
+
+	clock.waitTimers(t, 1)
+	clock.Advance(time.Minute)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrPublishBlocked) {
+			t.Errorf("expected ErrPublishBlocked, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the blocked publish to time out on the clock")
+	}
+}
+
+func TestDeliveryAgeWithClock(t *testing.T) {
+	clock := newFakeClock()
+	ch := &Channel{connection: &Connection{Config: Config{Clock: clock}}}
+
+	d := Delivery{Acknowledger: ch, Timestamp: clock.Now(), Expiration: "60000"}
+	clock.Advance(time.Minute - time.Second)
+
+	if age, ok := d.Age(); !ok || age != time.Minute-time.Second {
+		t.Errorf("expected the age to be read from the clock, got %v", age)
+	}
+	if want, got := "1000", d.Reply(Publishing{}).Expiration; want != got {
+		t.Errorf("expected the reply expiration %q left on the clock, got %q", want, got)
+	}
+}
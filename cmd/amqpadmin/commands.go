@@ -0,0 +1,294 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func runDeclare(ctx context.Context, conn *amqp.Connection, args []string) error {
+	fs := newFlagSet("declare")
+	path := fs.String("spec", "", "YAML topology spec")
+	concurrency := fs.Int("concurrency", 4, "channels declaring at once")
+	_ = fs.Parse(args)
+
+	topo, err := loadSpecFile(*path)
+	if err != nil {
+		return err
+	}
+
+	if err := amqp.ApplyTopologyParallel(ctx, conn, topo, *concurrency); err != nil {
+		return err
+	}
+
+	fmt.Printf("declared %d exchanges, %d queues, %d bindings\n",
+		len(topo.Exchanges), len(topo.Queues), len(topo.Bindings))
+	return nil
+}
+
+// runDelete removes the topology of a spec in the reverse order of its
+// declaration.  Missing entities close the channel, so each deletion uses a
+// channel of its own.
+func runDelete(ctx context.Context, conn *amqp.Connection, args []string) error {
+	fs := newFlagSet("delete")
+	path := fs.String("spec", "", "YAML topology spec")
+	ifUnused := fs.Bool("if-unused", false, "only delete queues and exchanges without consumers or bindings")
+	_ = fs.Parse(args)
+
+	topo, err := loadSpecFile(*path)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	do := func(desc string, f func(ch *amqp.Channel) error) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ch, err := conn.Channel()
+		if err == nil {
+			err = f(ch)
+			_ = ch.Close()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", desc, err))
+			return
+		}
+		fmt.Println("deleted", desc)
+	}
+
+	for _, b := range topo.Bindings {
+		b := b
+		do(fmt.Sprintf("binding %s -> %s (%s)", b.Exchange, b.Queue, b.Key), func(ch *amqp.Channel) error {
+			return ch.QueueUnbind(b.Queue, b.Key, b.Exchange, b.Args)
+		})
+	}
+	for _, q := range topo.Queues {
+		q := q
+		do("queue "+q.Name, func(ch *amqp.Channel) error {
+			_, err := ch.QueueDelete(q.Name, *ifUnused, false, false)
+			return err
+		})
+	}
+	for _, e := range topo.Exchanges {
+		e := e
+		do("exchange "+e.Name, func(ch *amqp.Channel) error {
+			return ch.ExchangeDelete(e.Name, *ifUnused, false)
+		})
+	}
+
+	if ctx.Err() != nil {
+		errs = append(errs, context.Cause(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+func runDrain(ctx context.Context, conn *amqp.Connection, args []string) error {
+	fs := newFlagSet("drain")
+	queue := fs.String("queue", "", "queue to purge")
+	_ = fs.Parse(args)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	n, err := ch.QueuePurge(*queue, false)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("purged %d messages from %s\n", n, *queue)
+	return nil
+}
+
+// runMove republishes the messages of a queue to another queue through the
+// default exchange, acknowledging each message once the republished copy is
+// confirmed, so that a message is never lost but may be duplicated on failure.
+func runMove(ctx context.Context, conn *amqp.Connection, args []string) error {
+	fs := newFlagSet("move")
+	from := fs.String("from", "", "source queue")
+	to := fs.String("to", "", "destination queue")
+	max := fs.Int("max", 0, "most messages to move, 0 moves all ready messages")
+	_ = fs.Parse(args)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+
+	var moved int
+	for *max == 0 || moved < *max {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
+		d, ok, err := ch.Get(*from, false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", *to, true, false, republishing(d))
+		if err != nil {
+			return err
+		}
+		acked, err := dc.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			_ = d.Nack(false, true)
+			return fmt.Errorf("publish to %s was nacked after moving %d messages", *to, moved)
+		}
+
+		if err := d.Ack(false); err != nil {
+			return err
+		}
+		moved++
+	}
+
+	fmt.Printf("moved %d messages from %s to %s\n", moved, *from, *to)
+	return nil
+}
+
+// republishing copies the properties and body of a delivery.
+func republishing(d amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+func runPublish(ctx context.Context, conn *amqp.Connection, args []string) error {
+	fs := newFlagSet("publish")
+	exchange := fs.String("exchange", "", "exchange to publish to")
+	key := fs.String("key", "", "routing key")
+	body := fs.String("body", "test", "message body")
+	contentType := fs.String("content-type", "text/plain", "message content type")
+	count := fs.Int("count", 1, "number of messages")
+	persistent := fs.Bool("persistent", false, "publish persistent messages")
+	_ = fs.Parse(args)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return err
+	}
+
+	msg := amqp.Publishing{
+		ContentType: *contentType,
+		Timestamp:   time.Now(),
+		Body:        []byte(*body),
+	}
+	if *persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+
+	dcs := make([]*amqp.DeferredConfirmation, 0, *count)
+	for i := 0; i < *count; i++ {
+		dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, *exchange, *key, false, false, msg)
+		if err != nil {
+			return err
+		}
+		dcs = append(dcs, dc)
+	}
+
+	var nacked int
+	for _, dc := range dcs {
+		acked, err := dc.WaitContext(ctx)
+		if err != nil {
+			return err
+		}
+		if !acked {
+			nacked++
+		}
+	}
+
+	fmt.Printf("published %d messages, %d nacked\n", *count, nacked)
+	if nacked > 0 {
+		return fmt.Errorf("%d publishings were nacked", nacked)
+	}
+	return nil
+}
+
+func runConsume(ctx context.Context, conn *amqp.Connection, args []string) error {
+	fs := newFlagSet("consume")
+	queue := fs.String("queue", "", "queue to consume from")
+	count := fs.Int("count", 1, "number of messages to consume, 0 consumes until interrupted")
+	timeout := fs.Duration("timeout", 5*time.Second, "how long to wait for a message, 0 waits indefinitely")
+	_ = fs.Parse(args)
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if *count > 0 {
+		if err := ch.Qos(*count, 0, false); err != nil {
+			return err
+		}
+	}
+
+	deliveries, err := ch.ConsumeWithContext(ctx, *queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for consumed := 0; *count == 0 || consumed < *count; consumed++ {
+		var expired <-chan time.Time
+		if *timeout > 0 {
+			expired = time.After(*timeout)
+		}
+
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return context.Cause(ctx)
+			}
+			fmt.Fprintf(os.Stdout, "%s %s %q\n", d.Exchange, d.RoutingKey, d.Body)
+			if err := d.Ack(false); err != nil {
+				return err
+			}
+
+		case <-expired:
+			return fmt.Errorf("no message within %s after %d messages", *timeout, consumed)
+		}
+	}
+
+	return nil
+}
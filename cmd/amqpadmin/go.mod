@@ -0,0 +1,13 @@
+module github.com/rabbitmq/amqp091-go/cmd/amqpadmin
+
+go 1.20
+
+require (
+	github.com/rabbitmq/amqp091-go v1.10.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+// amqpadmin uses library APIs that are not in a published release yet, so it
+// only builds from a checkout, against the library next to it: go install of
+// this module at a version does not work, as the replace is then ignored.
+replace github.com/rabbitmq/amqp091-go => ../..
@@ -0,0 +1,136 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/yaml.v3"
+)
+
+// spec is the YAML representation of an amqp.Topology:
+//
+//	exchanges:
+//	  - name: events
+//	    kind: topic
+//	    durable: true
+//	queues:
+//	  - name: orders
+//	    durable: true
+//	    args:
+//	      x-queue-type: quorum
+//	bindings:
+//	  - queue: orders
+//	    exchange: events
+//	    key: order.*
+type spec struct {
+	Exchanges []struct {
+		Name       string                 `yaml:"name"`
+		Kind       string                 `yaml:"kind"`
+		Durable    bool                   `yaml:"durable"`
+		AutoDelete bool                   `yaml:"auto_delete"`
+		Internal   bool                   `yaml:"internal"`
+		Args       map[string]interface{} `yaml:"args"`
+	} `yaml:"exchanges"`
+	Queues []struct {
+		Name       string                 `yaml:"name"`
+		Durable    bool                   `yaml:"durable"`
+		AutoDelete bool                   `yaml:"auto_delete"`
+		Exclusive  bool                   `yaml:"exclusive"`
+		Args       map[string]interface{} `yaml:"args"`
+	} `yaml:"queues"`
+	Bindings []struct {
+		Queue    string                 `yaml:"queue"`
+		Exchange string                 `yaml:"exchange"`
+		Key      string                 `yaml:"key"`
+		Args     map[string]interface{} `yaml:"args"`
+	} `yaml:"bindings"`
+}
+
+func loadSpecFile(path string) (amqp.Topology, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return amqp.Topology{}, err
+	}
+	defer f.Close()
+
+	return loadSpec(f)
+}
+
+// loadSpec decodes a YAML spec into the topology it describes.
+func loadSpec(r io.Reader) (amqp.Topology, error) {
+	var s spec
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&s); err != nil && err != io.EOF {
+		return amqp.Topology{}, fmt.Errorf("decode spec: %w", err)
+	}
+
+	var topo amqp.Topology
+	for _, e := range s.Exchanges {
+		if e.Kind == "" {
+			e.Kind = amqp.ExchangeDirect
+		}
+		topo.Exchanges = append(topo.Exchanges, amqp.ExchangeSpec{
+			Name:       e.Name,
+			Kind:       e.Kind,
+			Durable:    e.Durable,
+			AutoDelete: e.AutoDelete,
+			Internal:   e.Internal,
+			Args:       toTable(e.Args),
+		})
+	}
+	for _, q := range s.Queues {
+		topo.Queues = append(topo.Queues, amqp.QueueSpec{
+			Name:       q.Name,
+			Durable:    q.Durable,
+			AutoDelete: q.AutoDelete,
+			Exclusive:  q.Exclusive,
+			Args:       toTable(q.Args),
+		})
+	}
+	for _, b := range s.Bindings {
+		topo.Bindings = append(topo.Bindings, amqp.BindingSpec{
+			Queue:    b.Queue,
+			Exchange: b.Exchange,
+			Key:      b.Key,
+			Args:     toTable(b.Args),
+		})
+	}
+
+	return topo, nil
+}
+
+// toTable converts the maps decoded from YAML, which amqp.Table does not
+// accept as field values, to nested tables.
+func toTable(m map[string]interface{}) amqp.Table {
+	if m == nil {
+		return nil
+	}
+
+	t := make(amqp.Table, len(m))
+	for k, v := range m {
+		t[k] = toField(v)
+	}
+	return t
+}
+
+func toField(v interface{}) interface{} {
+	switch fv := v.(type) {
+	case map[string]interface{}:
+		return toTable(fv)
+
+	case []interface{}:
+		a := make([]interface{}, len(fv))
+		for i, f := range fv {
+			a[i] = toField(f)
+		}
+		return a
+	}
+	return v
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestLoadSpec(t *testing.T) {
+	topo, err := loadSpec(strings.NewReader(`
+exchanges:
+  - name: events
+    kind: topic
+    durable: true
+  - name: commands
+queues:
+  - name: orders
+    durable: true
+    args:
+      x-queue-type: quorum
+      x-max-length: 1000
+      x-nested:
+        list: [1, two]
+bindings:
+  - queue: orders
+    exchange: events
+    key: order.*
+`))
+	if err != nil {
+		t.Fatalf("could not load spec: %v", err)
+	}
+
+	if want, got := 2, len(topo.Exchanges); want != got {
+		t.Fatalf("expected %d exchanges, got %d", want, got)
+	}
+	if e := topo.Exchanges[0]; e.Name != "events" || e.Kind != amqp.ExchangeTopic || !e.Durable {
+		t.Errorf("unexpected exchange %+v", e)
+	}
+	if want, got := amqp.ExchangeDirect, topo.Exchanges[1].Kind; want != got {
+		t.Errorf("expected exchanges to default to %q, got %q", want, got)
+	}
+
+	q := topo.Queues[0]
+	if err := q.Args.Validate(); err != nil {
+		t.Errorf("expected queue arguments valid for AMQP, got %v", err)
+	}
+	if want, got := 1000, q.Args["x-max-length"]; want != got {
+		t.Errorf("expected x-max-length %v, got %v", want, got)
+	}
+
+	if b := topo.Bindings[0]; b.Queue != "orders" || b.Exchange != "events" || b.Key != "order.*" {
+		t.Errorf("unexpected binding %+v", b)
+	}
+}
+
+func TestLoadSpecUnknownField(t *testing.T) {
+	if _, err := loadSpec(strings.NewReader("queues:\n  - name: orders\n    durabel: true\n")); err == nil {
+		t.Error("expected an error for a misspelled field")
+	}
+}
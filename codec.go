@@ -0,0 +1,184 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedContentType is returned when no codec is registered for the
+// content type of a message, see RegisterCodec.
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// Codec marshals values to message bodies of one content type and back.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(body []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(body []byte, v interface{}) error { return json.Unmarshal(body, v) }
+
+// defaultContentType is the content type of Publishing.Encode and
+// Delivery.Decode for messages without one.
+const defaultContentType = "application/json"
+
+var (
+	codecsM sync.RWMutex
+	codecs  = map[string]Codec{
+		defaultContentType: jsonCodec{},
+	}
+)
+
+/*
+RegisterCodec makes c marshal and unmarshal the bodies of messages with the
+given content type, such as application/x-protobuf.  Content types are matched
+case insensitively and without their parameters.  Registering a content type
+again replaces its codec.
+
+application/json is registered by default.
+*/
+func RegisterCodec(contentType string, c Codec) {
+	codecsM.Lock()
+	defer codecsM.Unlock()
+
+	codecs[mediaType(contentType)] = c
+}
+
+// codecFor returns the codec registered for the content type.
+func codecFor(contentType string) (Codec, error) {
+	codecsM.RLock()
+	c, found := codecs[mediaType(contentType)]
+	codecsM.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+	return c, nil
+}
+
+// mediaType returns the content type without parameters such as charset.
+func mediaType(contentType string) string {
+	if mt, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mt
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
+
+/*
+Encode marshals v into the Body with the codec registered for the ContentType
+of the publishing, so that the serialization of every publisher follows the
+registry.  The ContentType is set to application/json when empty.
+
+	msg := amqp.Publishing{ContentType: "application/x-protobuf"}
+	if err := msg.Encode(order); err != nil {
+		return err
+	}
+*/
+func (p *Publishing) Encode(v interface{}) error {
+	if p.ContentType == "" {
+		p.ContentType = defaultContentType
+	}
+
+	codec, err := codecFor(p.ContentType)
+	if err != nil {
+		return err
+	}
+
+	body, err := codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshal %T: %w", v, err)
+	}
+
+	p.Body = body
+	return nil
+}
+
+// Decode unmarshals the body of the delivery into v with the codec registered
+// for its ContentType, application/json when empty, after decoding its
+// ContentEncoding like DecodedBody.
+func (d Delivery) Decode(v interface{}) error {
+	contentType := d.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	codec, err := codecFor(contentType)
+	if err != nil {
+		return err
+	}
+
+	body, err := d.DecodedBody()
+	if err != nil {
+		return err
+	}
+
+	if err := codec.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("unmarshal %T: %w", v, err)
+	}
+	return nil
+}
+
+/*
+PublishJSON publishes v marshaled with the codec of application/json, with
+that content type, to exchange with key.  It covers the common case of
+PublishWithContext with a Publishing built with Encode:
+
+	err := ch.PublishJSON(ctx, "orders", "order.created", order)
+
+Use PublishWithContext with Publishing.Encode to set other properties.
+*/
+func (ch *Channel) PublishJSON(ctx context.Context, exchange, key string, v interface{}) error {
+	msg := Publishing{ContentType: defaultContentType}
+	if err := msg.Encode(v); err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
+
+// ProtoContentType is the content type of the publishings of PublishProto.
+const ProtoContentType = "application/x-protobuf"
+
+/*
+ProtoMarshaler is a protocol buffers message, so that PublishProto does not
+depend on a protobuf library.  Messages generated with the marshaler plugin of
+gogoproto implement it; wrap the other messages with ProtoMarshalFunc:
+
+	err := ch.PublishProto(ctx, "orders", "order.created", amqp.ProtoMarshalFunc(func() ([]byte, error) {
+		return proto.Marshal(order)
+	}))
+*/
+type ProtoMarshaler interface {
+	Marshal() ([]byte, error)
+}
+
+// ProtoMarshalFunc is a ProtoMarshaler calling itself.
+type ProtoMarshalFunc func() ([]byte, error)
+
+// Marshal calls f.
+func (f ProtoMarshalFunc) Marshal() ([]byte, error) {
+	return f()
+}
+
+// PublishProto publishes m marshaled, with the ProtoContentType content type,
+// to exchange with key, like PublishJSON.
+func (ch *Channel) PublishProto(ctx context.Context, exchange, key string, m ProtoMarshaler) error {
+	body, err := m.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal %T: %w", m, err)
+	}
+	return ch.PublishWithContext(ctx, exchange, key, false, false, Publishing{
+		ContentType: ProtoContentType,
+		Body:        body,
+	})
+}
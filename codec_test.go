@@ -0,0 +1,124 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type upperCodec struct{}
+
+func (upperCodec) Marshal(v interface{}) ([]byte, error) {
+	return []byte(v.(string)), nil
+}
+
+func (upperCodec) Unmarshal(body []byte, v interface{}) error {
+	*v.(*string) = string(body)
+	return nil
+}
+
+func TestCodecRegistry(t *testing.T) {
+	if c, err := codecFor("Application/JSON; charset=utf-8"); err != nil || c != (jsonCodec{}) {
+		t.Errorf("expected the JSON codec regardless of case and parameters, got %v %v", c, err)
+	}
+
+	if _, err := codecFor("text/x-test-upper"); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Errorf("expected ErrUnsupportedContentType, got %v", err)
+	}
+
+	RegisterCodec("text/x-test-upper", upperCodec{})
+	t.Cleanup(func() {
+		codecsM.Lock()
+		delete(codecs, "text/x-test-upper")
+		codecsM.Unlock()
+	})
+	if c, err := codecFor("text/x-test-upper"); err != nil || c != (upperCodec{}) {
+		t.Errorf("expected the registered codec, got %v %v", c, err)
+	}
+}
+
+func TestPublishingEncodeDeliveryDecode(t *testing.T) {
+	type order struct{ ID int }
+
+	var msg Publishing
+	if err := msg.Encode(order{ID: 42}); err != nil {
+		t.Fatalf("could not encode: %v", err)
+	}
+	if want, got := "application/json", msg.ContentType; want != got {
+		t.Errorf("expected the default content type %q, got %q", want, got)
+	}
+	if want, got := `{"ID":42}`, string(msg.Body); want != got {
+		t.Errorf("expected the body %s, got %s", want, got)
+	}
+
+	var decoded order
+	if err := (Delivery{ContentType: msg.ContentType, Body: msg.Body}).Decode(&decoded); err != nil || decoded.ID != 42 {
+		t.Errorf("expected to decode the order, got %+v %v", decoded, err)
+	}
+
+	unsupported := Publishing{ContentType: "text/x-test-unknown"}
+	if err := unsupported.Encode("v"); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Errorf("expected ErrUnsupportedContentType, got %v", err)
+	}
+	var s string
+	if err := (Delivery{ContentType: "text/x-test-unknown"}).Decode(&s); !errors.Is(err, ErrUnsupportedContentType) {
+		t.Errorf("expected ErrUnsupportedContentType, got %v", err)
+	}
+}
+
+func TestPublishJSONAndProto(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := ch.PublishJSON(ctx, "orders", "created", map[string]int{"id": 1}); err != nil {
+		t.Fatalf("could not publish JSON: %v", err)
+	}
+	if err := ch.PublishJSON(ctx, "orders", "created", make(chan int)); err == nil {
+		t.Error("expected an error for a value JSON cannot marshal")
+	}
+	if err := ch.PublishProto(ctx, "orders", "created", ProtoMarshalFunc(func() ([]byte, error) {
+		return []byte{0x08, 0x01}, nil
+	})); err != nil {
+		t.Fatalf("could not publish protobuf: %v", err)
+	}
+
+	pub := <-published
+	if pub.Exchange != "orders" || pub.RoutingKey != "created" || pub.Properties.ContentType != "application/json" || string(pub.Body) != `{"id":1}` {
+		t.Errorf("expected the JSON publishing, got %+v", pub)
+	}
+	pub = <-published
+	if pub.Properties.ContentType != ProtoContentType || string(pub.Body) != "\x08\x01" {
+		t.Errorf("expected the protobuf publishing, got %+v", pub)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// minConfirmRing is the initial number of slots of a confirmRing, a power of
+// two.
+const minConfirmRing = 64
+
+// pendingConfirm is what is kept about a publishing until it is confirmed.
+type pendingConfirm struct {
+	tag   uint64
+	dc    *DeferredConfirmation
+	data  interface{} // see WithConfirmData
+	taken atomic.Bool // the slot is free once taken
+
+	// An out of sequence confirmation, only used while holding confirms.m.
+	confirmation Confirmation
+	received     bool
+}
+
+/*
+confirmRing tracks the publishings awaiting their confirmation by delivery tag,
+in slots indexed by the tag modulo their number.  Looking up and taking entries
+is lock-free, so that confirming does not contend with publishing, however many
+confirmations a multiple ack expands to.  Adding entries is serialized by putM,
+which only the publishers take, and doubles the slots when the tags awaiting
+their confirmation no longer fit.
+
+An entry copied when growing may be taken from the former slots meanwhile: it
+is then left taken in the new slots, which put reuses like an empty slot.
+*/
+type confirmRing struct {
+	slots atomic.Pointer[[]atomic.Pointer[pendingConfirm]]
+	high  atomic.Uint64 // highest tag put
+
+	putM sync.Mutex
+}
+
+func (r *confirmRing) load() []atomic.Pointer[pendingConfirm] {
+	if slots := r.slots.Load(); slots != nil {
+		return *slots
+	}
+	return nil
+}
+
+// put adds p, replacing any entry of the same tag.
+func (r *confirmRing) put(p *pendingConfirm) {
+	r.putM.Lock()
+	defer r.putM.Unlock()
+
+	slots := r.load()
+	if slots == nil {
+		slots = make([]atomic.Pointer[pendingConfirm], minConfirmRing)
+		r.slots.Store(&slots)
+	}
+
+	for {
+		slot := &slots[p.tag&uint64(len(slots)-1)]
+		if old := slot.Load(); old == nil || old.taken.Load() || old.tag == p.tag {
+			slot.Store(p)
+			break
+		}
+		slots = r.grow(slots)
+	}
+
+	if p.tag > r.high.Load() {
+		r.high.Store(p.tag)
+	}
+}
+
+// grow doubles the slots until the entries not taken yet fit.  Must be called
+// while holding putM.
+func (r *confirmRing) grow(slots []atomic.Pointer[pendingConfirm]) []atomic.Pointer[pendingConfirm] {
+	for size := 2 * len(slots); ; size *= 2 {
+		grown := make([]atomic.Pointer[pendingConfirm], size)
+		if moveConfirms(slots, grown) {
+			r.slots.Store(&grown)
+			return grown
+		}
+	}
+}
+
+// moveConfirms copies the entries of from not taken yet to their slots in to,
+// and returns false when two of them have the same slot.
+func moveConfirms(from, to []atomic.Pointer[pendingConfirm]) bool {
+	mask := uint64(len(to) - 1)
+	for i := range from {
+		p := from[i].Load()
+		if p == nil || p.taken.Load() {
+			continue
+		}
+		slot := &to[p.tag&mask]
+		if slot.Load() != nil {
+			return false
+		}
+		slot.Store(p)
+	}
+	return true
+}
+
+// get returns the entry of tag not taken yet, or nil.
+func (r *confirmRing) get(tag uint64) *pendingConfirm {
+	slots := r.load()
+	if slots == nil {
+		return nil
+	}
+	p := slots[tag&uint64(len(slots)-1)].Load()
+	if p == nil || p.tag != tag || p.taken.Load() {
+		return nil
+	}
+	return p
+}
+
+// take removes and returns the entry of tag, or nil when there is none or
+// another caller took it.
+func (r *confirmRing) take(tag uint64) *pendingConfirm {
+	slots := r.load()
+	if slots == nil {
+		return nil
+	}
+	slot := &slots[tag&uint64(len(slots)-1)]
+	p := slot.Load()
+	if p == nil || p.tag != tag || !p.taken.CompareAndSwap(false, true) {
+		return nil
+	}
+	slot.CompareAndSwap(p, nil)
+	return p
+}
+
+// drain takes every entry, calling fn with each when not nil.
+func (r *confirmRing) drain(fn func(*pendingConfirm)) {
+	slots := r.load()
+	for i := range slots {
+		p := slots[i].Load()
+		if p == nil || !p.taken.CompareAndSwap(false, true) {
+			continue
+		}
+		slots[i].CompareAndSwap(p, nil)
+		if fn != nil {
+			fn(p)
+		}
+	}
+}
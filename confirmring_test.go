@@ -0,0 +1,116 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConfirmRingGrowsAroundPendingTags(t *testing.T) {
+	var r confirmRing
+
+	// Tag 1 stays pending while later tags wrap around the slots.
+	r.put(&pendingConfirm{tag: 1})
+	for tag := uint64(2); tag <= 10*minConfirmRing; tag++ {
+		r.put(&pendingConfirm{tag: tag})
+		if tag > 2 && r.take(tag-1) == nil {
+			t.Fatalf("expected to take tag %d", tag-1)
+		}
+	}
+
+	if p := r.take(1); p == nil || p.tag != 1 {
+		t.Fatalf("expected the oldest tag to be kept while growing, got %+v", p)
+	}
+	if p := r.take(1); p != nil {
+		t.Errorf("expected a tag to be taken once, got %+v", p)
+	}
+	if want, got := uint64(10*minConfirmRing), r.high.Load(); want != got {
+		t.Errorf("expected the highest tag %d, got %d", want, got)
+	}
+}
+
+func TestConfirmRingTakesOnceWhileGrowing(t *testing.T) {
+	const count = 10000
+
+	var (
+		r     confirmRing
+		wg    sync.WaitGroup
+		m     sync.Mutex
+		taken = make([]int, count+1)
+		puts  = []chan uint64{make(chan uint64, count), make(chan uint64, count)}
+	)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for tag := uint64(1); tag <= count; tag++ {
+			r.put(&pendingConfirm{tag: tag})
+			for _, put := range puts {
+				put <- tag
+			}
+		}
+		for _, put := range puts {
+			close(put)
+		}
+	}()
+
+	// Two takers race for every tag but the first, which stays pending so
+	// that the slots grow meanwhile.
+	for _, put := range puts {
+		wg.Add(1)
+		go func(put <-chan uint64) {
+			defer wg.Done()
+			for tag := range put {
+				if tag == 1 {
+					continue
+				}
+				if p := r.take(tag); p != nil {
+					m.Lock()
+					taken[p.tag]++
+					m.Unlock()
+				}
+			}
+		}(put)
+	}
+	wg.Wait()
+	if r.take(1) != nil {
+		taken[1]++
+	}
+
+	for tag := 1; tag <= count; tag++ {
+		if taken[tag] != 1 {
+			t.Fatalf("expected tag %d to be taken once, got %d", tag, taken[tag])
+		}
+	}
+}
+
+func BenchmarkConcurrentPublishConfirm(b *testing.B) {
+	c := newConfirms(false)
+	l := make(chan Confirmation, 1024)
+	c.Listen(l)
+
+	go func() {
+		for range l {
+		}
+	}()
+
+	tags := make(chan uint64, 1024)
+	confirmed := make(chan struct{})
+	go func() {
+		defer close(confirmed)
+		for tag := range tags {
+			c.One(Confirmation{DeliveryTag: tag, Ack: true})
+		}
+	}()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tags <- c.publish("data").DeliveryTag
+	}
+	close(tags)
+	<-confirmed
+	c.Close()
+}
@@ -8,23 +8,34 @@ package amqp091
 import (
 	"context"
 	"sync"
+	"time"
 )
 
 // confirms resequences and notifies one or multiple publisher confirmation listeners
 type confirms struct {
 	m                     sync.Mutex
 	listeners             []chan Confirmation
-	sequencer             map[uint64]Confirmation
+	notifications         []chan Notification // see Channel.Notifications
+	callbacks             []func(Confirmation)
+	pending               confirmRing // data and out of sequence confirmations
 	deferredConfirmations *deferredConfirmations
 	published             uint64
 	publishedMut          sync.Mutex
 	expecting             uint64
+	sample                uint64        // track one in every sample publishings, 0 tracks all
+	strict                bool          // see Config.StrictNotify
+	stats                 *channelStats // nil unless Config.EnableStats
+
+	windowM     sync.Mutex    // protects below
+	window      int           // see Channel.SetConfirmWindow, 0 is unlimited
+	unconfirmed int           // publishings awaiting their confirmation
+	freed       chan struct{} // closed when unconfirmed decreases, nil without waiters
 }
 
 // newConfirms allocates a confirms
-func newConfirms() *confirms {
+func newConfirms(strict bool) *confirms {
 	return &confirms{
-		sequencer:             map[uint64]Confirmation{},
+		strict:                strict,
 		deferredConfirmations: newDeferredConfirmations(),
 		published:             0,
 		expecting:             1,
@@ -38,45 +49,184 @@ func (c *confirms) Listen(l chan Confirmation) {
 	c.listeners = append(c.listeners, l)
 }
 
-// Publish increments the publishing counter
-func (c *confirms) publish() *DeferredConfirmation {
+// notifyAll sends confirmations to a Notifications listener, which is closed
+// by the channel rather than by Close.
+func (c *confirms) notifyAll(l chan Notification) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.notifications = append(c.notifications, l)
+}
+
+func (c *confirms) OnConfirm(fn func(Confirmation)) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.callbacks = append(c.callbacks, fn)
+}
+
+// Publish increments the publishing counter and keeps the data attached to
+// the publishing until it is confirmed.  It returns nil when the publishing is
+// not part of the tracked sample.
+func (c *confirms) publish(data interface{}) *DeferredConfirmation {
+	c.publishedMut.Lock()
+	defer c.publishedMut.Unlock()
+
+	c.published++
+	if data != nil {
+		c.pending.put(&pendingConfirm{tag: c.published, data: data})
+	}
+	if c.sample > 1 && c.published%c.sample != 0 {
+		return nil
+	}
+	dc := c.deferredConfirmations.Add(c.published)
+	dc.Data = data
+	return dc
+}
+
+// republish increments the publishing counter for another attempt of dc, see
+// Channel.SetNackRetry.
+func (c *confirms) republish(dc *DeferredConfirmation) {
 	c.publishedMut.Lock()
 	defer c.publishedMut.Unlock()
 
 	c.published++
-	return c.deferredConfirmations.Add(c.published)
+	if dc.Data != nil {
+		c.pending.put(&pendingConfirm{tag: c.published, data: dc.Data})
+	}
+	c.deferredConfirmations.readd(c.published, dc)
+}
+
+// setSample tracks only one in every n publishings with a
+// DeferredConfirmation.
+func (c *confirms) setSample(n uint64) {
+	c.publishedMut.Lock()
+	defer c.publishedMut.Unlock()
+
+	c.sample = n
+}
+
+// sampled returns true when only a sample of the publishings is tracked, see
+// setSample.
+func (c *confirms) sampled() bool {
+	c.publishedMut.Lock()
+	defer c.publishedMut.Unlock()
+
+	return c.sample > 1
+}
+
+// setWindow limits the number of publishings awaiting their confirmation.
+func (c *confirms) setWindow(n int) {
+	c.windowM.Lock()
+	defer c.windowM.Unlock()
+
+	c.window = n
+	c.wake()
+}
+
+// acquire counts one more publishing awaiting its confirmation, waiting for
+// room in the window first.  It returns context.Cause(ctx) when ctx is done, or
+// ErrClosed when closed is closed, while waiting.
+func (c *confirms) acquire(ctx context.Context, closed <-chan struct{}) error {
+	for {
+		c.windowM.Lock()
+		if c.window <= 0 || c.unconfirmed < c.window {
+			c.unconfirmed++
+			c.windowM.Unlock()
+			return nil
+		}
+		if c.freed == nil {
+			c.freed = make(chan struct{})
+		}
+		freed := c.freed
+		c.windowM.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-closed:
+			return ErrClosed
+		case <-freed:
+		}
+	}
+}
+
+// release counts one less publishing awaiting its confirmation.
+func (c *confirms) release() {
+	c.windowM.Lock()
+	defer c.windowM.Unlock()
+
+	if c.unconfirmed > 0 {
+		c.unconfirmed--
+	}
+	c.wake()
+}
+
+// wake wakes the publishers waiting in acquire.  Must be called while holding
+// windowM.
+func (c *confirms) wake() {
+	if c.freed != nil {
+		close(c.freed)
+		c.freed = nil
+	}
 }
 
 // unpublish decrements the publishing counter and removes the
 // DeferredConfirmation. It must be called immediately after a publish fails.
-func (c *confirms) unpublish() {
+func (c *confirms) unpublish(err error) {
 	c.publishedMut.Lock()
 	defer c.publishedMut.Unlock()
-	c.deferredConfirmations.remove(c.published)
+	c.deferredConfirmations.remove(c.published, err)
+	c.pending.take(c.published)
 	c.published--
+	c.release()
 }
 
 // confirm confirms one publishing, increments the expecting delivery tag, and
 // removes bookkeeping for that delivery tag.
 func (c *confirms) confirm(confirmation Confirmation) {
-	delete(c.sequencer, c.expecting)
 	c.expecting++
+
+	if p := c.pending.take(confirmation.DeliveryTag); p != nil {
+		confirmation.Data = p.data
+	}
+
+	c.release()
+	if c.stats != nil {
+		c.stats.confirm(confirmation.Ack)
+	}
+
 	for _, l := range c.listeners {
-		l <- confirmation
+		notify(c.strict, l, confirmation, "NotifyPublish")
+	}
+	for _, l := range c.notifications {
+		notify(c.strict, l, Notification(confirmation), "Notifications")
+	}
+	for _, fn := range c.callbacks {
+		fn(confirmation)
 	}
 }
 
+// sequence keeps an out of order delivered confirmation until the ones before
+// it are delivered.
+func (c *confirms) sequence(confirmed Confirmation) {
+	p := c.pending.get(confirmed.DeliveryTag)
+	if p == nil {
+		p = &pendingConfirm{tag: confirmed.DeliveryTag}
+		c.pending.put(p)
+	}
+	p.confirmation = confirmed
+	p.received = true
+}
+
 // resequence confirms any out of order delivered confirmations
 func (c *confirms) resequence() {
-	c.publishedMut.Lock()
-	defer c.publishedMut.Unlock()
-
-	for c.expecting <= c.published {
-		sequenced, found := c.sequencer[c.expecting]
-		if !found {
+	for {
+		p := c.pending.get(c.expecting)
+		if p == nil || !p.received {
 			return
 		}
-		c.confirm(sequenced)
+		c.confirm(p.confirmation)
 	}
 }
 
@@ -90,7 +240,7 @@ func (c *confirms) One(confirmed Confirmation) {
 	if c.expecting == confirmed.DeliveryTag {
 		c.confirm(confirmed)
 	} else {
-		c.sequencer[confirmed.DeliveryTag] = confirmed
+		c.sequence(confirmed)
 	}
 	c.resequence()
 }
@@ -103,7 +253,7 @@ func (c *confirms) Multiple(confirmed Confirmation) {
 	c.deferredConfirmations.ConfirmMultiple(confirmed)
 
 	for c.expecting <= confirmed.DeliveryTag {
-		c.confirm(Confirmation{c.expecting, confirmed.Ack})
+		c.confirm(Confirmation{DeliveryTag: c.expecting, Ack: confirmed.Ack})
 	}
 	c.resequence()
 }
@@ -120,64 +270,98 @@ func (c *confirms) Close() error {
 		close(l)
 	}
 	c.listeners = nil
+	c.notifications = nil
+	c.callbacks = nil
+
+	c.pending.drain(nil)
 	return nil
 }
 
+type confirmDataKey struct{}
+
+/*
+WithConfirmData returns a context carrying data to attach to the publishing
+made with it, such as the ID of the database row the message was read from.
+The data is returned in the Data field of the Confirmation sent to
+Channel.NotifyPublish listeners and Channel.OnConfirm callbacks, and of the
+DeferredConfirmation returned by the publish, so that confirm handlers do not
+need to map delivery tags back to their publishings.
+
+	ctx := amqp.WithConfirmData(ctx, outbox.ID)
+	err := ch.PublishWithContext(ctx, "events", "order.created", false, false, msg)
+
+	for c := range ch.NotifyPublish(make(chan amqp.Confirmation, 100)) {
+		markSent(c.Data.(int64), c.Ack)
+	}
+
+Data is only kept for channels in confirm mode, until the publishing is
+confirmed or the channel is closed.
+*/
+func WithConfirmData(ctx context.Context, data interface{}) context.Context {
+	return context.WithValue(ctx, confirmDataKey{}, data)
+}
+
+func confirmData(ctx context.Context) interface{} {
+	return ctx.Value(confirmDataKey{})
+}
+
 type deferredConfirmations struct {
-	m             sync.Mutex
-	confirmations map[uint64]*DeferredConfirmation
+	m       sync.Mutex  // serializes settling
+	pending confirmRing // DeferredConfirmations by delivery tag
+	low     uint64      // lowest tag ConfirmMultiple did not settle, guarded by m
+
+	maxAttempts int                                        // see Channel.SetNackRetry
+	backoff     func(attempt int) time.Duration            // see Channel.SetNackRetry
+	republish   func(*DeferredConfirmation, time.Duration) // publishes again a nacked publishing
 }
 
 func newDeferredConfirmations() *deferredConfirmations {
-	return &deferredConfirmations{
-		confirmations: map[uint64]*DeferredConfirmation{},
-	}
+	return &deferredConfirmations{low: 1}
 }
 
 func (d *deferredConfirmations) Add(tag uint64) *DeferredConfirmation {
-	d.m.Lock()
-	defer d.m.Unlock()
-
 	dc := &DeferredConfirmation{DeliveryTag: tag}
 	dc.done = make(chan struct{})
-	d.confirmations[tag] = dc
+	d.pending.put(&pendingConfirm{tag: tag, dc: dc})
 	return dc
 }
 
 // remove is only used to drop a tag whose publish failed
-func (d *deferredConfirmations) remove(tag uint64) {
-	d.m.Lock()
-	defer d.m.Unlock()
-	dc, found := d.confirmations[tag]
-	if !found {
+func (d *deferredConfirmations) remove(tag uint64, err error) {
+	p := d.pending.take(tag)
+	if p == nil {
 		return
 	}
-	close(dc.done)
-	delete(d.confirmations, tag)
+	p.dc.err = err
+	close(p.dc.done)
 }
 
 func (d *deferredConfirmations) Confirm(confirmation Confirmation) {
 	d.m.Lock()
 	defer d.m.Unlock()
 
-	dc, found := d.confirmations[confirmation.DeliveryTag]
-	if !found {
+	p := d.pending.take(confirmation.DeliveryTag)
+	if p == nil {
 		// We should never receive a confirmation for a tag that hasn't
 		// been published, but a test causes this to happen.
 		return
 	}
-	dc.setAck(confirmation.Ack)
-	delete(d.confirmations, confirmation.DeliveryTag)
+	d.settle(p.dc, confirmation.Ack)
 }
 
 func (d *deferredConfirmations) ConfirmMultiple(confirmation Confirmation) {
 	d.m.Lock()
 	defer d.m.Unlock()
 
-	for k, v := range d.confirmations {
-		if k <= confirmation.DeliveryTag {
-			v.setAck(confirmation.Ack)
-			delete(d.confirmations, k)
+	// Tags above the highest tracked one cannot be pending.
+	last := confirmation.DeliveryTag
+	if high := d.pending.high.Load(); last > high {
+		last = high
+	}
+
+	for ; d.low <= last; d.low++ {
+		if p := d.pending.take(d.low); p != nil {
+			d.settle(p.dc, confirmation.Ack)
 		}
 	}
 }
@@ -187,10 +371,9 @@ func (d *deferredConfirmations) Close() {
 	d.m.Lock()
 	defer d.m.Unlock()
 
-	for k, v := range d.confirmations {
-		v.setAck(false)
-		delete(d.confirmations, k)
-	}
+	d.pending.drain(func(p *pendingConfirm) {
+		p.dc.fail(ErrClosed)
+	})
 }
 
 // setAck sets the acknowledgement status of the confirmation. Note that it must
@@ -225,13 +408,26 @@ func (d *DeferredConfirmation) Wait() bool {
 	return d.ack
 }
 
+// Err returns nil until the publisher confirmation, and then why the
+// publishing was not acknowledged: an error wrapping ErrPublishNacked when the
+// server negatively acknowledged it, after its last attempt with
+// Channel.SetNackRetry, or ErrClosed when the channel closed before.
+func (d *DeferredConfirmation) Err() error {
+	select {
+	case <-d.done:
+		return d.err
+	default:
+		return nil
+	}
+}
+
 // WaitContext waits until the publisher confirmation. It returns true if the
 // server successfully received the publishing. If the context expires before
-// that, ctx.Err() is returned.
+// that, context.Cause(ctx) is returned.
 func (d *DeferredConfirmation) WaitContext(ctx context.Context) (bool, error) {
 	select {
 	case <-ctx.Done():
-		return false, ctx.Err()
+		return false, context.Cause(ctx)
 	case <-d.done:
 	}
 	return d.ack, nil
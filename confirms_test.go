@@ -7,6 +7,7 @@ package amqp091
 
 import (
 	"context"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -15,18 +16,18 @@ import (
 func TestConfirmOneResequences(t *testing.T) {
 	var (
 		fixtures = []Confirmation{
-			{1, true},
-			{2, false},
-			{3, true},
+			{DeliveryTag: 1, Ack: true},
+			{DeliveryTag: 2, Ack: false},
+			{DeliveryTag: 3, Ack: true},
 		}
-		c = newConfirms()
+		c = newConfirms(false)
 		l = make(chan Confirmation, len(fixtures))
 	)
 
 	c.Listen(l)
 
 	for i := range fixtures {
-		if want, got := uint64(i+1), c.publish(); want != got.DeliveryTag {
+		if want, got := uint64(i+1), c.publish(nil); want != got.DeliveryTag {
 			t.Fatalf("expected publish to return the 1 based delivery tag published, want: %d, got: %d", want, got.DeliveryTag)
 		}
 	}
@@ -51,7 +52,7 @@ func TestConfirmOneResequences(t *testing.T) {
 
 func TestConfirmAndPublishDoNotDeadlock(t *testing.T) {
 	var (
-		c          = newConfirms()
+		c          = newConfirms(false)
 		l          = make(chan Confirmation)
 		iterations = 10
 	)
@@ -59,30 +60,71 @@ func TestConfirmAndPublishDoNotDeadlock(t *testing.T) {
 
 	go func() {
 		for i := 0; i < iterations; i++ {
-			c.One(Confirmation{uint64(i + 1), true})
+			c.One(Confirmation{DeliveryTag: uint64(i + 1), Ack: true})
 		}
 	}()
 
 	for i := 0; i < iterations; i++ {
-		c.publish()
+		c.publish(nil)
 		<-l
 	}
 }
 
+func TestConfirmWindowLimitsUnconfirmed(t *testing.T) {
+	c := newConfirms(false)
+	c.setWindow(2)
+
+	closed := make(chan struct{})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := c.acquire(ctx, closed); err != nil {
+			t.Fatalf("expected room in the window, got %v", err)
+		}
+		c.publish(nil)
+	}
+
+	full, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := c.acquire(full, closed); err != context.DeadlineExceeded {
+		t.Fatalf("expected the full window to wait until the deadline, got %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- c.acquire(ctx, closed) }()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("expected to wait for a confirmation, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	c.One(Confirmation{DeliveryTag: 1, Ack: true})
+	if err := <-acquired; err != nil {
+		t.Fatalf("expected the confirmation to make room, got %v", err)
+	}
+
+	go func() { acquired <- c.acquire(ctx, closed) }()
+	close(closed)
+	if err := <-acquired; err != ErrClosed {
+		t.Errorf("expected ErrClosed once closed, got %v", err)
+	}
+}
+
 func TestConfirmMixedResequences(t *testing.T) {
 	var (
 		fixtures = []Confirmation{
-			{1, true},
-			{2, true},
-			{3, true},
+			{DeliveryTag: 1, Ack: true},
+			{DeliveryTag: 2, Ack: true},
+			{DeliveryTag: 3, Ack: true},
 		}
-		c = newConfirms()
+		c = newConfirms(false)
 		l = make(chan Confirmation, len(fixtures))
 	)
 	c.Listen(l)
 
 	for range fixtures {
-		c.publish()
+		c.publish(nil)
 	}
 
 	c.One(fixtures[0])
@@ -106,18 +148,18 @@ func TestConfirmMixedResequences(t *testing.T) {
 func TestConfirmMultipleResequences(t *testing.T) {
 	var (
 		fixtures = []Confirmation{
-			{1, true},
-			{2, true},
-			{3, true},
-			{4, true},
+			{DeliveryTag: 1, Ack: true},
+			{DeliveryTag: 2, Ack: true},
+			{DeliveryTag: 3, Ack: true},
+			{DeliveryTag: 4, Ack: true},
 		}
-		c = newConfirms()
+		c = newConfirms(false)
 		l = make(chan Confirmation, len(fixtures))
 	)
 	c.Listen(l)
 
 	for range fixtures {
-		c.publish()
+		c.publish(nil)
 	}
 
 	c.Multiple(fixtures[len(fixtures)-1])
@@ -129,9 +171,40 @@ func TestConfirmMultipleResequences(t *testing.T) {
 	}
 }
 
+func TestConfirmCallbackResequences(t *testing.T) {
+	var (
+		c   = newConfirms(false)
+		got []Confirmation
+	)
+	c.OnConfirm(func(confirmed Confirmation) {
+		got = append(got, confirmed)
+	})
+
+	for i := 0; i < 5; i++ {
+		c.publish(nil)
+	}
+
+	c.One(Confirmation{DeliveryTag: 3, Ack: false})
+	c.One(Confirmation{DeliveryTag: 1, Ack: true})
+	c.Multiple(Confirmation{DeliveryTag: 2, Ack: true})
+	c.One(Confirmation{DeliveryTag: 5, Ack: true})
+	c.One(Confirmation{DeliveryTag: 4, Ack: true})
+
+	want := []Confirmation{{DeliveryTag: 1, Ack: true}, {DeliveryTag: 2, Ack: true}, {DeliveryTag: 3, Ack: false}, {DeliveryTag: 4, Ack: true}, {DeliveryTag: 5, Ack: true}}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected callbacks in sequence, want: %+v, got: %+v", want, got)
+	}
+
+	c.Close()
+	c.One(Confirmation{DeliveryTag: 6, Ack: true})
+	if len(got) != len(want) {
+		t.Errorf("expected no callback after close, got %+v", got[len(want):])
+	}
+}
+
 func BenchmarkSequentialBufferedConfirms(t *testing.B) {
 	var (
-		c = newConfirms()
+		c = newConfirms(false)
 		l = make(chan Confirmation, 10)
 	)
 
@@ -141,7 +214,7 @@ func BenchmarkSequentialBufferedConfirms(t *testing.B) {
 		if i > cap(l)-1 {
 			<-l
 		}
-		c.One(Confirmation{c.publish().DeliveryTag, true})
+		c.One(Confirmation{DeliveryTag: c.publish(nil).DeliveryTag, Ack: true})
 	}
 }
 
@@ -149,7 +222,7 @@ func TestConfirmsIsThreadSafe(t *testing.T) {
 	const count = 1000
 	const timeout = 5 * time.Second
 	var (
-		c    = newConfirms()
+		c    = newConfirms(false)
 		l    = make(chan Confirmation)
 		pub  = make(chan Confirmation)
 		done = make(chan Confirmation)
@@ -159,7 +232,7 @@ func TestConfirmsIsThreadSafe(t *testing.T) {
 	c.Listen(l)
 
 	for i := 0; i < count; i++ {
-		go func() { pub <- Confirmation{c.publish().DeliveryTag, true} }()
+		go func() { pub <- Confirmation{DeliveryTag: c.publish(nil).DeliveryTag, Ack: true} }()
 	}
 
 	for i := 0; i < count; i++ {
@@ -191,7 +264,7 @@ func TestDeferredConfirmationsConfirm(t *testing.T) {
 			result = dc.Wait()
 			wg.Done()
 		}()
-		dcs.Confirm(Confirmation{deliveryTag, ack})
+		dcs.Confirm(Confirmation{DeliveryTag: deliveryTag, Ack: ack})
 		wg.Wait()
 		if result != ack {
 			t.Fatalf("expected to receive matching ack got %v", result)
@@ -211,7 +284,7 @@ func TestDeferredConfirmationsConfirmMultiple(t *testing.T) {
 		result = dc1.Wait() && dc2.Wait() && dc3.Wait()
 		wg.Done()
 	}()
-	dcs.ConfirmMultiple(Confirmation{4, true})
+	dcs.ConfirmMultiple(Confirmation{DeliveryTag: 4, Ack: true})
 	wg.Wait()
 	if !result {
 		t.Fatal("expected to receive true for result, received false")
@@ -247,7 +320,7 @@ func TestDeferredConfirmationsDoneAcked(t *testing.T) {
 
 	// Confirm twice to ensure that setAck is called once.
 	for i := 0; i < 2; i++ {
-		dcs.Confirm(Confirmation{dc.DeliveryTag, true})
+		dcs.Confirm(Confirmation{DeliveryTag: dc.DeliveryTag, Ack: true})
 	}
 
 	<-dc.Done()
@@ -261,7 +334,7 @@ func TestDeferredConfirmationsWaitContextNack(t *testing.T) {
 	dcs := newDeferredConfirmations()
 	dc := dcs.Add(1)
 
-	dcs.Confirm(Confirmation{dc.DeliveryTag, false})
+	dcs.Confirm(Confirmation{DeliveryTag: dc.DeliveryTag, Ack: false})
 
 	ack, err := dc.WaitContext(context.Background())
 	if err != nil {
@@ -300,9 +373,87 @@ func TestDeferredConfirmationsConcurrency(t *testing.T) {
 		defer wg.Done()
 		result = dc1.Wait() && dc2.Wait() && dc3.Wait()
 	}()
-	dcs.ConfirmMultiple(Confirmation{4, true})
+	dcs.ConfirmMultiple(Confirmation{DeliveryTag: 4, Ack: true})
 	wg.Wait()
 	if !result {
 		t.Fatal("expected to receive true for concurrent confirmations, received false")
 	}
 }
+
+func TestConfirmsSampledPublish(t *testing.T) {
+	var (
+		c = newConfirms(false)
+		l = make(chan Confirmation, 6)
+	)
+	c.Listen(l)
+	c.setSample(3)
+
+	var sampled []*DeferredConfirmation
+	for i := 0; i < 6; i++ {
+		if dc := c.publish(nil); dc != nil {
+			sampled = append(sampled, dc)
+		}
+	}
+
+	if want, got := 2, len(sampled); want != got {
+		t.Fatalf("expected %d sampled confirmations, got %d", want, got)
+	}
+	if want, got := uint64(3), sampled[0].DeliveryTag; want != got {
+		t.Fatalf("expected the first sample to be tag %d, got %d", want, got)
+	}
+
+	c.Multiple(Confirmation{DeliveryTag: 6, Ack: true})
+
+	for _, dc := range sampled {
+		if !dc.Acked() {
+			t.Fatalf("expected sampled confirmation %d to be acked", dc.DeliveryTag)
+		}
+	}
+
+	for i := uint64(1); i <= 6; i++ {
+		if want, got := (Confirmation{DeliveryTag: i, Ack: true}), <-l; want != got {
+			t.Fatalf("expected listeners to receive every confirmation, want: %+v, got: %+v", want, got)
+		}
+	}
+}
+
+func TestConfirmationsCarryPublishingData(t *testing.T) {
+	c := newConfirms(false)
+	l := make(chan Confirmation, 3)
+	c.Listen(l)
+
+	ctx := WithConfirmData(context.Background(), "row-1")
+	first := c.publish(confirmData(ctx))
+	second := c.publish(nil)
+	third := c.publish("row-3")
+
+	if want, got := "row-1", first.Data; want != got {
+		t.Errorf("expected the deferred confirmation to carry %q, got %v", want, got)
+	}
+
+	c.One(Confirmation{DeliveryTag: 3, Ack: false})
+	c.Multiple(Confirmation{DeliveryTag: 2, Ack: true})
+
+	want := []Confirmation{
+		{DeliveryTag: 1, Ack: true, Data: "row-1"},
+		{DeliveryTag: 2, Ack: true},
+		{DeliveryTag: 3, Ack: false, Data: "row-3"},
+	}
+	for _, w := range want {
+		if got := <-l; w != got {
+			t.Errorf("expected confirmation %+v, got %+v", w, got)
+		}
+	}
+
+	if second.Data != nil {
+		t.Errorf("expected no data on the second publishing, got %v", second.Data)
+	}
+	if third.Wait() {
+		t.Errorf("expected the third publishing to be nacked")
+	}
+	for tag := uint64(1); tag <= 3; tag++ {
+		if p := c.pending.get(tag); p != nil {
+			t.Errorf("expected the data of confirmed publishing %d to be released, got %v", tag, p.data)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package amqp091
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -74,6 +75,183 @@ type Config struct {
 	// If Dial is nil, net.DialTimeout with a 30s connection and 30s deadline is
 	// used during TLS and AMQP handshaking.
 	Dial func(network, addr string) (net.Conn, error)
+
+	// BlockedPublishTimeout bounds how long a publish waits while the server
+	// has blocked the connection with connection.blocked.  When the connection
+	// is still blocked after the timeout, the publish fails with an error
+	// wrapping ErrPublishBlocked and carrying the reason given by the server.
+	// Zero waits indefinitely, as publishes block on TCP pushback.
+	BlockedPublishTimeout time.Duration
+
+	// ReadTimeout is the read deadline renewed after every frame received when
+	// no heartbeat has been negotiated, so that a broker that stops sending is
+	// detected.  With heartbeats, the read deadline is derived from the
+	// heartbeat interval instead.  Zero disables the read deadline.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the write deadline set on the transport before writing
+	// frames, bounding how long a publish can block in conn.Write when the
+	// broker stops reading.  A timed out write closes the connection.  Zero
+	// lets writes block, as RabbitMQ uses TCP pushback for flow control.
+	WriteTimeout time.Duration
+
+	// ReadBufferSize and WriteBufferSize are the sizes of the buffers the
+	// frames are read from and written to the transport with, 4096 bytes when
+	// not greater than 0.  A read buffer larger than the deliveries received
+	// reads many of them per system call.  Unless write interceptors are
+	// installed or FlushDelay is set, the frames of publishings are written
+	// without the write buffer, which then only holds the other methods.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// MaxUnackedBytes, when greater than 0, bounds the bytes of the bodies of
+	// the deliveries to consumers of all channels that are not acknowledged
+	// yet.  Once exceeded, the connection stops reading frames until enough
+	// deliveries are acknowledged, so that TCP pushes back on the server and
+	// slow consumers do not exhaust the memory of the process.  Replies to
+	// methods are not read either meanwhile, so consumers must acknowledge
+	// their deliveries before calling methods such as Channel.Cancel, except
+	// Close, which releases the deliveries the server requeues.  Deliveries
+	// to consumers started with autoAck are not counted.  See
+	// Channel.SetMaxUnackedBytes for a bound per channel.
+	MaxUnackedBytes int64
+
+	// FlushDelay, when greater than 0, coalesces the frames of publishings:
+	// they are buffered and flushed FlushDelay after the first of them, or
+	// once FlushFrames frames are buffered when FlushFrames is greater than
+	// 0, so that many small publishings are written with fewer system calls
+	// and TCP segments.  Any other method flushes the buffer, as does
+	// Connection.Flush.  A FlushDelay of 100µs is a good start.
+	FlushDelay  time.Duration
+	FlushFrames int
+
+	// EnableStats maintains the traffic counters returned by
+	// Connection.Stats.  The counters are updated atomically on every frame.
+	EnableStats bool
+
+	// ReadFrameInterceptors are run in order on every frame read from the
+	// server before it is dispatched.  See FrameInterceptor.
+	ReadFrameInterceptors []FrameInterceptor
+
+	// WriteFrameInterceptors are run in order on every frame before it is
+	// written to the server.  See FrameInterceptor.
+	WriteFrameInterceptors []FrameInterceptor
+
+	// DumpFrames logs every method frame sent and received, decoded, at
+	// LevelDebug, see SetStructuredLogger.  The SASL responses and the secrets
+	// of connection.update-secret are redacted.  Meant for debugging protocol
+	// issues, it is too verbose for production use.
+	DumpFrames bool
+
+	// WarmChannels is the number of channels opened right after the handshake
+	// and kept ready for Connection.Channel, so that callers do not wait for
+	// the channel.open round trip.  Each warm channel handed out, or found
+	// closed in the pool, is replaced in the background, and warm channels that
+	// failed to open are retried the next time Connection.Channel is called.
+	// Zero disables the warm pool.
+	WarmChannels int
+
+	// Clock schedules heartbeats, timeouts and the periodic work of the
+	// channels, nil uses SystemClock.  Read and write deadlines of the
+	// transport are derived from Clock.Now.
+	Clock Clock
+
+	// StrictNotify makes the library panic when a listener registered with
+	// one of the Notify* methods is not ready to receive a notification,
+	// instead of blocking the dispatch of every frame until it is.  It is
+	// meant for tests, to catch listeners without enough buffer or receiver.
+	StrictNotify bool
+
+	// StampPublishedAt sets the PublishedAtHeader of every publishing that
+	// does not have it yet to the time of the publish, with millisecond
+	// precision, see Delivery.Age.
+	StampPublishedAt bool
+
+	// Stamping sets the properties of the publishings left unset, such as
+	// their Timestamp and MessageId, see Stamping.
+	Stamping Stamping
+
+	// PoolDeliveryBodies assembles the Body of deliveries to consumers in
+	// pooled buffers that are reused once Delivery.Ack, Delivery.Nack or
+	// Delivery.Reject return without error, so that high rate consumers do
+	// not allocate a buffer for every delivery.  Only set it when the
+	// application does not use Body, or a Publishing sharing it, after
+	// acknowledging, see Delivery.Clone.
+	PoolDeliveryBodies bool
+
+	// SkipNameValidation disables the client side checks of queue and
+	// exchange names made before declaring them, see NameError.
+	SkipNameValidation bool
+
+	// Lifecycle hooks observe connection and channel state transitions.  All
+	// hooks are optional and are called synchronously from the goroutine
+	// driving the transition, so they should return quickly and must not
+	// block on the Connection or Channel being observed.
+
+	// OnDialing is called by DialConfig before the transport is dialed.
+	OnDialing func(network, addr string)
+
+	// OnConnected is called once the AMQP handshake has completed.
+	OnConnected func(c *Connection)
+
+	// OnClosed is called once when the connection shuts down.  The error is
+	// nil on a graceful close.
+	OnClosed func(c *Connection, err *Error)
+
+	// OnChannelOpen is called after a channel has been opened on the
+	// connection.
+	OnChannelOpen func(ch *Channel)
+
+	// OnChannelClose is called once when a channel shuts down, including when
+	// the connection closes.  The error is nil on a graceful close.
+	OnChannelClose func(ch *Channel, err *Error)
+
+	// OnChannelFlow is called when the server pauses (active false) or
+	// resumes (active true) publishing on a channel with channel.flow, after
+	// the listeners registered with Channel.NotifyFlow.
+	OnChannelFlow func(ch *Channel, active bool)
+
+	// OnConsumerLiveness is called every ConsumerLivenessInterval for each
+	// consumer of each open channel, from a goroutine per channel, so that a
+	// consumer that stopped making progress can be detected.  Both must be set
+	// for the progress of consumers to be tracked.
+	OnConsumerLiveness       func(l ConsumerLiveness)
+	ConsumerLivenessInterval time.Duration
+
+	// OnDispatchDepth is called every DispatchDepthInterval for each open
+	// channel, from a goroutine per channel, with the depth of its internal
+	// queues, to tune the delivery buffers of consumers and the number of
+	// channels publishing.  Both must be set for the depths to be reported.
+	OnDispatchDepth       func(d DispatchDepth)
+	DispatchDepthInterval time.Duration
+
+	// OnSlowConsumer is called when a delivery waits longer than
+	// SlowConsumerThreshold in the client-side buffer of its consumer, once
+	// per delivery, from a goroutine per channel, or when handing a
+	// delivery to the full buffer of a consumer blocks the connection longer
+	// than SlowConsumerThreshold, from the goroutine reading from the
+	// connection, which is held up meanwhile.  Both must be set for the
+	// deliveries to be timed.  See SlowConsumer.
+	OnSlowConsumer        func(s SlowConsumer)
+	SlowConsumerThreshold time.Duration
+
+	// DispatchWorkers, when greater than 0, has this many goroutines of the
+	// connection send the deliveries to the consumers of all its channels,
+	// instead of a goroutine per consumer, which saves memory for
+	// applications with thousands of channels.  The consumers are assigned
+	// to the workers in turn, and each worker waits for any of its consumers
+	// to receive, so that a slow consumer does not hold up the others.
+	// Consumers with a buffer, see WithBufferSize, keep a goroutine of their
+	// own.
+	DispatchWorkers int
+
+	// DecodeMode sets whether a field table with a field of an unknown type,
+	// or a malformed header frame, closes the connection, DecodeStrict by
+	// default, or is skipped, see DecodeLenient.  OnDecodeWarning, when set,
+	// is called with what DecodeLenient skipped, from the goroutine reading
+	// the frames, so it must not block.
+	DecodeMode      DecodeMode
+	OnDecodeWarning func(w DecodeWarning)
 }
 
 // NewConnectionProperties creates an amqp.Table to be used as amqp.Config.Properties.
@@ -99,36 +277,62 @@ type Connection struct {
 
 	conn io.ReadWriteCloser
 
-	rpc       chan message
-	writer    *writer
-	sends     chan time.Time     // timestamps of each frame sent
-	deadlines chan readDeadliner // heartbeater updates read deadlines
+	rpc        chan message
+	writer     *writer
+	unflushed  int           // frames buffered by writeContent, guarded by sendM
+	vectored   bool          // conn writes net.Buffers with writev, see sendContent
+	flushes    chan struct{} // signals the first unflushed frame, see flusher
+	dispatch   *dispatchPool // nil unless Config.DispatchWorkers
+	budget     memoryBudget  // see Config.MaxUnackedBytes
+	stalled    atomic.Bool   // the reader waits for the budgets, see waitBudgets
+	closing    atomic.Bool   // Close was called, the reader must not wait
+	budgetM    sync.Mutex
+	budgetWait chan struct{}      // closed when a budget is released, see waitBudgets
+	sends      chan time.Time     // timestamps of each frame sent
+	deadlines  chan readDeadliner // heartbeater updates read deadlines
 
 	allocator *allocator // id generator valid after openTune
-	channels  map[uint16]*Channel
+	channels  *channelTable
 
 	noNotify bool // true when we will never notify again
 	closes   []chan *Error
 	blocks   []chan Blocking
 
+	warm        chan *Channel // pre-opened channels, see Config.WarmChannels
+	warmMissing atomic.Int32  // warm channels that failed to open
+
+	stats *connStats // nil unless Config.EnableStats
+
+	name string // connection_name client property, logged with the messages
+
+	unblocked     chan struct{} // closed on connection.unblocked, nil when not blocked
+	blockedReason string
+
 	errors chan *Error
 	// if connection is closed should close this chan
 	close chan struct{}
 
 	Config Config // The negotiated Config after connection.open
 
-	Major      int      // Server's major version
-	Minor      int      // Server's minor version
-	Properties Table    // Server properties
-	Locales    []string // Server locales
+	Major        int                // Server's major version
+	Minor        int                // Server's minor version
+	Properties   Table              // Server properties
+	Capabilities ServerCapabilities // Server capabilities parsed from Properties
+	Locales      []string           // Server locales
 
 	closed int32 // Will be 1 if the connection is closed, 0 otherwise. Should only be accessed as atomic
+
+	closedBy atomic.Value // *Error of why the connection closed, see CloseReason
 }
 
 type readDeadliner interface {
 	SetReadDeadline(time.Time) error
 }
 
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
 // DefaultDial establishes a connection when config.Dial is not provided
 func DefaultDial(connectionTimeout time.Duration) func(network, addr string) (net.Conn, error) {
 	return func(network, addr string) (net.Conn, error) {
@@ -244,14 +448,37 @@ func DialConfig(url string, config Config) (*Connection, error) {
 		connectionTimeout = time.Duration(uri.ConnectionTimeout) * time.Millisecond
 	}
 
-	addr := net.JoinHostPort(uri.Host, strconv.FormatInt(int64(uri.Port), 10))
-
 	dialer := config.Dial
 	if dialer == nil {
 		dialer = DefaultDial(connectionTimeout)
 	}
 
-	conn, err = dialer("tcp", addr)
+	// Brokers of a multi-host URI are tried in order until one accepts the
+	// connection.
+	for _, address := range uri.addresses() {
+		if conn, err = dialAddress(uri, address, &config, dialer); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(conn, config)
+}
+
+// dialAddress dials one broker of uri and performs the TLS handshake for amqps.
+func dialAddress(uri URI, address Address, config *Config, dialer func(network, addr string) (net.Conn, error)) (net.Conn, error) {
+	network, addr := "tcp", net.JoinHostPort(address.Host, strconv.FormatInt(int64(address.Port), 10))
+	if uri.Scheme == unixScheme {
+		network, addr = "unix", uri.SocketPath
+	}
+
+	if config.OnDialing != nil {
+		config.OnDialing(network, addr)
+	}
+
+	conn, err := dialer(network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -260,18 +487,22 @@ func DialConfig(url string, config Config) (*Connection, error) {
 		if config.TLSClientConfig == nil {
 			tlsConfig, err := tlsConfigFromURI(uri)
 			if err != nil {
+				conn.Close()
 				return nil, fmt.Errorf("create TLS config from URI: %w", err)
 			}
 			config.TLSClientConfig = tlsConfig
 		}
 
 		// If ServerName has not been specified in TLSClientConfig,
-		// set it to the URI host used for this connection.
-		if config.TLSClientConfig.ServerName == "" {
-			config.TLSClientConfig.ServerName = uri.Host
+		// set it to the URI host used for this connection, without the
+		// zone of an IPv6 literal which certificates do not name.
+		tlsConfig := config.TLSClientConfig
+		if tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = strings.Cut(address.Host, "%")
 		}
 
-		client := tls.Client(conn, config.TLSClientConfig)
+		client := tls.Client(conn, tlsConfig)
 		if err := client.Handshake(); err != nil {
 			conn.Close()
 			return nil, err
@@ -280,7 +511,7 @@ func DialConfig(url string, config Config) (*Connection, error) {
 		conn = client
 	}
 
-	return Open(conn, config)
+	return conn, nil
 }
 
 /*
@@ -291,16 +522,85 @@ to use your own custom transport.
 func Open(conn io.ReadWriteCloser, config Config) (*Connection, error) {
 	c := &Connection{
 		conn:      conn,
-		writer:    &writer{bufio.NewWriter(conn)},
-		channels:  make(map[uint16]*Channel),
+		writer:    &writer{bufio.NewWriterSize(conn, bufferSize(config.WriteBufferSize))},
+		channels:  newChannelTable(),
 		rpc:       make(chan message),
 		sends:     make(chan time.Time),
 		errors:    make(chan *Error, 1),
 		close:     make(chan struct{}),
 		deadlines: make(chan readDeadliner, 1),
 	}
+
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		c.vectored = true
+	}
+
+	c.name, _ = config.Properties["connection_name"].(string)
+
+	if config.EnableStats {
+		c.stats = &connStats{}
+		c.writer = &writer{bufio.NewWriterSize(&countingWriter{conn, &c.stats.bytesWritten}, bufferSize(config.WriteBufferSize))}
+	}
+
+	c.Config.ReadBufferSize = config.ReadBufferSize
+	c.Config.MaxUnackedBytes = config.MaxUnackedBytes
+	c.budget.limit.Store(config.MaxUnackedBytes)
+	c.Config.FlushDelay = config.FlushDelay
+	c.Config.FlushFrames = config.FlushFrames
+	if c.Config.FlushDelay > 0 {
+		c.flushes = make(chan struct{}, 1)
+	}
+	c.Config.WriteBufferSize = config.WriteBufferSize
+
+	c.Config.EnableStats = config.EnableStats
+	c.Config.BlockedPublishTimeout = config.BlockedPublishTimeout
+	c.Config.ReadTimeout = config.ReadTimeout
+	c.Config.WriteTimeout = config.WriteTimeout
+	c.Config.ReadFrameInterceptors = config.ReadFrameInterceptors
+	c.Config.WriteFrameInterceptors = config.WriteFrameInterceptors
+	c.Config.DumpFrames = config.DumpFrames
+	c.Config.WarmChannels = config.WarmChannels
+	c.Config.SkipNameValidation = config.SkipNameValidation
+	c.Config.PoolDeliveryBodies = config.PoolDeliveryBodies
+	c.Config.StampPublishedAt = config.StampPublishedAt
+	c.Config.Stamping = config.Stamping
+	c.Config.Clock = config.Clock
+	c.Config.StrictNotify = config.StrictNotify
+
+	// Hooks must be in place before the reader can observe a shutdown.
+	c.Config.OnDialing = config.OnDialing
+	c.Config.OnConnected = config.OnConnected
+	c.Config.OnClosed = config.OnClosed
+	c.Config.OnChannelOpen = config.OnChannelOpen
+	c.Config.OnChannelClose = config.OnChannelClose
+	c.Config.OnChannelFlow = config.OnChannelFlow
+	c.Config.OnConsumerLiveness = config.OnConsumerLiveness
+	c.Config.ConsumerLivenessInterval = config.ConsumerLivenessInterval
+	c.Config.OnDispatchDepth = config.OnDispatchDepth
+	c.Config.DispatchDepthInterval = config.DispatchDepthInterval
+	c.Config.OnSlowConsumer = config.OnSlowConsumer
+	c.Config.SlowConsumerThreshold = config.SlowConsumerThreshold
+	c.Config.DispatchWorkers = config.DispatchWorkers
+	c.Config.DecodeMode = config.DecodeMode
+	c.Config.OnDecodeWarning = config.OnDecodeWarning
+	if c.Config.DispatchWorkers > 0 {
+		c.dispatch = newDispatchPool(c.Config.DispatchWorkers, c.close)
+	}
+
 	go c.reader(conn)
-	return c, c.open(config)
+
+	err := c.open(config)
+	if err == nil && c.flushes != nil {
+		go c.flusher(c.Config.FlushDelay)
+	}
+	if err == nil {
+		c.warmChannels()
+	}
+	if err == nil && c.Config.OnConnected != nil {
+		c.Config.OnConnected(c)
+	}
+	return c, err
 }
 
 /*
@@ -419,15 +719,31 @@ including the underlying io, Channels, Notify listeners and Channel consumers
 will also be closed.
 */
 func (c *Connection) Close() error {
+	return c.CloseWithCode(replySuccess, "kthxbai")
+}
+
+/*
+CloseWithCode closes the connection like Close, sending the given reply code
+and reason to the server instead of 200.  The reason shows up in the broker
+logs, which helps operators tell why an application disconnected, for example
+with ConnectionForced and "shutting down for deploy".
+
+The code must be a valid AMQP reply code, see the constants such as
+ConnectionForced.  Listeners to NotifyClose are notified as on a graceful
+Close.
+*/
+func (c *Connection) CloseWithCode(code int, reason string) error {
 	if c.IsClosed() {
 		return ErrClosed
 	}
 
+	c.stopWaitingBudgets()
+
 	defer c.shutdown(nil)
 	return c.call(
 		&connectionClose{
-			ReplyCode: replySuccess,
-			ReplyText: "kthxbai",
+			ReplyCode: uint16(code),
+			ReplyText: reason,
 		},
 		&connectionCloseOk{},
 	)
@@ -446,18 +762,29 @@ func (c *Connection) Close() error {
 // After returning from this call, all resources associated with this connection,
 // including the underlying io, Channels, Notify listeners and Channel consumers
 // will also be closed.
+//
+// Transports that do not support deadlines, such as those given to Open, are
+// closed with CloseContext instead.
 func (c *Connection) CloseDeadline(deadline time.Time) error {
 	if c.IsClosed() {
 		return ErrClosed
 	}
 
-	defer c.shutdown(nil)
+	if err := c.setDeadline(deadline); err != nil {
+		if err != errInvalidTypeAssertion {
+			defer c.shutdown(nil)
+			return err
+		}
 
-	err := c.setDeadline(deadline)
-	if err != nil {
-		return err
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		return c.CloseContext(ctx)
 	}
 
+	c.stopWaitingBudgets()
+
+	defer c.shutdown(nil)
+
 	return c.call(
 		&connectionClose{
 			ReplyCode: replySuccess,
@@ -467,11 +794,51 @@ func (c *Connection) CloseDeadline(deadline time.Time) error {
 	)
 }
 
+// CloseContext requests and waits for the response to close this AMQP
+// connection until ctx is done.
+//
+// When ctx is done before the server responds, the underlying io is closed
+// without waiting any further and context.Cause(ctx) is returned, so that shutting
+// down does not hang on an unresponsive server.
+//
+// Regardless of the error returned, the connection is considered closed, and it
+// should not be used after calling this function.
+func (c *Connection) CloseContext(ctx context.Context) error {
+	if c.IsClosed() {
+		return ErrClosed
+	}
+
+	c.stopWaitingBudgets()
+
+	defer c.shutdown(nil)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.call(
+			&connectionClose{
+				ReplyCode: replySuccess,
+				ReplyText: "kthxbai",
+			},
+			&connectionCloseOk{},
+		)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		_ = c.conn.Close()
+		return context.Cause(ctx)
+	}
+}
+
 func (c *Connection) closeWith(err *Error) error {
 	if c.IsClosed() {
 		return ErrClosed
 	}
 
+	c.stopWaitingBudgets()
+
 	defer c.shutdown(err)
 
 	return c.call(
@@ -507,7 +874,7 @@ func (c *Connection) send(f frame) error {
 	}
 
 	c.sendM.Lock()
-	err := c.writer.WriteFrame(f)
+	err := c.writeFrame(f, true)
 	c.sendM.Unlock()
 
 	if err != nil {
@@ -521,7 +888,7 @@ func (c *Connection) send(f frame) error {
 		// if there is something that can receive - like a non-reentrant
 		// call or if the heartbeater isn't running
 		select {
-		case c.sends <- time.Now():
+		case c.sends <- c.clock().Now():
 		default:
 		}
 	}
@@ -529,36 +896,26 @@ func (c *Connection) send(f frame) error {
 	return err
 }
 
-// This method is intended to be used with sendUnflushed() to end a sequence
-// of sendUnflushed() calls and flush the connection
-func (c *Connection) endSendUnflushed() error {
-	c.sendM.Lock()
-	defer c.sendM.Unlock()
-	return c.flush()
-}
-
-// sendUnflushed performs an *Unflushed* write. It is otherwise equivalent to
-// send(), and we provide a separate flush() function to explicitly flush the
-// buffer after all Frames are written.
-//
-// Why is this a thing?
-//
-// send() method uses writer.WriteFrame(), which will write the Frame then
-// flush the buffer. For cases like the sendOpen() method on Channel, which
-// sends multiple Frames (methodFrame, headerFrame, N x bodyFrame), flushing
-// after each Frame is inefficient as it negates much of the benefit of using a
-// buffered writer, and results in more syscalls than necessary. Flushing buffers
-// after every frame can have a significant performance impact when sending
-// (basicPublish) small messages, so this method performs an *Unflushed* write
-// but is otherwise equivalent to send() method, and we provide a separate
-// flush method to explicitly flush the buffer after all Frames are written.
-func (c *Connection) sendUnflushed(f frame) error {
+/*
+sendContent writes the method, header and body frames of a message with
+content, such as a basic.publish, with a single vectored write through
+net.Buffers: writev on TCP and Unix sockets, instead of a write per frame.
+Bodies are written from the memory of the message rather than copied into the
+buffer of the connection, except small ones, see inlineBodySize.
+
+Other transports, such as a *tls.Conn, would write every buffer on its own,
+as a TLS record each, so the frames go one by one through the buffered writer
+and are written with a single flush instead.  So they do when write
+interceptors are installed, as the interceptors may drop or rewrite them, and
+when Config.FlushDelay coalesces publishings.
+*/
+func (c *Connection) sendContent(frames []frame) error {
 	if c.IsClosed() {
 		return ErrClosed
 	}
 
 	c.sendM.Lock()
-	err := c.writer.WriteFrameNoFlush(f)
+	err := c.writeContent(frames)
 	c.sendM.Unlock()
 
 	if err != nil {
@@ -567,46 +924,137 @@ func (c *Connection) sendUnflushed(f frame) error {
 			Code:   FrameError,
 			Reason: err.Error(),
 		})
+		return err
 	}
 
-	return err
+	// Broadcast we sent a frame, reducing heartbeats, only if there is
+	// something that can receive.
+	select {
+	case c.sends <- c.clock().Now():
+	default:
+	}
+
+	return nil
 }
 
-// This method is intended to be used with sendUnflushed() to explicitly flush
-// the buffer after all required Frames have been written to the buffer.
-func (c *Connection) flush() (err error) {
-	if buf, ok := c.writer.w.(*bufio.Writer); ok {
-		err = buf.Flush()
+// writeContent writes the frames of sendContent.  Must be called while holding
+// sendM.
+func (c *Connection) writeContent(frames []frame) error {
+	if !c.vectored || c.flushes != nil || len(c.Config.WriteFrameInterceptors) > 0 {
+		for _, f := range frames {
+			if err := c.writeFrame(f, false); err != nil {
+				return err
+			}
+		}
+		if c.flushes != nil {
+			return c.deferFlush(len(frames))
+		}
+		return c.flushWriter()
+	}
+
+	c.setWriteDeadline()
+
+	// Frames left in the buffer go first.
+	if err := c.flushWriter(); err != nil {
+		return err
+	}
+
+	scratch := getPayload()
+	defer putPayload(scratch)
+
+	bufs, err := contentBuffers(scratch, frames)
+	if err != nil {
+		return err
+	}
 
-		// Moving send notifier to flush increases basicPublish for the small message
-		// case. As sendUnflushed + flush is used for the case of sending semantically
-		// related Frames (e.g. a Message like basicPublish) there is no real advantage
-		// to sending per Frame vice per "group of related Frames" and for the case of
-		// small messages time.Now() is (relatively) expensive.
+	n, err := bufs.WriteTo(c.conn)
+	if c.stats != nil {
+		atomic.AddUint64(&c.stats.bytesWritten, uint64(n))
 		if err == nil {
-			// Broadcast we sent a frame, reducing heartbeats, only
-			// if there is something that can receive - like a non-reentrant
-			// call or if the heartbeater isn't running
-			select {
-			case c.sends <- time.Now():
-			default:
+			for _, f := range frames {
+				c.stats.frameWritten(f)
 			}
 		}
 	}
+	if err == nil {
+		for _, f := range frames {
+			c.dumpFrame("sent", f)
+		}
+	}
+	return err
+}
+
+// writeFrame runs the write interceptors on f and writes the resulting frame.
+// A frame dropped by an interceptor is not written.  Must be called while
+// holding sendM.
+func (c *Connection) writeFrame(f frame, flush bool) error {
+	f, keep, err := interceptFrame(c.Config.WriteFrameInterceptors, f)
+	if err != nil || !keep {
+		return err
+	}
+
+	c.setWriteDeadline()
+
+	if flush {
+		c.unflushed = 0
+		err = c.writer.WriteFrame(f)
+	} else {
+		err = c.writer.WriteFrameNoFlush(f)
+	}
+
+	if err == nil && c.stats != nil {
+		c.stats.frameWritten(f)
+	}
+	if err == nil {
+		c.dumpFrame("sent", f)
+	}
+	return err
+}
+
+// setWriteDeadline renews the write deadline of the transport when
+// Config.WriteTimeout is set.  Must be called while holding sendM.
+func (c *Connection) setWriteDeadline() {
+	if c.Config.WriteTimeout <= 0 {
+		return
+	}
+
+	if conn, ok := c.conn.(writeDeadliner); ok {
+		if err := conn.SetWriteDeadline(c.clock().Now().Add(c.Config.WriteTimeout)); err != nil {
+			c.log(LevelWarn, "error setting write deadline", "error", err)
+		}
+	}
+}
 
-	return
+// CloseReason returns why the connection closed: ErrClosedByClient or
+// ErrConnectionLost.  It returns nil while the connection is open.  Operations
+// on a closed connection return ErrClosed whatever the reason.
+func (c *Connection) CloseReason() error {
+	if reason, ok := c.closedBy.Load().(*Error); ok {
+		return reason
+	}
+	return nil
 }
 
 func (c *Connection) shutdown(err *Error) {
+	if err == nil {
+		c.closedBy.CompareAndSwap(nil, ErrClosedByClient)
+	} else {
+		c.closedBy.CompareAndSwap(nil, ErrConnectionLost)
+	}
 	atomic.StoreInt32(&c.closed, 1)
 
+	var closed []*Channel
+	var done bool
+
 	c.destructor.Do(func() {
 		c.m.Lock()
 		defer c.m.Unlock()
 
+		done = true
+
 		if err != nil {
-			for _, c := range c.closes {
-				c <- err
+			for _, l := range c.closes {
+				notify(c.strictNotify(), l, err, "NotifyClose")
 			}
 			c.errors <- err
 		}
@@ -626,18 +1074,35 @@ func (c *Connection) shutdown(err *Error) {
 		//
 		// Ranging over c.channels and calling releaseChannel() that mutates
 		// c.channels is racy - see commit 6063341 for an example.
-		for _, ch := range c.channels {
-			ch.shutdown(err)
+		for _, ch := range c.channels.clear() {
+			if ch.shutdown(err) {
+				closed = append(closed, ch)
+			}
 		}
 
 		c.conn.Close()
 		// reader exit
 		close(c.close)
 
-		c.channels = nil
 		c.allocator = nil
 		c.noNotify = true
 	})
+
+	// Lifecycle hooks run after the connection lock has been released so
+	// they may safely inspect the connection.
+	if !done {
+		return
+	}
+
+	if c.Config.OnChannelClose != nil {
+		for _, ch := range closed {
+			c.Config.OnChannelClose(ch, err)
+		}
+	}
+
+	if c.Config.OnClosed != nil {
+		c.Config.OnClosed(c, err)
+	}
 }
 
 // All methods sent to the connection channel should be synchronous so we
@@ -658,16 +1123,18 @@ func (c *Connection) dispatch0(f frame) {
 			// Send immediately as shutdown will close our side of the writer.
 			f := &methodFrame{ChannelId: 0, Method: &connectionCloseOk{}}
 			if err := c.send(f); err != nil {
-				Logger.Printf("error sending connectionCloseOk, error: %+v", err)
+				c.log(LevelWarn, "error sending connectionCloseOk", "method", "connection.close-ok", "error", err)
 			}
 			c.shutdown(newError(m.ReplyCode, m.ReplyText))
 		case *connectionBlocked:
-			for _, c := range c.blocks {
-				c <- Blocking{Active: true, Reason: m.Reason}
+			c.setBlocked(true, m.Reason)
+			for _, l := range c.blocks {
+				notify(c.strictNotify(), l, Blocking{Active: true, Reason: m.Reason}, "NotifyBlocked")
 			}
 		case *connectionUnblocked:
-			for _, c := range c.blocks {
-				c <- Blocking{Active: false}
+			c.setBlocked(false, "")
+			for _, l := range c.blocks {
+				notify(c.strictNotify(), l, Blocking{Active: false}, "NotifyBlocked")
 			}
 		default:
 			select {
@@ -683,26 +1150,26 @@ func (c *Connection) dispatch0(f frame) {
 		// closeWith use call don't block reader
 		go func() {
 			if err := c.closeWith(ErrUnexpectedFrame); err != nil {
-				Logger.Printf("error sending connectionCloseOk with ErrUnexpectedFrame, error: %+v", err)
+				c.log(LevelWarn, "error closing the connection on an unexpected frame", "method", "connection.close", "error", err)
 			}
 		}()
 	}
 }
 
 func (c *Connection) dispatchN(f frame) {
-	c.m.Lock()
-	channel, ok := c.channels[f.channel()]
+	// Looked up without the connection lock, see channelTable.
+	channel, ok := c.channels.get(f.channel())
 	if ok {
 		updateChannel(f, channel)
 	} else {
-		Logger.Printf("[debug] dropping frame, channel %d does not exist", f.channel())
+		c.log(LevelDebug, "dropping frame, channel does not exist", "channel", f.channel())
 	}
-	c.m.Unlock()
 
 	// Note: this could result in concurrent dispatch depending on
 	// how channels are managed in an application
 	if ok {
 		channel.recv(channel, f)
+		c.waitBudgets(channel)
 	} else {
 		c.dispatchClosed(f)
 	}
@@ -726,7 +1193,7 @@ func (c *Connection) dispatchClosed(f frame) {
 		case *channelClose:
 			f := &methodFrame{ChannelId: f.channel(), Method: &channelCloseOk{}}
 			if err := c.send(f); err != nil {
-				Logger.Printf("error sending channelCloseOk, channel id: %d error: %+v", f.channel(), err)
+				c.log(LevelWarn, "error sending channelCloseOk", "channel", f.channel(), "method", "channel.close-ok", "error", err)
 			}
 		case *channelCloseOk:
 			// we are already closed, so do nothing
@@ -735,20 +1202,42 @@ func (c *Connection) dispatchClosed(f frame) {
 			// closeWith use call don't block reader
 			go func() {
 				if err := c.closeWith(ErrClosed); err != nil {
-					Logger.Printf("error sending connectionCloseOk with ErrClosed, error: %+v", err)
+					c.log(LevelWarn, "error closing the connection on a frame for a closed channel", "method", "connection.close", "error", err)
 				}
 			}()
 		}
 	}
 }
 
+// defaultBufferSize is the size of the read and write buffers of a connection
+// when not configured, see Config.ReadBufferSize.
+const defaultBufferSize = 4096
+
+func bufferSize(size int) int {
+	if size <= 0 {
+		return defaultBufferSize
+	}
+	return size
+}
+
 // Reads each frame off the IO and hand off to the connection object that
 // will demux the streams and dispatch to one of the opened channels or
 // handle on channel 0 (the connection channel).
 func (c *Connection) reader(r io.Reader) {
-	buf := bufio.NewReader(r)
+	src := r
+	if c.stats != nil {
+		src = &countingReader{r, &c.stats.bytesRead}
+	}
+
+	buf := bufio.NewReaderSize(src, bufferSize(c.Config.ReadBufferSize))
 	frames := &reader{buf}
-	conn, haveDeadliner := r.(readDeadliner)
+	if c.Config.DecodeMode == DecodeLenient {
+		frames = &reader{newLenientReader(buf, c.Config.OnDecodeWarning)}
+	}
+
+	// Transports without deadlines are still reported as nil so that the
+	// heartbeater knows when the server was last heard from.
+	conn, _ := r.(readDeadliner)
 
 	defer close(c.rpc)
 
@@ -759,15 +1248,33 @@ func (c *Connection) reader(r io.Reader) {
 			return
 		}
 
-		c.demux(frame)
+		read := frame
+		frame, keep, err := interceptFrame(c.Config.ReadFrameInterceptors, frame)
+		if err != nil {
+			c.shutdown(&Error{Code: FrameError, Reason: err.Error()})
+			return
+		}
 
-		if haveDeadliner {
-			select {
-			case c.deadlines <- conn:
-			default:
-				// On c.Close() c.heartbeater() might exit just before c.deadlines <- conn is called.
-				// Which results in this goroutine being stuck forever.
-			}
+		if c.stats != nil {
+			c.stats.frameRead(frame)
+		}
+		c.dumpFrame("received", frame)
+
+		if keep {
+			c.demux(frame)
+		}
+
+		// Body frames are copied by the channels, their buffers are reused.
+		releaseFrame(read)
+		if keep && frame != read {
+			releaseFrame(frame)
+		}
+
+		select {
+		case c.deadlines <- conn:
+		default:
+			// On c.Close() c.heartbeater() might exit just before c.deadlines <- conn is called.
+			// Which results in this goroutine being stuck forever.
 		}
 	}
 }
@@ -777,14 +1284,24 @@ func (c *Connection) reader(r io.Reader) {
 func (c *Connection) heartbeater(interval time.Duration, done chan *Error) {
 	const maxServerHeartbeatsInFlight = 3
 
+	clock := c.clock()
+
 	var sendTicks <-chan time.Time
 	if interval > 0 {
-		ticker := time.NewTicker(interval)
+		ticker := clock.NewTicker(interval)
 		defer ticker.Stop()
-		sendTicks = ticker.C
+		sendTicks = ticker.C()
 	}
 
-	lastSent := time.Now()
+	lastSent := clock.Now()
+	lastRead := lastSent
+
+	// Read deadlines cover at least 2 server heartbeats, or the configured
+	// timeout when no heartbeat has been negotiated.
+	timeout := maxServerHeartbeatsInFlight * interval
+	if interval <= 0 {
+		timeout = c.Config.ReadTimeout
+	}
 
 	for {
 		select {
@@ -797,6 +1314,22 @@ func (c *Connection) heartbeater(interval time.Duration, done chan *Error) {
 			}
 
 		case at := <-sendTicks:
+			// The server is not heard from while the reader waits for the
+			// memory budgets on purpose.
+			if c.stalled.Load() {
+				lastRead = at
+			}
+
+			// Also detect missed heartbeats on transports without read
+			// deadlines, and when time is driven by Config.Clock.
+			if at.Sub(lastRead) > timeout {
+				go c.shutdown(&Error{
+					Code:   FrameError,
+					Reason: fmt.Sprintf("missed heartbeats from server, timeout: %s", timeout),
+				})
+				return
+			}
+
 			// When idle, fill the space with a heartbeat frame
 			if at.Sub(lastSent) > interval-time.Second {
 				if err := c.send(&heartbeatFrame{}); err != nil {
@@ -808,12 +1341,13 @@ func (c *Connection) heartbeater(interval time.Duration, done chan *Error) {
 
 		case conn := <-c.deadlines:
 			// When reading, reset our side of the deadline, if we've negotiated one with
-			// a deadline that covers at least 2 server heartbeats
-			if interval > 0 {
-				if err := conn.SetReadDeadline(time.Now().Add(maxServerHeartbeatsInFlight * interval)); err != nil {
+			// a deadline that covers at least 2 server heartbeats, or configured one
+			lastRead = clock.Now()
+			if conn != nil && timeout > 0 {
+				if err := conn.SetReadDeadline(lastRead.Add(timeout)); err != nil {
 					var opErr *net.OpError
 					if !errors.As(err, &opErr) {
-						Logger.Printf("error setting read deadline in heartbeater: %+v", err)
+						c.log(LevelWarn, "error setting read deadline in heartbeater", "error", err)
 						return
 					}
 				}
@@ -825,13 +1359,105 @@ func (c *Connection) heartbeater(interval time.Duration, done chan *Error) {
 	}
 }
 
-// Convenience method to inspect the Connection.Properties["capabilities"]
-// Table for server identified capabilities like "basic.ack" or
-// "confirm.select".
-func (c *Connection) isCapable(featureName string) bool {
-	capabilities, _ := c.Properties["capabilities"].(Table)
-	hasFeature, _ := capabilities[featureName].(bool)
-	return hasFeature
+// setBlocked records the TCP flow control state sent by the server with
+// connection.blocked and connection.unblocked.
+func (c *Connection) setBlocked(active bool, reason string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if active {
+		if c.unblocked == nil {
+			c.unblocked = make(chan struct{})
+		}
+		c.blockedReason = reason
+	} else if c.unblocked != nil {
+		close(c.unblocked)
+		c.unblocked = nil
+		c.blockedReason = ""
+	}
+}
+
+// isBlocked returns true while the server has blocked the connection.
+func (c *Connection) isBlocked() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.unblocked != nil
+}
+
+// waitUnblocked waits up to timeout for the server to unblock the connection.
+func (c *Connection) waitUnblocked(ctx context.Context, timeout time.Duration) error {
+	c.m.Lock()
+	unblocked, reason := c.unblocked, c.blockedReason
+	c.m.Unlock()
+
+	if unblocked == nil {
+		return nil
+	}
+
+	timer := c.clock().NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-unblocked:
+		return nil
+	case <-c.close:
+		return ErrClosed
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C():
+		return fmt.Errorf("%w after %s: %s", ErrPublishBlocked, timeout, reason)
+	}
+}
+
+// ServerCapabilities holds the protocol extensions advertised by the server in
+// the "capabilities" table of the connection.start server properties.  A
+// capability the server does not advertise is false.
+//
+// See https://www.rabbitmq.com/extensions.html for a description of each
+// extension.
+type ServerCapabilities struct {
+	PublisherConfirms          bool // publisher_confirms
+	ExchangeExchangeBindings   bool // exchange_exchange_bindings
+	BasicNack                  bool // basic.nack
+	ConsumerCancelNotify       bool // consumer_cancel_notify
+	ConnectionBlocked          bool // connection.blocked
+	ConsumerPriorities         bool // consumer_priorities
+	AuthenticationFailureClose bool // authentication_failure_close
+	PerConsumerQos             bool // per_consumer_qos
+	DirectReplyTo              bool // direct_reply_to
+}
+
+// newServerCapabilities parses the Properties["capabilities"] Table sent by the
+// server on connection.start.
+func newServerCapabilities(properties Table) ServerCapabilities {
+	capabilities, _ := properties["capabilities"].(Table)
+	has := func(featureName string) bool {
+		hasFeature, _ := capabilities[featureName].(bool)
+		return hasFeature
+	}
+
+	return ServerCapabilities{
+		PublisherConfirms:          has("publisher_confirms"),
+		ExchangeExchangeBindings:   has("exchange_exchange_bindings"),
+		BasicNack:                  has("basic.nack"),
+		ConsumerCancelNotify:       has("consumer_cancel_notify"),
+		ConnectionBlocked:          has("connection.blocked"),
+		ConsumerPriorities:         has("consumer_priorities"),
+		AuthenticationFailureClose: has("authentication_failure_close"),
+		PerConsumerQos:             has("per_consumer_qos"),
+		DirectReplyTo:              has("direct_reply_to"),
+	}
+}
+
+// ChannelMax returns the maximum number of channels that can be open at once
+// on the connection, as negotiated with the server, like Config.ChannelMax.
+// When neither the client nor the server set a limit, this is 2^16 - 1.
+func (c *Connection) ChannelMax() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return int(c.Config.ChannelMax)
 }
 
 // allocateChannel records but does not open a new channel with a unique id.
@@ -851,7 +1477,7 @@ func (c *Connection) allocateChannel() (*Channel, error) {
 	}
 
 	ch := newChannel(c, uint16(id))
-	c.channels[uint16(id)] = ch
+	c.channels.add(ch)
 
 	return ch, nil
 }
@@ -862,12 +1488,8 @@ func (c *Connection) releaseChannel(ch *Channel) {
 	c.m.Lock()
 	defer c.m.Unlock()
 
-	if !c.IsClosed() {
-		got, ok := c.channels[ch.id]
-		if ok && got == ch {
-			delete(c.channels, ch.id)
-			c.allocator.release(int(ch.id))
-		}
+	if !c.IsClosed() && c.channels.remove(ch) {
+		c.allocator.release(int(ch.id))
 	}
 }
 
@@ -878,10 +1500,36 @@ func (c *Connection) openChannel() (*Channel, error) {
 		return nil, err
 	}
 
+	reportLiveness := c.Config.OnConsumerLiveness != nil && c.Config.ConsumerLivenessInterval > 0
+	if reportLiveness {
+		ch.consumers.trackLiveness()
+	}
+
+	reportSlow := c.Config.OnSlowConsumer != nil && c.Config.SlowConsumerThreshold > 0
+	if reportSlow {
+		ch.consumers.trackSlowConsumers(ch, c.Config.SlowConsumerThreshold, c.Config.OnSlowConsumer)
+	}
+
 	if err := ch.open(); err != nil {
 		c.releaseChannel(ch)
 		return nil, err
 	}
+
+	if reportLiveness {
+		go ch.reportLiveness(c.Config.ConsumerLivenessInterval, c.Config.OnConsumerLiveness)
+	}
+
+	if c.Config.OnDispatchDepth != nil && c.Config.DispatchDepthInterval > 0 {
+		go ch.reportDepth(c.Config.DispatchDepthInterval, c.Config.OnDispatchDepth)
+	}
+
+	if reportSlow {
+		go ch.reportSlowConsumers(c.Config.SlowConsumerThreshold, c.Config.OnSlowConsumer)
+	}
+
+	if c.Config.OnChannelOpen != nil {
+		c.Config.OnChannelOpen(ch)
+	}
 	return ch, nil
 }
 
@@ -889,8 +1537,12 @@ func (c *Connection) openChannel() (*Channel, error) {
 // closures should be initiated here for proper channel lifecycle management on
 // this connection.
 func (c *Connection) closeChannel(ch *Channel, e *Error) {
-	ch.shutdown(e)
+	done := ch.shutdown(e)
 	c.releaseChannel(ch)
+
+	if done && c.Config.OnChannelClose != nil {
+		c.Config.OnChannelClose(ch, e)
+	}
 }
 
 /*
@@ -901,11 +1553,79 @@ invalid and a new Channel should be opened.
 Channels are not thread-safe. To avoid unexpected behavior, do not share
 a single Channel instance between multiple goroutines. Concurrent calls
 to Channel methods may result in race conditions or unpredictable outcomes.
+
+When Config.WarmChannels is set, an already opened channel is returned from
+the warm pool when one is available.
 */
 func (c *Connection) Channel() (*Channel, error) {
+	if ch := c.takeWarmChannel(); ch != nil {
+		return ch, nil
+	}
 	return c.openChannel()
 }
 
+// warmChannels fills the warm pool after the handshake.  Failing to open a
+// warm channel is not fatal, Channel falls back to opening channels inline.
+func (c *Connection) warmChannels() {
+	if c.Config.WarmChannels <= 0 {
+		return
+	}
+
+	c.warm = make(chan *Channel, c.Config.WarmChannels)
+	for i := 0; i < c.Config.WarmChannels; i++ {
+		if !c.refillWarmChannel() {
+			// The rest are retried along with the one that failed.
+			c.warmMissing.Add(int32(c.Config.WarmChannels - i - 1))
+			return
+		}
+	}
+}
+
+// takeWarmChannel returns an open channel from the warm pool, or nil when the
+// pool is empty.  Every channel taken out of the pool, closed or not, and every
+// warm channel that failed to open before is replaced in the background.
+func (c *Connection) takeWarmChannel() *Channel {
+	for n := c.warmMissing.Load(); n > 0; n = c.warmMissing.Load() {
+		if c.warmMissing.CompareAndSwap(n, n-1) {
+			go c.refillWarmChannel()
+		}
+	}
+
+	for {
+		select {
+		case ch := <-c.warm:
+			go c.refillWarmChannel()
+			if ch.IsClosed() {
+				continue
+			}
+			return ch
+		default:
+			return nil
+		}
+	}
+}
+
+// refillWarmChannel opens one channel into the warm pool.  A channel that
+// fails to open is counted as missing, to be retried by takeWarmChannel.
+func (c *Connection) refillWarmChannel() bool {
+	ch, err := c.openChannel()
+	if err != nil {
+		if !c.IsClosed() {
+			c.log(LevelWarn, "could not open warm channel", "method", "channel.open", "error", err)
+			c.warmMissing.Add(1)
+		}
+		return false
+	}
+
+	select {
+	case c.warm <- ch:
+		return true
+	default:
+		_ = ch.Close()
+		return false
+	}
+}
+
 func (c *Connection) call(req message, res ...message) error {
 	// Special case for when the protocol header frame is sent insted of a
 	// request method
@@ -973,6 +1693,7 @@ func (c *Connection) openStart(config Config) error {
 	c.Major = int(start.VersionMajor)
 	c.Minor = int(start.VersionMinor)
 	c.Properties = start.ServerProperties
+	c.Capabilities = newServerCapabilities(start.ServerProperties)
 	c.Locales = strings.Split(start.Locales, " ")
 
 	// eventually support challenge/response here by also responding to
@@ -1023,14 +1744,16 @@ func (c *Connection) openTune(config Config, auth Authentication) error {
 	c.m.Lock()
 
 	// When the server and client both use default 0, then the max channel is
-	// only limited by uint16.
-	c.Config.ChannelMax = pickUInt16(config.ChannelMax, tune.ChannelMax)
-	if c.Config.ChannelMax == 0 {
-		c.Config.ChannelMax = defaultChannelMax
+	// only limited by uint16.  The ids are then allocated from the default
+	// range, grown as more channels are opened.
+	channelMax := pickUInt16(config.ChannelMax, tune.ChannelMax)
+	if channelMax == 0 {
+		c.Config.ChannelMax = maxChannelMax
+		c.allocator = newGrowableAllocator(1, int(defaultChannelMax), maxChannelMax)
+	} else {
+		c.Config.ChannelMax = channelMax
+		c.allocator = newAllocator(1, int(channelMax))
 	}
-	c.Config.ChannelMax = minUInt16(c.Config.ChannelMax, maxChannelMax)
-
-	c.allocator = newAllocator(1, int(c.Config.ChannelMax))
 
 	c.m.Unlock()
 
@@ -1051,7 +1774,7 @@ func (c *Connection) openTune(config Config, auth Authentication) error {
 	if err := c.send(&methodFrame{
 		ChannelId: 0,
 		Method: &connectionTuneOk{
-			ChannelMax: uint16(c.Config.ChannelMax),
+			ChannelMax: channelMax,
 			FrameMax:   uint32(c.Config.FrameSize),
 			Heartbeat:  uint16(c.Config.Heartbeat / time.Second),
 		},
@@ -62,13 +62,13 @@ func TestChannelOpenOnAClosedConnectionFails_ReleasesAllocatedChannel(t *testing
 	conn := integrationConnection(t, "releases channel allocation")
 	conn.Close()
 
-	before := len(conn.channels)
+	before := conn.channels.len()
 
 	if _, err := conn.Channel(); err != ErrClosed {
 		t.Fatalf("channel.open on a closed connection %#v is expected to fail", conn)
 	}
 
-	if len(conn.channels) != before {
+	if conn.channels.len() != before {
 		t.Fatalf("channel.open failed, but the allocated channel was not released")
 	}
 }
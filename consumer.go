@@ -0,0 +1,409 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+)
+
+// Action is how a Consumer settles a delivery once its handler returns.
+type Action int
+
+const (
+	// Ack acknowledges the delivery.
+	Ack Action = iota
+	// NackRequeue negatively acknowledges the delivery and requeues it.
+	NackRequeue
+	// NackDiscard negatively acknowledges the delivery without requeue, so
+	// the server drops it or routes it to a configured dead-letter exchange.
+	NackDiscard
+)
+
+func (a Action) String() string {
+	switch a {
+	case Ack:
+		return "ack"
+	case NackRequeue:
+		return "nack-requeue"
+	case NackDiscard:
+		return "nack-discard"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+/*
+Consumer consumes a queue with a pool of workers calling Handler with every
+delivery, and settles each delivery with the Action returned by Handler:
+
+	c := amqp.NewConsumer(ch, "orders", func(ctx context.Context, d amqp.Delivery) amqp.Action {
+		if err := ship(ctx, d.Body); err != nil {
+			return amqp.NackRequeue
+		}
+		return amqp.Ack
+	})
+	c.Prefetch = 20
+	c.Concurrency = 4
+
+	err := c.Run(ctx)
+
+Run shuts down gracefully when ctx is done: the consumer is cancelled, the
+handlers already running finish and their deliveries are settled, and the
+deliveries received but not handled yet are requeued.
+*/
+type Consumer struct {
+	Channel *Channel
+	Queue   string
+
+	// Tag is the consumer tag, a unique one is generated when empty.
+	Tag string
+
+	// Args are the arguments of basic.consume, such as x-priority.
+	Args Table
+
+	// Prefetch sets Channel.Qos with this prefetch count before consuming
+	// when greater than 0.
+	Prefetch int
+
+	// Concurrency is the number of workers calling Handler, 1 when not
+	// greater than 0.
+	Concurrency int
+
+	// OrderedAcks settles the deliveries in the order they were received,
+	// holding back the settlement of a delivery handled by one worker until
+	// the deliveries received before it are handled by the others, so that
+	// the server sees the acknowledgements of a single consumer in order.
+	OrderedAcks bool
+
+	// Key routes the deliveries to the workers when not nil: deliveries with
+	// the same key are handled by the same worker, one at a time in the order
+	// received, while deliveries with different keys are handled in
+	// parallel.  See KeyByRoutingKey and KeyByHeader.
+	Key func(d Delivery) string
+
+	// MaxAttempts discards the deliveries that were delivered MaxAttempts
+	// times before without calling Handler, when greater than 0, so that a
+	// poison message that keeps being requeued ends up in the dead-letter
+	// exchange of the queue, if any, instead of being redelivered forever.
+	// See Delivery.DeliveryCount.
+	MaxAttempts int
+
+	Handler func(ctx context.Context, d Delivery) Action
+
+	m       sync.Mutex
+	resumed chan struct{} // closed by Resume, nil unless paused
+}
+
+// NewConsumer returns a Consumer calling handler with the deliveries of queue
+// from a single worker.
+func NewConsumer(ch *Channel, queue string, handler func(ctx context.Context, d Delivery) Action) *Consumer {
+	return &Consumer{
+		Channel: ch,
+		Queue:   queue,
+		Handler: handler,
+	}
+}
+
+/*
+Run consumes Queue until ctx is done or the consumer stops, and returns once
+every worker has returned.  It then returns context.Cause(ctx), or the reason
+the channel was closed or the consumer cancelled.
+
+The ctx given to Handler is ctx, so handlers can stop early when shutting down;
+the Action they return is still applied.
+*/
+func (c *Consumer) Run(ctx context.Context) error {
+	if c.Prefetch > 0 {
+		if err := c.Channel.Qos(c.Prefetch, 0, false); err != nil {
+			return err
+		}
+	}
+
+	deliveries, err := c.Channel.ConsumeWithContext(ctx, c.Queue, c.Tag, false, false, false, false, c.Args)
+	if err != nil {
+		return err
+	}
+
+	workers := c.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	jobs, settle := c.sequence(deliveries)
+
+	var wg sync.WaitGroup
+	for _, jobs := range c.partition(jobs, workers) {
+		wg.Add(1)
+		go func(jobs <-chan *consumerJob) {
+			defer wg.Done()
+			for {
+				c.waitResumed(ctx)
+				j, ok := <-jobs
+				if !ok {
+					return
+				}
+				settle(j, c.decide(ctx, j.d))
+			}
+		}(jobs)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if !c.Channel.IsClosed() {
+		return fmt.Errorf("consumer of queue %q cancelled by the server", c.Queue)
+	}
+	return ErrClosed
+}
+
+/*
+Pause stops the workers from taking new deliveries once the ones they are
+handling are settled, to stop intake temporarily, for instance during an outage
+of a downstream system, without cancelling the consumer.  The server keeps
+delivering until Prefetch deliveries are unacknowledged, and these wait in the
+client until Resume, so set Prefetch to bound them.
+
+Pausing a paused Consumer has no effect.  A Consumer shutting down because the
+context given to Run is done is not held up by Pause.
+*/
+func (c *Consumer) Pause() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.resumed == nil {
+		c.resumed = make(chan struct{})
+	}
+}
+
+// Resume lets the workers of a paused Consumer take new deliveries again.
+func (c *Consumer) Resume() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.resumed != nil {
+		close(c.resumed)
+		c.resumed = nil
+	}
+}
+
+// Paused reports whether the Consumer is paused.
+func (c *Consumer) Paused() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.resumed != nil
+}
+
+// waitResumed blocks while the Consumer is paused, until ctx is done.
+func (c *Consumer) waitResumed(ctx context.Context) {
+	c.m.Lock()
+	resumed := c.resumed
+	c.m.Unlock()
+
+	if resumed == nil {
+		return
+	}
+
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+	}
+}
+
+// consumerJob is a delivery handled by a worker of a Consumer.
+type consumerJob struct {
+	d      Delivery
+	action Action
+	done   bool
+}
+
+// sequence returns the jobs of the workers and how to settle them.  With
+// OrderedAcks the jobs are settled once the jobs received before them are,
+// otherwise as soon as they are handled.
+func (c *Consumer) sequence(deliveries <-chan Delivery) (<-chan *consumerJob, func(*consumerJob, Action)) {
+	jobs := make(chan *consumerJob)
+
+	if !c.OrderedAcks {
+		go func() {
+			defer close(jobs)
+			for d := range deliveries {
+				jobs <- &consumerJob{d: d}
+			}
+		}()
+		return jobs, func(j *consumerJob, action Action) { c.settle(j.d, action) }
+	}
+
+	var m sync.Mutex
+	var pending []*consumerJob // in the order received
+
+	go func() {
+		defer close(jobs)
+		for d := range deliveries {
+			j := &consumerJob{d: d}
+			m.Lock()
+			pending = append(pending, j)
+			m.Unlock()
+			jobs <- j
+		}
+	}()
+
+	return jobs, func(j *consumerJob, action Action) {
+		m.Lock()
+		defer m.Unlock()
+
+		j.action, j.done = action, true
+		for len(pending) > 0 && pending[0].done {
+			c.settle(pending[0].d, pending[0].action)
+			pending[0] = nil
+			pending = pending[1:]
+		}
+	}
+}
+
+// partition returns the jobs of each worker.  Without Key the workers share
+// the jobs, otherwise the jobs with the same key go to the same worker.
+func (c *Consumer) partition(jobs <-chan *consumerJob, workers int) []<-chan *consumerJob {
+	partitions := make([]<-chan *consumerJob, workers)
+	if c.Key == nil {
+		for i := range partitions {
+			partitions[i] = jobs
+		}
+		return partitions
+	}
+
+	// Buffered up to the prefetch, and at least one job without one, so that
+	// a busy worker does not hold up the jobs of the others.
+	size := c.Prefetch
+	if size < 1 {
+		size = 1
+	}
+
+	queues := make([]chan *consumerJob, workers)
+	for i := range queues {
+		queues[i] = make(chan *consumerJob, size)
+		partitions[i] = queues[i]
+	}
+
+	go func() {
+		defer func() {
+			for _, q := range queues {
+				close(q)
+			}
+		}()
+
+		for j := range jobs {
+			h := fnv.New32a()
+			h.Write([]byte(c.Key(j.d)))
+			queues[h.Sum32()%uint32(workers)] <- j
+		}
+	}()
+
+	return partitions
+}
+
+// KeyByRoutingKey is a Consumer.Key handling the deliveries with the same
+// routing key in order.
+func KeyByRoutingKey(d Delivery) string {
+	return d.RoutingKey
+}
+
+// KeyByHeader returns a Consumer.Key handling the deliveries with the same
+// value of the header name in order.  Deliveries without the header share
+// the empty key.
+func KeyByHeader(name string) func(d Delivery) string {
+	return func(d Delivery) string {
+		v, found := d.Headers[name]
+		if !found {
+			return ""
+		}
+		return fmt.Sprint(v)
+	}
+}
+
+// decide calls Handler with d, or requeues d without calling Handler once ctx
+// is done, or discards d after MaxAttempts.
+func (c *Consumer) decide(ctx context.Context, d Delivery) Action {
+	if ctx.Err() != nil {
+		return NackRequeue
+	}
+	if c.MaxAttempts > 0 && d.DeliveryCount() >= int64(c.MaxAttempts) {
+		logAt(LevelWarn, "discarding message delivered too many times", "delivery", d.DeliveryTag, "queue", c.Queue, "count", d.DeliveryCount())
+		return NackDiscard
+	}
+	return c.Handler(ctx, d)
+}
+
+// settle acknowledges d according to action.
+func (c *Consumer) settle(d Delivery, action Action) {
+	var err error
+	switch action {
+	case Ack:
+		err = d.Ack(false)
+	case NackRequeue:
+		err = d.Nack(false, true)
+	default:
+		err = d.Nack(false, false)
+	}
+
+	if err != nil {
+		logAt(LevelWarn, "could not settle message", "action", action, "delivery", d.DeliveryTag, "queue", c.Queue, "error", err)
+	}
+}
+
+// HandlerPanic is the error of a Consumer handler that panicked, see
+// RecoverPanics.
+type HandlerPanic struct {
+	Delivery Delivery
+	Value    interface{} // recovered
+	Stack    []byte
+	Action   Action // NackRequeue or NackDiscard
+}
+
+func (p *HandlerPanic) Error() string {
+	return fmt.Sprintf("handler panicked with delivery %d of consumer %q: %v", p.Delivery.DeliveryTag, p.Delivery.ConsumerTag, p.Value)
+}
+
+/*
+RecoverPanics wraps a Consumer handler so that a panic while handling a
+delivery does not crash the process.  The delivery of a panicking handler is
+negatively acknowledged and requeued, for another attempt, and onPanic, when
+not nil, is called with a *HandlerPanic.
+
+A message that panics every time would be redelivered forever, so when
+maxRedeliveries is greater than 0 a delivery that has already been delivered
+maxRedeliveries times before is discarded instead of requeued, which routes it
+to the dead-letter exchange of the queue if any.  The previous deliveries are
+counted with the x-delivery-count header of quorum queues; other queues only
+report whether a delivery is redelivered, which counts as one.
+
+	consumer := amqp.NewConsumer(ch, "orders", amqp.RecoverPanics(handle, 3, func(err error) {
+		log.Print(err)
+	}))
+*/
+func RecoverPanics(handler func(ctx context.Context, d Delivery) Action, maxRedeliveries int, onPanic func(err error)) func(ctx context.Context, d Delivery) Action {
+	return func(ctx context.Context, d Delivery) (action Action) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+
+			action = NackRequeue
+			if maxRedeliveries > 0 && d.DeliveryCount() >= int64(maxRedeliveries) {
+				action = NackDiscard
+			}
+
+			if onPanic != nil {
+				onPanic(&HandlerPanic{Delivery: d, Value: v, Stack: debug.Stack(), Action: action})
+			}
+		}()
+
+		return handler(ctx, d)
+	}
+}
@@ -0,0 +1,507 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsumerSettlesWithHandlerAction(t *testing.T) {
+	const tag = "handled"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	settled := make(chan message, 3)
+	qos := make(chan *basicQos, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		qos <- srv.recv(1, &basicQos{}).(*basicQos)
+		srv.send(1, &basicQosOk{})
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		for i, body := range []string{"ack", "requeue", "discard"} {
+			srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: uint64(i + 1), Body: []byte(body)})
+		}
+
+		settled <- srv.recv(1, &basicAck{})
+		settled <- srv.recv(1, &basicNack{})
+		settled <- srv.recv(1, &basicNack{})
+		cancel()
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	consumer := NewConsumer(ch, "q", func(_ context.Context, d Delivery) Action {
+		switch string(d.Body) {
+		case "ack":
+			return Ack
+		case "requeue":
+			return NackRequeue
+		}
+		return NackDiscard
+	})
+	consumer.Tag = tag
+	consumer.Prefetch = 3
+
+	if err := consumer.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return once cancelled, got %v", err)
+	}
+
+	if want, got := uint16(3), (<-qos).PrefetchCount; want != got {
+		t.Errorf("expected a prefetch of %d, got %d", want, got)
+	}
+	if ack := (<-settled).(*basicAck); ack.DeliveryTag != 1 {
+		t.Errorf("expected the first delivery to be acked, got %+v", ack)
+	}
+	if nack := (<-settled).(*basicNack); nack.DeliveryTag != 2 || !nack.Requeue {
+		t.Errorf("expected the second delivery to be requeued, got %+v", nack)
+	}
+	if nack := (<-settled).(*basicNack); nack.DeliveryTag != 3 || nack.Requeue {
+		t.Errorf("expected the third delivery to be discarded, got %+v", nack)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConsumerOrderedAcks(t *testing.T) {
+	const tag = "ordered"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	acks := make(chan uint64, 3)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		for i := uint64(1); i <= 3; i++ {
+			srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: i})
+		}
+
+		for i := 0; i < 3; i++ {
+			acks <- srv.recv(1, &basicAck{}).(*basicAck).DeliveryTag
+		}
+		cancel()
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	// The first delivery is handled last.
+	handled := make(chan struct{}, 2)
+	consumer := NewConsumer(ch, "q", func(_ context.Context, d Delivery) Action {
+		if d.DeliveryTag == 1 {
+			<-handled
+			<-handled
+		} else {
+			handled <- struct{}{}
+		}
+		return Ack
+	})
+	consumer.Tag = tag
+	consumer.Concurrency = 3
+	consumer.OrderedAcks = true
+
+	if err := consumer.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return once cancelled, got %v", err)
+	}
+
+	for want := uint64(1); want <= 3; want++ {
+		if got := <-acks; want != got {
+			t.Errorf("expected delivery %d to be acknowledged next, got %d", want, got)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConsumerPauseAndResume(t *testing.T) {
+	const tag = "paused"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+
+		srv.recv(1, &basicAck{})
+		cancel()
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	handled := make(chan uint64, 1)
+	consumer := NewConsumer(ch, "q", func(_ context.Context, d Delivery) Action {
+		handled <- d.DeliveryTag
+		return Ack
+	})
+	consumer.Tag = tag
+
+	consumer.Pause()
+	if !consumer.Paused() {
+		t.Fatal("expected the consumer to be paused")
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- consumer.Run(ctx) }()
+
+	select {
+	case tag := <-handled:
+		t.Fatalf("expected no delivery to be handled while paused, got %d", tag)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	consumer.Resume()
+	if want, got := uint64(1), <-handled; want != got {
+		t.Errorf("expected delivery %d to be handled once resumed, got %d", want, got)
+	}
+
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return once cancelled, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConsumerKeyHandlesSameKeyInOrder(t *testing.T) {
+	const (
+		tag        = "keyed"
+		deliveries = 12
+	)
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		for i := uint64(1); i <= deliveries; i++ {
+			key := []string{"a", "b", "c"}[i%3]
+			srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: i, RoutingKey: key})
+		}
+
+		for i := 0; i < deliveries; i++ {
+			srv.recv(1, &basicAck{})
+		}
+		cancel()
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	var m sync.Mutex
+	active := make(map[string]int)
+	last := make(map[string]uint64)
+
+	consumer := NewConsumer(ch, "q", func(_ context.Context, d Delivery) Action {
+		m.Lock()
+		active[d.RoutingKey]++
+		if active[d.RoutingKey] > 1 {
+			t.Errorf("expected the deliveries of key %q to be handled one at a time", d.RoutingKey)
+		}
+		if d.DeliveryTag < last[d.RoutingKey] {
+			t.Errorf("expected delivery %d of key %q to be handled after %d", d.DeliveryTag, d.RoutingKey, last[d.RoutingKey])
+		}
+		last[d.RoutingKey] = d.DeliveryTag
+		m.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		m.Lock()
+		active[d.RoutingKey]--
+		m.Unlock()
+		return Ack
+	})
+	consumer.Tag = tag
+	consumer.Concurrency = 4
+	consumer.Key = KeyByRoutingKey
+
+	if err := consumer.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return once cancelled, got %v", err)
+	}
+
+	if want, got := 3, len(last); want != got {
+		t.Errorf("expected deliveries of %d keys to be handled, got %d", want, got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConsumerKeyBlockedDoesNotStallOtherKeys(t *testing.T) {
+	const tag = "keyed"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		// Keys "a" and "b" go to different workers, the second delivery of
+		// "a" waits behind the first while "b" is handled.
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, RoutingKey: "a"})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2, RoutingKey: "a"})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 3, RoutingKey: "b"})
+
+		for i := 0; i < 3; i++ {
+			srv.recv(1, &basicAck{})
+		}
+		cancel()
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	handledB := make(chan struct{})
+
+	consumer := NewConsumer(ch, "q", func(_ context.Context, d Delivery) Action {
+		switch {
+		case d.DeliveryTag == 1:
+			select {
+			case <-handledB:
+			case <-time.After(time.Second):
+				t.Error("expected key b to be handled while key a is blocked")
+			}
+		case d.RoutingKey == "b":
+			close(handledB)
+		}
+		return Ack
+	})
+	consumer.Tag = tag
+	consumer.Concurrency = 2
+	consumer.Key = KeyByRoutingKey
+
+	if err := consumer.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return once cancelled, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestKeyByHeader(t *testing.T) {
+	key := KeyByHeader("tenant")
+
+	if want, got := "42", key(Delivery{Headers: Table{"tenant": int32(42)}}); want != got {
+		t.Errorf("expected the key %q, got %q", want, got)
+	}
+	if want, got := "", key(Delivery{}); want != got {
+		t.Errorf("expected an empty key without the header, got %q", got)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	var panics []error
+	handler := RecoverPanics(func(_ context.Context, d Delivery) Action {
+		if string(d.Body) == "poison" {
+			panic("cannot parse")
+		}
+		return Ack
+	}, 3, func(err error) { panics = append(panics, err) })
+
+	for _, tc := range []struct {
+		d    Delivery
+		want Action
+	}{
+		{Delivery{Body: []byte("ok")}, Ack},
+		{Delivery{Body: []byte("poison")}, NackRequeue},
+		{Delivery{Body: []byte("poison"), Redelivered: true}, NackRequeue},
+		{Delivery{Body: []byte("poison"), Redelivered: true, Headers: Table{"x-delivery-count": int64(3)}}, NackDiscard},
+	} {
+		if got := handler(context.Background(), tc.d); tc.want != got {
+			t.Errorf("expected %s for %+v, got %s", tc.want, tc.d, got)
+		}
+	}
+
+	if want, got := 3, len(panics); want != got {
+		t.Fatalf("expected %d panics to be reported, got %d", want, got)
+	}
+
+	var p *HandlerPanic
+	if !errors.As(panics[2], &p) {
+		t.Fatalf("expected a *HandlerPanic, got %T", panics[2])
+	}
+	if p.Value != "cannot parse" || p.Action != NackDiscard || len(p.Stack) == 0 {
+		t.Errorf("unexpected panic report %+v", p)
+	}
+}
+
+func TestConsumerMaxAttemptsDiscardsPoison(t *testing.T) {
+	const tag = "poison"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nacks := make(chan *basicNack, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, Redelivered: true,
+			Properties: properties{Headers: Table{"x-delivery-count": int64(3)}}})
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2, Redelivered: true,
+			Properties: properties{Headers: Table{"x-delivery-count": int64(2)}}})
+		srv.recv(1, &basicAck{})
+		cancel()
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	var handled []uint64
+	consumer := NewConsumer(ch, "q", func(_ context.Context, d Delivery) Action {
+		handled = append(handled, d.DeliveryTag)
+		return Ack
+	})
+	consumer.Tag = tag
+	consumer.MaxAttempts = 3
+
+	if err := consumer.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return once cancelled, got %v", err)
+	}
+
+	if nack := <-nacks; nack.DeliveryTag != 1 || nack.Requeue {
+		t.Errorf("expected the poison delivery to be discarded, got %+v", nack)
+	}
+	if want, got := []uint64{2}, handled; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("expected only delivery %v to be handled, got %v", want, got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -6,10 +6,13 @@
 package amqp091
 
 import (
+	"context"
+	"errors"
 	"os"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var consumerSeq uint64
@@ -34,6 +37,166 @@ func commandNameBasedUniqueConsumerTag(commandName string) string {
 
 type consumerBuffers map[string]chan *Delivery
 
+// ConsumeOption configures optional client side behaviour of a consumer
+// started with Channel.ConsumeWithOptions.
+type ConsumeOption func(*consumeOptions)
+
+type consumeOptions struct {
+	queue       string // consumed, see Delivery.RetryLater
+	noAck       bool
+	maxBodySize uint64
+	streamSize  uint64 // see WithStreamedBody
+	onCancel    func(cause error)
+
+	nackOnCancel bool
+	cancelled    <-chan struct{} // of Channel.ConsumeWithOptions, see WithNackOnCancel
+
+	ackDeadline time.Duration
+	onExpired   func(Delivery)
+	clock       Clock
+	expire      func(msg *Delivery, onExpired func(Delivery)) // Channel.expire, see WithAckDeadline
+
+	bufferSize   int
+	bufferPolicy BufferPolicy
+
+	resubscribe *resubscription
+}
+
+// resubscription is how a consumer started WithResubscribe consumes again.
+type resubscription struct {
+	interval time.Duration
+	onCancel func(consumer string)
+
+	ctx context.Context // of Channel.ConsumeWithOptions
+	req basicConsume
+}
+
+// BufferPolicy is what a consumer does when its delivery buffer is full, see
+// WithDeliveryBuffer.
+type BufferPolicy int
+
+const (
+	// BufferBlock stops reading deliveries from the connection until the
+	// application receives from the consumer chan.  This holds up every
+	// channel of the connection, including the responses to synchronous
+	// methods, so the application must keep receiving.
+	BufferBlock BufferPolicy = iota
+)
+
+/*
+WithDeliveryBuffer limits the number of deliveries buffered for a consumer
+that the application has not received from the consumer chan yet, so that the
+memory used when the application stalls is predictable.  By default the
+buffer is unbounded and only limited by Channel.Qos.
+
+When size deliveries are buffered, the policy decides how to push back on the
+server, see BufferBlock.  A size of 0 means no limit.
+*/
+func WithDeliveryBuffer(size int, policy BufferPolicy) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.bufferSize = size
+		o.bufferPolicy = policy
+	}
+}
+
+/*
+WithMaxBodySize limits the size of the deliveries accepted by a consumer.  When
+the content header of a delivery announces a body larger than size bytes, its
+body frames are discarded as they arrive instead of being buffered, and the
+delivery is negatively acknowledged without requeue so the server drops it or
+routes it to a configured dead-letter exchange.  The delivery is never sent to
+the consumer chan.
+
+Deliveries to consumers started with autoAck cannot be rejected and are
+silently dropped.
+
+A size of 0 means no limit.
+*/
+func WithMaxBodySize(size uint64) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.maxBodySize = size
+	}
+}
+
+/*
+WithStreamedBody hands the deliveries of a consumer with a body larger than
+size bytes to the consumer chan as soon as their content header arrives, with
+a nil Body and a BodyReader yielding the body frames as they arrive, so that
+very large messages are processed without holding them in memory.
+
+The body frames are read from the connection only as fast as the application
+reads the BodyReader, which holds up every channel of the connection in the
+meantime, including the responses to synchronous methods such as Channel.Qos.
+Read the body to the end, or Close the BodyReader to discard the rest, before
+anything else.  When the channel closes before the body is complete the
+BodyReader returns the reason.
+
+A size of 0 means that bodies are never streamed.
+*/
+func WithStreamedBody(size uint64) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.streamSize = size
+	}
+}
+
+/*
+WithResubscribe consumes the queue again when the server cancels the consumer,
+for instance because the queue was deleted or its quorum leader changed,
+instead of closing the consumer chan.  Every retryInterval the client checks on
+a temporary channel whether the queue exists, and once it does re-issues
+basic.consume with the same consumer tag and arguments, so deliveries resume
+on the same chan.
+
+onCancel, when not nil, is called with the consumer tag for each cancel
+received from the server, before resubscribing.  Use Channel.NotifyCancel to
+observe the cancels of every consumer of the channel instead.
+
+Resubscribing stops when the context given to Channel.ConsumeWithOptions is
+done, the consumer is cancelled with Channel.Cancel or the channel is closed.
+Deliveries received before the cancel and not acknowledged are requeued by the
+server, and may be delivered again once resubscribed.
+*/
+func WithResubscribe(retryInterval time.Duration, onCancel func(consumer string)) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.resubscribe = &resubscription{interval: retryInterval, onCancel: onCancel}
+	}
+}
+
+// WithOnContextCancel calls fn with context.Cause of the context given to
+// Channel.ConsumeWithOptions once the consumer has been cancelled because that
+// context is done, so that the reason for the consumer shutdown can be
+// recorded.  fn is called from a separate goroutine after basic.cancel has
+// been sent.
+func WithOnContextCancel(fn func(cause error)) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.onCancel = fn
+	}
+}
+
+/*
+WithNackOnCancel negatively acknowledges with requeue the deliveries still
+buffered for a consumer when the context given to Channel.ConsumeWithOptions is
+done.  Without it the deliveries the application has not received yet are
+still sent to the consumer chan after the consumer is cancelled, and stay
+unacknowledged until the channel is closed when nobody receives them.
+
+Deliveries the application has already received are left to the application.
+The option has no effect for consumers started with autoAck.
+*/
+func WithNackOnCancel() ConsumeOption {
+	return func(o *consumeOptions) {
+		o.nackOnCancel = true
+	}
+}
+
+func newConsumeOptions(ch *Channel, queue string, autoAck bool, opts []ConsumeOption) consumeOptions {
+	o := consumeOptions{queue: queue, noAck: autoAck}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // Concurrent type that manages the consumerTag ->
 // ingress consumerBuffer mapping
 type consumers struct {
@@ -42,16 +205,79 @@ type consumers struct {
 
 	sync.Mutex // protects below
 	chans      consumerBuffers
+	queues     map[string]*dispatchQueue // in place of chans, see Config.DispatchWorkers
+	pool       *dispatchPool             // nil unless Config.DispatchWorkers
+	opts       map[string]consumeOptions
+	buffers    map[string]*bufferState // deliveries buffered, see DispatchDepth
+
+	// Buffers of chans being sent to without the mutex, with the number of
+	// sends, and those to close once the sends return, see push.
+	pushing  map[chan *Delivery]int
+	unclosed map[chan *Delivery]struct{}
+
+	unacked unackedDeliveries
+
+	// charge records the body sizes of the unacknowledged deliveries in the
+	// memory budgets of the channel, while budgeted reports one is set, see
+	// Channel.SetMaxUnackedBytes.
+	charge   func(n int64)
+	budgeted func() bool
+
+	clock Clock // of the connection, SystemClock when nil
+
+	// Only allocated when liveness is reported, see trackLiveness.
+	liveness map[string]*consumerLiveness
+
+	slow *slowConsumers // nil unless slow consumers are reported
+
+	drains map[string][]chan struct{} // see Channel.CancelAndDrain
+
+	// Only allocated for consumers with an ack deadline, see startDeadline.
+	deadlines map[uint64]ackDeadline
+	expired   map[uint64]struct{} // requeued delivery tags
+
+	// deadlined is set once a consumer with an ack deadline is added, so
+	// that acknowledgements skip checkExpired until then.
+	deadlined atomic.Bool
 }
 
 func makeConsumers() *consumers {
 	return &consumers{
-		closed: make(chan struct{}),
-		chans:  make(consumerBuffers),
+		closed:  make(chan struct{}),
+		chans:   make(consumerBuffers),
+		queues:  make(map[string]*dispatchQueue),
+		opts:    make(map[string]consumeOptions),
+		buffers: make(map[string]*bufferState),
+		pushing: make(map[chan *Delivery]int),
 	}
 }
 
-func (subs *consumers) buffer(in chan *Delivery, out chan Delivery) {
+// isCancelled is true once the consumer context is done, see WithNackOnCancel.
+func (o consumeOptions) isCancelled() bool {
+	select {
+	case <-o.cancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// requeue returns the buffered deliveries to the server.
+func requeue(queue []*Delivery) {
+	for _, d := range queue {
+		if d.BodyReader != nil {
+			d.BodyReader.Close()
+		}
+		if err := d.Nack(false, true); errors.Is(err, ErrAckDeadlineExceeded) {
+			continue
+		} else if err != nil {
+			logAt(LevelWarn, "error requeueing delivery", "method", "basic.nack", "delivery", d.DeliveryTag, "consumer", d.ConsumerTag, "error", err)
+			return
+		}
+	}
+}
+
+func (subs *consumers) buffer(in chan *Delivery, out chan Delivery, opts consumeOptions, state *bufferState) {
 	defer close(out)
 	defer subs.Done()
 
@@ -62,14 +288,28 @@ func (subs *consumers) buffer(in chan *Delivery, out chan Delivery) {
 		queue = append(queue, delivery)
 
 		for len(queue) > 0 {
+			state.set(queue)
+
+			receiving := inflight
+			switch {
+			case opts.bufferSize == 0:
+			case len(queue) >= opts.bufferSize:
+				// Leave the deliveries in the connection until there is room.
+				receiving = nil
+			}
+
 			select {
 			case <-subs.closed:
 				// closed before drained, drop in-flight
 				return
 
-			case delivery, consuming := <-inflight:
+			case delivery, consuming := <-receiving:
 				if consuming {
 					queue = append(queue, delivery)
+				} else if opts.isCancelled() {
+					requeue(queue)
+					state.set(nil)
+					return
 				} else {
 					inflight = nil
 				}
@@ -105,50 +345,117 @@ func (subs *consumers) buffer(in chan *Delivery, out chan Delivery) {
 				queue = queue[1:]
 			}
 		}
+		state.set(nil)
 	}
 }
 
 // On key conflict, close the previous channel.
-func (subs *consumers) add(tag string, consumer chan Delivery) {
+func (subs *consumers) add(tag string, consumer chan Delivery, opts consumeOptions) {
 	subs.Lock()
 	defer subs.Unlock()
 
 	if prev, found := subs.chans[tag]; found {
-		close(prev)
+		delete(subs.chans, tag)
+		subs.closeBuffer(prev)
+	}
+	if prev, found := subs.queues[tag]; found {
+		delete(subs.queues, tag)
+		prev.end()
+	}
+
+	state := new(bufferState)
+	if opts.expire != nil {
+		subs.deadlined.Store(true)
+	}
+	subs.opts[tag] = opts
+	subs.buffers[tag] = state
+	subs.added(tag)
+	subs.Add(1)
+
+	// Buffered consumers apply their policy from a goroutine of their own.
+	if subs.pool != nil && opts.bufferSize == 0 {
+		subs.queues[tag] = newDispatchQueue(subs, consumer, opts, state)
+		return
 	}
 
 	in := make(chan *Delivery)
 	subs.chans[tag] = in
-
-	subs.Add(1)
-	go subs.buffer(in, consumer)
+	go subs.buffer(in, consumer, opts, state)
 }
 
 func (subs *consumers) cancel(tag string) (found bool) {
 	subs.Lock()
 	defer subs.Unlock()
 
-	ch, found := subs.chans[tag]
+	ch, buffered := subs.chans[tag]
+	q, queued := subs.queues[tag]
 
-	if found {
+	if buffered || queued {
 		delete(subs.chans, tag)
-		close(ch)
+		delete(subs.queues, tag)
+		delete(subs.opts, tag)
+		delete(subs.buffers, tag)
+		subs.removed(tag)
+	}
+	if buffered {
+		subs.closeBuffer(ch)
+	}
+	if queued {
+		q.end()
 	}
 
-	return found
+	return buffered || queued
 }
 
-func (subs *consumers) close() {
+// closeBuffer closes the chan of a removed consumer, or has the last send to
+// it close it when it is being sent to.  Must be called while holding the
+// mutex.
+func (subs *consumers) closeBuffer(in chan *Delivery) {
+	if subs.pushing[in] > 0 {
+		if subs.unclosed == nil {
+			subs.unclosed = make(map[chan *Delivery]struct{})
+		}
+		subs.unclosed[in] = struct{}{}
+		return
+	}
+	close(in)
+}
+
+// options returns the client side options of the consumer identified by tag.
+func (subs *consumers) options(tag string) (consumeOptions, bool) {
 	subs.Lock()
 	defer subs.Unlock()
 
+	opts, found := subs.opts[tag]
+	return opts, found
+}
+
+func (subs *consumers) close() {
+	// Signalled before locking, to release a send waiting for room in the
+	// buffer of a consumer.
 	close(subs.closed)
 
+	subs.Lock()
+	defer subs.Unlock()
+
 	for tag, ch := range subs.chans {
 		delete(subs.chans, tag)
-		close(ch)
+		delete(subs.opts, tag)
+		delete(subs.buffers, tag)
+		subs.removed(tag)
+		subs.closeBuffer(ch)
+	}
+	for tag, q := range subs.queues {
+		delete(subs.queues, tag)
+		delete(subs.opts, tag)
+		delete(subs.buffers, tag)
+		subs.removed(tag)
+		q.end()
 	}
 
+	// The deliveries can no longer be acknowledged.
+	subs.release(subs.unacked.uncharge())
+
 	subs.Wait()
 }
 
@@ -157,13 +464,93 @@ func (subs *consumers) close() {
 // could block all deliveries until the consumer
 // receives on the other end of the channel.
 func (subs *consumers) send(tag string, msg *Delivery) bool {
+	found, blocked, buffered := subs.push(tag, msg)
+	if blocked > 0 {
+		subs.slow.blocked(msg, blocked, buffered)
+	}
+	return found
+}
+
+// push buffers msg for its consumer, returning how long the buffer blocked
+// when slow consumers are reported.  The mutex is released while waiting for
+// room in the buffer, so that the deliveries already received can be
+// acknowledged meanwhile.
+func (subs *consumers) push(tag string, msg *Delivery) (found bool, blocked time.Duration, buffered int) {
+	buffer, state, found := subs.enqueue(tag, msg)
+	if buffer == nil {
+		return found, 0, 0
+	}
+	defer subs.pushed(buffer)
+
+	if subs.slow == nil {
+		select {
+		case buffer <- msg:
+		case <-subs.closed:
+		}
+		return true, 0, 0
+	}
+
+	select {
+	case buffer <- msg:
+	default:
+		select {
+		case buffer <- msg:
+		case <-subs.closed:
+		}
+		blocked = subs.now().Sub(msg.buffered)
+		buffered = int(state.depth.Load())
+	}
+	return true, blocked, buffered
+}
+
+func (subs *consumers) now() time.Time {
+	if subs.clock == nil {
+		return SystemClock.Now()
+	}
+	return subs.clock.Now()
+}
+
+// enqueue records msg as delivered to its consumer, and queues it for the
+// dispatch workers or returns the chan of its buffer to send it to.
+func (subs *consumers) enqueue(tag string, msg *Delivery) (buffer chan *Delivery, state *bufferState, found bool) {
 	subs.Lock()
 	defer subs.Unlock()
 
-	buffer, found := subs.chans[tag]
-	if found {
-		buffer <- msg
+	buffer, chans := subs.chans[tag]
+	q, queued := subs.queues[tag]
+	if !chans && !queued {
+		return nil, nil, false
 	}
 
-	return found
+	subs.delivered(msg)
+	if opts := subs.opts[tag]; opts.expire != nil {
+		subs.startDeadline(msg, opts)
+	}
+	if subs.slow != nil {
+		msg.buffered = subs.now()
+	}
+
+	if queued {
+		q.push(msg)
+		return nil, nil, true
+	}
+
+	subs.pushing[buffer]++
+	return buffer, subs.buffers[tag], true
+}
+
+// pushed closes the chan of a consumer removed while msg was being sent to
+// it, see closeBuffer.
+func (subs *consumers) pushed(buffer chan *Delivery) {
+	subs.Lock()
+	defer subs.Unlock()
+
+	if subs.pushing[buffer]--; subs.pushing[buffer] > 0 {
+		return
+	}
+	delete(subs.pushing, buffer)
+	if _, found := subs.unclosed[buffer]; found {
+		delete(subs.unclosed, buffer)
+		close(buffer)
+	}
 }
@@ -6,8 +6,12 @@
 package amqp091
 
 import (
+	"context"
+	"errors"
+	"io"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGeneratedUniqueConsumerTagDoesNotExceedMaxLength(t *testing.T) {
@@ -23,3 +27,377 @@ func TestGeneratedUniqueConsumerTagDoesNotExceedMaxLength(t *testing.T) {
 	assertCorrectLength(strings.Repeat("z", 256))
 	assertCorrectLength(strings.Repeat("z", 1024))
 }
+
+func TestConsumeMaxBodySizeRejectsOversizedDeliveries(t *testing.T) {
+	const tag = "consumer-tag"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	nacked := make(chan *basicNack, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, Body: []byte("too large")})
+
+		nack := &basicNack{}
+		srv.recv(1, nack)
+		nacked <- nack
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2, Body: []byte("fits")})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "queue", tag, false, false, false, false, nil, WithMaxBodySize(4))
+	if err != nil {
+		t.Fatalf("unexpected error during consume: %v", err)
+	}
+
+	nack := <-nacked
+	if nack.DeliveryTag != 1 || nack.Requeue || nack.Multiple {
+		t.Errorf("expected oversized delivery 1 to be nacked without requeue, got %+v", nack)
+	}
+
+	select {
+	case d := <-deliveries:
+		if want, got := uint64(2), d.DeliveryTag; want != got {
+			t.Errorf("unexpected delivery tag: want: %d, got: %d", want, got)
+		}
+		if want, got := "fits", string(d.Body); want != got {
+			t.Errorf("unexpected delivery body: want: %q, got: %q", want, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected delivery within the body size limit")
+	}
+}
+
+func TestConsumeWithContextReportsCancelCause(t *testing.T) {
+	const tag = "consumer-tag"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	shutdown := errors.New("worker shutting down")
+	causes := make(chan error, 1)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	deliveries, err := ch.ConsumeWithOptions(ctx, "q", tag, false, false, false, false, nil,
+		WithOnContextCancel(func(cause error) { causes <- cause }))
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	cancel(shutdown)
+
+	select {
+	case cause := <-causes:
+		if cause != shutdown {
+			t.Errorf("expected cancel cause %v, got %v", shutdown, cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the consumer cancel cause")
+	}
+
+	for range deliveries {
+	}
+
+	if _, err := ch.ConsumeWithContext(ctx, "q", "", false, false, false, false, nil); err != shutdown {
+		t.Errorf("expected consuming with a cancelled context to return its cause, got %v", err)
+	}
+}
+
+func TestDeliveryBufferBlocksWhenFull(t *testing.T) {
+	subs := makeConsumers()
+	out := make(chan Delivery)
+	subs.add("a", out, consumeOptions{bufferSize: 2})
+	defer subs.close()
+
+	sent := make(chan uint64, 3)
+	go func() {
+		for tag := uint64(1); tag <= 3; tag++ {
+			subs.send("a", &Delivery{DeliveryTag: tag})
+			sent <- tag
+		}
+	}()
+
+	<-sent
+	<-sent
+	select {
+	case tag := <-sent:
+		t.Fatalf("expected delivery %d to wait for room in the buffer", tag)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if d := <-out; d.DeliveryTag != 1 {
+		t.Errorf("expected delivery 1 first, got %d", d.DeliveryTag)
+	}
+	if tag := <-sent; tag != 3 {
+		t.Errorf("expected delivery 3 to be buffered once there was room, got %d", tag)
+	}
+}
+
+func TestDeliveryBufferAcksWhileFull(t *testing.T) {
+	subs := makeConsumers()
+	out := make(chan Delivery)
+	subs.add("a", out, consumeOptions{bufferSize: 1})
+	defer subs.close()
+
+	sent := make(chan uint64, 3)
+	go func() {
+		for tag := uint64(1); tag <= 3; tag++ {
+			subs.send("a", &Delivery{ConsumerTag: "a", DeliveryTag: tag})
+			sent <- tag
+		}
+	}()
+
+	d := <-out
+	<-sent
+	<-sent
+	// Delivery 2 fills the buffer, delivery 3 waits for room.
+
+	acked := make(chan struct{})
+	go func() {
+		subs.acked(d.DeliveryTag, false)
+		close(acked)
+	}()
+
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		t.Fatal("expected the ack not to wait for room in the buffer")
+	}
+
+	<-out
+	if tag := <-sent; tag != 3 {
+		t.Errorf("expected delivery 3 to be buffered once there was room, got %d", tag)
+	}
+}
+
+func TestDeliveryBufferCancelWhileFull(t *testing.T) {
+	subs := makeConsumers()
+	out := make(chan Delivery)
+	subs.add("a", out, consumeOptions{bufferSize: 1})
+	defer subs.close()
+
+	sent := make(chan uint64, 2)
+	go func() {
+		for tag := uint64(1); tag <= 2; tag++ {
+			subs.send("a", &Delivery{ConsumerTag: "a", DeliveryTag: tag})
+			sent <- tag
+		}
+	}()
+
+	<-sent
+	time.Sleep(10 * time.Millisecond) // delivery 2 waits for room
+
+	if !subs.cancel("a") {
+		t.Fatal("expected the consumer to be cancelled")
+	}
+
+	for tag := uint64(1); tag <= 2; tag++ {
+		if d := <-out; d.DeliveryTag != tag {
+			t.Errorf("expected delivery %d, got %d", tag, d.DeliveryTag)
+		}
+	}
+	if _, open := <-out; open {
+		t.Error("expected the consumer chan to be closed once drained")
+	}
+}
+
+func TestConsumeWithNackOnCancelRequeuesBuffered(t *testing.T) {
+	const tag = "consumer-tag"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	nacks := make(chan *basicNack, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 3})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries, err := ch.ConsumeWithOptions(ctx, "q", tag, false, false, false, false, nil, WithNackOnCancel())
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	if d := <-deliveries; d.DeliveryTag != 1 {
+		t.Fatalf("expected the first delivery, got %d", d.DeliveryTag)
+	}
+	cancel()
+
+	for _, want := range []uint64{2, 3} {
+		select {
+		case nack := <-nacks:
+			if nack.DeliveryTag != want || !nack.Requeue || nack.Multiple {
+				t.Errorf("expected delivery %d to be requeued, got %+v", want, nack)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for delivery %d to be requeued", want)
+		}
+	}
+
+	for d := range deliveries {
+		t.Errorf("expected no delivery after the cancel, got %d", d.DeliveryTag)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+// sendChunked sends a delivery with its body split in body frames of size
+// bytes, the last frames of the body are left out when truncated.
+func (t *server) sendChunked(channel int, m *basicDeliver, body []byte, size, truncated int) {
+	t.Helper()
+
+	frames := []frame{
+		&methodFrame{ChannelId: uint16(channel), Method: m},
+		&headerFrame{ChannelId: uint16(channel), ClassId: 60, Size: uint64(len(body))},
+	}
+	for len(body) > truncated {
+		n := size
+		if n > len(body) {
+			n = len(body)
+		}
+		frames = append(frames, &bodyFrame{ChannelId: uint16(channel), Body: body[:n]})
+		body = body[n:]
+	}
+
+	for _, f := range frames {
+		if err := t.w.WriteFrame(f); err != nil {
+			t.Fatalf("WriteFrame error: %v", err)
+		}
+	}
+}
+
+func TestConsumeWithStreamedBody(t *testing.T) {
+	const tag = "consumer-tag"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	large := []byte(strings.Repeat("0123456789", 100))
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, Body: []byte("small")})
+		srv.sendChunked(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2}, large, 64, 0)
+		srv.recv(1, &basicAck{})
+
+		// Interrupted by the channel closing.
+		srv.sendChunked(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 3}, large, 64, 100)
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "gone"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "q", tag, false, false, false, false, nil,
+		WithStreamedBody(100))
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	small := <-deliveries
+	if small.BodyReader != nil || string(small.Body) != "small" {
+		t.Errorf("expected a small delivery to be buffered, got %+v", small)
+	}
+
+	streamed := <-deliveries
+	if streamed.Body != nil || streamed.BodyReader == nil {
+		t.Fatalf("expected a large delivery to be streamed, got %+v", streamed)
+	}
+	body, err := io.ReadAll(streamed.BodyReader)
+	if err != nil || string(body) != string(large) {
+		t.Errorf("expected the streamed body of %d bytes, got %d bytes, %v", len(large), len(body), err)
+	}
+	if err := streamed.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	interrupted := <-deliveries
+	if _, err := io.ReadAll(interrupted.BodyReader); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected an interrupted body to fail with io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrAckDeadlineExceeded is returned when acknowledging a delivery that has
+// already been requeued because its deadline passed, see WithAckDeadline.
+var ErrAckDeadlineExceeded = errors.New("delivery requeued after its acknowledgement deadline")
+
+/*
+WithAckDeadline requeues the deliveries of a consumer that the application has
+not acknowledged, negatively acknowledged or rejected within deadline of their
+arrival, and calls onExpired, when not nil, with each of them.
+
+RabbitMQ closes the channel of a consumer holding a delivery for longer than its
+consumer_timeout, 30 minutes by default, which fails every other consumer and
+publisher of the channel.  With a deadline shorter than consumer_timeout only
+the delivery of a stuck handler is requeued, and onExpired can log it.
+
+Acknowledging a delivery after its deadline returns ErrAckDeadlineExceeded
+without sending anything, as the server would close the channel for the
+unknown delivery tag.  This is also the case for a multiple acknowledgement up
+to an expired delivery, which then leaves the earlier deliveries
+unacknowledged.
+
+onExpired is called from a separate goroutine.  The option has no effect for
+consumers started with autoAck.
+*/
+func WithAckDeadline(deadline time.Duration, onExpired func(Delivery)) ConsumeOption {
+	return func(o *consumeOptions) {
+		o.ackDeadline = deadline
+		o.onExpired = onExpired
+	}
+}
+
+// ackDeadline stops the deadline of an unacknowledged delivery.
+type ackDeadline struct {
+	stop chan struct{}
+}
+
+// startDeadline requeues msg unless it is acknowledged within the deadline of
+// the consumer.  Called with the consumers mutex held.
+func (subs *consumers) startDeadline(msg *Delivery, opts consumeOptions) {
+	if subs.deadlines == nil {
+		subs.deadlines = make(map[uint64]ackDeadline)
+		subs.expired = make(map[uint64]struct{})
+	}
+
+	d := ackDeadline{stop: make(chan struct{})}
+	subs.deadlines[msg.DeliveryTag] = d
+
+	timer := opts.clock.NewTimer(opts.ackDeadline)
+	go func() {
+		defer timer.Stop()
+
+		select {
+		case <-d.stop:
+			return
+		case <-subs.closed:
+			return
+		case <-timer.C():
+		}
+
+		if subs.expire(msg.DeliveryTag) {
+			opts.expire(msg, opts.onExpired)
+		}
+	}()
+}
+
+// expire marks the delivery tag as requeued, unless it was acknowledged in
+// the meantime.
+func (subs *consumers) expire(tag uint64) bool {
+	subs.Lock()
+	defer subs.Unlock()
+
+	if _, found := subs.deadlines[tag]; !found {
+		return false
+	}
+
+	delete(subs.deadlines, tag)
+	subs.expired[tag] = struct{}{}
+	return true
+}
+
+// stopDeadlines stops the deadlines of the acknowledged deliveries.  Called
+// with the consumers mutex held.
+func (subs *consumers) stopDeadlines(tag uint64, multiple bool) {
+	if len(subs.deadlines) == 0 {
+		return
+	}
+
+	stop := func(deliveryTag uint64, d ackDeadline) {
+		delete(subs.deadlines, deliveryTag)
+		close(d.stop)
+	}
+
+	if !multiple {
+		if d, found := subs.deadlines[tag]; found {
+			stop(tag, d)
+		}
+		return
+	}
+
+	for deliveryTag, d := range subs.deadlines {
+		if deliveryTag <= tag || tag == 0 {
+			stop(deliveryTag, d)
+		}
+	}
+}
+
+// checkExpired returns ErrAckDeadlineExceeded once for a delivery tag that
+// has been requeued after its deadline.  It does not take the consumers mutex
+// until a consumer with an ack deadline has been added.
+func (subs *consumers) checkExpired(tag uint64) error {
+	if !subs.deadlined.Load() {
+		return nil
+	}
+
+	subs.Lock()
+	defer subs.Unlock()
+
+	if _, found := subs.expired[tag]; found {
+		delete(subs.expired, tag)
+		return ErrAckDeadlineExceeded
+	}
+	return nil
+}
+
+// expire requeues a delivery whose deadline passed, see WithAckDeadline.
+func (ch *Channel) expire(msg *Delivery, onExpired func(Delivery)) {
+	ch.m.Lock()
+	err := ch.send(&basicNack{DeliveryTag: msg.DeliveryTag, Requeue: true})
+	ch.m.Unlock()
+
+	if err != nil {
+		// The server requeues the delivery when the channel closes.
+		return
+	}
+
+	ch.consumers.acked(msg.DeliveryTag, false)
+	if onExpired != nil {
+		onExpired(*msg)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConsumeWithAckDeadlineRequeuesExpired(t *testing.T) {
+	const tag = "consumer-tag"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	clock := newFakeClock()
+	acked := make(chan struct{})
+	nacks := make(chan *basicNack, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2})
+
+		if ack := srv.recv(1, &basicAck{}).(*basicAck); ack.DeliveryTag != 1 {
+			t.Errorf("expected delivery 1 to be acknowledged, got %d", ack.DeliveryTag)
+		}
+		close(acked)
+
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+
+		srv.recv(1, &basicAck{})
+		srv.connectionClose()
+	}()
+
+	config := defaultConfig()
+	config.Clock = clock
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	expired := make(chan Delivery, 1)
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "q", tag, false, false, false, false, nil,
+		WithAckDeadline(time.Second, func(d Delivery) { expired <- d }))
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	first, second := <-deliveries, <-deliveries
+	if err := first.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	<-acked
+
+	clock.Advance(time.Second)
+
+	select {
+	case nack := <-nacks:
+		if nack.DeliveryTag != 2 || !nack.Requeue {
+			t.Errorf("expected delivery 2 to be requeued, got %+v", nack)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the expired delivery to be requeued")
+	}
+
+	if d := <-expired; d.DeliveryTag != 2 {
+		t.Errorf("expected delivery 2 to be reported as expired, got %d", d.DeliveryTag)
+	}
+
+	if err := second.Ack(false); !errors.Is(err, ErrAckDeadlineExceeded) {
+		t.Errorf("expected acking the expired delivery to fail with ErrAckDeadlineExceeded, got %v", err)
+	}
+
+	// Unblocks the server, which would fail on a second ack of delivery 2.
+	if err := ch.Ack(3, false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestCheckExpiredSkipsMutexWithoutDeadlineConsumers(t *testing.T) {
+	subs := makeConsumers()
+	defer subs.close()
+
+	subs.add("a", make(chan Delivery), consumeOptions{})
+
+	subs.Lock()
+	checked := make(chan error, 1)
+	go func() { checked <- subs.checkExpired(1) }()
+
+	select {
+	case err := <-checked:
+		if err != nil {
+			t.Errorf("expected no error without deadline consumers, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected checkExpired not to wait for the consumers mutex")
+	}
+	subs.Unlock()
+
+	subs.add("b", make(chan Delivery), consumeOptions{ackDeadline: time.Second, expire: func(*Delivery, func(Delivery)) {}})
+	if !subs.deadlined.Load() {
+		t.Error("expected a consumer with an ack deadline to enable checkExpired")
+	}
+}
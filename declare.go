@@ -0,0 +1,195 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeclareOption sets a property or argument of a queue or exchange declared
+// with Channel.QueueDeclareWithOptions or Channel.ExchangeDeclareWithOptions.
+type DeclareOption func(*declareOptions)
+
+type declareOptions struct {
+	passive    bool
+	durable    bool
+	autoDelete bool
+	exclusive  bool
+	internal   bool
+	noWait     bool
+	args       Table
+
+	// The last option used that only applies to queues or exchanges.
+	queueOnly    string
+	exchangeOnly string
+}
+
+func newDeclareOptions(opts []DeclareOption) declareOptions {
+	var o declareOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func (o *declareOptions) arg(key string, value interface{}) {
+	if o.args == nil {
+		o.args = make(Table)
+	}
+	o.args[key] = value
+}
+
+// Durable declares a queue or exchange that survives server restarts.
+func Durable() DeclareOption {
+	return func(o *declareOptions) { o.durable = true }
+}
+
+// AutoDelete declares a queue deleted when its last consumer is cancelled, or
+// an exchange deleted when its last binding is removed.
+func AutoDelete() DeclareOption {
+	return func(o *declareOptions) { o.autoDelete = true }
+}
+
+// NoWait declares without waiting for the server to confirm the declaration.
+func NoWait() DeclareOption {
+	return func(o *declareOptions) { o.noWait = true }
+}
+
+// Passive only checks that the queue or exchange exists, as
+// Channel.QueueDeclarePassive and Channel.ExchangeDeclarePassive.
+func Passive() DeclareOption {
+	return func(o *declareOptions) { o.passive = true }
+}
+
+// Args adds server specific arguments to the declaration.  Arguments set by
+// other options take precedence when they are applied after Args.
+func Args(args Table) DeclareOption {
+	return func(o *declareOptions) {
+		for k, v := range args {
+			o.arg(k, v)
+		}
+	}
+}
+
+// Exclusive declares a queue only accessible by the declaring connection and
+// deleted when it closes.
+func Exclusive() DeclareOption {
+	return func(o *declareOptions) {
+		o.exclusive = true
+		o.queueOnly = "Exclusive"
+	}
+}
+
+// QueueType declares a queue of the given type, such as QueueTypeQuorum, see
+// QueueTypeArg.
+func QueueType(kind string) DeclareOption {
+	return func(o *declareOptions) {
+		o.arg(QueueTypeArg, kind)
+		o.queueOnly = "QueueType"
+	}
+}
+
+// TTL declares a queue discarding messages older than ttl, in milliseconds,
+// see QueueMessageTTLArg.
+func TTL(ttl time.Duration) DeclareOption {
+	return func(o *declareOptions) {
+		o.arg(QueueMessageTTLArg, ttl.Milliseconds())
+		o.queueOnly = "TTL"
+	}
+}
+
+// Expires declares a queue deleted after being unused for expires, in
+// milliseconds, see QueueTTLArg.
+func Expires(expires time.Duration) DeclareOption {
+	return func(o *declareOptions) {
+		o.arg(QueueTTLArg, expires.Milliseconds())
+		o.queueOnly = "Expires"
+	}
+}
+
+// MaxLength declares a queue holding at most n ready messages, see
+// QueueMaxLenArg.
+func MaxLength(n int64) DeclareOption {
+	return func(o *declareOptions) {
+		o.arg(QueueMaxLenArg, n)
+		o.queueOnly = "MaxLength"
+	}
+}
+
+// DLX declares a queue dead-lettering messages to the exchange.
+func DLX(exchange string) DeclareOption {
+	return func(o *declareOptions) {
+		o.arg("x-dead-letter-exchange", exchange)
+		o.queueOnly = "DLX"
+	}
+}
+
+// DLXRoutingKey declares a queue dead-lettering messages with the routing key
+// instead of their original one.
+func DLXRoutingKey(key string) DeclareOption {
+	return func(o *declareOptions) {
+		o.arg("x-dead-letter-routing-key", key)
+		o.queueOnly = "DLXRoutingKey"
+	}
+}
+
+// Internal declares an exchange that does not accept publishings, only
+// bindings from other exchanges.
+func Internal() DeclareOption {
+	return func(o *declareOptions) {
+		o.internal = true
+		o.exchangeOnly = "Internal"
+	}
+}
+
+/*
+QueueDeclareWithOptions declares a queue as QueueDeclare, or
+QueueDeclarePassive with the Passive option, with its properties given as
+options rather than positional arguments:
+
+	q, err := ch.QueueDeclareWithOptions("orders",
+		amqp.Durable(),
+		amqp.QueueType(amqp.QueueTypeQuorum),
+		amqp.TTL(24*time.Hour),
+		amqp.DLX("orders.dead"),
+	)
+
+It returns an error without declaring the queue when an option only applies
+to exchanges.
+*/
+func (ch *Channel) QueueDeclareWithOptions(name string, opts ...DeclareOption) (Queue, error) {
+	o := newDeclareOptions(opts)
+	if o.exchangeOnly != "" {
+		return Queue{}, fmt.Errorf("declare option %s does not apply to queues", o.exchangeOnly)
+	}
+
+	if o.passive {
+		return ch.QueueDeclarePassive(name, o.durable, o.autoDelete, o.exclusive, o.noWait, o.args)
+	}
+	return ch.QueueDeclare(name, o.durable, o.autoDelete, o.exclusive, o.noWait, o.args)
+}
+
+/*
+ExchangeDeclareWithOptions declares an exchange as ExchangeDeclare, or
+ExchangeDeclarePassive with the Passive option, with its properties given as
+options rather than positional arguments:
+
+	err := ch.ExchangeDeclareWithOptions("events", amqp.ExchangeTopic, amqp.Durable())
+
+It returns an error without declaring the exchange when an option only applies
+to queues.
+*/
+func (ch *Channel) ExchangeDeclareWithOptions(name, kind string, opts ...DeclareOption) error {
+	o := newDeclareOptions(opts)
+	if o.queueOnly != "" {
+		return fmt.Errorf("declare option %s does not apply to exchanges", o.queueOnly)
+	}
+
+	if o.passive {
+		return ch.ExchangeDeclarePassive(name, kind, o.durable, o.autoDelete, o.internal, o.noWait, o.args)
+	}
+	return ch.ExchangeDeclare(name, kind, o.durable, o.autoDelete, o.internal, o.noWait, o.args)
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeclareWithOptions(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	queues := make(chan *queueDeclare, 2)
+	exchanges := make(chan *exchangeDeclare, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		queues <- srv.recv(1, &queueDeclare{}).(*queueDeclare)
+		srv.send(1, &queueDeclareOk{Queue: "orders", MessageCount: 3})
+		queues <- srv.recv(1, &queueDeclare{}).(*queueDeclare)
+		srv.send(1, &queueDeclareOk{Queue: "orders"})
+
+		exchanges <- srv.recv(1, &exchangeDeclare{}).(*exchangeDeclare)
+		srv.send(1, &exchangeDeclareOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	q, err := ch.QueueDeclareWithOptions("orders",
+		Durable(),
+		Args(Table{QueueMaxLenArg: int32(10)}),
+		QueueType(QueueTypeQuorum),
+		TTL(time.Minute),
+		DLX("orders.dead"),
+		MaxLength(100),
+	)
+	if err != nil {
+		t.Fatalf("queue declare error: %v", err)
+	}
+	if q.Name != "orders" || q.Messages != 3 {
+		t.Errorf("expected the declared queue to be returned, got %+v", q)
+	}
+
+	req := <-queues
+	if !req.Durable || req.AutoDelete || req.Exclusive || req.NoWait || req.Passive {
+		t.Errorf("expected a durable queue, got %+v", req)
+	}
+	for k, want := range map[string]interface{}{
+		QueueTypeArg:             QueueTypeQuorum,
+		QueueMessageTTLArg:       int64(60000),
+		"x-dead-letter-exchange": "orders.dead",
+		QueueMaxLenArg:           int64(100),
+	} {
+		if got := req.Arguments[k]; got != want {
+			t.Errorf("expected argument %s=%v, got %v", k, want, got)
+		}
+	}
+
+	if _, err := ch.QueueDeclareWithOptions("orders", Passive()); err != nil {
+		t.Fatalf("passive queue declare error: %v", err)
+	}
+	if req := <-queues; !req.Passive || req.Durable {
+		t.Errorf("expected a passive declaration, got %+v", req)
+	}
+
+	if err := ch.ExchangeDeclareWithOptions("events", ExchangeTopic, TTL(time.Second)); err == nil {
+		t.Error("expected a queue option to be refused for an exchange")
+	}
+	if _, err := ch.QueueDeclareWithOptions("orders", Internal()); err == nil {
+		t.Error("expected an exchange option to be refused for a queue")
+	}
+
+	if err := ch.ExchangeDeclareWithOptions("events", ExchangeTopic, Durable(), AutoDelete(), Internal()); err != nil {
+		t.Fatalf("exchange declare error: %v", err)
+	}
+	if req := <-exchanges; req.Type != ExchangeTopic || !req.Durable || !req.AutoDelete || !req.Internal {
+		t.Errorf("expected a durable auto-deleted internal topic exchange, got %+v", req)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
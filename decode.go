@@ -0,0 +1,94 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DecodeMode sets how the frames received that cannot be fully decoded are
+// handled, see Config.DecodeMode.
+type DecodeMode int
+
+const (
+	// DecodeStrict closes the connection with a FrameError on a field table
+	// with a field of an unknown type or on a malformed header frame.
+	DecodeStrict DecodeMode = iota
+
+	/*
+		DecodeLenient skips what cannot be decoded, for interoperability with
+		peers that do not follow the specification, and reports it to
+		Config.OnDecodeWarning:
+
+		  - a field of an unknown type is dropped from its table, along with
+		    the fields following it in the table, as the size of its value is
+		    unknown.
+		  - a malformed header frame keeps the properties read before the
+		    malformed one, the following ones are dropped.
+	*/
+	DecodeLenient
+)
+
+// DecodeWarning reports what was skipped decoding a frame in DecodeLenient
+// mode.
+type DecodeWarning struct {
+	Channel uint16 // of the frame
+	Err     error  // why, such as ErrSyntax for a field of an unknown type
+}
+
+// lenientReader reads the frames in DecodeLenient mode.  Decoding functions
+// read from one skip what they cannot decode, see DecodeLenient.
+type lenientReader struct {
+	io.Reader
+	decoder *lenientDecoder
+}
+
+type lenientDecoder struct {
+	channel   uint16 // of the frame being read
+	onWarning func(DecodeWarning)
+}
+
+func newLenientReader(r io.Reader, onWarning func(DecodeWarning)) *lenientReader {
+	return &lenientReader{Reader: r, decoder: &lenientDecoder{onWarning: onWarning}}
+}
+
+// lenient returns the lenientReader r is, or nil.
+func lenient(r io.Reader) *lenientReader {
+	l, _ := r.(*lenientReader)
+	return l
+}
+
+// wrap returns r read leniently too.
+func (l *lenientReader) wrap(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &lenientReader{Reader: r, decoder: l.decoder}
+}
+
+func (l *lenientReader) warn(err error) {
+	if l.decoder.onWarning != nil {
+		l.decoder.onWarning(DecodeWarning{Channel: l.decoder.channel, Err: err})
+	}
+}
+
+// parseHeaderFrame reads the whole payload of a header frame before decoding
+// it, so that the next frame is read from its start however malformed the
+// properties are.
+func (l *lenientReader) parseHeaderFrame(channel uint16, size uint32) (frame, error) {
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(l.Reader, payload); err != nil {
+		return nil, err
+	}
+
+	hf, err := readHeaderFrame(l.wrap(bytes.NewReader(payload)), channel)
+	if err != nil && hf != nil {
+		l.warn(fmt.Errorf("dropping properties of malformed header frame: %w", err))
+		return hf, nil
+	}
+	return hf, err
+}
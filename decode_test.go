@@ -0,0 +1,184 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// encodeFrames returns the wire encoding of frames.
+func encodeFrames(t *testing.T, frames ...frame) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := &writer{&buf}
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatalf("could not write frame: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// unknownFieldType returns a header frame whose headers hold an array with a
+// field of an unknown type after a valid one, followed by a heartbeat.
+func unknownFieldType(t *testing.T) []byte {
+	t.Helper()
+
+	wire := encodeFrames(t,
+		&headerFrame{ChannelId: 1, ClassId: 60, Size: 3, Properties: properties{
+			Headers:   Table{"list": []interface{}{int32(1), int16(7)}},
+			MessageId: "id",
+		}},
+		&heartbeatFrame{},
+	)
+	return bytes.Replace(wire, []byte{'s', 0, 7}, []byte{'Z', 0, 7}, 1)
+}
+
+func TestDecodeStrictRejectsUnknownFieldTypes(t *testing.T) {
+	r := reader{bytes.NewReader(unknownFieldType(t))}
+	if _, err := r.ReadFrame(); !errors.Is(err, ErrSyntax) {
+		t.Errorf("expected an unknown field type to fail with ErrSyntax, got %v", err)
+	}
+}
+
+func TestDecodeLenientSkipsUnknownFieldTypes(t *testing.T) {
+	var warnings []DecodeWarning
+	r := reader{newLenientReader(bytes.NewReader(unknownFieldType(t)), func(w DecodeWarning) {
+		warnings = append(warnings, w)
+	})}
+
+	f, err := r.ReadFrame()
+	if err != nil {
+		t.Fatalf("could not read the header frame leniently: %v", err)
+	}
+	hf := f.(*headerFrame)
+	if want, got := (Table{"list": []interface{}{int32(1)}}), hf.Properties.Headers; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the headers %v without the unknown field, got %v", want, got)
+	}
+	if want, got := "id", hf.Properties.MessageId; want != got {
+		t.Errorf("expected the properties after the headers to be read, got message id %q", got)
+	}
+
+	if len(warnings) != 1 || warnings[0].Channel != 1 || !errors.Is(warnings[0].Err, ErrSyntax) {
+		t.Errorf("expected a warning about the unknown field on channel 1, got %+v", warnings)
+	}
+
+	if f, err := r.ReadFrame(); err != nil {
+		t.Errorf("expected to read the following frame, got %v", err)
+	} else if _, ok := f.(*heartbeatFrame); !ok {
+		t.Errorf("expected the following heartbeat, got %#v", f)
+	}
+}
+
+func TestDecodeLenientKeepsPropertiesOfMalformedHeaderFrames(t *testing.T) {
+	header := encodeFrames(t, &headerFrame{ChannelId: 2, ClassId: 60, Size: 3, Properties: properties{
+		ContentType: "text/plain",
+		MessageId:   "truncated",
+	}})
+
+	// Cut the message id short, leaving the frame well delimited.
+	const cut = 4
+	size := binary.BigEndian.Uint32(header[3:7])
+	binary.BigEndian.PutUint32(header[3:7], size-cut)
+	malformed := append(header[:len(header)-1-cut:len(header)-1-cut], frameEnd)
+	malformed = append(malformed, encodeFrames(t, &heartbeatFrame{})...)
+
+	var warnings []DecodeWarning
+	r := reader{newLenientReader(bytes.NewReader(malformed), func(w DecodeWarning) {
+		warnings = append(warnings, w)
+	})}
+
+	f, err := r.ReadFrame()
+	if err != nil {
+		t.Fatalf("could not read the malformed header frame leniently: %v", err)
+	}
+	hf := f.(*headerFrame)
+	if hf.Size != 3 || hf.Properties.ContentType != "text/plain" {
+		t.Errorf("expected the size and properties before the malformed one, got %+v", hf)
+	}
+	if len(warnings) != 1 || warnings[0].Channel != 2 {
+		t.Errorf("expected a warning about the header frame on channel 2, got %+v", warnings)
+	}
+
+	if _, err := r.ReadFrame(); err != nil {
+		t.Errorf("expected to read the following frame, got %v", err)
+	}
+}
+
+func TestDecodeLenientConnectionDelivers(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: "lenient"})
+
+		srv.send(1, &basicDeliver{ConsumerTag: "lenient", DeliveryTag: 1, Properties: properties{
+			Headers: Table{"list": []interface{}{int32(1), int16(7)}},
+		}, Body: []byte("abc")})
+
+		srv.connectionClose()
+	}()
+
+	warnings := make(chan DecodeWarning, 1)
+
+	config := defaultConfig()
+	config.DecodeMode = DecodeLenient
+	config.OnDecodeWarning = func(w DecodeWarning) { warnings <- w }
+
+	c, err := Open(&unknownTypeIO{rwc}, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	deliveries, err := ch.Consume("q", "lenient", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	d := <-deliveries
+	if want, got := (Table{"list": []interface{}{int32(1)}}), d.Headers; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the headers %v without the unknown field, got %v", want, got)
+	}
+	if want, got := "abc", string(d.Body); want != got {
+		t.Errorf("expected the body %q, got %q", want, got)
+	}
+	if w := <-warnings; w.Channel != 1 {
+		t.Errorf("expected a warning on channel 1, got %+v", w)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+// unknownTypeIO stands for a peer sending fields of an unknown type, in place
+// of the int16 fields of value 7.
+type unknownTypeIO struct {
+	rwc io.ReadWriteCloser
+}
+
+func (u *unknownTypeIO) Read(p []byte) (int, error) {
+	n, err := u.rwc.Read(p)
+	copy(p[:n], bytes.Replace(p[:n], []byte{'s', 0, 7}, []byte{'Z', 0, 7}, -1))
+	return n, err
+}
+
+func (u *unknownTypeIO) Write(p []byte) (int, error) { return u.rwc.Write(p) }
+func (u *unknownTypeIO) Close() error                { return u.rwc.Close() }
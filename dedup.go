@@ -0,0 +1,135 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+Deduplicator remembers the MessageId of the messages handled recently, so that
+the duplicates of a message redelivered after it was processed, because its
+acknowledgement was lost with the connection or because the publisher
+retried, are not processed again.  Together with idempotent publishers setting
+a MessageId this gives effectively once processing on top of the at least once
+delivery of RabbitMQ.
+
+It holds at most size ids, evicting the least recently seen first, and
+forgets an id ttl after it was added.  A Deduplicator is safe for concurrent
+use and can be shared by several consumers.
+*/
+type Deduplicator struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	m       sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // of *dedupEntry, most recently seen first
+}
+
+type dedupEntry struct {
+	id    string
+	added time.Time
+}
+
+// NewDeduplicator returns a Deduplicator of at most size ids remembered for
+// ttl.  A size or ttl not greater than 0 means no limit.
+func NewDeduplicator(size int, ttl time.Duration) *Deduplicator {
+	return &Deduplicator{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Seen reports whether the message id was added and has not expired or been
+// evicted since.
+func (dd *Deduplicator) Seen(messageID string) bool {
+	dd.m.Lock()
+	defer dd.m.Unlock()
+
+	e, found := dd.entries[messageID]
+	if !found {
+		return false
+	}
+
+	if dd.expired(e.Value.(*dedupEntry), dd.now()) {
+		dd.remove(e)
+		return false
+	}
+
+	dd.order.MoveToFront(e)
+	return true
+}
+
+// Add remembers the message id, evicting the least recently seen id when full.
+func (dd *Deduplicator) Add(messageID string) {
+	dd.m.Lock()
+	defer dd.m.Unlock()
+
+	now := dd.now()
+	if e, found := dd.entries[messageID]; found {
+		e.Value.(*dedupEntry).added = now
+		dd.order.MoveToFront(e)
+		return
+	}
+
+	dd.entries[messageID] = dd.order.PushFront(&dedupEntry{id: messageID, added: now})
+
+	for dd.size > 0 && dd.order.Len() > dd.size {
+		dd.remove(dd.order.Back())
+	}
+}
+
+// Len returns the number of ids remembered, including the expired ones not
+// looked up since.
+func (dd *Deduplicator) Len() int {
+	dd.m.Lock()
+	defer dd.m.Unlock()
+
+	return dd.order.Len()
+}
+
+func (dd *Deduplicator) expired(e *dedupEntry, now time.Time) bool {
+	return dd.ttl > 0 && now.Sub(e.added) >= dd.ttl
+}
+
+func (dd *Deduplicator) remove(e *list.Element) {
+	dd.order.Remove(e)
+	delete(dd.entries, e.Value.(*dedupEntry).id)
+}
+
+/*
+Handler wraps a Consumer handler so that the deliveries whose MessageId has
+been handled successfully before are acknowledged without calling handler.
+The id of a delivery is remembered once handler returns Ack, so a delivery that
+is requeued is handled again.  Deliveries without a MessageId are always
+handled.
+
+	consumer := amqp.NewConsumer(ch, "payments", dedup.Handler(charge))
+*/
+func (dd *Deduplicator) Handler(handler func(ctx context.Context, d Delivery) Action) func(ctx context.Context, d Delivery) Action {
+	return func(ctx context.Context, d Delivery) Action {
+		if d.MessageId == "" {
+			return handler(ctx, d)
+		}
+
+		if dd.Seen(d.MessageId) {
+			return Ack
+		}
+
+		action := handler(ctx, d)
+		if action == Ack {
+			dd.Add(d.MessageId)
+		}
+		return action
+	}
+}
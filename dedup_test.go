@@ -0,0 +1,66 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeduplicatorEvictsAndExpires(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	dd := NewDeduplicator(2, time.Minute)
+	dd.now = func() time.Time { return now }
+
+	dd.Add("a")
+	dd.Add("b")
+	if !dd.Seen("a") {
+		t.Error("expected a to be seen")
+	}
+
+	// b is now the least recently seen.
+	dd.Add("c")
+	if dd.Seen("b") {
+		t.Error("expected b to be evicted")
+	}
+	if want, got := 2, dd.Len(); want != got {
+		t.Errorf("expected %d ids, got %d", want, got)
+	}
+
+	now = now.Add(time.Minute)
+	if dd.Seen("a") || dd.Seen("c") {
+		t.Error("expected the ids to expire after the ttl")
+	}
+	if want, got := 0, dd.Len(); want != got {
+		t.Errorf("expected the expired ids to be removed, got %d", got)
+	}
+}
+
+func TestDeduplicatorHandler(t *testing.T) {
+	dd := NewDeduplicator(10, 0)
+
+	var handled []string
+	results := map[string]Action{"ok": Ack, "retry": NackRequeue}
+	handler := dd.Handler(func(_ context.Context, d Delivery) Action {
+		handled = append(handled, d.MessageId)
+		return results[d.MessageId]
+	})
+
+	for _, id := range []string{"ok", "ok", "retry", "retry", "", ""} {
+		handler(context.Background(), Delivery{MessageId: id})
+	}
+
+	want := []string{"ok", "retry", "retry", "", ""}
+	if len(handled) != len(want) {
+		t.Fatalf("expected the handled deliveries %q, got %q", want, handled)
+	}
+	for i := range want {
+		if want[i] != handled[i] {
+			t.Errorf("expected the handled deliveries %q, got %q", want, handled)
+			break
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The exchange type, declaration argument and header of the delayed message
+// exchange plugin, rabbitmq_delayed_message_exchange.
+const (
+	ExchangeDelayedMessage = "x-delayed-message"
+	DelayedTypeArg         = "x-delayed-type"
+	DelayHeader            = "x-delay"
+)
+
+// MaxDelay is the longest delay of the delayed message exchange plugin.
+const MaxDelay = (1<<32 - 1) * time.Millisecond
+
+/*
+ExchangeDeclareDelayed declares an exchange of the delayed message exchange
+plugin, which holds the messages published with PublishDelayed for their delay
+before routing them like an exchange of innerType, such as ExchangeDirect:
+
+	err := ch.ExchangeDeclareDelayed("reminders", amqp.ExchangeTopic, amqp.Durable())
+
+The plugin must be enabled on the server.
+*/
+func (ch *Channel) ExchangeDeclareDelayed(name, innerType string, opts ...DeclareOption) error {
+	opts = append(opts[:len(opts):len(opts)], Args(Table{DelayedTypeArg: innerType}))
+	return ch.ExchangeDeclareWithOptions(name, ExchangeDelayedMessage, opts...)
+}
+
+/*
+PublishDelayed publishes msg like PublishWithContext to an exchange declared
+with ExchangeDeclareDelayed, which routes it once delay has passed.  It sets
+the DelayHeader of a copy of the headers of msg to the delay in milliseconds.
+It returns an error without publishing when delay is negative or longer than
+MaxDelay.
+
+The plugin keeps delayed messages on a single node and does not support
+mandatory publishings: the delay is not meant for millions of messages or for
+messages that must not be lost.
+*/
+func (ch *Channel) PublishDelayed(ctx context.Context, delay time.Duration, exchange, key string, msg Publishing) error {
+	if delay < 0 || delay > MaxDelay {
+		return fmt.Errorf("delay %v out of the range of the delayed message exchange, 0 to %v", delay, MaxDelay)
+	}
+
+	headers := make(Table, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[DelayHeader] = delay.Milliseconds()
+	msg.Headers = headers
+
+	return ch.PublishWithContext(ctx, exchange, key, false, false, msg)
+}
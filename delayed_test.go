@@ -0,0 +1,81 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDelayedMessageExchange(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	exchanges := make(chan *exchangeDeclare, 1)
+	published := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		exchanges <- srv.recv(1, &exchangeDeclare{}).(*exchangeDeclare)
+		srv.send(1, &exchangeDeclareOk{})
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	opts := make([]DeclareOption, 1, 2)
+	opts[0] = Args(Table{DelayedTypeArg: ExchangeFanout, "alternate-exchange": "unrouted"})
+	if err := ch.ExchangeDeclareDelayed("reminders", ExchangeTopic, append(opts, Durable())...); err != nil {
+		t.Fatalf("could not declare the delayed exchange: %v", err)
+	}
+
+	req := <-exchanges
+	if req.Exchange != "reminders" || req.Type != ExchangeDelayedMessage || !req.Durable {
+		t.Errorf("expected a durable delayed message exchange, got %+v", req)
+	}
+	if want, got := ExchangeTopic, req.Arguments[DelayedTypeArg]; want != got {
+		t.Errorf("expected the inner type %q, got %v", want, got)
+	}
+	if want, got := "unrouted", req.Arguments["alternate-exchange"]; want != got {
+		t.Errorf("expected the other arguments to be kept, got %v", req.Arguments)
+	}
+
+	ctx := context.Background()
+	for _, delay := range []time.Duration{-time.Second, MaxDelay + time.Millisecond} {
+		if err := ch.PublishDelayed(ctx, delay, "reminders", "due", Publishing{}); err == nil {
+			t.Errorf("expected a delay of %v to be refused", delay)
+		}
+	}
+
+	headers := Table{"k": "v"}
+	if err := ch.PublishDelayed(ctx, 90*time.Second, "reminders", "due", Publishing{Headers: headers, Body: []byte("ping")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	pub := <-published
+	if want, got := int64(90000), pub.Properties.Headers[DelayHeader]; want != got {
+		t.Errorf("expected the delay header %d, got %v", want, got)
+	}
+	if pub.Properties.Headers["k"] != "v" || len(headers) != 1 {
+		t.Errorf("expected the headers to be copied, got %v and %v", pub.Properties.Headers, headers)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
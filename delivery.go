@@ -7,6 +7,7 @@ package amqp091
 
 import (
 	"errors"
+	"io"
 	"time"
 )
 
@@ -25,6 +26,10 @@ type Acknowledger interface {
 // Delivery captures the fields for a previously delivered message resident in
 // a queue to be delivered by the server to a consumer from Channel.Consume or
 // Channel.Get.
+//
+// With Config.PoolDeliveryBodies, the Body of a delivery to a consumer must not
+// be used once the delivery has been acknowledged with Ack, Nack or Reject, as
+// its buffer is then reused.
 type Delivery struct {
 	Acknowledger Acknowledger // the channel from which this delivery arrived
 
@@ -56,6 +61,18 @@ type Delivery struct {
 	RoutingKey  string // basic.publish routing key
 
 	Body []byte
+
+	// BodyReader yields the body instead of Body for large deliveries to
+	// consumers started WithStreamedBody, nil otherwise.
+	BodyReader io.ReadCloser
+
+	// slab is the pooled buffer of Body, released once the delivery is
+	// acknowledged, see Config.PoolDeliveryBodies.
+	slab slabRef
+
+	// When the delivery was handed to the client-side buffer of its
+	// consumer, zero unless slow consumers are reported.
+	buffered time.Time
 }
 
 func newDelivery(channel *Channel, msg messageWithContent) *Delivery {
@@ -124,7 +141,7 @@ func (d Delivery) Ack(multiple bool) error {
 	if d.Acknowledger == nil {
 		return ErrDeliveryNotInitialized
 	}
-	return d.Acknowledger.Ack(d.DeliveryTag, multiple)
+	return d.release(d.Acknowledger.Ack(d.DeliveryTag, multiple))
 }
 
 /*
@@ -144,7 +161,7 @@ func (d Delivery) Reject(requeue bool) error {
 	if d.Acknowledger == nil {
 		return ErrDeliveryNotInitialized
 	}
-	return d.Acknowledger.Reject(d.DeliveryTag, requeue)
+	return d.release(d.Acknowledger.Reject(d.DeliveryTag, requeue))
 }
 
 /*
@@ -169,5 +186,282 @@ func (d Delivery) Nack(multiple, requeue bool) error {
 	if d.Acknowledger == nil {
 		return ErrDeliveryNotInitialized
 	}
-	return d.Acknowledger.Nack(d.DeliveryTag, multiple, requeue)
+	return d.release(d.Acknowledger.Nack(d.DeliveryTag, multiple, requeue))
+}
+
+/*
+AckMultipleThrough acknowledges this delivery and every prior unacknowledged
+delivery of the same channel, see Channel.AckUpTo.  It is Ack with multiple
+true for Acknowledgers other than a Channel.
+*/
+func (d Delivery) AckMultipleThrough() error {
+	if d.Acknowledger == nil {
+		return ErrDeliveryNotInitialized
+	}
+	if ch, ok := d.Acknowledger.(*Channel); ok {
+		return d.release(ch.AckUpTo(d.DeliveryTag))
+	}
+	return d.release(d.Acknowledger.Ack(d.DeliveryTag, true))
+}
+
+/*
+NackOrReject negatively acknowledges the delivery with basic.nack or
+basic.reject, depending on what the server supports, see
+Channel.NackOrReject.  Acknowledgers other than a Channel are sent a Nack.
+*/
+func (d Delivery) NackOrReject(multiple, requeue bool) error {
+	if d.Acknowledger == nil {
+		return ErrDeliveryNotInitialized
+	}
+	if ch, ok := d.Acknowledger.(*Channel); ok {
+		return d.release(ch.NackOrReject(d.DeliveryTag, multiple, requeue))
+	}
+	return d.release(d.Acknowledger.Nack(d.DeliveryTag, multiple, requeue))
+}
+
+/*
+Clone returns a deep copy of the delivery whose Headers and Body share no
+memory with the original, for pipelines that keep deliveries after they have
+been acknowledged, or that hand them to several goroutines which may modify
+them.
+
+The copy is acknowledged through the same Acknowledger as the original.
+*/
+func (d Delivery) Clone() Delivery {
+	if d.Headers != nil {
+		d.Headers = cloneField(d.Headers).(Table)
+	}
+	if d.Body != nil {
+		d.Body = append(make([]byte, 0, len(d.Body)), d.Body...)
+	}
+	d.slab = slabRef{}
+	return d
+}
+
+// release returns the pooled buffer of the Body to the pool once the delivery
+// has been acknowledged without error.
+func (d Delivery) release(err error) error {
+	if err == nil {
+		d.slab.release()
+	}
+	return err
+}
+
+// cloneField deep copies the values of a table field that can share memory,
+// see Table.
+func cloneField(f interface{}) interface{} {
+	switch fv := f.(type) {
+	case []byte:
+		return append(make([]byte, 0, len(fv)), fv...)
+
+	case []interface{}:
+		c := make([]interface{}, len(fv))
+		for i, v := range fv {
+			c[i] = cloneField(v)
+		}
+		return c
+
+	case Table:
+		c := make(Table, len(fv))
+		for k, v := range fv {
+			c[k] = cloneField(v)
+		}
+		return c
+	}
+
+	return f
+}
+
+/*
+ToPublishing returns a Publishing with the body, headers and properties of the
+delivery, to publish it again from a shovel, a retry or a republish.  The
+Headers are a copy that can be modified without changing the delivery, the Body
+is shared.
+*/
+func (d Delivery) ToPublishing() Publishing {
+	var headers Table
+	if d.Headers != nil {
+		headers = make(Table, len(d.Headers))
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+	}
+
+	// A pooled body is reused once d is acknowledged, which may happen
+	// before the publishing is written.
+	body := d.Body
+	if d.slab.s != nil {
+		body = append(make([]byte, 0, len(d.Body)), d.Body...)
+	}
+
+	return Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            body,
+	}
+}
+
+// Headers stamped by Delivery.ToRepublishing with where a republished message
+// was delivered from and how many times it was republished.
+const (
+	RepublishedExchangeHeader   = "x-republished-exchange"
+	RepublishedRoutingKeyHeader = "x-republished-routing-key"
+	RepublishCountHeader        = "x-republish-count"
+)
+
+// ToRepublishing is ToPublishing with the exchange and routing key the
+// delivery was published to stamped in the headers, and the count of
+// republishes incremented, so that consumers of the republished message can
+// trace it and break republish loops.
+func (d Delivery) ToRepublishing() Publishing {
+	p := d.ToPublishing()
+	if p.Headers == nil {
+		p.Headers = make(Table, 3)
+	}
+
+	var count int64
+	switch c := p.Headers[RepublishCountHeader].(type) {
+	case int64:
+		count = c
+	case int32:
+		count = int64(c)
+	}
+
+	p.Headers[RepublishedExchangeHeader] = d.Exchange
+	p.Headers[RepublishedRoutingKeyHeader] = d.RoutingKey
+	p.Headers[RepublishCountHeader] = count + 1
+	return p
+}
+
+/*
+XDeath is an entry of the x-death header the server adds to dead-lettered
+messages, see Delivery.Deaths.  There is one entry per queue and reason a
+message was dead-lettered for, the most recent first.
+*/
+type XDeath struct {
+	Count              int64     // times dead-lettered from Queue for Reason
+	Reason             string    // rejected, expired, maxlen or delivery_limit
+	Queue              string    // queue the message was dead-lettered from
+	Exchange           string    // exchange the message was published to
+	RoutingKeys        []string  // routing keys the message was published with
+	Time               time.Time // when the message was first dead-lettered from Queue for Reason
+	OriginalExpiration string    // per-message TTL of the message, if it had one
+}
+
+/*
+Deaths returns the entries of the x-death header of a dead-lettered delivery,
+the most recent first, or nil when the delivery was never dead-lettered.
+Fields missing from an entry, or of an unexpected type, are left to their zero
+value.
+*/
+func (d Delivery) Deaths() []XDeath {
+	entries, _ := d.Headers["x-death"].([]interface{})
+
+	var deaths []XDeath
+	for _, entry := range entries {
+		t, ok := entry.(Table)
+		if !ok {
+			continue
+		}
+
+		death := XDeath{}
+		switch count := t["count"].(type) {
+		case int64:
+			death.Count = count
+		case int32:
+			death.Count = int64(count)
+		}
+		death.Reason, _ = t["reason"].(string)
+		death.Queue, _ = t["queue"].(string)
+		death.Exchange, _ = t["exchange"].(string)
+		death.Time, _ = t["time"].(time.Time)
+		death.OriginalExpiration, _ = t["original-expiration"].(string)
+		if keys, ok := t["routing-keys"].([]interface{}); ok {
+			for _, key := range keys {
+				if key, ok := key.(string); ok {
+					death.RoutingKeys = append(death.RoutingKeys, key)
+				}
+			}
+		}
+
+		deaths = append(deaths, death)
+	}
+
+	return deaths
+}
+
+/*
+DeliveryCount returns how many times the delivery was delivered before, from
+the x-delivery-count header set by quorum queues, which RabbitMQ increments
+every time a delivery is requeued.  Other queues only flag a delivery as
+Redelivered, which counts as 1, so the count of a message requeued several
+times is only exact with quorum queues.
+*/
+func (d Delivery) DeliveryCount() int64 {
+	switch count := d.Headers["x-delivery-count"].(type) {
+	case int64:
+		return count
+	case int32:
+		return int64(count)
+	}
+	if d.Redelivered {
+		return 1
+	}
+	return 0
+}
+
+// PublishedAtHeader is the header stamped on publishings with the time they
+// were published, in milliseconds since the Unix epoch, when
+// Config.StampPublishedAt is set.
+const PublishedAtHeader = "x-published-at"
+
+/*
+Age returns how long ago the delivery was published, for freshness checks and
+staleness metrics.  It is computed from the Timestamp property, or from the
+PublishedAtHeader stamped by publishers with Config.StampPublishedAt when the
+Timestamp is not set.  The second return value is false when the delivery
+carries neither.
+
+The Timestamp property has a precision of one second and both depend on the
+clocks of the publisher and consumer being in sync, so an age is never
+negative but can be off by the skew between them.  The consumer side is read
+from the Config.Clock of the connection the delivery was received on.
+*/
+func (d Delivery) Age() (time.Duration, bool) {
+	return d.ageAt(d.now())
+}
+
+// now reads the Clock of the connection of a delivery received on a Channel.
+func (d Delivery) now() time.Time {
+	if ch, ok := d.Acknowledger.(*Channel); ok && ch.connection != nil {
+		return ch.connection.clock().Now()
+	}
+	return SystemClock.Now()
+}
+
+func (d Delivery) ageAt(now time.Time) (time.Duration, bool) {
+	published := d.Timestamp
+	if published.IsZero() {
+		ms, ok := d.Headers[PublishedAtHeader].(int64)
+		if !ok {
+			return 0, false
+		}
+		published = time.UnixMilli(ms)
+	}
+
+	if age := now.Sub(published); age > 0 {
+		return age, true
+	}
+	return 0, true
 }
@@ -7,8 +7,10 @@ package amqp091
 
 import (
 	"errors"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func shouldNotPanic(t *testing.T) {
@@ -64,3 +66,205 @@ func TestRejectZeroValueAcknowledgerDoesNotPanic(t *testing.T) {
 		t.Errorf("expected '%s' got '%s'", expectedErrMessage, err)
 	}
 }
+
+func TestDeliveryCloneIsDetached(t *testing.T) {
+	d := Delivery{
+		Acknowledger: &Channel{},
+		Headers: Table{
+			"bytes":  []byte("abc"),
+			"nested": Table{"list": []interface{}{[]byte("x"), int32(1)}},
+		},
+		DeliveryTag: 7,
+		Body:        []byte("body"),
+	}
+
+	c := d.Clone()
+
+	d.Body[0] = 'B'
+	d.Headers["bytes"].([]byte)[0] = 'A'
+	d.Headers["nested"].(Table)["list"].([]interface{})[0].([]byte)[0] = 'X'
+	d.Headers["added"] = "later"
+
+	if want, got := "body", string(c.Body); want != got {
+		t.Errorf("expected body %q, got %q", want, got)
+	}
+	if want, got := "abc", string(c.Headers["bytes"].([]byte)); want != got {
+		t.Errorf("expected header %q, got %q", want, got)
+	}
+	if want, got := "x", string(c.Headers["nested"].(Table)["list"].([]interface{})[0].([]byte)); want != got {
+		t.Errorf("expected nested header %q, got %q", want, got)
+	}
+	if _, found := c.Headers["added"]; found {
+		t.Error("expected headers added to the original not to be visible in the clone")
+	}
+	if c.DeliveryTag != d.DeliveryTag || c.Acknowledger != d.Acknowledger {
+		t.Errorf("expected the clone to be acknowledged like the original")
+	}
+}
+
+func TestDeliveryAge(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		delivery Delivery
+		want     time.Duration
+		ok       bool
+	}{
+		{Delivery{}, 0, false},
+		{Delivery{Timestamp: now.Add(-3 * time.Second)}, 3 * time.Second, true},
+		{Delivery{Timestamp: now.Add(time.Minute)}, 0, true},
+		{Delivery{Headers: Table{PublishedAtHeader: now.Add(-1500 * time.Millisecond).UnixMilli()}}, 1500 * time.Millisecond, true},
+		{Delivery{Headers: Table{PublishedAtHeader: "yesterday"}}, 0, false},
+		{Delivery{
+			Timestamp: now.Add(-time.Second),
+			Headers:   Table{PublishedAtHeader: now.Add(-time.Hour).UnixMilli()},
+		}, time.Second, true},
+	}
+
+	for i, tt := range tests {
+		if got, ok := tt.delivery.ageAt(now); got != tt.want || ok != tt.ok {
+			t.Errorf("%d: expected age %s %t, got %s %t", i, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestStampPublishedAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	headers := Table{"k": "v"}
+	stamped := stampPublishedAt(headers, now)
+	if want, got := now.UnixMilli(), stamped[PublishedAtHeader]; want != got {
+		t.Errorf("expected the header to be stamped with %d, got %v", want, got)
+	}
+	if _, ok := headers[PublishedAtHeader]; ok {
+		t.Error("expected the headers of the caller to be left unchanged")
+	}
+
+	if age, ok := (Delivery{Headers: stamped}).ageAt(now.Add(time.Second)); !ok || age != time.Second {
+		t.Errorf("expected the stamped header to give an age of 1s, got %s %t", age, ok)
+	}
+
+	if again := stampPublishedAt(stamped, now.Add(time.Hour)); again[PublishedAtHeader] != now.UnixMilli() {
+		t.Error("expected an existing stamp to be kept")
+	}
+}
+
+func TestDeliveryDeaths(t *testing.T) {
+	died := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d := Delivery{Headers: Table{"x-death": []interface{}{
+		Table{
+			"count":               int64(2),
+			"reason":              "expired",
+			"queue":               "work.retry.1",
+			"exchange":            "",
+			"routing-keys":        []interface{}{"work.retry.1"},
+			"time":                died,
+			"original-expiration": "5000",
+		},
+		Table{"count": int32(1), "reason": "rejected", "queue": "work"},
+		"not a table",
+	}}}
+
+	want := []XDeath{
+		{
+			Count:              2,
+			Reason:             "expired",
+			Queue:              "work.retry.1",
+			RoutingKeys:        []string{"work.retry.1"},
+			Time:               died,
+			OriginalExpiration: "5000",
+		},
+		{Count: 1, Reason: "rejected", Queue: "work"},
+	}
+	if got := d.Deaths(); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected deaths %+v, got %+v", want, got)
+	}
+
+	if deaths := (Delivery{}).Deaths(); deaths != nil {
+		t.Errorf("expected no deaths without the header, got %+v", deaths)
+	}
+}
+
+func TestDeliveryDeliveryCount(t *testing.T) {
+	for _, tc := range []struct {
+		d    Delivery
+		want int64
+	}{
+		{Delivery{}, 0},
+		{Delivery{Redelivered: true}, 1},
+		{Delivery{Redelivered: true, Headers: Table{"x-delivery-count": int64(4)}}, 4},
+		{Delivery{Redelivered: true, Headers: Table{"x-delivery-count": int32(2)}}, 2},
+	} {
+		if got := tc.d.DeliveryCount(); tc.want != got {
+			t.Errorf("expected a delivery count of %d for %+v, got %d", tc.want, tc.d, got)
+		}
+	}
+}
+
+func TestDeliveryToPublishing(t *testing.T) {
+	d := Delivery{
+		Headers:       Table{"tenant": "eu"},
+		ContentType:   "application/json",
+		DeliveryMode:  Persistent,
+		Priority:      3,
+		CorrelationId: "c",
+		Expiration:    "1000",
+		MessageId:     "m",
+		Timestamp:     time.Unix(1700000000, 0),
+		AppId:         "app",
+		Exchange:      "orders",
+		RoutingKey:    "eu.created",
+		Body:          []byte("{}"),
+	}
+
+	p := d.ToPublishing()
+	want := Publishing{
+		Headers:       Table{"tenant": "eu"},
+		ContentType:   "application/json",
+		DeliveryMode:  Persistent,
+		Priority:      3,
+		CorrelationId: "c",
+		Expiration:    "1000",
+		MessageId:     "m",
+		Timestamp:     time.Unix(1700000000, 0),
+		AppId:         "app",
+		Body:          []byte("{}"),
+	}
+	if !reflect.DeepEqual(want, p) {
+		t.Errorf("expected the publishing %+v, got %+v", want, p)
+	}
+
+	p.Headers["added"] = true
+	if _, found := d.Headers["added"]; found {
+		t.Error("expected the headers of the publishing to be a copy")
+	}
+
+	d.Headers[RepublishCountHeader] = int64(1)
+	r := d.ToRepublishing()
+	for k, v := range map[string]interface{}{
+		RepublishedExchangeHeader:   "orders",
+		RepublishedRoutingKeyHeader: "eu.created",
+		RepublishCountHeader:        int64(2),
+	} {
+		if got := r.Headers[k]; got != v {
+			t.Errorf("expected header %s to be %v, got %v", k, v, got)
+		}
+	}
+}
+
+func TestDeliveryToPublishingCopiesPooledBody(t *testing.T) {
+	body, slab := getSlab(len("pooled"))
+	copy(body, "pooled")
+
+	d := Delivery{Body: body, slab: slab}
+	p := d.ToPublishing()
+
+	// Acknowledging d hands its body to the next delivery.
+	d.slab.release()
+	copy(body, "reused")
+
+	if want, got := "pooled", string(p.Body); want != got {
+		t.Errorf("expected the publishing body %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+DispatchDepth is a periodic report on the internal queues of one channel, see
+Config.OnDispatchDepth.
+
+PendingWrites staying above 0 means the channel publishes faster than the
+connection writes, and spreading the publishings over more channels or
+connections will not help if the network is the bottleneck.  A consumer whose
+Buffered count keeps growing receives faster than the application handles
+its deliveries, and is a candidate for WithDeliveryBuffer or a lower
+Channel.Qos prefetch.
+*/
+type DispatchDepth struct {
+	Channel       *Channel
+	PendingWrites int            // methods and publishings of the channel waiting to be written
+	Buffered      map[string]int // deliveries waiting for the application, by consumer tag
+}
+
+// bufferState is what the client-side buffer of a consumer holds, for the
+// depth and slow consumer reports.
+type bufferState struct {
+	depth atomic.Int64             // deliveries buffered
+	head  atomic.Pointer[Delivery] // oldest delivery buffered, nil when empty
+}
+
+// set records the deliveries buffered, oldest first.
+func (b *bufferState) set(queue []*Delivery) {
+	b.depth.Store(int64(len(queue)))
+	if len(queue) == 0 {
+		b.head.Store(nil)
+	} else {
+		b.head.Store(queue[0])
+	}
+}
+
+// depthReport returns the number of deliveries buffered for every consumer.
+func (subs *consumers) depthReport() map[string]int {
+	subs.Lock()
+	defer subs.Unlock()
+
+	buffered := make(map[string]int, len(subs.buffers))
+	for tag, state := range subs.buffers {
+		buffered[tag] = int(state.depth.Load())
+	}
+
+	return buffered
+}
+
+// reportDepth calls fn with the depth of the queues of the channel each
+// interval until the channel closes.
+func (ch *Channel) reportDepth(interval time.Duration, fn func(DispatchDepth)) {
+	ticker := ch.connection.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ch.close:
+			return
+		case <-ticker.C():
+			fn(DispatchDepth{
+				Channel:       ch,
+				PendingWrites: int(ch.pendingWrites.Load()),
+				Buffered:      ch.consumers.depthReport(),
+			})
+		}
+	}
+}
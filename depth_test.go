@@ -0,0 +1,93 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDispatchDepthReportsBufferedDeliveries(t *testing.T) {
+	const tag = "deep"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: "deep", DeliveryTag: tag})
+		}
+
+		srv.connectionClose()
+	}()
+
+	reports := make(chan DispatchDepth, 16)
+
+	config := defaultConfig()
+	config.DispatchDepthInterval = 5 * time.Millisecond
+	config.OnDispatchDepth = func(d DispatchDepth) {
+		select {
+		case reports <- d:
+		default:
+		}
+	}
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	deliveries, err := ch.Consume("q", tag, true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	waitFor := func(buffered int) {
+		t.Helper()
+
+		timeout := time.After(time.Second)
+		for {
+			var d DispatchDepth
+			select {
+			case d = <-reports:
+			case <-timeout:
+				t.Fatalf("timed out waiting for a report with %d buffered deliveries", buffered)
+			}
+
+			if d.Channel != ch {
+				t.Fatalf("unexpected report for channel %d", d.Channel.id)
+			}
+			if d.Buffered[tag] == buffered {
+				if d.PendingWrites != 0 {
+					t.Errorf("expected no pending writes, got %d", d.PendingWrites)
+				}
+				return
+			}
+		}
+	}
+
+	waitFor(3)
+
+	for i := 0; i < 3; i++ {
+		<-deliveries
+	}
+
+	waitFor(0)
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
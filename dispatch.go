@@ -0,0 +1,211 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// dispatchPool is the bounded set of goroutines sending the deliveries to the
+// consumers of all the channels of a connection, see Config.DispatchWorkers.
+type dispatchPool struct {
+	workers []*dispatchWorker
+	next    atomic.Uint32
+}
+
+// newDispatchPool starts workers goroutines, which return once done is closed.
+func newDispatchPool(workers int, done <-chan struct{}) *dispatchPool {
+	p := &dispatchPool{}
+	for i := 0; i < workers; i++ {
+		w := &dispatchWorker{
+			ready: make(map[*dispatchQueue]struct{}),
+			wake:  make(chan struct{}, 1),
+		}
+		p.workers = append(p.workers, w)
+		go w.work(done)
+	}
+	return p
+}
+
+// assign returns the worker of a new consumer, in turn.
+func (p *dispatchPool) assign() *dispatchWorker {
+	return p.workers[int(p.next.Add(1)-1)%len(p.workers)]
+}
+
+/*
+dispatchWorker sends the deliveries of the consumers assigned to it.  It waits
+for any of them to receive its next delivery at once, rather than for each in
+turn, so that a consumer not receiving its deliveries does not hold up the
+others.
+*/
+type dispatchWorker struct {
+	m     sync.Mutex
+	ready map[*dispatchQueue]struct{} // queues with deliveries, or ended
+
+	wake chan struct{} // signals a change of ready
+}
+
+// schedule has the worker send the deliveries of q, or close its consumer
+// chan.
+func (w *dispatchWorker) schedule(q *dispatchQueue) {
+	w.m.Lock()
+	w.ready[q] = struct{}{}
+	w.m.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *dispatchWorker) work(done <-chan struct{}) {
+	var (
+		queues   []*dispatchQueue
+		requeued []*Delivery
+		cases    []reflect.SelectCase
+	)
+
+	for {
+		queues, requeued = queues[:0], requeued[:0]
+		cases = append(cases[:0],
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(w.wake)},
+			reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)},
+		)
+
+		w.m.Lock()
+		for q := range w.ready {
+			d, cancelled, ok := q.next()
+			requeued = append(requeued, cancelled...)
+			if !ok {
+				delete(w.ready, q)
+				continue
+			}
+			queues = append(queues, q)
+			cases = append(cases, reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(q.out),
+				Send: reflect.ValueOf(d),
+			})
+		}
+		w.m.Unlock()
+
+		if len(requeued) > 0 {
+			requeue(requeued)
+			continue
+		}
+
+		switch chosen, _, _ := reflect.Select(cases); chosen {
+		case 0:
+		case 1:
+			return
+		default:
+			queues[chosen-2].pop()
+		}
+	}
+}
+
+// dispatchQueue buffers the deliveries of a consumer until its worker sends
+// them, in place of the goroutine of consumers.buffer.
+type dispatchQueue struct {
+	subs   *consumers
+	worker *dispatchWorker
+	out    chan Delivery
+	opts   consumeOptions
+	state  *bufferState
+
+	m        sync.Mutex // protects below
+	queue    []*Delivery
+	ended    bool // cancelled
+	finished bool // out is closed
+}
+
+func newDispatchQueue(subs *consumers, out chan Delivery, opts consumeOptions, state *bufferState) *dispatchQueue {
+	return &dispatchQueue{
+		subs:   subs,
+		worker: subs.pool.assign(),
+		out:    out,
+		opts:   opts,
+		state:  state,
+	}
+}
+
+// push queues a delivery for the worker.
+func (q *dispatchQueue) push(d *Delivery) {
+	q.m.Lock()
+	q.queue = append(q.queue, d)
+	q.state.set(q.queue)
+	q.m.Unlock()
+
+	q.worker.schedule(q)
+}
+
+// end has the worker close the consumer chan once the queued deliveries are
+// sent, or right away, dropping them, when the channel is closed.
+func (q *dispatchQueue) end() {
+	q.m.Lock()
+	q.ended = true
+	q.m.Unlock()
+
+	q.worker.schedule(q)
+}
+
+/*
+next returns the delivery to send, with ok false when there is none: the
+consumer chan is then closed when the consumer is ended.  Like consumers.buffer,
+the queued deliveries are dropped once the channel is closed, and returned to be
+requeued once the consumer is cancelled with WithNackOnCancel.
+*/
+func (q *dispatchQueue) next() (d Delivery, requeued []*Delivery, ok bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.finished {
+		return Delivery{}, nil, false
+	}
+
+	select {
+	case <-q.subs.closed:
+		// closed before drained, drop in-flight
+		q.finish()
+		return Delivery{}, nil, false
+	default:
+	}
+
+	if len(q.queue) == 0 {
+		if q.ended {
+			q.finish()
+		}
+		return Delivery{}, nil, false
+	}
+
+	if q.ended && q.opts.isCancelled() {
+		requeued = q.queue
+		q.finish()
+		return Delivery{}, requeued, false
+	}
+
+	return *q.queue[0], nil, true
+}
+
+// pop drops the delivery sent.
+func (q *dispatchQueue) pop() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.queue[0] = nil
+	q.queue = q.queue[1:]
+	q.state.set(q.queue)
+}
+
+// finish closes the consumer chan.  Must be called while holding m.
+func (q *dispatchQueue) finish() {
+	q.finished = true
+	q.queue = nil
+	q.state.set(nil)
+	close(q.out)
+	q.subs.Done()
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDispatchWorkersDeliverToTheConsumersOfAllChannels(t *testing.T) {
+	const channels = 3
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		for id := 1; id <= channels; id++ {
+			srv.channelOpen(id)
+			srv.recv(id, &basicConsume{})
+			srv.send(id, &basicConsumeOk{ConsumerTag: "worker"})
+		}
+
+		for tag := uint64(1); tag <= 2; tag++ {
+			for id := 1; id <= channels; id++ {
+				srv.send(id, &basicDeliver{ConsumerTag: "worker", DeliveryTag: tag, Body: []byte{byte(id)}})
+			}
+		}
+
+		for id := 1; id <= channels; id++ {
+			srv.recv(id, &channelClose{})
+			srv.send(id, &channelCloseOk{})
+		}
+		srv.connectionClose()
+	}()
+
+	config := defaultConfig()
+	config.DispatchWorkers = 1
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	var chs []*Channel
+	var consumers []<-chan Delivery
+	for id := 1; id <= channels; id++ {
+		ch, err := c.Channel()
+		if err != nil {
+			t.Fatalf("could not open channel: %v", err)
+		}
+		deliveries, err := ch.Consume("q", "worker", true, false, false, false, nil)
+		if err != nil {
+			t.Fatalf("could not consume: %v", err)
+		}
+		if len(ch.consumers.queues) != 1 || len(ch.consumers.chans) != 0 {
+			t.Fatalf("expected the consumer to be dispatched by the workers")
+		}
+		chs = append(chs, ch)
+		consumers = append(consumers, deliveries)
+	}
+
+	for i, deliveries := range consumers {
+		for tag := uint64(1); tag <= 2; tag++ {
+			select {
+			case d := <-deliveries:
+				if d.DeliveryTag != tag || d.Body[0] != byte(i+1) {
+					t.Errorf("expected delivery %d on channel %d, got %d on channel %d", tag, i+1, d.DeliveryTag, d.Body[0])
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("timeout waiting for delivery %d on channel %d", tag, i+1)
+			}
+		}
+	}
+
+	for i, ch := range chs {
+		if err := ch.Close(); err != nil {
+			t.Fatalf("could not close channel: %v", err)
+		}
+		if _, open := <-consumers[i]; open {
+			t.Errorf("expected the deliveries of channel %d to be closed", i+1)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestDispatchWorkersNackOnCancelRequeuesQueued(t *testing.T) {
+	const tag = "consumer-tag"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	nacks := make(chan *basicNack, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 3})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+		nacks <- srv.recv(1, &basicNack{}).(*basicNack)
+
+		srv.connectionClose()
+	}()
+
+	config := defaultConfig()
+	config.DispatchWorkers = 2
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries, err := ch.ConsumeWithOptions(ctx, "q", tag, false, false, false, false, nil, WithNackOnCancel())
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	if d := <-deliveries; d.DeliveryTag != 1 {
+		t.Fatalf("expected the first delivery, got %d", d.DeliveryTag)
+	}
+	cancel()
+
+	for _, want := range []uint64{2, 3} {
+		select {
+		case nack := <-nacks:
+			if nack.DeliveryTag != want || !nack.Requeue || nack.Multiple {
+				t.Errorf("expected delivery %d to be requeued, got %+v", want, nack)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for delivery %d to be requeued", want)
+		}
+	}
+
+	for d := range deliveries {
+		t.Errorf("expected no delivery after the cancel, got %d", d.DeliveryTag)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestDispatchWorkersKeepAGoroutineForBufferedConsumers(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	subs := makeConsumers()
+	subs.pool = newDispatchPool(1, done)
+
+	subs.add("queued", make(chan Delivery), consumeOptions{})
+	subs.add("buffered", make(chan Delivery), consumeOptions{bufferSize: 2})
+
+	if _, found := subs.queues["queued"]; !found {
+		t.Errorf("expected the unbuffered consumer to be dispatched by the workers")
+	}
+	if _, found := subs.chans["buffered"]; !found {
+		t.Errorf("expected the buffered consumer to keep its goroutine")
+	}
+
+	subs.close()
+}
@@ -144,6 +144,16 @@ a select listening on the two channels inside a for loop like:
 It is strongly recommended to use buffered channels to avoid deadlocks inside
 the library.
 
+When a channel shuts down, every notification dispatched before the shutdown
+has been sent to its listeners, then the listeners are closed in a fixed order:
+the error is sent to [Channel.NotifyClose] listeners, consumer chans are closed,
+then the [Channel.NotifyClose], [Channel.NotifyFlow], [Channel.NotifyReturn],
+[Channel.NotifyCancel] and [Channel.NotifyPublish] listeners are closed, so a
+listener can be drained until it is closed without losing notifications.
+
+Set Config.StrictNotify in tests to panic when a listener is not ready to
+receive, rather than have the library block until it is.
+
 # Best practises for NotifyPublish notifications:
 
 Using [Channel.NotifyPublish] allows the caller of the library to be notified,
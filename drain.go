@@ -0,0 +1,75 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "context"
+
+/*
+CancelAndDrain stops a consumer without losing its deliveries, for a graceful
+shutdown.  It cancels the consumer like Cancel, so that the deliveries already
+sent by the server keep arriving on the consumer chan until the server
+confirms the cancel, and then waits until the application has acknowledged,
+negatively acknowledged or rejected every delivery of the consumer.
+
+The consumer chan must be received from until it is closed for the remaining
+deliveries to be settled.  When ctx is done before, context.Cause(ctx) is
+returned and the unacknowledged deliveries are left to the application; they
+are requeued by the server once the channel closes.  Deliveries of autoAck
+consumers need no acknowledgement, so only the cancel is waited for.
+*/
+func (ch *Channel) CancelAndDrain(ctx context.Context, consumer string) error {
+	if err := ch.callContext(ctx, &basicCancel{ConsumerTag: consumer}, &basicCancelOk{}); err != nil {
+		return err
+	}
+	ch.consumers.cancel(consumer)
+
+	select {
+	case <-ch.consumers.drain(consumer):
+		return nil
+	case <-ch.close:
+		return ErrClosed
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// drain returns a chan closed once the consumer identified by tag has no
+// unacknowledged deliveries.
+func (subs *consumers) drain(tag string) <-chan struct{} {
+	subs.Lock()
+	defer subs.Unlock()
+
+	drained := make(chan struct{})
+	if subs.inFlight(tag) == 0 {
+		close(drained)
+		return drained
+	}
+
+	if subs.drains == nil {
+		subs.drains = make(map[string][]chan struct{})
+	}
+	subs.drains[tag] = append(subs.drains[tag], drained)
+	return drained
+}
+
+// notifyDrained closes the drains of the consumers without unacknowledged
+// deliveries.  Called with the consumers mutex held.
+func (subs *consumers) notifyDrained() {
+	for tag, drains := range subs.drains {
+		if subs.inFlight(tag) > 0 {
+			continue
+		}
+		for _, drained := range drains {
+			close(drained)
+		}
+		delete(subs.drains, tag)
+	}
+}
+
+// inFlight counts the unacknowledged deliveries of the consumer identified by
+// tag.  Called with the consumers mutex held.
+func (subs *consumers) inFlight(tag string) int {
+	return subs.unacked.inFlight[tag]
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCancelAndDrainWaitsForAcks(t *testing.T) {
+	const tag = "consumer-tag"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2})
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 3})
+		srv.send(1, &basicCancelOk{ConsumerTag: tag})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			if ack := srv.recv(1, &basicAck{}).(*basicAck); ack.DeliveryTag != tag {
+				t.Errorf("expected delivery %d to be acknowledged, got %d", tag, ack.DeliveryTag)
+			}
+		}
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.Consume("q", tag, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	first := <-deliveries
+
+	drained := make(chan error, 1)
+	go func() {
+		drained <- ch.CancelAndDrain(context.Background(), tag)
+	}()
+
+	var rest []Delivery
+	for d := range deliveries {
+		rest = append(rest, d)
+	}
+	if want, got := 2, len(rest); want != got {
+		t.Fatalf("expected %d deliveries after the cancel, got %d", want, got)
+	}
+
+	for _, d := range append([]Delivery{first}, rest[0]) {
+		if err := d.Ack(false); err != nil {
+			t.Fatalf("could not ack: %v", err)
+		}
+	}
+
+	select {
+	case err := <-drained:
+		t.Fatalf("expected the drain to wait for delivery 3, returned %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	if err := rest[1].Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Errorf("expected the drain to succeed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the drain")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConsumersDrain(t *testing.T) {
+	subs := makeConsumers()
+	subs.add("a", make(chan Delivery, 1), consumeOptions{})
+	defer subs.close()
+
+	subs.Lock()
+	subs.delivered(&Delivery{ConsumerTag: "a", DeliveryTag: 1})
+	subs.delivered(&Delivery{ConsumerTag: "a", DeliveryTag: 2})
+	subs.Unlock()
+
+	drained := subs.drain("a")
+
+	subs.acked(1, false)
+	select {
+	case <-drained:
+		t.Fatal("expected the consumer to have a delivery in flight")
+	default:
+	}
+
+	subs.acked(2, true)
+	select {
+	case <-drained:
+	default:
+		t.Fatal("expected the consumer to be drained")
+	}
+}
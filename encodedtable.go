@@ -0,0 +1,97 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "bytes"
+
+/*
+EncodedTable is a Table encoded once by Table.Freeze, for the identical headers
+of many publishings, which are then written without being encoded again:
+
+	headers := amqp.Table{"tenant": "acme", "schema": "v2"}.Freeze()
+	if err := headers.Err(); err != nil {
+		return err
+	}
+
+	for _, body := range bodies {
+		err := ch.PublishWithContext(ctx, "events", "", false, false, amqp.Publishing{
+			EncodedHeaders: headers,
+			Body:           body,
+		})
+	}
+
+When the Headers of a publishing are set too, such as by a middleware adding a
+header, both are merged, the Headers winning, and encoded again.  The zero
+EncodedTable holds no headers.
+*/
+type EncodedTable struct {
+	table   Table
+	encoded []byte
+	err     error
+}
+
+/*
+Freeze validates and encodes a deep copy of the table.  Changing the table
+afterwards does not change the EncodedTable.  The error of an invalid table is
+returned by the Err method of the EncodedTable, and by the publishings using
+it.
+*/
+func (t Table) Freeze() EncodedTable {
+	if err := t.Validate(); err != nil {
+		return EncodedTable{err: err}
+	}
+
+	var encoded bytes.Buffer
+	if err := writeTable(&encoded, t); err != nil {
+		return EncodedTable{err: err}
+	}
+
+	frozen, _ := cloneField(t).(Table)
+	if frozen == nil {
+		frozen = Table{}
+	}
+
+	return EncodedTable{
+		table:   frozen,
+		encoded: encoded.Bytes(),
+	}
+}
+
+// Err returns the error of Table.Freeze when the table is invalid.
+func (e EncodedTable) Err() error {
+	return e.err
+}
+
+// Table returns a copy of the table that was frozen, nil for the zero
+// EncodedTable.
+func (e EncodedTable) Table() Table {
+	if e.table == nil {
+		return nil
+	}
+	return cloneField(e.table).(Table)
+}
+
+// headers returns the table or the encoded table of the headers of p, see
+// EncodedTable.
+func (p *Publishing) headers() (Table, []byte) {
+	if p.EncodedHeaders.table == nil {
+		return p.Headers, nil
+	}
+	if p.Headers == nil {
+		if len(p.EncodedHeaders.table) == 0 {
+			return nil, nil
+		}
+		return nil, p.EncodedHeaders.encoded
+	}
+
+	merged := make(Table, len(p.EncodedHeaders.table)+len(p.Headers))
+	for k, v := range p.EncodedHeaders.table {
+		merged[k] = v
+	}
+	for k, v := range p.Headers {
+		merged[k] = v
+	}
+	return merged, nil
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestTableFreeze(t *testing.T) {
+	table := Table{"tenant": "acme", "nested": Table{"level": int32(1)}}
+
+	frozen := table.Freeze()
+	if err := frozen.Err(); err != nil {
+		t.Fatalf("could not freeze a valid table: %v", err)
+	}
+
+	table["tenant"] = "changed"
+	table["nested"].(Table)["level"] = int32(2)
+
+	want := Table{"tenant": "acme", "nested": Table{"level": int32(1)}}
+	if got := frozen.Table(); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the frozen table not to change with the original, got %v", got)
+	}
+
+	// The encoding is the one of the table at the time it was frozen.
+	var wire bytes.Buffer
+	if err := writeTable(&wire, want); err != nil {
+		t.Fatalf("could not write table: %v", err)
+	}
+	decoded, err := readTable(bytes.NewReader(frozen.encoded))
+	if err != nil {
+		t.Fatalf("could not read the encoded table: %v", err)
+	}
+	if !reflect.DeepEqual(want, decoded) || len(frozen.encoded) != wire.Len() {
+		t.Errorf("expected the encoded table to decode to %v, got %v", want, decoded)
+	}
+
+	if err := (Table{"invalid": struct{}{}}).Freeze().Err(); err == nil {
+		t.Errorf("expected an invalid table not to freeze")
+	}
+	if (EncodedTable{}).Table() != nil {
+		t.Errorf("expected the zero EncodedTable to hold no table")
+	}
+}
+
+func TestPublishEncodedHeaders(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 3)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for i := 0; i < 3; i++ {
+			published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		}
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	frozen := Table{"tenant": "acme", "schema": "v2"}.Freeze()
+	ctx := context.Background()
+
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{EncodedHeaders: frozen}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{
+		EncodedHeaders: frozen,
+		Headers:        Table{"schema": "v3", "trace": "abc"},
+	}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{EncodedHeaders: Table{}.Freeze()}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	invalid := Table{"invalid": struct{}{}}.Freeze()
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{EncodedHeaders: invalid}); err == nil {
+		t.Errorf("expected publishing invalid encoded headers to fail")
+	}
+
+	if want, got := (Table{"tenant": "acme", "schema": "v2"}), (<-published).Properties.Headers; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the encoded headers %v, got %v", want, got)
+	}
+	if want, got := (Table{"tenant": "acme", "schema": "v3", "trace": "abc"}), (<-published).Properties.Headers; !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the headers merged with the encoded headers %v, got %v", want, got)
+	}
+	if got := (<-published).Properties.Headers; got != nil {
+		t.Errorf("expected no headers for an empty encoded table, got %v", got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func BenchmarkWriteProperties(b *testing.B) {
+	table := Table{
+		"tenant":  "acme",
+		"schema":  "v2",
+		"source":  "orders",
+		"retries": int32(0),
+	}
+	frozen := table.Freeze()
+
+	b.Run("Table", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := writeProperties(io.Discard, properties{Headers: table}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("EncodedTable", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := writeProperties(io.Discard, properties{encodedHeaders: frozen.encoded}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
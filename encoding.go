@@ -0,0 +1,225 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnsupportedContentEncoding is returned by Delivery.DecodedBody when no
+// decoder is registered for the ContentEncoding of the delivery.
+var ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+
+// ContentDecoder returns a reader of the decoded content of r, see
+// RegisterContentDecoder.
+type ContentDecoder func(r io.Reader) (io.ReadCloser, error)
+
+var (
+	contentDecodersM sync.RWMutex
+	contentDecoders  = map[string]ContentDecoder{
+		"gzip":   gzipDecoder,
+		"x-gzip": gzipDecoder,
+		"deflate": func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	}
+)
+
+func gzipDecoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ContentEncoder returns a writer encoding the content written to it into w,
+// see RegisterContentEncoder.
+type ContentEncoder func(w io.Writer) (io.WriteCloser, error)
+
+var (
+	contentEncodersM sync.RWMutex
+	contentEncoders  = map[string]ContentEncoder{
+		"gzip":   gzipEncoder,
+		"x-gzip": gzipEncoder,
+		"deflate": func(w io.Writer) (io.WriteCloser, error) {
+			return zlib.NewWriter(w), nil
+		},
+	}
+)
+
+func gzipEncoder(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// AcceptEncodingHeader lists the content encodings the publisher of a message
+// can decode, comma separated in order of preference, so that the messages
+// sent back to it can be compressed.  See Publishing.AdvertiseEncodings and
+// Delivery.AcceptedEncoding.
+const AcceptEncodingHeader = "x-accept-encoding"
+
+/*
+RegisterContentDecoder makes dec decode the bodies of deliveries with the given
+content encoding in Delivery.DecodedBody.  Encodings are matched case
+insensitively.  Registering an encoding again replaces its decoder.
+
+gzip and deflate are registered by default.  Other encodings need a decoder
+from outside the standard library, for instance zstd with
+github.com/klauspost/compress/zstd:
+
+	amqp.RegisterContentDecoder("zstd", func(r io.Reader) (io.ReadCloser, error) {
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	})
+*/
+func RegisterContentDecoder(encoding string, dec ContentDecoder) {
+	contentDecodersM.Lock()
+	defer contentDecodersM.Unlock()
+
+	contentDecoders[strings.ToLower(encoding)] = dec
+}
+
+/*
+DecodedBody returns the body decompressed according to ContentEncoding, for
+consumers of producers that compress their publishings.  A body without
+ContentEncoding, or with the identity encoding, is returned as is.
+
+An error wrapping ErrUnsupportedContentEncoding is returned when no decoder is
+registered for the encoding, see RegisterContentDecoder.
+*/
+func (d Delivery) DecodedBody() ([]byte, error) {
+	encoding := strings.ToLower(strings.TrimSpace(d.ContentEncoding))
+	if encoding == "" || encoding == "identity" {
+		return d.Body, nil
+	}
+
+	contentDecodersM.RLock()
+	dec, found := contentDecoders[encoding]
+	contentDecodersM.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, d.ContentEncoding)
+	}
+
+	r, err := dec(bytes.NewReader(d.Body))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s body: %w", encoding, err)
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decode %s body: %w", encoding, err)
+	}
+
+	return body, nil
+}
+
+/*
+RegisterContentEncoder makes enc encode the bodies of publishings with the
+given content encoding in Publishing.EncodeBody.  Encodings are matched case
+insensitively.  Registering an encoding again replaces its encoder.
+
+gzip and deflate are registered by default.
+*/
+func RegisterContentEncoder(encoding string, enc ContentEncoder) {
+	contentEncodersM.Lock()
+	defer contentEncodersM.Unlock()
+
+	contentEncoders[strings.ToLower(encoding)] = enc
+}
+
+/*
+EncodeBody compresses the body with the encoding and sets ContentEncoding, so
+that consumers can restore it with Delivery.DecodedBody.  The identity or an
+empty encoding leaves the publishing as is.
+
+An error wrapping ErrUnsupportedContentEncoding is returned when no encoder is
+registered for the encoding, see RegisterContentEncoder.
+*/
+func (p *Publishing) EncodeBody(encoding string) error {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+	if p.ContentEncoding != "" {
+		return fmt.Errorf("publishing is already encoded with %q", p.ContentEncoding)
+	}
+
+	contentEncodersM.RLock()
+	enc, found := contentEncoders[encoding]
+	contentEncodersM.RUnlock()
+
+	if !found {
+		return fmt.Errorf("%w: %q", ErrUnsupportedContentEncoding, encoding)
+	}
+
+	var buf bytes.Buffer
+	w, err := enc(&buf)
+	if err != nil {
+		return fmt.Errorf("encode %s body: %w", encoding, err)
+	}
+	if _, err := w.Write(p.Body); err != nil {
+		return fmt.Errorf("encode %s body: %w", encoding, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("encode %s body: %w", encoding, err)
+	}
+
+	p.Body = buf.Bytes()
+	p.ContentEncoding = encoding
+	return nil
+}
+
+/*
+AdvertiseEncodings sets the AcceptEncodingHeader of the publishing to the
+encodings with a registered decoder, so that its consumer can compress what
+it sends back, for instance the reply to a request.  The headers of the
+publishing are copied rather than modified.
+
+Fleets migrate to compressed payloads by first advertising the encodings from
+every client, then compressing only towards the peers that advertised them.
+*/
+func (p *Publishing) AdvertiseEncodings() {
+	contentDecodersM.RLock()
+	encodings := make([]string, 0, len(contentDecoders))
+	for encoding := range contentDecoders {
+		encodings = append(encodings, encoding)
+	}
+	contentDecodersM.RUnlock()
+	sort.Strings(encodings)
+
+	headers := make(Table, len(p.Headers)+1)
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+	headers[AcceptEncodingHeader] = strings.Join(encodings, ",")
+	p.Headers = headers
+}
+
+// AcceptedEncoding returns the first encoding of the AcceptEncodingHeader of
+// the delivery with a registered encoder, or an empty string when the
+// publisher did not advertise any encoding this client can produce.
+func (d Delivery) AcceptedEncoding() string {
+	accepted, _ := d.Headers[AcceptEncodingHeader].(string)
+
+	contentEncodersM.RLock()
+	defer contentEncodersM.RUnlock()
+
+	for _, encoding := range strings.Split(accepted, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if _, found := contentEncoders[encoding]; found {
+			return encoding
+		}
+	}
+	return ""
+}
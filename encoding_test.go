@@ -0,0 +1,147 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDeliveryDecodedBody(t *testing.T) {
+	const payload = "hello, compressed world"
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte(payload))
+	_ = gw.Close()
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	_, _ = zw.Write([]byte(payload))
+	_ = zw.Close()
+
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(payload)},
+		{"identity", []byte(payload)},
+		{"gzip", gz.Bytes()},
+		{"GZIP", gz.Bytes()},
+		{"deflate", zl.Bytes()},
+	}
+
+	for _, tt := range tests {
+		got, err := Delivery{ContentEncoding: tt.encoding, Body: tt.body}.DecodedBody()
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.encoding, err)
+			continue
+		}
+		if string(got) != payload {
+			t.Errorf("%q: expected %q, got %q", tt.encoding, payload, got)
+		}
+	}
+
+	if _, err := (Delivery{ContentEncoding: "gzip", Body: []byte("plain")}).DecodedBody(); err == nil {
+		t.Error("expected an error for a corrupt gzip body")
+	}
+}
+
+func TestRegisterContentDecoder(t *testing.T) {
+	d := Delivery{ContentEncoding: "x-upper", Body: []byte("shout")}
+
+	if _, err := d.DecodedBody(); !errors.Is(err, ErrUnsupportedContentEncoding) {
+		t.Fatalf("expected ErrUnsupportedContentEncoding, got %v", err)
+	}
+
+	RegisterContentDecoder("X-Upper", func(r io.Reader) (io.ReadCloser, error) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(strings.ToUpper(string(b)))), nil
+	})
+	t.Cleanup(func() {
+		contentDecodersM.Lock()
+		delete(contentDecoders, "x-upper")
+		contentDecodersM.Unlock()
+	})
+
+	got, err := d.DecodedBody()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "SHOUT"; string(got) != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestPublishingEncodeBody(t *testing.T) {
+	payload := strings.Repeat("compressible ", 100)
+
+	for _, encoding := range []string{"gzip", "Deflate"} {
+		p := Publishing{Body: []byte(payload)}
+		if err := p.EncodeBody(encoding); err != nil {
+			t.Fatalf("%s: encode error: %v", encoding, err)
+		}
+		if len(p.Body) >= len(payload) {
+			t.Errorf("%s: expected the body to be compressed, got %d bytes", encoding, len(p.Body))
+		}
+
+		body, err := Delivery{ContentEncoding: p.ContentEncoding, Body: p.Body}.DecodedBody()
+		if err != nil || string(body) != payload {
+			t.Errorf("%s: expected the body to decode back, got %v", encoding, err)
+		}
+
+		if err := p.EncodeBody("gzip"); err == nil {
+			t.Errorf("%s: expected an error encoding an encoded body", encoding)
+		}
+	}
+
+	p := Publishing{Body: []byte(payload)}
+	if err := p.EncodeBody("br"); !errors.Is(err, ErrUnsupportedContentEncoding) {
+		t.Errorf("expected ErrUnsupportedContentEncoding, got %v", err)
+	}
+	if err := p.EncodeBody("identity"); err != nil || p.ContentEncoding != "" {
+		t.Errorf("expected the identity encoding to leave the body as is, got %v", err)
+	}
+}
+
+func TestEncodingNegotiation(t *testing.T) {
+	headers := Table{"k": "v"}
+	p := Publishing{Headers: headers}
+	p.AdvertiseEncodings()
+
+	if _, ok := headers[AcceptEncodingHeader]; ok {
+		t.Error("expected the headers of the caller to be left unchanged")
+	}
+	advertised, _ := p.Headers[AcceptEncodingHeader].(string)
+	if !strings.Contains(advertised, "gzip") || !strings.Contains(advertised, "deflate") {
+		t.Errorf("expected the default decoders to be advertised, got %q", advertised)
+	}
+
+	tests := []struct {
+		accepted interface{}
+		want     string
+	}{
+		{nil, ""},
+		{"", ""},
+		{"br, Deflate, gzip", "deflate"},
+		{"gzip", "gzip"},
+		{"br", ""},
+		{int32(1), ""},
+	}
+	for _, tt := range tests {
+		d := Delivery{Headers: Table{AcceptEncodingHeader: tt.accepted}}
+		if got := d.AcceptedEncoding(); got != tt.want {
+			t.Errorf("AcceptedEncoding(%v): expected %q, got %q", tt.accepted, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"time"
+)
+
+// Reasons for dead-lettering a message, found in Expired.Reason.
+const (
+	DeadLetterExpired       = "expired"
+	DeadLetterRejected      = "rejected"
+	DeadLetterMaxLen        = "maxlen"
+	DeadLetterDeliveryLimit = "delivery_limit"
+)
+
+// Expired is a message dead-lettered from a queue, received from an
+// ExpiryNotifier.  The embedded Delivery must be acknowledged.
+type Expired struct {
+	Delivery
+
+	Queue  string    // queue the message was dead-lettered from
+	Reason string    // why it was dead-lettered, such as DeadLetterExpired
+	Time   time.Time // when it was dead-lettered, zero if unknown
+}
+
+// newExpired reads the most recent dead-lettering of d from its x-death
+// header, or from the x-first-death headers without it.
+func newExpired(d Delivery) Expired {
+	e := Expired{Delivery: d}
+
+	if deaths := d.Deaths(); len(deaths) > 0 {
+		e.Queue, e.Reason, e.Time = deaths[0].Queue, deaths[0].Reason, deaths[0].Time
+		return e
+	}
+
+	e.Queue, _ = d.Headers["x-first-death-queue"].(string)
+	e.Reason, _ = d.Headers["x-first-death-reason"].(string)
+	return e
+}
+
+/*
+ExpiryNotifier receives the messages dead-lettered from a queue, typically
+when their TTL expires, for timeout and scheduler services built on
+per-message or per-queue TTLs:
+
+	n, err := amqp.DeclareExpiryNotifier(ch, "timeouts")
+	if err != nil {
+		return err
+	}
+	_, err = ch.QueueDeclare("timeouts", true, false, false, false,
+		n.QueueArgs(amqp.Table{amqp.QueueMessageTTLArg: 30000}))
+	if err != nil {
+		return err
+	}
+
+	expired, err := n.Consume(ctx, ch)
+	for e := range expired {
+		handleTimeout(e)
+		e.Ack(false)
+	}
+
+The queue must be declared with the arguments returned by QueueArgs, or be
+matched by a policy setting the dead-letter exchange to Exchange.  Messages
+dead-lettered for other reasons, such as being rejected without requeue, are
+received too, with their Reason.
+*/
+type ExpiryNotifier struct {
+	Exchange string // fanout exchange the queue dead-letters to
+	Queue    string // queue bound to Exchange holding the expired messages
+}
+
+// DeclareExpiryNotifier declares a durable fanout exchange and a durable queue
+// bound to it, both named after the queue with an .expired suffix, to receive
+// the messages dead-lettered from the queue.
+func DeclareExpiryNotifier(ch *Channel, queue string) (*ExpiryNotifier, error) {
+	n := &ExpiryNotifier{
+		Exchange: queue + ".expired",
+		Queue:    queue + ".expired",
+	}
+
+	if err := ch.ExchangeDeclare(n.Exchange, ExchangeFanout, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	if _, err := ch.QueueDeclare(n.Queue, true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+	if err := ch.QueueBind(n.Queue, "", n.Exchange, false, nil); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// QueueArgs returns a copy of args dead-lettering to Exchange, to declare the
+// watched queue with.
+func (n *ExpiryNotifier) QueueArgs(args Table) Table {
+	dlx := make(Table, len(args)+1)
+	for k, v := range args {
+		dlx[k] = v
+	}
+	dlx["x-dead-letter-exchange"] = n.Exchange
+	return dlx
+}
+
+// Consume consumes Queue on ch and returns the dead-lettered messages.  The
+// chan is closed when ctx is done or the channel is closed.
+func (n *ExpiryNotifier) Consume(ctx context.Context, ch *Channel) (<-chan Expired, error) {
+	deliveries, err := ch.ConsumeWithContext(ctx, n.Queue, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make(chan Expired)
+	go func() {
+		defer close(expired)
+		for d := range deliveries {
+			expired <- newExpired(d)
+		}
+	}()
+
+	return expired, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExpiryNotifier(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	died := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &exchangeDeclare{})
+		srv.send(1, &exchangeDeclareOk{})
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &queueDeclareOk{Queue: "timeouts.expired"})
+		srv.recv(1, &queueBind{})
+		srv.send(1, &queueBindOk{})
+
+		consume := srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 1,
+			Properties: properties{Headers: Table{
+				"x-death": []interface{}{
+					Table{"queue": "timeouts", "reason": "expired", "time": died, "count": int64(1)},
+				},
+			}},
+			Body: []byte("job-1"),
+		})
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 2,
+			Properties: properties{Headers: Table{
+				"x-first-death-queue":  "timeouts",
+				"x-first-death-reason": "rejected",
+			}},
+		})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	n, err := DeclareExpiryNotifier(ch, "timeouts")
+	if err != nil {
+		t.Fatalf("declare error: %v", err)
+	}
+
+	args := Table{QueueMessageTTLArg: 30000}
+	if dlx := n.QueueArgs(args); dlx["x-dead-letter-exchange"] != "timeouts.expired" || dlx[QueueMessageTTLArg] != 30000 {
+		t.Errorf("expected the queue arguments to dead-letter to the notifier, got %v", dlx)
+	}
+	if _, ok := args["x-dead-letter-exchange"]; ok {
+		t.Error("expected the arguments of the caller to be left unchanged")
+	}
+
+	expired, err := n.Consume(context.Background(), ch)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	e := <-expired
+	if e.Queue != "timeouts" || e.Reason != DeadLetterExpired || !e.Time.Equal(died) || string(e.Body) != "job-1" {
+		t.Errorf("unexpected expiry %+v", e)
+	}
+
+	e = <-expired
+	if e.Queue != "timeouts" || e.Reason != DeadLetterRejected || !e.Time.IsZero() {
+		t.Errorf("expected the first death headers to be used without x-death, got %+v", e)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+
+	if _, ok := <-expired; ok {
+		t.Error("expected the expiry chan to be closed with the channel")
+	}
+}
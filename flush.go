@@ -0,0 +1,102 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "time"
+
+/*
+Flush writes the frames of the publishings buffered by Config.FlushDelay
+without waiting for the delay, for publishers that favor latency for some of
+their publishings:
+
+	err := ch.PublishWithContext(ctx, "", "rpc", false, false, request)
+	...
+	err = conn.Flush()
+
+It returns nil without writing anything when nothing is buffered.
+*/
+func (c *Connection) Flush() error {
+	if c.IsClosed() {
+		return ErrClosed
+	}
+
+	c.sendM.Lock()
+	c.setWriteDeadline()
+	err := c.flushWriter()
+	c.sendM.Unlock()
+
+	if err != nil {
+		// shutdown could be re-entrant from signaling notify chans
+		go c.shutdown(&Error{
+			Code:   FrameError,
+			Reason: err.Error(),
+		})
+	}
+	return err
+}
+
+// flushWriter writes the buffered frames.  Must be called while holding sendM.
+func (c *Connection) flushWriter() error {
+	c.unflushed = 0
+	return c.writer.Flush()
+}
+
+// deferFlush records n frames buffered by a publishing and flushes them once
+// Config.FlushFrames are buffered, or has the flusher flush them.  Must be
+// called while holding sendM.
+func (c *Connection) deferFlush(n int) error {
+	c.unflushed += n
+
+	if c.Config.FlushFrames > 0 && c.unflushed >= c.Config.FlushFrames {
+		return c.flushWriter()
+	}
+
+	if c.unflushed == n {
+		// The first frames since the last flush start the delay.
+		select {
+		case c.flushes <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// flusher flushes the frames buffered by publishings delay after the first of
+// them, until the connection is closed.
+func (c *Connection) flusher(delay time.Duration) {
+	clock := c.clock()
+
+	for {
+		select {
+		case <-c.flushes:
+		case <-c.close:
+			return
+		}
+
+		timer := clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-c.close:
+			timer.Stop()
+			return
+		}
+
+		c.sendM.Lock()
+		var err error
+		if c.unflushed > 0 {
+			c.setWriteDeadline()
+			err = c.flushWriter()
+		}
+		c.sendM.Unlock()
+
+		if err != nil {
+			c.shutdown(&Error{
+				Code:   FrameError,
+				Reason: err.Error(),
+			})
+			return
+		}
+	}
+}
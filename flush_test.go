@@ -0,0 +1,137 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"context"
+	"testing"
+	"time"
+)
+
+// buffered returns the number of bytes waiting in the write buffer of c.
+func buffered(c *Connection) int {
+	c.sendM.Lock()
+	defer c.sendM.Unlock()
+	return c.writer.w.(*bufio.Writer).Buffered()
+}
+
+// openCoalescing opens a connection with config whose server receives n
+// publishings on channel 1.
+func openCoalescing(t *testing.T, config Config, n int) (*Connection, *Channel, <-chan string) {
+	t.Helper()
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan string, n)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for i := 0; i < n; i++ {
+			published <- string(srv.recv(1, &basicPublish{}).(*basicPublish).Body)
+		}
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	return c, ch, published
+}
+
+func TestFlushWritesBufferedPublishings(t *testing.T) {
+	config := defaultConfig()
+	config.FlushDelay = time.Hour
+
+	c, ch, published := openCoalescing(t, config, 1)
+
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("one")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if buffered(c) == 0 {
+		t.Fatalf("expected the publishing to be buffered")
+	}
+
+	if err := c.Flush(); err != nil {
+		t.Fatalf("could not flush: %v", err)
+	}
+	if want, got := "one", <-published; want != got {
+		t.Errorf("expected %q to be published, got %q", want, got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestFlushFramesFlushesOnceReached(t *testing.T) {
+	config := defaultConfig()
+	config.FlushDelay = time.Hour
+	config.FlushFrames = 6 // two publishings of a method, header and body frame
+
+	c, ch, published := openCoalescing(t, config, 2)
+
+	ctx := context.Background()
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{Body: []byte("one")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if buffered(c) == 0 {
+		t.Fatalf("expected the first publishing to be buffered")
+	}
+
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{Body: []byte("two")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if n := buffered(c); n != 0 {
+		t.Fatalf("expected the publishings to be flushed, %d bytes are buffered", n)
+	}
+	if first, second := <-published, <-published; first != "one" || second != "two" {
+		t.Errorf("expected the publishings in order, got %q and %q", first, second)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestFlushDelayFlushesAfterTheDelay(t *testing.T) {
+	clock := newFakeClock()
+	config := defaultConfig()
+	config.Clock = clock
+	config.FlushDelay = 100 * time.Microsecond
+
+	c, ch, published := openCoalescing(t, config, 1)
+
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("one")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for buffered(c) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the publishing to be flushed after the delay")
+		}
+		clock.Advance(100 * time.Microsecond)
+		time.Sleep(time.Millisecond)
+	}
+	if want, got := "one", <-published; want != got {
+		t.Errorf("expected %q to be published, got %q", want, got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// redacted replaces the credentials of the frames dumped.
+const redacted = "[redacted]"
+
+// dumpFrame logs f at LevelDebug when it is a method frame and
+// Config.DumpFrames is set.  direction is "sent" or "received".
+func (c *Connection) dumpFrame(direction string, f frame) {
+	if !c.Config.DumpFrames {
+		return
+	}
+	mf, ok := f.(*methodFrame)
+	if !ok {
+		return
+	}
+
+	c.log(LevelDebug, direction+" frame",
+		"channel", mf.ChannelId,
+		"method", methodName(mf.Method),
+		"frame", fmt.Sprintf("%+v", redactMethod(mf.Method)),
+	)
+}
+
+// methodName returns the AMQP name of m, such as "basic.get-ok" for
+// basicGetOk.
+func methodName(m message) string {
+	var name strings.Builder
+	sep := '.'
+	for i, r := range reflect.TypeOf(m).Elem().Name() {
+		if i > 0 && unicode.IsUpper(r) {
+			name.WriteRune(sep)
+			sep = '-'
+		}
+		name.WriteRune(unicode.ToLower(r))
+	}
+	return name.String()
+}
+
+// redactMethod returns m, or a copy of it without the SASL responses and
+// secrets it carries.
+func redactMethod(m message) message {
+	switch m := m.(type) {
+	case *connectionStartOk:
+		c := *m
+		c.Response = redacted
+		return &c
+	case *connectionSecureOk:
+		c := *m
+		c.Response = redacted
+		return &c
+	case *connectionUpdateSecret:
+		c := *m
+		c.NewSecret = redacted
+		return &c
+	}
+	return m
+}
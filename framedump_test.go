@@ -0,0 +1,126 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type syncRecordingLogger struct {
+	m sync.Mutex
+	recordingLogger
+}
+
+func (l *syncRecordingLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.recordingLogger.Log(level, msg, keysAndValues...)
+}
+
+// dumped returns the direction, method and text of the frames dumped.
+func (l *syncRecordingLogger) dumped() []string {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	var frames []string
+	for _, e := range l.entries {
+		if e.level != LevelDebug || !strings.HasSuffix(e.msg, " frame") {
+			continue
+		}
+		kv := e.keysAndValues
+		frames = append(frames, fmt.Sprintf("%s %v %v", e.msg, kv[len(kv)-3], kv[len(kv)-1]))
+	}
+	return frames
+}
+
+func TestDumpFramesRedactsCredentials(t *testing.T) {
+	logger := &syncRecordingLogger{}
+	withLoggers(t, NullLogger{}, logger)
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.connectionClose()
+	}()
+
+	config := defaultConfigWithAuth(&PlainAuth{Username: "user", Password: "s3cr3t"})
+	config.DumpFrames = true
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("body")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+
+	frames := logger.dumped()
+	for _, want := range []string{
+		"received frame connection.start ",
+		"sent frame connection.start-ok ",
+		"received frame connection.tune ",
+		"sent frame channel.open ",
+		"sent frame basic.publish ",
+		"sent frame connection.close ",
+	} {
+		found := false
+		for _, f := range frames {
+			found = found || strings.HasPrefix(f, want)
+		}
+		if !found {
+			t.Errorf("expected a frame dumped as %q, got %q", want, frames)
+		}
+	}
+
+	for _, f := range frames {
+		if strings.Contains(f, "s3cr3t") {
+			t.Errorf("expected the password to be redacted, got %q", f)
+		}
+		if strings.HasPrefix(f, "sent frame connection.start-ok ") && !strings.Contains(f, redacted) {
+			t.Errorf("expected the SASL response to be redacted, got %q", f)
+		}
+	}
+}
+
+func TestDumpFramesOffByDefault(t *testing.T) {
+	logger := &syncRecordingLogger{}
+	withLoggers(t, NullLogger{}, logger)
+
+	c := &Connection{}
+	c.dumpFrame("sent", &methodFrame{ChannelId: 1, Method: &channelOpen{}})
+
+	if frames := logger.dumped(); len(frames) != 0 {
+		t.Errorf("expected no frame dumped, got %q", frames)
+	}
+}
+
+func TestMethodName(t *testing.T) {
+	for m, want := range map[message]string{
+		&basicPublish{}:           "basic.publish",
+		&basicGetEmpty{}:          "basic.get-empty",
+		&connectionUpdateSecret{}: "connection.update-secret",
+		&txCommitOk{}:             "tx.commit-ok",
+		&confirmSelect{}:          "confirm.select",
+	} {
+		if got := methodName(m); want != got {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
@@ -1560,10 +1560,11 @@ func TestDeclareArgsRejectToDeadLetterQueue(t *testing.T) {
 				t.Fatalf("expected success in parsing reject, got: %v", err)
 			} else {
 				// pass if we've parsed an array
-				if v, ok := d.Headers["x-death"]; ok {
-					if _, ok := v.([]interface{}); ok {
-						return
+				if deaths := d.Deaths(); len(deaths) > 0 {
+					if deaths[0].Reason != "rejected" || deaths[0].Queue != q || deaths[0].Count != 1 {
+						t.Fatalf("expected one rejection from %v, got: %+v", q, deaths[0])
 					}
+					return
 				}
 				t.Fatalf("array field x-death expected in the headers, got: %v (%T)", d.Headers, d.Headers["x-death"])
 			}
@@ -1918,7 +1919,7 @@ func TestRabbitMQQueueNackMultipleRequeue(t *testing.T) {
 	if c := integrationRabbitMQ(t, "nack"); c != nil {
 		defer c.Close()
 
-		if c.isCapable("basic.nack") {
+		if c.Capabilities.BasicNack {
 			queue := "test.rabbitmq-basic-nack"
 			channel, err := c.Channel()
 			if err != nil {
@@ -2097,9 +2098,13 @@ func TestIntegrationGetNextPublishSeqNoRace(t *testing.T) {
 
 		go func() {
 			defer wg.Done()
-			if err := ch.PublishWithContext(context.TODO(), "test-get-next-pub-seq", "", false, false, Publishing{}); err != nil {
+			n, err := ch.PublishWithSeqNo(context.TODO(), "test-get-next-pub-seq", "", false, false, Publishing{})
+			if err != nil {
 				t.Logf("publish error: %v", err)
 				fail = true
+			} else if n != 1 {
+				t.Logf("wrong publish sequence number, expected: %d, got: %d", 1, n)
+				fail = true
 			}
 		}()
 
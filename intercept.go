@@ -0,0 +1,90 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Frame types carried in Frame.Type.
+const (
+	FrameMethod    = frameMethod
+	FrameHeader    = frameHeader
+	FrameBody      = frameBody
+	FrameHeartbeat = frameHeartbeat
+)
+
+// Frame is the wire representation of an AMQP frame passed to a
+// FrameInterceptor.  Payload excludes the 7 octet frame header and the
+// frame-end octet.
+type Frame struct {
+	Type    uint8
+	Channel uint16
+	Payload []byte
+}
+
+/*
+FrameInterceptor observes a frame read from or written to the server.  It
+returns the frame to pass on, which may be modified, and false to drop the
+frame.
+
+Interceptors are installed with Config.ReadFrameInterceptors and
+Config.WriteFrameInterceptors and run in order, each receiving the frame
+returned by the previous one.  Read interceptors are called from the
+connection reader goroutine, write interceptors while holding the connection
+write lock, so they should return quickly and must not call back into the
+Connection.
+
+Frames are re-encoded for every interceptor chain, so interceptors are meant
+for sniffing, tests and tooling rather than hot paths.  Dropping or mutating
+frames can easily violate the protocol and cause the server to close the
+connection.
+*/
+type FrameInterceptor func(f Frame) (Frame, bool)
+
+// interceptFrame runs f through the interceptors and returns the resulting
+// frame, or false when an interceptor dropped it.
+func interceptFrame(interceptors []FrameInterceptor, f frame) (frame, bool, error) {
+	if len(interceptors) == 0 {
+		return f, true, nil
+	}
+
+	// The protocol header is not a frame on the wire.
+	if _, ok := f.(*protocolHeader); ok {
+		return f, true, nil
+	}
+
+	var buf bytes.Buffer
+	if err := f.write(&buf); err != nil {
+		return nil, false, err
+	}
+
+	raw := buf.Bytes()
+	wire := Frame{
+		Type:    raw[0],
+		Channel: binary.BigEndian.Uint16(raw[1:3]),
+		Payload: raw[7 : len(raw)-1],
+	}
+
+	for _, intercept := range interceptors {
+		var keep bool
+		if wire, keep = intercept(wire); !keep {
+			return nil, false, nil
+		}
+	}
+
+	var out bytes.Buffer
+	if err := writeFrame(&out, wire.Type, wire.Channel, wire.Payload); err != nil {
+		return nil, false, err
+	}
+
+	intercepted, err := (&reader{&out}).ReadFrame()
+	if err != nil {
+		return nil, false, err
+	}
+
+	return intercepted, true, nil
+}
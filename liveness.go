@@ -0,0 +1,146 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+ConsumerLiveness is a periodic report on the progress of one consumer, see
+Config.OnConsumerLiveness.
+
+A consumer with in-flight deliveries whose LastAck stops advancing, while the
+process itself is healthy, usually has a deadlocked or stuck handler.
+*/
+type ConsumerLiveness struct {
+	Channel      *Channel
+	ConsumerTag  string
+	Since        time.Time // when the consumer was registered
+	LastDelivery time.Time // zero until the first delivery
+	LastAck      time.Time // last ack, nack or reject of a delivery, zero until the first
+	InFlight     int       // deliveries not acknowledged yet, always 0 with autoAck
+}
+
+// consumerLiveness is the progress of a consumer, protected by the consumers
+// mutex.
+type consumerLiveness struct {
+	since        time.Time
+	lastDelivery time.Time
+	lastAck      time.Time
+	inFlight     int
+}
+
+// trackLiveness starts recording the progress of consumers, it must be called
+// before the first consumer is added.
+func (subs *consumers) trackLiveness() {
+	subs.Lock()
+	defer subs.Unlock()
+
+	subs.liveness = make(map[string]*consumerLiveness)
+}
+
+// added and removed are called with the consumers mutex held.
+func (subs *consumers) added(tag string) {
+	if subs.liveness != nil {
+		subs.liveness[tag] = &consumerLiveness{since: subs.now()}
+	}
+}
+
+// The deliveries of a removed consumer stay unacknowledged until the
+// application settles them, see Channel.CancelAndDrain.
+func (subs *consumers) removed(tag string) {
+	if subs.liveness != nil {
+		delete(subs.liveness, tag)
+	}
+}
+
+// delivered is called with the consumers mutex held.
+func (subs *consumers) delivered(msg *Delivery) {
+	noAck := subs.opts[msg.ConsumerTag].noAck
+	if !noAck {
+		var size int64
+		if subs.budgeted != nil && subs.budgeted() {
+			size = int64(len(msg.Body))
+			subs.charge(size)
+		}
+		subs.unacked.push(msg.DeliveryTag, msg.ConsumerTag, size)
+	}
+
+	if l, found := subs.liveness[msg.ConsumerTag]; found {
+		l.lastDelivery = subs.now()
+		if !noAck {
+			l.inFlight++
+		}
+	}
+}
+
+// acked records the acknowledgement of the delivery tag, or of every delivery
+// up to it when multiple is true.
+func (subs *consumers) acked(tag uint64, multiple bool) {
+	subs.Lock()
+	defer subs.Unlock()
+
+	subs.stopDeadlines(tag, multiple)
+	defer subs.notifyDrained()
+
+	var released int64
+	defer func() { subs.release(released) }()
+
+	var now time.Time
+	if subs.liveness != nil {
+		now = subs.now()
+	}
+	subs.unacked.settle(tag, multiple, func(d unackedDelivery) {
+		released += d.size
+		if l, found := subs.liveness[d.consumer]; found {
+			l.inFlight--
+			l.lastAck = now
+		}
+	})
+}
+
+// livenessReport returns the progress of every consumer ordered by tag.
+func (subs *consumers) livenessReport(ch *Channel) []ConsumerLiveness {
+	subs.Lock()
+	defer subs.Unlock()
+
+	report := make([]ConsumerLiveness, 0, len(subs.liveness))
+	for tag, l := range subs.liveness {
+		report = append(report, ConsumerLiveness{
+			Channel:      ch,
+			ConsumerTag:  tag,
+			Since:        l.since,
+			LastDelivery: l.lastDelivery,
+			LastAck:      l.lastAck,
+			InFlight:     l.inFlight,
+		})
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].ConsumerTag < report[j].ConsumerTag
+	})
+
+	return report
+}
+
+// reportLiveness calls fn with the progress of every consumer of the channel
+// each interval of the connection clock until the channel closes.
+func (ch *Channel) reportLiveness(interval time.Duration, fn func(ConsumerLiveness)) {
+	ticker := ch.connection.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ch.close:
+			return
+		case <-ticker.C():
+			for _, l := range ch.consumers.livenessReport(ch) {
+				fn(l)
+			}
+		}
+	}
+}
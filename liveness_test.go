@@ -0,0 +1,164 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConsumerLivenessReportsProgress(t *testing.T) {
+	const tag = "live"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2})
+		srv.recv(1, &basicAck{})
+
+		srv.connectionClose()
+	}()
+
+	reports := make(chan ConsumerLiveness, 16)
+
+	config := defaultConfig()
+	config.ConsumerLivenessInterval = 5 * time.Millisecond
+	config.OnConsumerLiveness = func(l ConsumerLiveness) {
+		select {
+		case reports <- l:
+		default:
+		}
+	}
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	deliveries, err := ch.Consume("q", tag, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	first := <-deliveries
+	<-deliveries
+
+	if err := first.Ack(false); err != nil {
+		t.Fatalf("ack error: %v", err)
+	}
+
+	timeout := time.After(time.Second)
+	for {
+		var l ConsumerLiveness
+		select {
+		case l = <-reports:
+		case <-timeout:
+			t.Fatal("timed out waiting for a liveness report with one in-flight delivery")
+		}
+
+		if l.ConsumerTag != tag || l.Channel != ch {
+			t.Fatalf("unexpected report for consumer %q", l.ConsumerTag)
+		}
+		if l.InFlight == 1 && !l.LastAck.IsZero() {
+			if l.LastDelivery.IsZero() || l.LastAck.Before(l.LastDelivery) {
+				t.Errorf("expected the ack to follow the last delivery, got %+v", l)
+			}
+			break
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestConsumersAckedMultiple(t *testing.T) {
+	subs := makeConsumers()
+	subs.trackLiveness()
+
+	subs.add("a", make(chan Delivery), consumeOptions{})
+	subs.add("b", make(chan Delivery), consumeOptions{noAck: true})
+	defer subs.close()
+
+	subs.Lock()
+	for tag := uint64(1); tag <= 3; tag++ {
+		subs.delivered(&Delivery{ConsumerTag: "a", DeliveryTag: tag})
+	}
+	subs.delivered(&Delivery{ConsumerTag: "b", DeliveryTag: 4})
+	subs.Unlock()
+
+	subs.acked(2, true)
+
+	report := subs.livenessReport(nil)
+	if want, got := 1, report[0].InFlight; want != got {
+		t.Errorf("expected %d delivery in flight for a, got %d", want, got)
+	}
+	if want, got := 0, report[1].InFlight; want != got {
+		t.Errorf("expected %d delivery in flight for autoAck b, got %d", want, got)
+	}
+
+	subs.acked(0, true)
+
+	if want, got := 0, subs.livenessReport(nil)[0].InFlight; want != got {
+		t.Errorf("expected a multiple ack of 0 to settle everything, got %d in flight", got)
+	}
+}
+
+func TestConsumerLivenessUsesClock(t *testing.T) {
+	clock := newFakeClock()
+
+	subs := makeConsumers()
+	subs.clock = clock
+	subs.trackLiveness()
+
+	subs.add("a", make(chan Delivery), consumeOptions{})
+	defer subs.close()
+	since := clock.Now()
+
+	clock.Advance(time.Minute)
+	subs.Lock()
+	subs.delivered(&Delivery{ConsumerTag: "a", DeliveryTag: 1})
+	subs.Unlock()
+	delivered := clock.Now()
+
+	clock.Advance(time.Minute)
+	subs.acked(1, false)
+
+	l := subs.livenessReport(nil)[0]
+	if !l.Since.Equal(since) || !l.LastDelivery.Equal(delivered) || !l.LastAck.Equal(clock.Now()) {
+		t.Errorf("expected the progress to follow the clock, got %+v", l)
+	}
+}
+
+func TestConsumerLivenessNotTrackedWithoutReports(t *testing.T) {
+	subs := makeConsumers()
+	subs.add("a", make(chan Delivery), consumeOptions{})
+	defer subs.close()
+
+	subs.Lock()
+	subs.delivered(&Delivery{ConsumerTag: "a", DeliveryTag: 1})
+	subs.Unlock()
+	subs.acked(1, false)
+
+	if subs.liveness != nil {
+		t.Errorf("expected no liveness bookkeeping without OnConsumerLiveness")
+	}
+	if report := subs.livenessReport(nil); len(report) != 0 {
+		t.Errorf("expected no liveness report, got %+v", report)
+	}
+}
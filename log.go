@@ -4,6 +4,11 @@
 
 package amqp091
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Logging interface {
 	Printf(format string, v ...interface{})
 }
@@ -20,3 +25,92 @@ type NullLogger struct{}
 
 func (l NullLogger) Printf(format string, v ...interface{}) {
 }
+
+// Level is the severity of a message logged by the library.  Its values are
+// those of the levels of log/slog.
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+/*
+StructuredLogging receives the messages of the library with their level and
+their context, as alternating keys and values:
+
+	connection: the connection name, from the connection_name client property
+	channel:    the channel id
+	method:     the AMQP method involved, such as "channel.close-ok"
+	error:      the error being reported
+
+NewSlogLogger adapts a log/slog Logger.
+*/
+type StructuredLogging interface {
+	Log(level Level, msg string, keysAndValues ...interface{})
+}
+
+var structuredLogger StructuredLogging
+
+// SetStructuredLogger enables logging using a StructuredLogging instance, in
+// place of the Logging instance of SetLogger.  Like SetLogger, it is not
+// thread safe and should be called at application start.
+func SetStructuredLogger(logger StructuredLogging) {
+	structuredLogger = logger
+}
+
+// logAt logs msg to the StructuredLogging instance, or else formats it with
+// its context for the Logging instance.
+func logAt(level Level, msg string, keysAndValues ...interface{}) {
+	if structuredLogger != nil {
+		structuredLogger.Log(level, msg, keysAndValues...)
+		return
+	}
+	if _, null := Logger.(NullLogger); null {
+		return
+	}
+
+	var line strings.Builder
+	if level == LevelDebug {
+		line.WriteString("[debug] ")
+	}
+	line.WriteString(msg)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&line, " %v=%+v", keysAndValues[i], keysAndValues[i+1])
+	}
+	Logger.Printf("%s", line.String())
+}
+
+// log logs msg with the name of the connection, when it has one.
+func (c *Connection) log(level Level, msg string, keysAndValues ...interface{}) {
+	if c.name != "" {
+		keysAndValues = append([]interface{}{"connection", c.name}, keysAndValues...)
+	}
+	logAt(level, msg, keysAndValues...)
+}
+
+// log logs msg with the channel id and the name of its connection.
+func (ch *Channel) log(level Level, msg string, keysAndValues ...interface{}) {
+	keysAndValues = append([]interface{}{"channel", ch.id}, keysAndValues...)
+	if ch.connection == nil {
+		logAt(level, msg, keysAndValues...)
+		return
+	}
+	ch.connection.log(level, msg, keysAndValues...)
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package amqp091
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger returns a StructuredLogging instance logging to logger, or to
+// slog.Default() when logger is nil.
+func NewSlogLogger(logger *slog.Logger) StructuredLogging {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return slogLogger{logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l slogLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.Level(level), msg, keysAndValues...)
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+
+package amqp091
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSlogLoggerKeepsLevelsAndContext(t *testing.T) {
+	var out bytes.Buffer
+	handler := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	withLoggers(t, NullLogger{}, NewSlogLogger(slog.New(handler)))
+
+	c := &Connection{name: "orders"}
+	c.log(LevelDebug, "dropping frame, channel does not exist", "channel", uint16(7))
+	c.log(LevelWarn, "could not open warm channel", "method", "channel.open")
+
+	want := `level=WARN msg="could not open warm channel" connection=orders method=channel.open`
+	if got := strings.TrimSpace(out.String()); want != got {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
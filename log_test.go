@@ -0,0 +1,130 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type logEntry struct {
+	level         Level
+	msg           string
+	keysAndValues []interface{}
+}
+
+type recordingLogger struct {
+	entries []logEntry
+}
+
+func (l *recordingLogger) Log(level Level, msg string, keysAndValues ...interface{}) {
+	l.entries = append(l.entries, logEntry{level, msg, keysAndValues})
+}
+
+type printfLogger struct {
+	lines []string
+}
+
+func (l *printfLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+// withLoggers installs the loggers for the test.
+func withLoggers(t *testing.T, logger Logging, structured StructuredLogging) {
+	previous, previousStructured := Logger, structuredLogger
+	t.Cleanup(func() {
+		SetLogger(previous)
+		SetStructuredLogger(previousStructured)
+	})
+	SetLogger(logger)
+	SetStructuredLogger(structured)
+}
+
+func TestStructuredLoggerReceivesChannelContext(t *testing.T) {
+	logger := &recordingLogger{}
+	withLoggers(t, NullLogger{}, logger)
+
+	c := &Connection{name: "orders"}
+	ch := &Channel{id: 3, connection: c}
+
+	err := errors.New("broken pipe")
+	ch.log(LevelWarn, "error sending channelFlowOk", "method", "channel.flow-ok", "error", err)
+
+	want := []logEntry{{
+		level: LevelWarn,
+		msg:   "error sending channelFlowOk",
+		keysAndValues: []interface{}{
+			"connection", "orders", "channel", uint16(3), "method", "channel.flow-ok", "error", err,
+		},
+	}}
+	if !reflect.DeepEqual(want, logger.entries) {
+		t.Errorf("expected %+v, got %+v", want, logger.entries)
+	}
+}
+
+func TestStructuredLogFallsBackToPrintf(t *testing.T) {
+	logger := &printfLogger{}
+	withLoggers(t, logger, nil)
+
+	c := &Connection{}
+	c.log(LevelDebug, "dropping frame, channel does not exist", "channel", uint16(7))
+	c.log(LevelWarn, "error setting write deadline", "error", errors.New("closed"))
+
+	want := []string{
+		"[debug] dropping frame, channel does not exist channel=7",
+		"error setting write deadline error=closed",
+	}
+	if !reflect.DeepEqual(want, logger.lines) {
+		t.Errorf("expected %q, got %q", want, logger.lines)
+	}
+}
+
+func TestLevelString(t *testing.T) {
+	for level, want := range map[Level]string{
+		LevelDebug: "debug",
+		LevelInfo:  "info",
+		LevelWarn:  "warn",
+		LevelError: "error",
+		Level(2):   "level(2)",
+	} {
+		if got := level.String(); want != got {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestStructuredLoggerReceivesConnectionName(t *testing.T) {
+	logger := &recordingLogger{}
+	withLoggers(t, NullLogger{}, logger)
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.connectionClose()
+	}()
+
+	config := defaultConfig()
+	config.Properties = NewConnectionProperties()
+	config.Properties.SetClientConnectionName("orders")
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+	c.log(LevelInfo, "opened")
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+
+	want := logEntry{level: LevelInfo, msg: "opened", keysAndValues: []interface{}{"connection", "orders"}}
+	if len(logger.entries) == 0 || !reflect.DeepEqual(want, logger.entries[0]) {
+		t.Errorf("expected %+v, got %+v", want, logger.entries)
+	}
+}
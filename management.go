@@ -0,0 +1,251 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Binding is a binding as reported by the RabbitMQ management API.
+type Binding struct {
+	Source          string `json:"source"`
+	Destination     string `json:"destination"`
+	DestinationType string `json:"destination_type"` // "queue" or "exchange"
+	RoutingKey      string `json:"routing_key"`
+	Arguments       Table  `json:"arguments"`
+}
+
+/*
+ManagementClient reads topology from the RabbitMQ management HTTP API, which
+AMQP 0-9-1 itself has no method to enumerate.  It requires the
+rabbitmq_management plugin and a user with at least the monitoring tag.
+
+Use it for startup assertions on topology declared by other applications:
+
+	bindings, err := mgmt.QueueBindings(ctx, "/", "orders")
+	if err != nil {
+		return err
+	}
+	if !HasBinding(bindings, "events", "orders", "order.*") {
+		return errors.New("orders must be bound to events with order.*")
+	}
+*/
+type ManagementClient struct {
+	// Endpoint is the base URL of the management API, for example
+	// http://localhost:15672.
+	Endpoint string
+	Username string
+	Password string
+
+	// HTTPClient is used for requests when set, otherwise
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// QueueBindings returns the bindings with the queue as destination, including
+// the implicit binding to the default exchange.
+func (m *ManagementClient) QueueBindings(ctx context.Context, vhost, queue string) ([]Binding, error) {
+	return m.bindings(ctx, "queues", vhost, queue, "bindings")
+}
+
+// ExchangeBindings returns the bindings with the exchange as source.
+func (m *ManagementClient) ExchangeBindings(ctx context.Context, vhost, exchange string) ([]Binding, error) {
+	return m.bindings(ctx, "exchanges", vhost, exchange, "bindings/source")
+}
+
+func (m *ManagementClient) bindings(ctx context.Context, kind, vhost, name, suffix string) ([]Binding, error) {
+	var bindings []Binding
+	if err := m.get(ctx, kind, vhost, name, suffix, &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// get decodes the JSON response of the management API for the named resource
+// into v.
+func (m *ManagementClient) get(ctx context.Context, kind, vhost, name, suffix string, v interface{}) error {
+	endpoint := strings.TrimSuffix(m.Endpoint, "/") + "/api/" + kind + "/" +
+		url.PathEscape(vhost) + "/" + url.PathEscape(name)
+	if suffix != "" {
+		endpoint += "/" + suffix
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(m.Username, m.Password)
+	req.Header.Set("Accept", "application/json")
+
+	client := m.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("management API %s %s: %s", kind, name, res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode management API %s: %w", kind, err)
+	}
+
+	return nil
+}
+
+// HasBinding returns true when bindings contain a binding from the source
+// exchange to the destination with the routing key.
+func HasBinding(bindings []Binding, source, destination, routingKey string) bool {
+	for _, b := range bindings {
+		if b.Source == source && b.Destination == destination && b.RoutingKey == routingKey {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyConflict is a queue argument whose declared value differs from the
+// value set by the policies matching the queue.
+type PolicyConflict struct {
+	Argument  string      // queue argument, such as x-message-ttl
+	Policy    string      // policy key, such as message-ttl
+	Declared  interface{} // value declared with the queue
+	Defined   interface{} // value of the effective policy definition
+	Effective interface{} // value the broker applies
+}
+
+func (c PolicyConflict) String() string {
+	return fmt.Sprintf("queue argument %s=%v conflicts with policy %s=%v, effective value is %v",
+		c.Argument, c.Declared, c.Policy, c.Defined, c.Effective)
+}
+
+// policyArguments maps the policy keys to the queue arguments they override.
+// For limits the broker applies the lower of both values, otherwise the
+// declared argument takes precedence over the policy.
+var policyArguments = []struct {
+	policy   string
+	argument string
+	lowest   bool
+}{
+	{"dead-letter-exchange", "x-dead-letter-exchange", false},
+	{"dead-letter-routing-key", "x-dead-letter-routing-key", false},
+	{"delivery-limit", "x-delivery-limit", true},
+	{"expires", QueueTTLArg, true},
+	{"max-age", StreamMaxAgeArg, false},
+	{"max-length", QueueMaxLenArg, true},
+	{"max-length-bytes", QueueMaxLenBytesArg, true},
+	{"message-ttl", QueueMessageTTLArg, true},
+	{"overflow", QueueOverflowArg, false},
+	{"queue-mode", "x-queue-mode", false},
+	{"queue-version", QueueVersionArg, false},
+}
+
+/*
+QueuePolicyConflicts compares the arguments a queue is declared with to the
+effective definition of the policy and operator policy matching the queue on
+the broker.  It returns a PolicyConflict for every argument set both ways with
+different values, including the value that actually applies, and logs each of
+them as a warning.
+
+A nil result means the declared arguments apply as written.  Call it after
+declaring a queue to learn that, for example, an operator policy caps the
+message TTL below the declared one:
+
+	conflicts, err := mgmt.QueuePolicyConflicts(ctx, "/", "orders", args)
+*/
+func (m *ManagementClient) QueuePolicyConflicts(ctx context.Context, vhost, queue string, declared Table) ([]PolicyConflict, error) {
+	var info struct {
+		Policy         string `json:"policy"`
+		OperatorPolicy string `json:"operator_policy"`
+		Definition     Table  `json:"effective_policy_definition"`
+	}
+	if err := m.get(ctx, "queues", vhost, queue, "", &info); err != nil {
+		return nil, err
+	}
+
+	var conflicts []PolicyConflict
+	for _, p := range policyArguments {
+		arg, ok := declared[p.argument]
+		if !ok {
+			continue
+		}
+		def, ok := info.Definition[p.policy]
+		if !ok || policyValueEqual(arg, def) {
+			continue
+		}
+
+		effective := arg
+		if p.lowest {
+			a, aok := policyNumber(arg)
+			d, dok := policyNumber(def)
+			if aok && dok && d < a {
+				effective = def
+			}
+		}
+
+		conflict := PolicyConflict{
+			Argument:  p.argument,
+			Policy:    p.policy,
+			Declared:  arg,
+			Defined:   def,
+			Effective: effective,
+		}
+		logAt(LevelWarn, "queue argument conflicts with a policy", "queue", queue, "vhost", vhost,
+			"conflict", conflict, "policy", info.Policy, "operator_policy", info.OperatorPolicy)
+		conflicts = append(conflicts, conflict)
+	}
+
+	return conflicts, nil
+}
+
+// policyValueEqual compares a declared argument to a value decoded from JSON,
+// where all numbers are float64.
+func policyValueEqual(declared, defined interface{}) bool {
+	a, aok := policyNumber(declared)
+	d, dok := policyNumber(defined)
+	if aok || dok {
+		return aok && dok && a == d
+	}
+	return fmt.Sprint(declared) == fmt.Sprint(defined)
+}
+
+func policyNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
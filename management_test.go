@@ -0,0 +1,114 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestManagementClientQueueBindings(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/queues/%2F/orders/bindings", r.URL.EscapedPath(); want != got {
+			t.Errorf("expected request to %q, got %q", want, got)
+		}
+		if user, pass, ok := r.BasicAuth(); !ok || user != "guest" || pass != "guest" {
+			t.Errorf("expected basic auth credentials, got %q %q", user, pass)
+		}
+
+		_, _ = w.Write([]byte(`[
+			{"source":"","destination":"orders","destination_type":"queue","routing_key":"orders","arguments":{}},
+			{"source":"events","destination":"orders","destination_type":"queue","routing_key":"order.*","arguments":{"x-match":"all"}}
+		]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	mgmt := &ManagementClient{Endpoint: srv.URL, Username: "guest", Password: "guest"}
+
+	bindings, err := mgmt.QueueBindings(context.Background(), "/", "orders")
+	if err != nil {
+		t.Fatalf("could not list bindings: %v", err)
+	}
+
+	if want, got := 2, len(bindings); want != got {
+		t.Fatalf("expected %d bindings, got %d", want, got)
+	}
+	if !HasBinding(bindings, "events", "orders", "order.*") {
+		t.Errorf("expected binding from events with order.*, got %+v", bindings)
+	}
+	if HasBinding(bindings, "events", "orders", "order.#") {
+		t.Errorf("expected no binding with order.#")
+	}
+	if want, got := "all", bindings[1].Arguments["x-match"]; want != got {
+		t.Errorf("expected binding arguments to be decoded, got %v", got)
+	}
+}
+
+func TestManagementClientErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	t.Cleanup(srv.Close)
+
+	mgmt := &ManagementClient{Endpoint: srv.URL}
+
+	if _, err := mgmt.ExchangeBindings(context.Background(), "/", "missing"); err == nil {
+		t.Fatal("expected an error for a missing exchange")
+	}
+}
+
+func TestManagementClientQueuePolicyConflicts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if want, got := "/api/queues/%2F/orders", r.URL.EscapedPath(); want != got {
+			t.Errorf("expected request to %q, got %q", want, got)
+		}
+
+		_, _ = w.Write([]byte(`{
+			"name":"orders",
+			"policy":"ttl",
+			"operator_policy":"limits",
+			"effective_policy_definition":{
+				"message-ttl":60000,
+				"max-length":1000,
+				"dead-letter-exchange":"policy-dlx",
+				"overflow":"reject-publish"
+			}
+		}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	mgmt := &ManagementClient{Endpoint: srv.URL}
+
+	conflicts, err := mgmt.QueuePolicyConflicts(context.Background(), "/", "orders", Table{
+		QueueMessageTTLArg:       int32(300000),
+		QueueMaxLenArg:           int64(100),
+		"x-dead-letter-exchange": "declared-dlx",
+		QueueOverflowArg:         "reject-publish",
+		QueueTypeArg:             QueueTypeClassic,
+	})
+	if err != nil {
+		t.Fatalf("could not check policies: %v", err)
+	}
+
+	got := make(map[string]PolicyConflict)
+	for _, c := range conflicts {
+		got[c.Argument] = c
+	}
+	if want := 3; len(conflicts) != want {
+		t.Fatalf("expected %d conflicts, got %+v", want, conflicts)
+	}
+
+	if c := got[QueueMessageTTLArg]; c.Effective != float64(60000) {
+		t.Errorf("expected the lower policy TTL to apply, got %v", c)
+	}
+	if c := got[QueueMaxLenArg]; c.Effective != int64(100) {
+		t.Errorf("expected the lower declared max length to apply, got %v", c)
+	}
+	if c := got["x-dead-letter-exchange"]; c.Effective != "declared-dlx" {
+		t.Errorf("expected the declared dead letter exchange to apply, got %v", c)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+var (
+	// ErrUnroutable is wrapped by the UnroutableError of PublishMandatory.
+	ErrUnroutable = errors.New("publishing returned as unroutable")
+
+	// ErrNotConfirming is returned by PublishMandatory on a channel not in
+	// confirm mode, or tracking only a sample of its publishings.
+	ErrNotConfirming = errors.New("channel not in confirm mode")
+)
+
+// MandatorySeqHeader is the header PublishMandatory stamps on its publishings
+// to match the messages returned by the server.
+const MandatorySeqHeader = "x-mandatory-seq"
+
+// UnroutableError is the error of PublishMandatory for a message returned by
+// the server, which it unwraps to ErrUnroutable.
+type UnroutableError struct {
+	Return Return
+}
+
+func (e *UnroutableError) Error() string {
+	return fmt.Sprintf("%v: %d %s", ErrUnroutable, e.Return.ReplyCode, e.Return.ReplyText)
+}
+
+// Unwrap returns ErrUnroutable.
+func (e *UnroutableError) Unwrap() error {
+	return ErrUnroutable
+}
+
+/*
+PublishMandatory publishes msg as mandatory on a channel in confirm mode and
+waits for the server to confirm it.  It returns an *UnroutableError, holding
+the Return, when the server returned the message as it could not be routed to
+any queue, the error of the DeferredConfirmation when it was negatively
+acknowledged, and context.Cause(ctx) when ctx is done first:
+
+	err := ch.PublishMandatory(ctx, "orders", "eu.created", msg)
+
+	var unroutable *amqp.UnroutableError
+	if errors.As(err, &unroutable) {
+		log.Printf("no queue bound for %s", unroutable.Return.RoutingKey)
+	}
+
+The server sends the return of a message before its confirmation, and the
+message carries the MandatorySeqHeader header to be matched with its return,
+so concurrent calls on the same channel do not mix up their returns.  Returns
+are still sent to the NotifyReturn listeners.
+*/
+func (ch *Channel) PublishMandatory(ctx context.Context, exchange, key string, msg Publishing) error {
+	ch.confirmM.Lock()
+	confirming := ch.confirming
+	ch.confirmM.Unlock()
+	if !confirming {
+		return ErrNotConfirming
+	}
+
+	seq := atomic.AddInt64(&ch.mandatorySeq, 1)
+
+	headers := make(Table, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[MandatorySeqHeader] = seq
+	msg.Headers = headers
+
+	returned := make(chan Return, 1)
+	ch.mandatory.Store(seq, returned)
+	defer ch.mandatory.Delete(seq)
+
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil {
+		return err
+	}
+	if dc == nil {
+		// Not sampled by ConfirmSampled.
+		return ErrNotConfirming
+	}
+
+	select {
+	case <-dc.Done():
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+
+	select {
+	case ret := <-returned:
+		return &UnroutableError{Return: ret}
+	default:
+	}
+
+	if !dc.Acked() {
+		return dc.Err()
+	}
+	return nil
+}
+
+// returned hands a return to the PublishMandatory call waiting for it.
+// Called from dispatch before the confirmation of the message.
+func (ch *Channel) returned(ret Return) {
+	seq, ok := ret.Headers[MandatorySeqHeader].(int64)
+	if !ok {
+		return
+	}
+	if returned, found := ch.mandatory.Load(seq); found {
+		select {
+		case returned.(chan Return) <- ret:
+		default:
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPublishMandatory(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		pub := srv.recv(1, &basicPublish{}).(*basicPublish)
+		srv.send(1, &basicReturn{ReplyCode: NoRoute, ReplyText: "NO_ROUTE", Exchange: pub.Exchange, RoutingKey: pub.RoutingKey, Properties: pub.Properties, Body: pub.Body})
+		srv.send(1, &basicAck{DeliveryTag: 1})
+
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 2})
+
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 3})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	returns := ch.NotifyReturn(make(chan Return, 1))
+
+	ctx := context.Background()
+	if err := ch.PublishMandatory(ctx, "orders", "key", Publishing{}); !errors.Is(err, ErrNotConfirming) {
+		t.Errorf("expected ErrNotConfirming outside of confirm mode, got %v", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("could not put the channel in confirm mode: %v", err)
+	}
+
+	err = ch.PublishMandatory(ctx, "orders", "unbound", Publishing{Headers: Table{"k": "v"}, Body: []byte("a")})
+	var unroutable *UnroutableError
+	if !errors.As(err, &unroutable) || !errors.Is(err, ErrUnroutable) {
+		t.Fatalf("expected an UnroutableError, got %v", err)
+	}
+	if unroutable.Return.ReplyCode != NoRoute || unroutable.Return.RoutingKey != "unbound" || string(unroutable.Return.Body) != "a" {
+		t.Errorf("expected the return of the message, got %+v", unroutable.Return)
+	}
+	if want, got := "orders", (<-returns).Exchange; want != got {
+		t.Errorf("expected the return to reach the NotifyReturn listeners, got exchange %q", got)
+	}
+
+	if err := ch.PublishMandatory(ctx, "orders", "bound", Publishing{}); err != nil {
+		t.Errorf("expected the routed message to be confirmed, got %v", err)
+	}
+
+	if err := ch.PublishMandatory(ctx, "orders", "bound", Publishing{}); !errors.Is(err, ErrPublishNacked) {
+		t.Errorf("expected ErrPublishNacked, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
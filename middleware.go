@@ -0,0 +1,91 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "context"
+
+// PublishHandler publishes msg, see Channel.PublishWithDeferredConfirmWithContext.
+type PublishHandler func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error)
+
+// DeliveryHandler hands a delivery to the consumer, or returns it from
+// Channel.Get.
+type DeliveryHandler func(d Delivery)
+
+/*
+Middleware wraps the publishings sent and the deliveries received on a
+Channel, for tracing, header injection, validation or metrics.  Either
+function may be nil.
+
+Publish returns a handler that is called for every publishing instead of next.
+It may modify the arguments before calling next, return an error without
+calling next to refuse the publishing, or observe the result of next.
+
+Deliver returns a handler that is called for every delivery to a consumer and
+every message returned by Channel.Get instead of next.  It may modify the
+delivery before calling next, or drop it by not calling next, in which case it
+must still acknowledge the delivery unless it was consumed with autoAck.
+Delivery handlers are called from the connection reader goroutine, so they
+should return quickly and must not call back into the Connection other than to
+acknowledge deliveries.
+*/
+type Middleware struct {
+	Publish func(next PublishHandler) PublishHandler
+	Deliver func(next DeliveryHandler) DeliveryHandler
+}
+
+// middlewares is the copy on write list of middleware of a channel.
+type middlewares []Middleware
+
+/*
+Use appends middleware to the channel.  The first middleware used is the
+outermost: it sees a publishing first and a delivery first, like HTTP
+middleware wrapping a handler.
+
+	ch.Use(amqp.Middleware{
+		Publish: func(next amqp.PublishHandler) amqp.PublishHandler {
+			return func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg amqp.Publishing) (*amqp.DeferredConfirmation, error) {
+				msg.Headers = injectTrace(ctx, msg.Headers)
+				return next(ctx, exchange, key, mandatory, immediate, msg)
+			}
+		},
+	})
+
+Middleware applies to the publishings and deliveries following the call to
+Use, including deliveries to consumers started before.
+*/
+func (ch *Channel) Use(mw ...Middleware) {
+	ch.notifyM.Lock()
+	defer ch.notifyM.Unlock()
+
+	prev, _ := ch.middleware.Load().(middlewares)
+	next := make(middlewares, 0, len(prev)+len(mw))
+	next = append(append(next, prev...), mw...)
+	ch.middleware.Store(next)
+}
+
+func (ch *Channel) middlewares() middlewares {
+	mw, _ := ch.middleware.Load().(middlewares)
+	return mw
+}
+
+// publish returns h wrapped in the Publish functions of mw.
+func (mw middlewares) publish(h PublishHandler) PublishHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		if mw[i].Publish != nil {
+			h = mw[i].Publish(h)
+		}
+	}
+	return h
+}
+
+// deliver returns h wrapped in the Deliver functions of mw.
+func (mw middlewares) deliver(h DeliveryHandler) DeliveryHandler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		if mw[i].Deliver != nil {
+			h = mw[i].Deliver(h)
+		}
+	}
+	return h
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareWrapsPublishingsAndDeliveries(t *testing.T) {
+	const tag = "mw"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, Body: []byte("drop")})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 2, Body: []byte("keep")})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	var order []string
+	trace := func(name string) Middleware {
+		return Middleware{
+			Publish: func(next PublishHandler) PublishHandler {
+				return func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+					order = append(order, name)
+					msg.Headers = Table{"x-trace": strings.Join(order, ",")}
+					return next(ctx, exchange, key, mandatory, immediate, msg)
+				}
+			},
+		}
+	}
+	refuseEmpty := Middleware{
+		Publish: func(next PublishHandler) PublishHandler {
+			return func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+				if len(msg.Body) == 0 {
+					return nil, errors.New("empty body")
+				}
+				return next(ctx, exchange, key, mandatory, immediate, msg)
+			}
+		},
+		Deliver: func(next DeliveryHandler) DeliveryHandler {
+			return func(d Delivery) {
+				if string(d.Body) == "drop" {
+					return
+				}
+				d.Body = []byte(strings.ToUpper(string(d.Body)))
+				next(d)
+			}
+		},
+	}
+
+	ch.Use(trace("outer"), refuseEmpty, trace("inner"))
+
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{}); err == nil {
+		t.Error("expected the middleware to refuse an empty publishing")
+	}
+	order = nil
+
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("x")}); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	if want, got := "outer,inner", (<-published).Properties.Headers["x-trace"]; want != got {
+		t.Errorf("expected the middleware to run outermost first as %q, got %q", want, got)
+	}
+
+	deliveries, err := ch.Consume("q", tag, true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	if d := <-deliveries; d.DeliveryTag != 2 || string(d.Body) != "KEEP" {
+		t.Errorf("expected only the modified second delivery, got %d %q", d.DeliveryTag, d.Body)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MultiConsumerQueue is a queue consumed by a MultiConsumer.
+type MultiConsumerQueue struct {
+	Name string
+
+	// Prefetch sets Channel.Qos with this prefetch count on the channel of
+	// the queue when greater than 0.
+	Prefetch int
+
+	// Args are the arguments of basic.consume, such as x-priority.
+	Args Table
+}
+
+/*
+MultiConsumer consumes several queues of a connection, for instance the shards
+of a queue of the sharding plugin, and hands the deliveries of all of them to a
+single Handler, settling them like a Consumer:
+
+	m := amqp.NewMultiConsumer(conn, []amqp.MultiConsumerQueue{
+		{Name: "orders.eu", Prefetch: 50},
+		{Name: "orders.us", Prefetch: 10},
+	}, handle)
+
+	err := m.Run(ctx)
+
+Each queue is consumed on a channel of its own, so each has its own prefetch
+and recovers on its own: when the channel of a queue is closed by the server,
+or its consumer cancelled, the queue is consumed again on a new channel after
+RetryInterval while the other queues keep going.
+*/
+type MultiConsumer struct {
+	Connection *Connection
+	Queues     []MultiConsumerQueue
+
+	// Concurrency is the number of workers calling Handler for each queue,
+	// 1 when not greater than 0.
+	Concurrency int
+
+	Handler func(ctx context.Context, d Delivery) Action
+
+	// RetryInterval is how long to wait before consuming a queue again,
+	// one second when not greater than 0.
+	RetryInterval time.Duration
+
+	// OnError, when not nil, is called with the reason a queue stopped being
+	// consumed before it is consumed again.
+	OnError func(queue string, err error)
+}
+
+// NewMultiConsumer returns a MultiConsumer calling handler with the deliveries
+// of queues.
+func NewMultiConsumer(conn *Connection, queues []MultiConsumerQueue, handler func(ctx context.Context, d Delivery) Action) *MultiConsumer {
+	return &MultiConsumer{
+		Connection: conn,
+		Queues:     queues,
+		Handler:    handler,
+	}
+}
+
+/*
+Run consumes every queue until ctx is done or the connection is closed, and
+returns once the handlers of all queues have returned.  It then returns
+context.Cause(ctx), or the reason the connection was closed.
+
+Like Consumer.Run, the deliveries received but not handled yet are requeued
+when ctx is done.
+*/
+func (m *MultiConsumer) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, q := range m.Queues {
+		wg.Add(1)
+		go func(q MultiConsumerQueue) {
+			defer wg.Done()
+			m.run(ctx, q)
+		}(q)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return ErrClosed
+}
+
+// run consumes q again every time it stops, until ctx is done or the
+// connection is closed.
+func (m *MultiConsumer) run(ctx context.Context, q MultiConsumerQueue) {
+	interval := m.RetryInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	for {
+		err := m.consume(ctx, q)
+		if ctx.Err() != nil || m.Connection.IsClosed() {
+			return
+		}
+		if m.OnError != nil {
+			m.OnError(q.Name, err)
+		}
+
+		timer := m.Connection.clock().NewTimer(interval)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// consume consumes q on a new channel until it stops.
+func (m *MultiConsumer) consume(ctx context.Context, q MultiConsumerQueue) error {
+	ch, err := m.Connection.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	c := &Consumer{
+		Channel:     ch,
+		Queue:       q.Name,
+		Args:        q.Args,
+		Prefetch:    q.Prefetch,
+		Concurrency: m.Concurrency,
+		Handler:     m.Handler,
+	}
+	return c.Run(ctx)
+}
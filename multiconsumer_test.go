@@ -0,0 +1,92 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiConsumerRecoversQueue(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	prefetches := make(chan uint16, 2)
+
+	go func() {
+		srv.connectionOpen()
+
+		srv.channelOpen(1)
+		prefetches <- srv.recv(1, &basicQos{}).(*basicQos).PrefetchCount
+		srv.send(1, &basicQosOk{})
+		tag := srv.recv(1, &basicConsume{}).(*basicConsume).ConsumerTag
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, Body: []byte("first")})
+		srv.recv(1, &basicAck{})
+
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "queue leader moved"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(2)
+		prefetches <- srv.recv(2, &basicQos{}).(*basicQos).PrefetchCount
+		srv.send(2, &basicQosOk{})
+		tag = srv.recv(2, &basicConsume{}).(*basicConsume).ConsumerTag
+		srv.send(2, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(2, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1, Body: []byte("second")})
+		srv.recv(2, &basicAck{})
+		cancel()
+
+		srv.recv(2, &basicCancel{})
+		srv.send(2, &basicCancelOk{ConsumerTag: tag})
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	handled := make(chan string, 2)
+	m := NewMultiConsumer(c, []MultiConsumerQueue{{Name: "q", Prefetch: 5}}, func(_ context.Context, d Delivery) Action {
+		handled <- string(d.Body)
+		return Ack
+	})
+	m.RetryInterval = time.Millisecond
+
+	errs := make(chan error, 1)
+	m.OnError = func(queue string, err error) { errs <- err }
+
+	if err := m.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Run to return once cancelled, got %v", err)
+	}
+
+	for _, want := range []string{"first", "second"} {
+		if got := <-handled; want != got {
+			t.Errorf("expected delivery %q to be handled, got %q", want, got)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if want, got := uint16(5), <-prefetches; want != got {
+			t.Errorf("expected a prefetch of %d, got %d", want, got)
+		}
+	}
+
+	var amqpErr *Error
+	if err := <-errs; !errors.As(err, &amqpErr) {
+		t.Errorf("expected the channel close to be reported, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+SetNackRetry makes a channel in confirm mode publish again, transparently, the
+messages negatively acknowledged by the server, until they are published
+maxAttempts times.  The DeferredConfirmation of such a message is only done
+once the server acknowledges one of its attempts, or negatively acknowledges
+the last one, in which case its Err wraps ErrPublishNacked:
+
+	ch.SetNackRetry(3, func(attempt int) time.Duration {
+		return time.Duration(attempt) * 100 * time.Millisecond
+	})
+
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, "orders", "eu.created", false, false, msg)
+
+	if !dc.Wait() {
+		log.Printf("order lost: %v", dc.Err())
+	}
+
+backoff returns how long to wait before the given attempt, from 2; messages
+are published again right away when it is nil.  A maxAttempts of 0 or 1, the
+default, does not retry.
+
+Each attempt is a new publishing with a delivery tag of its own, so listeners
+added with Channel.NotifyPublish or Channel.Notifications still receive the
+confirmation of every attempt, the DeliveryTag of the DeferredConfirmation
+being the tag of the first one.  Only the publishings tracked with a
+DeferredConfirmation are retried, which excludes the ones not sampled by
+Channel.ConfirmSampled.
+*/
+func (ch *Channel) SetNackRetry(maxAttempts int, backoff func(attempt int) time.Duration) {
+	ch.confirms.deferredConfirmations.setRetry(maxAttempts, backoff, ch.republish)
+}
+
+// republishing is what a DeferredConfirmation needs to be published again.
+type republishing struct {
+	exchange  string
+	key       string
+	mandatory bool
+	immediate bool
+	msg       Publishing
+	encoded   []byte
+	attempts  int
+}
+
+func (d *deferredConfirmations) setRetry(maxAttempts int, backoff func(attempt int) time.Duration, republish func(*DeferredConfirmation, time.Duration)) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	d.maxAttempts = maxAttempts
+	d.backoff = backoff
+	d.republish = republish
+}
+
+// retain keeps what is needed to publish dc again when the channel retries
+// nacked publishings.
+func (d *deferredConfirmations) retain(dc *DeferredConfirmation, exchange, key string, mandatory, immediate bool, msg Publishing, encoded []byte) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if d.maxAttempts <= 1 {
+		return
+	}
+	dc.retry = &republishing{
+		exchange:  exchange,
+		key:       key,
+		mandatory: mandatory,
+		immediate: immediate,
+		msg:       msg,
+		encoded:   encoded,
+		attempts:  1,
+	}
+}
+
+// settle completes dc with the confirmation of its delivery tag, or publishes
+// it again when nacked with attempts left.  Must be called while holding d.m.
+func (d *deferredConfirmations) settle(dc *DeferredConfirmation, ack bool) {
+	if !ack {
+		if r := dc.retry; r != nil {
+			if r.attempts < d.maxAttempts && d.republish != nil {
+				var backoff time.Duration
+				if d.backoff != nil {
+					backoff = d.backoff(r.attempts + 1)
+				}
+				go d.republish(dc, backoff)
+				return
+			}
+			dc.err = fmt.Errorf("%w after %d attempts", ErrPublishNacked, r.attempts)
+		} else {
+			dc.err = ErrPublishNacked
+		}
+	}
+	dc.retry = nil
+	dc.setAck(ack)
+}
+
+// readd tracks dc again under the delivery tag of its next attempt.
+func (d *deferredConfirmations) readd(tag uint64, dc *DeferredConfirmation) {
+	d.pending.put(&pendingConfirm{tag: tag, dc: dc})
+}
+
+// republish publishes dc again after backoff, see SetNackRetry.
+func (ch *Channel) republish(dc *DeferredConfirmation, backoff time.Duration) {
+	if backoff > 0 {
+		timer := ch.connection.clock().NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-ch.close:
+			timer.Stop()
+			dc.fail(ErrClosed)
+			return
+		}
+	}
+
+	r := dc.retry
+	r.attempts++
+	if _, err := ch.sendPublishing(context.Background(), r.exchange, r.key, r.mandatory, r.immediate, r.msg, r.encoded, dc); err != nil {
+		// dc is already done when the failed attempt was tracked.
+		select {
+		case <-dc.done:
+		default:
+			dc.fail(err)
+		}
+	}
+}
+
+// fail completes dc negatively with err.
+func (dc *DeferredConfirmation) fail(err error) {
+	dc.err = err
+	dc.retry = nil
+	dc.setAck(false)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNackRetryRepublishesNackedPublishings(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	bodies := make(chan string, 4)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		// Nacked once, then acknowledged.
+		pub := srv.recv(1, &basicPublish{}).(*basicPublish)
+		bodies <- string(pub.Body)
+		srv.send(1, &basicNack{DeliveryTag: 1})
+		pub = srv.recv(1, &basicPublish{}).(*basicPublish)
+		bodies <- string(pub.Body)
+		srv.send(1, &basicAck{DeliveryTag: 2})
+
+		// Nacked on every attempt.
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 3})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 4})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	confirms := ch.NotifyPublish(make(chan Confirmation, 4))
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("could not put the channel in confirm mode: %v", err)
+	}
+
+	var attempts []int
+	ch.SetNackRetry(2, func(attempt int) time.Duration {
+		attempts = append(attempts, attempt)
+		return time.Millisecond
+	})
+
+	ctx := WithConfirmData(context.Background(), "order-1")
+	acked, err := ch.PublishWithDeferredConfirmWithContext(ctx, "orders", "key", false, false, Publishing{Body: []byte("a")})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if !acked.Wait() || acked.Err() != nil {
+		t.Errorf("expected the publishing to be acknowledged on its second attempt, got %v", acked.Err())
+	}
+	if want, got := uint64(1), acked.DeliveryTag; want != got {
+		t.Errorf("expected the delivery tag of the first attempt %d, got %d", want, got)
+	}
+	for i := 0; i < 2; i++ {
+		if want, got := "a", <-bodies; want != got {
+			t.Errorf("expected attempt %d to publish %q, got %q", i+1, want, got)
+		}
+	}
+
+	nacked, err := ch.PublishWithDeferredConfirmWithContext(context.Background(), "orders", "key", false, false, Publishing{Body: []byte("b")})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if nacked.Wait() || !errors.Is(nacked.Err(), ErrPublishNacked) {
+		t.Errorf("expected the publishing to fail with ErrPublishNacked after 2 attempts, got %v", nacked.Err())
+	}
+
+	if want, got := []int{2, 2}, attempts; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected backoffs before attempts %v, got %v", want, got)
+	}
+
+	for _, want := range []Confirmation{
+		{DeliveryTag: 1, Ack: false, Data: "order-1"},
+		{DeliveryTag: 2, Ack: true, Data: "order-1"},
+		{DeliveryTag: 3, Ack: false},
+		{DeliveryTag: 4, Ack: false},
+	} {
+		if got := <-confirms; want != got {
+			t.Errorf("expected listeners to receive the confirmation of every attempt %+v, got %+v", want, got)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestDeferredConfirmationErrWithoutRetry(t *testing.T) {
+	c := newConfirms(false)
+
+	nacked := c.publish(nil)
+	closed := c.publish(nil)
+	if nacked.Err() != nil {
+		t.Errorf("expected no error before the confirmation, got %v", nacked.Err())
+	}
+
+	c.One(Confirmation{DeliveryTag: 1, Ack: false})
+	if err := nacked.Err(); err != ErrPublishNacked {
+		t.Errorf("expected ErrPublishNacked, got %v", err)
+	}
+
+	c.Close()
+	if err := closed.Err(); err != ErrClosed {
+		t.Errorf("expected ErrClosed once closed, got %v", err)
+	}
+}
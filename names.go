@@ -0,0 +1,88 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Constraints on queue and exchange names enforced by the server.
+const (
+	// MaxNameLength is the maximum length in bytes of a queue or exchange
+	// name, the length of a shortstr.
+	MaxNameLength = 255
+
+	// ReservedNamePrefix starts the names of queues and exchanges reserved
+	// to the server.  They can only be declared passively.
+	ReservedNamePrefix = "amq."
+)
+
+// Errors wrapped by NameError, to be tested with errors.Is.
+var (
+	ErrNameTooLong  = errors.New("name is longer than 255 bytes")
+	ErrNameInvalid  = errors.New("name is not valid UTF-8 or contains control characters")
+	ErrNameReserved = errors.New("name starts with the reserved prefix amq.")
+)
+
+// NameError is returned when a queue or exchange name breaks the rules of the
+// server, before the server gets to close the channel for it.  Name checks
+// can be disabled with Config.SkipNameValidation.
+type NameError struct {
+	Kind string // "queue" or "exchange"
+	Name string
+	Err  error // one of ErrNameTooLong, ErrNameInvalid or ErrNameReserved
+}
+
+func (e *NameError) Error() string {
+	return fmt.Sprintf("invalid %s name %q: %v", e.Kind, e.Name, e.Err)
+}
+
+func (e *NameError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateQueueName returns a *NameError when name cannot be declared as a
+// queue.  The empty name is valid, the server generates one.
+func ValidateQueueName(name string) error {
+	return validateName("queue", name, false)
+}
+
+// ValidateExchangeName returns a *NameError when name cannot be declared as
+// an exchange.
+func ValidateExchangeName(name string) error {
+	return validateName("exchange", name, false)
+}
+
+// validateName checks name against the server rules, the reserved prefix
+// only applies to active declarations.  RabbitMQ accepts any UTF-8 in names,
+// beyond the characters listed by the AMQP specification, so only control
+// characters are refused.
+func validateName(kind, name string, passive bool) error {
+	var err error
+	switch {
+	case len(name) > MaxNameLength:
+		err = ErrNameTooLong
+	case !utf8.ValidString(name) || strings.IndexFunc(name, unicode.IsControl) >= 0:
+		err = ErrNameInvalid
+	case !passive && strings.HasPrefix(name, ReservedNamePrefix):
+		err = ErrNameReserved
+	default:
+		return nil
+	}
+
+	return &NameError{Kind: kind, Name: name, Err: err}
+}
+
+// validateName checks name unless disabled by Config.SkipNameValidation.
+func (ch *Channel) validateName(kind, name string, passive bool) error {
+	if ch.connection != nil && ch.connection.Config.SkipNameValidation {
+		return nil
+	}
+	return validateName(kind, name, passive)
+}
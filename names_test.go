@@ -0,0 +1,85 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want error
+	}{
+		{"", nil},
+		{"orders.eu-west:1", nil},
+		{"an example", nil},
+		{"commandes-élevées", nil},
+		{strings.Repeat("q", MaxNameLength), nil},
+		{strings.Repeat("q", MaxNameLength+1), ErrNameTooLong},
+		{"tab\tname", ErrNameInvalid},
+		{"bad\xffutf8", ErrNameInvalid},
+		{"amq.orders", ErrNameReserved},
+	}
+
+	for _, tt := range tests {
+		if err := ValidateQueueName(tt.name); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("ValidateQueueName(%q): expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	var nameErr *NameError
+	if err := ValidateExchangeName("amq.direct"); !errors.As(err, &nameErr) || nameErr.Kind != "exchange" {
+		t.Errorf("expected an exchange *NameError, got %v", err)
+	}
+
+	if err := validateName("exchange", "amq.direct", true); err != nil {
+		t.Errorf("expected reserved names to be declared passively, got %v", err)
+	}
+}
+
+func TestDeclareInvalidNameDoesNotReachServer(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		// The next method after the rejected declarations.
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &queueDeclareOk{Queue: "amq.skipped"})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	if _, err := ch.QueueDeclare("amq.orders", false, false, false, false, nil); !errors.Is(err, ErrNameReserved) {
+		t.Errorf("expected ErrNameReserved, got %v", err)
+	}
+	if err := ch.ExchangeDeclare(strings.Repeat("x", 300), "direct", false, false, false, false, nil); !errors.Is(err, ErrNameTooLong) {
+		t.Errorf("expected ErrNameTooLong, got %v", err)
+	}
+
+	c.Config.SkipNameValidation = true
+	if _, err := ch.QueueDeclare("amq.skipped", false, false, false, false, nil); err != nil {
+		t.Errorf("expected the declaration to be sent with SkipNameValidation, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "fmt"
+
+// notify sends v to a listener registered with one of the Notify* methods.
+// With Config.StrictNotify it panics instead of blocking when the listener is
+// not ready to receive, to surface in tests the listeners that would stall
+// the dispatcher in production.
+func notify[T any](strict bool, listener chan T, v T, kind string) {
+	if !strict {
+		listener <- v
+		return
+	}
+
+	select {
+	case listener <- v:
+	default:
+		panic(fmt.Sprintf("amqp091: %s listener would block the dispatcher, buffered %d of %d", kind, len(listener), cap(listener)))
+	}
+}
+
+/*
+Notification is an event of a channel received from Channel.Notifications, one
+of Confirmation, Return, CancelNotification, FlowNotification or
+CloseNotification:
+
+	for n := range ch.Notifications(make(chan amqp.Notification, 100)) {
+		switch n := n.(type) {
+		case amqp.Confirmation:
+			settle(n.DeliveryTag, n.Ack)
+		case amqp.Return:
+			unroutable(n)
+		case amqp.CloseNotification:
+			log.Printf("channel closed: %v", n.Err)
+		}
+	}
+*/
+type Notification interface {
+	notification()
+}
+
+// CancelNotification is the Notification of a consumer cancelled by the
+// server, as sent to Channel.NotifyCancel listeners.
+type CancelNotification struct {
+	ConsumerTag string
+}
+
+// FlowNotification is the Notification of the server pausing or resuming the
+// publishings of the channel, as sent to Channel.NotifyFlow listeners.
+type FlowNotification struct {
+	Active bool
+}
+
+// CloseNotification is the Notification of the channel closing with an error,
+// as sent to Channel.NotifyClose listeners.
+type CloseNotification struct {
+	Err *Error
+}
+
+func (Confirmation) notification()       {}
+func (Return) notification()             {}
+func (CancelNotification) notification() {}
+func (FlowNotification) notification()   {}
+func (CloseNotification) notification()  {}
+
+/*
+Notifications registers a listener receiving every event of the channel on a
+single chan, instead of registering with NotifyPublish, NotifyReturn,
+NotifyCancel, NotifyFlow and NotifyClose.  The events are received in the
+order they happened, and the chan is closed once, after the last event, when
+the channel shuts down.  A CloseNotification is the last event when the
+channel closes with an error.
+
+Confirmations are only received after Channel.Confirm.  Like the other
+listeners, the chan must be received from until it is closed, as the events are
+sent from the goroutine reading from the connection.
+*/
+func (ch *Channel) Notifications(c chan Notification) chan Notification {
+	ch.notifyM.Lock()
+	defer ch.notifyM.Unlock()
+
+	if ch.noNotify {
+		close(c)
+	} else {
+		ch.notifications = append(ch.notifications, c)
+		ch.confirms.notifyAll(c)
+	}
+
+	return c
+}
+
+// notifyAll sends n to the Notifications listeners.  Must be called while
+// holding notifyM.
+func (ch *Channel) notifyAll(n Notification) {
+	for _, c := range ch.notifications {
+		notify(ch.connection.strictNotify(), c, n, "Notifications")
+	}
+}
+
+// strictNotify reports whether Config.StrictNotify is set.
+func (c *Connection) strictNotify() bool {
+	return c != nil && c.Config.StrictNotify
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStrictNotifyPanicsOnBlockedListener(t *testing.T) {
+	ch := newChannel(&Connection{Config: Config{StrictNotify: true}}, 1)
+
+	returns := ch.NotifyReturn(make(chan Return, 1))
+	ch.dispatch(&basicReturn{ReplyText: "first"})
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(fmt.Sprint(r), "NotifyReturn") {
+			t.Fatalf("expected a panic naming the NotifyReturn listener, got %v", r)
+		}
+		if ret := <-returns; ret.ReplyText != "first" {
+			t.Errorf("expected the buffered return to be kept, got %+v", ret)
+		}
+	}()
+
+	ch.dispatch(&basicReturn{ReplyText: "second"})
+}
+
+func TestShutdownDrainsReturnsBeforeClosing(t *testing.T) {
+	ch := newChannel(&Connection{}, 1)
+
+	returns := ch.NotifyReturn(make(chan Return, 2))
+	closes := ch.NotifyClose(make(chan *Error, 1))
+
+	ch.dispatch(&basicReturn{ReplyText: "first"})
+	ch.dispatch(&basicReturn{ReplyText: "second"})
+	ch.shutdown(ErrChannelError)
+
+	var got []string
+	for ret := range returns {
+		got = append(got, ret.ReplyText)
+	}
+	if want := "first second"; strings.Join(got, " ") != want {
+		t.Errorf("expected returns %q before the listener is closed, got %q", want, got)
+	}
+
+	if err := <-closes; err != ErrChannelError {
+		t.Errorf("expected the shutdown error, got %v", err)
+	}
+	if _, open := <-closes; open {
+		t.Error("expected NotifyClose to be closed after the error")
+	}
+
+	if r := ch.NotifyReturn(make(chan Return)); r != nil {
+		if _, open := <-r; open {
+			t.Error("expected listeners registered after shutdown to be closed")
+		}
+	}
+}
+
+func TestNotificationsReceivesEveryEventInOrder(t *testing.T) {
+	ch := newChannel(&Connection{}, 1)
+	ch.confirming = true
+
+	events := ch.Notifications(make(chan Notification, 8))
+
+	ch.confirms.publish(nil)
+	ch.dispatch(&basicReturn{ReplyText: "unroutable"})
+	ch.dispatch(&basicCancel{ConsumerTag: "ctag"})
+	ch.dispatch(&basicAck{DeliveryTag: 1})
+	ch.shutdown(ErrChannelError)
+
+	var got []Notification
+	for n := range events {
+		got = append(got, n)
+	}
+
+	if want := 4; len(got) != want {
+		t.Fatalf("expected %d notifications, got %+v", want, got)
+	}
+	if ret, ok := got[0].(Return); !ok || ret.ReplyText != "unroutable" {
+		t.Errorf("expected the return first, got %+v", got[0])
+	}
+	if cancel, ok := got[1].(CancelNotification); !ok || cancel.ConsumerTag != "ctag" {
+		t.Errorf("expected the cancel second, got %+v", got[1])
+	}
+	if confirm, ok := got[2].(Confirmation); !ok || confirm.DeliveryTag != 1 || !confirm.Ack {
+		t.Errorf("expected the confirmation third, got %+v", got[2])
+	}
+	if closing, ok := got[3].(CloseNotification); !ok || closing.Err != ErrChannelError {
+		t.Errorf("expected the close last, got %+v", got[3])
+	}
+
+	if _, open := <-ch.Notifications(make(chan Notification)); open {
+		t.Error("expected listeners registered after shutdown to be closed")
+	}
+}
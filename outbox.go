@@ -0,0 +1,197 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrConfirmSampled is returned by OutboxRelay and Channel.StartCheckpoint on a
+// channel put in confirm mode with Channel.ConfirmSampled, as they cannot wait
+// for the confirmation of each message.
+var ErrConfirmSampled = errors.New("channel tracks only a sample of its confirmations")
+
+// OutboxMessage is a message of an OutboxSource waiting to be published.
+type OutboxMessage struct {
+	// ID identifies the message to the OutboxSource, such as the primary key
+	// of its row.
+	ID interface{}
+
+	Exchange string
+	Key      string
+	Msg      Publishing
+}
+
+/*
+OutboxSource is the store of a transactional outbox: the table the
+application writes its messages to in the same database transaction as its
+state changes, for an OutboxRelay to publish them.
+*/
+type OutboxSource interface {
+	// Pending returns at most limit messages not marked as sent yet, oldest
+	// first.
+	Pending(ctx context.Context, limit int) ([]OutboxMessage, error)
+
+	// MarkSent records that the server acknowledged the message, which must
+	// not be returned by Pending anymore.
+	MarkSent(ctx context.Context, id interface{}) error
+
+	// MarkNacked records that the server negatively acknowledged the message
+	// with reason.  The source decides whether Pending returns it again.
+	MarkNacked(ctx context.Context, id interface{}, reason error) error
+}
+
+/*
+OutboxRelay publishes the messages of an OutboxSource on a channel in confirm
+mode, and marks them as sent only once the server acknowledged them:
+
+	relay := amqp.NewOutboxRelay(ch, outbox)
+	err := relay.Run(ctx)
+
+Messages whose confirmation is lost, because the channel or the relay stopped
+before it arrived, stay pending and are published again, so consumers can
+receive duplicates: set a MessageId and see Deduplicator.  Unroutable messages
+are acknowledged by the server like any other, so declare the bindings of the
+outbox messages, or an alternate exchange, before relaying them.
+
+The channel is owned by the relay while it runs.  It must not be in confirm
+mode with Channel.ConfirmSampled, which tracks only some of the confirmations:
+the relay returns ErrConfirmSampled.
+*/
+type OutboxRelay struct {
+	Channel *Channel
+	Source  OutboxSource
+
+	// BatchSize is the number of pending messages published before waiting
+	// for their confirmations, 100 when not greater than 0.
+	BatchSize int
+
+	// PollInterval is how long to wait for new messages once all pending
+	// messages are relayed, one second when not greater than 0.
+	PollInterval time.Duration
+
+	// OnError, when not nil, is called with the error of a batch, such as an
+	// error of the Source, before the relay tries again after PollInterval.
+	OnError func(err error)
+}
+
+// NewOutboxRelay returns an OutboxRelay publishing the messages of source on
+// ch.
+func NewOutboxRelay(ch *Channel, source OutboxSource) *OutboxRelay {
+	return &OutboxRelay{
+		Channel: ch,
+		Source:  source,
+	}
+}
+
+/*
+Run puts the channel in confirm mode and relays the pending messages until ctx
+is done or the channel is closed.  It then returns context.Cause(ctx) or the
+reason the channel was closed.
+*/
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	if r.Channel.confirms.sampled() {
+		return ErrConfirmSampled
+	}
+	if err := r.Channel.Confirm(false); err != nil {
+		return err
+	}
+
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	for {
+		n, err := r.RelayOnce(ctx)
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if r.Channel.IsClosed() {
+			return ErrClosed
+		}
+		if err != nil && r.OnError != nil {
+			r.OnError(err)
+		}
+		if err == nil && n == r.batchSize() {
+			continue
+		}
+
+		timer := r.Channel.connection.clock().NewTimer(interval)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return context.Cause(ctx)
+		case <-r.Channel.close:
+			timer.Stop()
+			return ErrClosed
+		}
+	}
+}
+
+/*
+RelayOnce publishes a batch of pending messages, waits for their
+confirmations and marks them, on a channel already in confirm mode.  It
+returns the number of pending messages fetched, and the first error of the
+batch.
+*/
+func (r *OutboxRelay) RelayOnce(ctx context.Context) (int, error) {
+	if r.Channel.confirms.sampled() {
+		return 0, ErrConfirmSampled
+	}
+
+	pending, err := r.Source.Pending(ctx, r.batchSize())
+	if err != nil {
+		return 0, err
+	}
+
+	confirms := make([]*DeferredConfirmation, 0, len(pending))
+	for _, m := range pending {
+		dc, err := r.Channel.PublishWithDeferredConfirmWithContext(ctx, m.Exchange, m.Key, false, false, m.Msg)
+		if err != nil {
+			// The messages published so far are still marked.
+			r.mark(ctx, pending[:len(confirms)], confirms)
+			return len(pending), err
+		}
+		if dc == nil {
+			return len(pending), ErrNotConfirming
+		}
+		confirms = append(confirms, dc)
+	}
+
+	return len(pending), r.mark(ctx, pending, confirms)
+}
+
+// mark waits for the confirmation of each message and marks it.
+func (r *OutboxRelay) mark(ctx context.Context, pending []OutboxMessage, confirms []*DeferredConfirmation) (first error) {
+	for i, dc := range confirms {
+		ack, err := dc.WaitContext(ctx)
+		switch {
+		case err != nil:
+			return err
+		case ack:
+			err = r.Source.MarkSent(ctx, pending[i].ID)
+		case errors.Is(dc.Err(), ErrClosed):
+			// Unconfirmed: the message stays pending.
+			continue
+		default:
+			err = r.Source.MarkNacked(ctx, pending[i].ID, dc.Err())
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (r *OutboxRelay) batchSize() int {
+	if r.BatchSize <= 0 {
+		return 100
+	}
+	return r.BatchSize
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+type memoryOutbox struct {
+	m       sync.Mutex
+	pending []OutboxMessage
+	sent    []interface{}
+	nacked  map[interface{}]error
+}
+
+func (o *memoryOutbox) Pending(ctx context.Context, limit int) ([]OutboxMessage, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if limit > len(o.pending) {
+		limit = len(o.pending)
+	}
+	return append([]OutboxMessage(nil), o.pending[:limit]...), nil
+}
+
+func (o *memoryOutbox) MarkSent(ctx context.Context, id interface{}) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	o.sent = append(o.sent, id)
+	o.remove(id)
+	return nil
+}
+
+func (o *memoryOutbox) MarkNacked(ctx context.Context, id interface{}, reason error) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if o.nacked == nil {
+		o.nacked = make(map[interface{}]error)
+	}
+	o.nacked[id] = reason
+	o.remove(id)
+	return nil
+}
+
+func (o *memoryOutbox) remove(id interface{}) {
+	for i, m := range o.pending {
+		if m.ID == id {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func TestOutboxRelayMarksAfterConfirmation(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 3)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		for i := 0; i < 2; i++ {
+			published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		}
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.send(1, &basicNack{DeliveryTag: 2})
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		srv.send(1, &basicAck{DeliveryTag: 3})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("could not put the channel in confirm mode: %v", err)
+	}
+
+	outbox := &memoryOutbox{pending: []OutboxMessage{
+		{ID: 1, Exchange: "orders", Key: "created", Msg: Publishing{Body: []byte("1")}},
+		{ID: 2, Exchange: "orders", Key: "created", Msg: Publishing{Body: []byte("2")}},
+		{ID: 3, Exchange: "orders", Key: "paid", Msg: Publishing{Body: []byte("3")}},
+	}}
+	relay := NewOutboxRelay(ch, outbox)
+	relay.BatchSize = 2
+
+	ctx := context.Background()
+	if n, err := relay.RelayOnce(ctx); n != 2 || err != nil {
+		t.Fatalf("expected a batch of 2, got %d %v", n, err)
+	}
+	if n, err := relay.RelayOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("expected a batch of 1, got %d %v", n, err)
+	}
+
+	for _, want := range []string{"1", "2", "3"} {
+		if got := string((<-published).Body); want != got {
+			t.Errorf("expected message %s to be published, got %s", want, got)
+		}
+	}
+	if want, got := []interface{}{1, 3}, outbox.sent; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected %v to be marked as sent, got %v", want, got)
+	}
+	if err := outbox.nacked[2]; !errors.Is(err, ErrPublishNacked) {
+		t.Errorf("expected message 2 to be marked as nacked with ErrPublishNacked, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestOutboxRelayRunStopsWhenChannelCloses(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "gone"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	outbox := &memoryOutbox{pending: []OutboxMessage{{ID: 1, Exchange: "orders", Key: "created"}}}
+	if err := NewOutboxRelay(ch, outbox).Run(context.Background()); err == nil {
+		t.Error("expected the relay to stop with the reason the channel closed")
+	}
+	if len(outbox.pending) != 1 || len(outbox.sent) != 0 {
+		t.Errorf("expected the unconfirmed message to stay pending, got pending %v and sent %v", outbox.pending, outbox.sent)
+	}
+
+	c.Close()
+}
+
+func TestOutboxRelayRejectsSampledChannel(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := ch.ConfirmSampled(false, 2); err != nil {
+		t.Fatalf("could not put the channel in confirm mode: %v", err)
+	}
+
+	outbox := &memoryOutbox{pending: []OutboxMessage{{ID: 1, Exchange: "orders", Key: "created"}}}
+	relay := NewOutboxRelay(ch, outbox)
+	if err := relay.Run(context.Background()); err != ErrConfirmSampled {
+		t.Errorf("expected Run to return ErrConfirmSampled, got %v", err)
+	}
+	if n, err := relay.RelayOnce(context.Background()); n != 0 || err != ErrConfirmSampled {
+		t.Errorf("expected RelayOnce to return ErrConfirmSampled before fetching, got %d and %v", n, err)
+	}
+	if len(outbox.pending) != 1 || len(outbox.sent) != 0 {
+		t.Errorf("expected the message to stay pending, got pending %v and sent %v", outbox.pending, outbox.sent)
+	}
+
+	c.Close()
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer bounds the capacity of the buffers returned to the pools, so
+// that an occasional large message does not stay pinned in memory.
+const maxPooledBuffer = 1 << 20
+
+// payloads are the buffers method and content header payloads are encoded
+// into before being written.
+var payloads = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+func getPayload() *bytes.Buffer {
+	return payloads.Get().(*bytes.Buffer)
+}
+
+func putPayload(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	payloads.Put(b)
+}
+
+// slabs are the buffers body frames are read into, and the bodies of
+// deliveries are assembled into when Config.PoolDeliveryBodies is set.
+var slabs sync.Pool
+
+// slab is a pooled buffer.  Its generation changes every time it is released,
+// so that a stale reference to it, such as a copy of a delivery acknowledged
+// twice, cannot release it again once it is reused.
+type slab struct {
+	buf []byte
+	gen atomic.Uint64
+}
+
+// slabRef references a slab for the generation it was handed out.
+type slabRef struct {
+	s   *slab
+	gen uint64
+}
+
+// getSlab returns a buffer of n bytes, from the pool when one of the pooled
+// buffers is large enough.
+func getSlab(n int) ([]byte, slabRef) {
+	s, _ := slabs.Get().(*slab)
+	if s == nil || cap(s.buf) < n {
+		if s != nil {
+			slabs.Put(s)
+		}
+		s = &slab{buf: make([]byte, n)}
+	}
+	return s.buf[:n], slabRef{s: s, gen: s.gen.Load()}
+}
+
+// release returns the slab to the pool, once, after which the buffer must not
+// be used anymore.
+func (r slabRef) release() {
+	if r.s == nil || !r.s.gen.CompareAndSwap(r.gen, r.gen+1) {
+		return
+	}
+	if cap(r.s.buf) > maxPooledBuffer {
+		return
+	}
+	slabs.Put(r.s)
+}
+
+// releaseFrame returns the buffer a body frame was read into to the pool.
+func releaseFrame(f frame) {
+	if bf, ok := f.(*bodyFrame); ok {
+		bf.slab.release()
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestSlabReleasedOnce(t *testing.T) {
+	buf, ref := getSlab(16)
+	if len(buf) != 16 || ref.s == nil {
+		t.Fatalf("expected a slab of 16 bytes, got %d bytes", len(buf))
+	}
+
+	stale := ref
+	ref.release()
+	if want, got := stale.gen+1, ref.s.gen.Load(); want != got {
+		t.Fatalf("expected the release to advance the generation to %d, got %d", want, got)
+	}
+
+	// A stale reference, such as a copy of a delivery acknowledged twice,
+	// must not release the slab again.
+	stale.release()
+	if want, got := stale.gen+1, ref.s.gen.Load(); want != got {
+		t.Errorf("expected a stale release to be ignored, got generation %d", got)
+	}
+
+	(slabRef{}).release()
+}
+
+func TestPooledFramesRoundTrip(t *testing.T) {
+	var wire bytes.Buffer
+	w := &writer{&wire}
+
+	frames := []frame{
+		&methodFrame{ChannelId: 1, Method: &basicPublish{Exchange: "e", RoutingKey: "k"}},
+		&headerFrame{ChannelId: 1, ClassId: 60, Size: 5, Properties: properties{ContentType: "text/plain"}},
+		&bodyFrame{ChannelId: 1, Body: []byte("hello")},
+		&methodFrame{ChannelId: 1, Method: &basicPublish{Exchange: "other", RoutingKey: "key"}},
+	}
+	for _, f := range frames {
+		if err := w.WriteFrame(f); err != nil {
+			t.Fatalf("could not write %#v: %v", f, err)
+		}
+	}
+
+	r := &reader{&wire}
+
+	read := make([]frame, 0, len(frames))
+	for range frames {
+		f, err := r.ReadFrame()
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		read = append(read, f)
+	}
+
+	if m := read[0].(*methodFrame).Method.(*basicPublish); m.Exchange != "e" || m.RoutingKey != "k" {
+		t.Errorf("expected the first publish to survive the reuse of its payload, got %+v", m)
+	}
+	if h := read[1].(*headerFrame); h.Properties.ContentType != "text/plain" || h.Size != 5 {
+		t.Errorf("unexpected header frame %+v", h)
+	}
+	body := read[2].(*bodyFrame)
+	if string(body.Body) != "hello" || body.slab.s == nil {
+		t.Errorf("expected a pooled body frame, got %+v", body)
+	}
+	releaseFrame(body)
+
+	if _, err := r.ReadFrame(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestDeliveryBodyReleasedOnAck(t *testing.T) {
+	for _, pool := range []bool{true, false} {
+		rwc, srv := newSession(t)
+
+		go func() {
+			srv.connectionOpen()
+			srv.channelOpen(1)
+
+			srv.recv(1, &basicConsume{})
+			srv.send(1, &basicConsumeOk{ConsumerTag: "tag"})
+
+			srv.send(1, &basicDeliver{ConsumerTag: "tag", DeliveryTag: 1, Body: []byte("pooled")})
+			srv.recv(1, &basicAck{})
+
+			srv.connectionClose()
+		}()
+
+		config := defaultConfig()
+		config.PoolDeliveryBodies = pool
+
+		c, err := Open(rwc, config)
+		if err != nil {
+			t.Fatalf("could not create connection: %v", err)
+		}
+
+		ch, err := c.Channel()
+		if err != nil {
+			t.Fatalf("could not open channel: %v", err)
+		}
+
+		deliveries, err := ch.ConsumeWithContext(context.Background(), "q", "tag", false, false, false, false, nil)
+		if err != nil {
+			t.Fatalf("could not consume: %v", err)
+		}
+
+		d := <-deliveries
+		if string(d.Body) != "pooled" {
+			t.Fatalf("unexpected body %q", d.Body)
+		}
+		if pooled := d.slab.s != nil; pooled != pool {
+			t.Errorf("with PoolDeliveryBodies %v, expected the body to be pooled: %v", pool, pool)
+		}
+		if d.Clone().slab.s != nil {
+			t.Errorf("expected a clone not to release the body it copied")
+		}
+
+		if err := d.Ack(false); err != nil {
+			t.Fatalf("could not ack: %v", err)
+		}
+		if d.slab.s != nil && d.slab.s.gen.Load() == d.slab.gen {
+			t.Errorf("expected the body to be released by the ack")
+		}
+
+		if err := c.Close(); err != nil {
+			t.Fatalf("connection close error: %v", err)
+		}
+		rwc.Close()
+	}
+}
+
+func BenchmarkPooledMethodFrameWrite(b *testing.B) {
+	f := &methodFrame{ChannelId: 1, Method: &basicPublish{Exchange: "events", RoutingKey: "order.created"}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := f.write(io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
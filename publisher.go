@@ -0,0 +1,59 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "context"
+
+// ContextPublisher publishes with context aware methods only.  Depend on it
+// instead of *Channel in code that publishes, so that it cannot call the
+// deprecated context-free methods.  Channel implements ContextPublisher.
+type ContextPublisher interface {
+	PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) error
+	PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error)
+}
+
+// LegacyPublisher is the deprecated context-free publishing interface of
+// Channel, still implemented by wrappers and test doubles written against it.
+type LegacyPublisher interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg Publishing) error
+	PublishWithDeferredConfirm(exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error)
+}
+
+var _ ContextPublisher = (*Channel)(nil)
+
+/*
+NewContextPublisher adapts a LegacyPublisher to ContextPublisher, so that
+callers can migrate to the context aware methods before the legacy
+implementation does.
+
+When p already implements ContextPublisher, as Channel does, it is returned
+as is.  Otherwise the context is checked before each publish and
+context.Cause returned when it is done, as a legacy publish cannot be
+interrupted once started.
+*/
+func NewContextPublisher(p LegacyPublisher) ContextPublisher {
+	if cp, ok := p.(ContextPublisher); ok {
+		return cp
+	}
+	return legacyPublisher{p}
+}
+
+type legacyPublisher struct {
+	p LegacyPublisher
+}
+
+func (l legacyPublisher) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) error {
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return l.p.Publish(exchange, key, mandatory, immediate, msg)
+}
+
+func (l legacyPublisher) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	return l.p.PublishWithDeferredConfirm(exchange, key, mandatory, immediate, msg)
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type recordingLegacyPublisher struct {
+	published []string
+}
+
+func (r *recordingLegacyPublisher) Publish(exchange, key string, mandatory, immediate bool, msg Publishing) error {
+	r.published = append(r.published, key)
+	return nil
+}
+
+func (r *recordingLegacyPublisher) PublishWithDeferredConfirm(exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	return nil, r.Publish(exchange, key, mandatory, immediate, msg)
+}
+
+func TestNewContextPublisherAdaptsLegacyPublisher(t *testing.T) {
+	legacy := &recordingLegacyPublisher{}
+	p := NewContextPublisher(legacy)
+
+	if err := p.PublishWithContext(context.Background(), "", "q", false, false, Publishing{}); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := p.PublishWithDeferredConfirmWithContext(ctx, "", "cancelled", false, false, Publishing{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if want, got := []string{"q"}, legacy.published; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("expected only %v to be published, got %v", want, got)
+	}
+}
+
+func TestNewContextPublisherReturnsChannel(t *testing.T) {
+	ch := &Channel{}
+	if p := NewContextPublisher(ch); p != ContextPublisher(ch) {
+		t.Errorf("expected the channel to be returned as is, got %T", p)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"strconv"
+	"time"
+)
+
+// defaultPublishingContentType is the content type of the publishings built
+// with NewPublishing, unless set.
+const defaultPublishingContentType = "application/octet-stream"
+
+/*
+PublishingBuilder builds a Publishing with chained calls, from NewPublishing:
+
+	msg := amqp.NewPublishing(body).
+		Persistent().
+		ContentType("application/json").
+		WithHeader("tenant", tenant).
+		Expire(30 * time.Second).
+		Build()
+
+Each call returns the builder, which must not be used once built.
+*/
+type PublishingBuilder struct {
+	msg Publishing
+}
+
+// NewPublishing returns a builder of a transient Publishing of body, with the
+// application/octet-stream content type.
+func NewPublishing(body []byte) *PublishingBuilder {
+	return &PublishingBuilder{msg: Publishing{
+		ContentType:  defaultPublishingContentType,
+		DeliveryMode: Transient,
+		Body:         body,
+	}}
+}
+
+// Build returns the Publishing.
+func (b *PublishingBuilder) Build() Publishing {
+	return b.msg
+}
+
+// Persistent makes the server store the message on disk, see Persistent.
+func (b *PublishingBuilder) Persistent() *PublishingBuilder {
+	b.msg.DeliveryMode = Persistent
+	return b
+}
+
+// Transient keeps the message in memory only, see Transient.
+func (b *PublishingBuilder) Transient() *PublishingBuilder {
+	b.msg.DeliveryMode = Transient
+	return b
+}
+
+// ContentType sets the MIME content type of the body.
+func (b *PublishingBuilder) ContentType(contentType string) *PublishingBuilder {
+	b.msg.ContentType = contentType
+	return b
+}
+
+// ContentEncoding sets the MIME content encoding of the body, such as gzip.
+func (b *PublishingBuilder) ContentEncoding(contentEncoding string) *PublishingBuilder {
+	b.msg.ContentEncoding = contentEncoding
+	return b
+}
+
+// WithHeader sets the header k to v.
+func (b *PublishingBuilder) WithHeader(k string, v interface{}) *PublishingBuilder {
+	if b.msg.Headers == nil {
+		b.msg.Headers = Table{}
+	}
+	b.msg.Headers[k] = v
+	return b
+}
+
+// Expire makes the message expire ttl after it reaches a queue, see
+// ExpirationFromDuration.
+func (b *PublishingBuilder) Expire(ttl time.Duration) *PublishingBuilder {
+	b.msg.Expiration = ExpirationFromDuration(ttl)
+	return b
+}
+
+// Priority sets the priority of the message, from 0 to 9.
+func (b *PublishingBuilder) Priority(priority uint8) *PublishingBuilder {
+	b.msg.Priority = priority
+	return b
+}
+
+// CorrelationId sets the correlation identifier of the message.
+func (b *PublishingBuilder) CorrelationId(id string) *PublishingBuilder {
+	b.msg.CorrelationId = id
+	return b
+}
+
+// ReplyTo sets the queue to reply to.
+func (b *PublishingBuilder) ReplyTo(queue string) *PublishingBuilder {
+	b.msg.ReplyTo = queue
+	return b
+}
+
+// MessageId sets the identifier of the message.
+func (b *PublishingBuilder) MessageId(id string) *PublishingBuilder {
+	b.msg.MessageId = id
+	return b
+}
+
+// Timestamp sets the timestamp of the message.
+func (b *PublishingBuilder) Timestamp(t time.Time) *PublishingBuilder {
+	b.msg.Timestamp = t
+	return b
+}
+
+// Type sets the type name of the message.
+func (b *PublishingBuilder) Type(typ string) *PublishingBuilder {
+	b.msg.Type = typ
+	return b
+}
+
+// UserId sets the id of the user publishing the message, which the server
+// checks against the user of the connection.
+func (b *PublishingBuilder) UserId(id string) *PublishingBuilder {
+	b.msg.UserId = id
+	return b
+}
+
+// AppId sets the id of the application publishing the message.
+func (b *PublishingBuilder) AppId(id string) *PublishingBuilder {
+	b.msg.AppId = id
+	return b
+}
+
+// ExpirationFromDuration returns the Publishing.Expiration of a message TTL,
+// in milliseconds rounded up.  A ttl not greater than 0 is ImmediatelyExpire.
+func ExpirationFromDuration(ttl time.Duration) string {
+	if ttl <= 0 {
+		return ImmediatelyExpire
+	}
+	ms := (ttl + time.Millisecond - 1) / time.Millisecond
+	return strconv.FormatInt(int64(ms), 10)
+}
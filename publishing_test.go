@@ -0,0 +1,86 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewPublishingDefaults(t *testing.T) {
+	msg := NewPublishing([]byte("body")).Build()
+
+	if want, got := "application/octet-stream", msg.ContentType; want != got {
+		t.Errorf("expected the default content type %q, got %q", want, got)
+	}
+	if want, got := Transient, msg.DeliveryMode; want != got {
+		t.Errorf("expected a transient publishing, got delivery mode %d", got)
+	}
+	if want, got := NeverExpire, msg.Expiration; want != got {
+		t.Errorf("expected no expiration, got %q", got)
+	}
+	if want, got := "body", string(msg.Body); want != got {
+		t.Errorf("expected the body %q, got %q", want, got)
+	}
+}
+
+func TestPublishingBuilder(t *testing.T) {
+	now := time.Now()
+	msg := NewPublishing([]byte("{}")).
+		Persistent().
+		ContentType("application/json").
+		ContentEncoding("gzip").
+		WithHeader("tenant", "acme").
+		WithHeader("attempt", int32(1)).
+		Expire(30 * time.Second).
+		Priority(5).
+		CorrelationId("corr").
+		ReplyTo("replies").
+		MessageId("id").
+		Timestamp(now).
+		Type("order.created").
+		UserId("guest").
+		AppId("orders").
+		Build()
+
+	want := Publishing{
+		Headers:         Table{"tenant": "acme", "attempt": int32(1)},
+		ContentType:     "application/json",
+		ContentEncoding: "gzip",
+		DeliveryMode:    Persistent,
+		Priority:        5,
+		CorrelationId:   "corr",
+		ReplyTo:         "replies",
+		Expiration:      "30000",
+		MessageId:       "id",
+		Timestamp:       now,
+		Type:            "order.created",
+		UserId:          "guest",
+		AppId:           "orders",
+		Body:            []byte("{}"),
+	}
+	if !reflect.DeepEqual(want, msg) {
+		t.Errorf("expected %+v, got %+v", want, msg)
+	}
+}
+
+func TestExpirationFromDuration(t *testing.T) {
+	for _, tc := range []struct {
+		ttl  time.Duration
+		want string
+	}{
+		{0, ImmediatelyExpire},
+		{-time.Second, ImmediatelyExpire},
+		{time.Nanosecond, "1"},
+		{time.Millisecond, "1"},
+		{1500 * time.Microsecond, "2"},
+		{time.Minute, "60000"},
+	} {
+		if got := ExpirationFromDuration(tc.ttl); tc.want != got {
+			t.Errorf("expected the expiration of %v to be %q, got %q", tc.ttl, tc.want, got)
+		}
+	}
+}
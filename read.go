@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"time"
 )
@@ -54,6 +55,10 @@ func (r *reader) ReadFrame() (frame frame, err error) {
 	channel := binary.BigEndian.Uint16(scratch[1:3])
 	size := binary.BigEndian.Uint32(scratch[3:7])
 
+	if l := lenient(r.r); l != nil {
+		l.decoder.channel = channel
+	}
+
 	switch typ {
 	case frameMethod:
 		if frame, err = r.parseMethodFrame(channel, size); err != nil {
@@ -151,9 +156,11 @@ func readTimestamp(r io.Reader) (v time.Time, err error) {
 'B': byte
 'd': float64
 'f': float32
+'i': uint32
 'l': int64
 's': int16
 't': bool
+'u': uint16
 'x': []byte
 */
 func readField(r io.Reader) (v interface{}, err error) {
@@ -191,6 +198,20 @@ func readField(r io.Reader) (v interface{}, err error) {
 		}
 		return value, nil
 
+	case 'u':
+		var value uint16
+		if err = binary.Read(r, binary.BigEndian, &value); err != nil {
+			return
+		}
+		return value, nil
+
+	case 'i':
+		var value uint32
+		if err = binary.Read(r, binary.BigEndian, &value); err != nil {
+			return
+		}
+		return value, nil
+
 	case 'I':
 		var value int32
 		if err = binary.Read(r, binary.BigEndian, &value); err != nil {
@@ -273,15 +294,24 @@ func readTable(r io.Reader) (table Table, err error) {
 
 	table = make(Table)
 
+	l := lenient(r)
+	fields := l.wrap(&nested)
+
 	for nested.Len() > 0 {
 		var key string
 		var value interface{}
 
-		if key, err = readShortstr(&nested); err != nil {
+		if key, err = readShortstr(fields); err != nil {
 			return
 		}
 
-		if value, err = readField(&nested); err != nil {
+		if value, err = readField(fields); err != nil {
+			if l != nil {
+				// The size of the value is unknown, the fields following
+				// it cannot be found.
+				l.warn(fmt.Errorf("dropping table field %q and the %d bytes following it: %w", key, nested.Len(), err))
+				return table, nil
+			}
 			return
 		}
 
@@ -300,14 +330,22 @@ func readArray(r io.Reader) (arr []interface{}, err error) {
 
 	var (
 		lim   = &io.LimitedReader{R: r, N: int64(size)}
+		l     = lenient(r)
 		field interface{}
 	)
 
 	for {
-		if field, err = readField(lim); err != nil {
+		if field, err = readField(l.wrap(lim)); err != nil {
 			if err == io.EOF {
 				break
 			}
+			if l != nil {
+				l.warn(fmt.Errorf("dropping array field %d and the %d bytes following it: %w", len(arr), lim.N, err))
+				if _, err = io.Copy(io.Discard, lim); err != nil {
+					return nil, err
+				}
+				break
+			}
 			return nil, err
 		}
 		arr = append(arr, field)
@@ -322,95 +360,109 @@ func hasProperty(mask uint16, prop int) bool {
 }
 
 func (r *reader) parseHeaderFrame(channel uint16, size uint32) (frame frame, err error) {
-	hf := &headerFrame{
+	if l := lenient(r.r); l != nil {
+		return l.parseHeaderFrame(channel, size)
+	}
+
+	hf, err := readHeaderFrame(r.r, channel)
+	if err != nil {
+		return nil, err
+	}
+	return hf, nil
+}
+
+// readHeaderFrame reads the payload of a header frame.  On error, the frame is
+// returned with the properties read before when its size could be read.
+func readHeaderFrame(r io.Reader, channel uint16) (hf *headerFrame, err error) {
+	hf = &headerFrame{
 		ChannelId: channel,
 	}
 
-	if err = binary.Read(r.r, binary.BigEndian, &hf.ClassId); err != nil {
-		return
+	if err = binary.Read(r, binary.BigEndian, &hf.ClassId); err != nil {
+		return nil, err
 	}
 
-	if err = binary.Read(r.r, binary.BigEndian, &hf.weight); err != nil {
-		return
+	if err = binary.Read(r, binary.BigEndian, &hf.weight); err != nil {
+		return nil, err
 	}
 
-	if err = binary.Read(r.r, binary.BigEndian, &hf.Size); err != nil {
-		return
+	if err = binary.Read(r, binary.BigEndian, &hf.Size); err != nil {
+		return nil, err
 	}
 
 	var flags uint16
 
-	if err = binary.Read(r.r, binary.BigEndian, &flags); err != nil {
+	if err = binary.Read(r, binary.BigEndian, &flags); err != nil {
 		return
 	}
 
 	if hasProperty(flags, flagContentType) {
-		if hf.Properties.ContentType, err = readShortstr(r.r); err != nil {
+		if hf.Properties.ContentType, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagContentEncoding) {
-		if hf.Properties.ContentEncoding, err = readShortstr(r.r); err != nil {
+		if hf.Properties.ContentEncoding, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagHeaders) {
-		if hf.Properties.Headers, err = readTable(r.r); err != nil {
+		if hf.Properties.Headers, err = readTable(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagDeliveryMode) {
-		if err = binary.Read(r.r, binary.BigEndian, &hf.Properties.DeliveryMode); err != nil {
+		if err = binary.Read(r, binary.BigEndian, &hf.Properties.DeliveryMode); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagPriority) {
-		if err = binary.Read(r.r, binary.BigEndian, &hf.Properties.Priority); err != nil {
+		if err = binary.Read(r, binary.BigEndian, &hf.Properties.Priority); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagCorrelationId) {
-		if hf.Properties.CorrelationId, err = readShortstr(r.r); err != nil {
+		if hf.Properties.CorrelationId, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagReplyTo) {
-		if hf.Properties.ReplyTo, err = readShortstr(r.r); err != nil {
+		if hf.Properties.ReplyTo, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagExpiration) {
-		if hf.Properties.Expiration, err = readShortstr(r.r); err != nil {
+		if hf.Properties.Expiration, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagMessageId) {
-		if hf.Properties.MessageId, err = readShortstr(r.r); err != nil {
+		if hf.Properties.MessageId, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagTimestamp) {
-		if hf.Properties.Timestamp, err = readTimestamp(r.r); err != nil {
+		if hf.Properties.Timestamp, err = readTimestamp(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagType) {
-		if hf.Properties.Type, err = readShortstr(r.r); err != nil {
+		if hf.Properties.Type, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagUserId) {
-		if hf.Properties.UserId, err = readShortstr(r.r); err != nil {
+		if hf.Properties.UserId, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagAppId) {
-		if hf.Properties.AppId, err = readShortstr(r.r); err != nil {
+		if hf.Properties.AppId, err = readShortstr(r); err != nil {
 			return
 		}
 	}
 	if hasProperty(flags, flagReserved1) {
-		if hf.Properties.reserved1, err = readShortstr(r.r); err != nil {
+		if hf.Properties.reserved1, err = readShortstr(r); err != nil {
 			return
 		}
 	}
@@ -421,10 +473,11 @@ func (r *reader) parseHeaderFrame(channel uint16, size uint32) (frame frame, err
 func (r *reader) parseBodyFrame(channel uint16, size uint32) (frame frame, err error) {
 	bf := &bodyFrame{
 		ChannelId: channel,
-		Body:      make([]byte, size),
 	}
+	bf.Body, bf.slab = getSlab(int(size))
 
 	if _, err = io.ReadFull(r.r, bf.Body); err != nil {
+		bf.slab.release()
 		return nil, err
 	}
 
@@ -0,0 +1,442 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RecoveringChannelOptions configures a RecoveringChannel.
+type RecoveringChannelOptions struct {
+	// RetryInterval is the delay between two attempts to open and set up a
+	// replacement channel.  Zero means one second.
+	RetryInterval time.Duration
+
+	// OnRecovered is called after the channel has been replaced and its
+	// state re-applied, or with the error of the failed attempt.  It is
+	// optional.
+	OnRecovered func(ch *Channel, err error)
+
+	// ErrorPolicy decides whether the channel is replaced or the error is
+	// escalated when the channel is closed by an error.  Nil replaces the
+	// channel on every error.
+	ErrorPolicy ErrorPolicy
+
+	// OnEscalate is called with the error when ErrorPolicy escalates it,
+	// after the RecoveringChannel has stopped.  It is optional.
+	OnEscalate func(err *Error)
+}
+
+// ErrorAction is what a RecoveringChannel does when its channel is closed by
+// an error.
+type ErrorAction int
+
+const (
+	// ErrorReopen replaces the channel.  Only the operation that failed
+	// returns the error, the consumers and the state of the channel carry
+	// over to the replacement.
+	ErrorReopen ErrorAction = iota
+
+	// ErrorEscalate treats the error as fatal: the RecoveringChannel stops
+	// recovering and is closed, its consumer chans are closed and
+	// RecoveringChannelOptions.OnEscalate is called.
+	ErrorEscalate
+)
+
+/*
+ErrorPolicy returns the action to take for the error closing a channel, for
+example to reopen the channel after a publish to a missing exchange but to
+stop when access is refused:
+
+	opts := amqp.RecoveringChannelOptions{
+		ErrorPolicy: amqp.ErrorPolicyByCode(map[int]amqp.ErrorAction{
+			amqp.NotFound:      amqp.ErrorReopen,
+			amqp.AccessRefused: amqp.ErrorEscalate,
+		}, amqp.ErrorReopen),
+		OnEscalate: func(err *amqp.Error) {
+			log.Fatalf("giving up on the channel: %v", err)
+		},
+	}
+*/
+type ErrorPolicy func(err *Error) ErrorAction
+
+// ErrorPolicyByCode returns an ErrorPolicy taking the action of the reply code
+// of the error, or fallback for the codes not in actions.
+func ErrorPolicyByCode(actions map[int]ErrorAction, fallback ErrorAction) ErrorPolicy {
+	return func(err *Error) ErrorAction {
+		if action, ok := actions[err.Code]; ok {
+			return action
+		}
+		return fallback
+	}
+}
+
+/*
+RecoveringChannel keeps a channel usable across channel and connection
+failures.  When the channel closes with an error, a replacement is obtained
+from the open function given to NewRecoveringChannel, and the Qos, Confirm and
+Consume calls made through the RecoveringChannel are re-issued on it with their
+original arguments.
+
+Deliveries of the replacement consumers are spliced into the chans returned by
+Consume, so that consumers survive broker restarts without any plumbing in
+the application.  Deliveries must still be acknowledged through the channel
+that delivered them: acknowledging a delivery received before the recovery
+fails, and the server redelivers it.
+
+To also survive connection failures, open must return a channel of a live
+connection, for instance one re-dialed by the application.  A graceful
+Channel.Close of the underlying channel is not recovered from, nor are the
+errors escalated by RecoveringChannelOptions.ErrorPolicy.
+*/
+type RecoveringChannel struct {
+	open func() (*Channel, error)
+	opts RecoveringChannelOptions
+
+	m         sync.Mutex // protects below
+	ch        *Channel
+	qos       *recoveringQos
+	confirm   bool
+	consumers []*recoveringConsumer
+	closed    bool
+	escalated *Error // set when closed by ErrorPolicy
+
+	clock Clock // of the connection of the first channel, for the retries
+	done  chan struct{}
+}
+
+type recoveringQos struct {
+	prefetchCount int
+	prefetchSize  int
+	global        bool
+}
+
+type recoveringConsumer struct {
+	queue     string
+	tag       string
+	autoAck   bool
+	exclusive bool
+	noLocal   bool
+	noWait    bool
+	args      Table
+	opts      []ConsumeOption
+
+	sources chan consumerSource
+	out     chan Delivery
+}
+
+// consumerSource is the deliveries of a consumer on one channel.
+type consumerSource struct {
+	ch         *Channel
+	deliveries <-chan Delivery
+}
+
+// NewRecoveringChannel opens the first channel with open and starts watching
+// it for failures.
+func NewRecoveringChannel(open func() (*Channel, error), opts RecoveringChannelOptions) (*RecoveringChannel, error) {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = time.Second
+	}
+
+	ch, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RecoveringChannel{
+		open:  open,
+		opts:  opts,
+		ch:    ch,
+		clock: SystemClock,
+		done:  make(chan struct{}),
+	}
+	if ch.connection != nil {
+		r.clock = ch.connection.clock()
+	}
+
+	go r.watch(ch.NotifyClose(make(chan *Error, 1)))
+
+	return r, nil
+}
+
+// Channel returns the current underlying channel, to publish or declare the
+// topology on.  State set directly on it is not re-applied on recovery.
+func (r *RecoveringChannel) Channel() *Channel {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.ch
+}
+
+// Qos calls Channel.Qos and re-applies it on every replacement channel.
+func (r *RecoveringChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.ch.Qos(prefetchCount, prefetchSize, global); err != nil {
+		return err
+	}
+
+	r.qos = &recoveringQos{prefetchCount, prefetchSize, global}
+	return nil
+}
+
+// Confirm calls Channel.Confirm and puts every replacement channel in confirm
+// mode.  Publishings left unconfirmed when the channel fails are not
+// confirmed by the replacement channel.
+func (r *RecoveringChannel) Confirm(noWait bool) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := r.ch.Confirm(noWait); err != nil {
+		return err
+	}
+
+	r.confirm = true
+	return nil
+}
+
+/*
+Consume calls Channel.ConsumeWithOptions and re-registers the consumer with the
+same arguments on every replacement channel.  When consumer is empty, a unique
+consumer tag is generated once and kept across recoveries.
+
+The returned chan stays open across recoveries and is closed by Close, or when
+the consumer is cancelled by the server.
+*/
+func (r *RecoveringChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table, opts ...ConsumeOption) (<-chan Delivery, error) {
+	if consumer == "" {
+		consumer = uniqueConsumerTag()
+	}
+
+	c := &recoveringConsumer{
+		queue:     queue,
+		tag:       consumer,
+		autoAck:   autoAck,
+		exclusive: exclusive,
+		noLocal:   noLocal,
+		noWait:    noWait,
+		args:      args,
+		opts:      opts,
+		sources:   make(chan consumerSource, 1),
+		out:       make(chan Delivery),
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed {
+		return nil, r.closedErr()
+	}
+
+	deliveries, err := c.consume(r.ch)
+	if err != nil {
+		return nil, err
+	}
+
+	c.sources <- consumerSource{r.ch, deliveries}
+	r.consumers = append(r.consumers, c)
+
+	go r.forward(c)
+
+	return c.out, nil
+}
+
+// Close stops recovering, closes the underlying channel and the chans returned
+// by Consume.
+func (r *RecoveringChannel) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed {
+		return r.closedErr()
+	}
+
+	r.closed = true
+	close(r.done)
+
+	return r.ch.Close()
+}
+
+// closedErr returns the escalated error once closed by ErrorPolicy, and
+// ErrClosed otherwise.
+func (r *RecoveringChannel) closedErr() error {
+	if r.escalated != nil {
+		return r.escalated
+	}
+	return ErrClosed
+}
+
+// watch waits for the current channel to fail and replaces it until the
+// RecoveringChannel is closed.
+func (r *RecoveringChannel) watch(closes chan *Error) {
+	for {
+		select {
+		case err, ok := <-closes:
+			if !ok || err == nil {
+				// graceful close
+				return
+			}
+			if r.escalate(err) {
+				return
+			}
+		case <-r.done:
+			return
+		}
+
+		for {
+			var err error
+			if closes, err = r.recover(); err == nil {
+				break
+			}
+
+			timer := r.clock.NewTimer(r.opts.RetryInterval)
+			select {
+			case <-timer.C():
+			case <-r.done:
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// escalate stops the RecoveringChannel when ErrorPolicy escalates err.
+func (r *RecoveringChannel) escalate(err *Error) bool {
+	if r.opts.ErrorPolicy == nil || r.opts.ErrorPolicy(err) != ErrorEscalate {
+		return false
+	}
+
+	r.m.Lock()
+	if r.closed {
+		r.m.Unlock()
+		return true
+	}
+	r.closed = true
+	r.escalated = err
+	close(r.done)
+	r.m.Unlock()
+
+	if r.opts.OnEscalate != nil {
+		r.opts.OnEscalate(err)
+	}
+
+	return true
+}
+
+// recover opens a replacement channel and re-applies the recorded state on
+// it.  It returns the chan notified when the replacement closes.
+func (r *RecoveringChannel) recover() (closes chan *Error, err error) {
+	var ch *Channel
+	defer func() {
+		if r.opts.OnRecovered != nil {
+			r.opts.OnRecovered(ch, err)
+		}
+	}()
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.closed {
+		return nil, ErrClosed
+	}
+
+	if ch, err = r.open(); err != nil {
+		return nil, err
+	}
+	closes = ch.NotifyClose(make(chan *Error, 1))
+
+	if err = r.apply(ch); err != nil {
+		_ = ch.Close()
+		ch = nil
+		return nil, err
+	}
+
+	r.ch = ch
+
+	return closes, nil
+}
+
+func (r *RecoveringChannel) apply(ch *Channel) error {
+	if r.qos != nil {
+		if err := ch.Qos(r.qos.prefetchCount, r.qos.prefetchSize, r.qos.global); err != nil {
+			return err
+		}
+	}
+
+	if r.confirm {
+		if err := ch.Confirm(false); err != nil {
+			return err
+		}
+	}
+
+	sources := make([]<-chan Delivery, 0, len(r.consumers))
+	for _, c := range r.consumers {
+		deliveries, err := c.consume(ch)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, deliveries)
+	}
+
+	// Only splice once every consumer is registered, a failed attempt is
+	// retried from scratch on another channel.  A source not picked up yet
+	// belongs to a dead channel and is replaced.
+	for i, c := range r.consumers {
+		select {
+		case <-c.sources:
+		default:
+		}
+		c.sources <- consumerSource{ch, sources[i]}
+	}
+
+	return nil
+}
+
+func (c *recoveringConsumer) consume(ch *Channel) (<-chan Delivery, error) {
+	return ch.ConsumeWithOptions(context.Background(), c.queue, c.tag, c.autoAck, c.exclusive, c.noLocal, c.noWait, c.args, c.opts...)
+}
+
+// forward splices the deliveries of successive channels into the chan of the
+// consumer.  A source closed while its channel is still open means the
+// consumer was cancelled, which ends the consumer.
+func (r *RecoveringChannel) forward(c *recoveringConsumer) {
+	defer close(c.out)
+
+	for {
+		var src consumerSource
+		select {
+		case src = <-c.sources:
+		case <-r.done:
+			return
+		}
+
+		for d := range src.deliveries {
+			select {
+			case c.out <- d:
+			case <-r.done:
+				return
+			}
+		}
+
+		if !src.ch.IsClosed() {
+			r.removeConsumer(c)
+			return
+		}
+	}
+}
+
+func (r *RecoveringChannel) removeConsumer(c *recoveringConsumer) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for i, other := range r.consumers {
+		if other == c {
+			r.consumers = append(r.consumers[:i], r.consumers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRecoveringChannelReregistersConsumer(t *testing.T) {
+	const tag = "recovering"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	received := make(chan struct{})
+	consumes := make(chan *basicConsume, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicQos{})
+		srv.send(1, &basicQosOk{})
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+		consumes <- srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+		<-received
+
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "queue deleted"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(2)
+		srv.recv(2, &basicQos{})
+		srv.send(2, &basicQosOk{})
+		srv.recv(2, &confirmSelect{})
+		srv.send(2, &confirmSelectOk{})
+		consumes <- srv.recv(2, &basicConsume{}).(*basicConsume)
+		srv.send(2, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(2, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1})
+
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	recovered := make(chan *Channel, 1)
+	r, err := NewRecoveringChannel(c.Channel, RecoveringChannelOptions{
+		RetryInterval: 10 * time.Millisecond,
+		OnRecovered: func(ch *Channel, err error) {
+			if err != nil {
+				t.Errorf("unexpected recovery error: %v", err)
+			}
+			recovered <- ch
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not open recovering channel: %v", err)
+	}
+
+	if err := r.Qos(10, 0, false); err != nil {
+		t.Fatalf("qos error: %v", err)
+	}
+	if err := r.Confirm(false); err != nil {
+		t.Fatalf("confirm error: %v", err)
+	}
+
+	deliveries, err := r.Consume("q", tag, false, true, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	first := <-deliveries
+	close(received)
+
+	second, ok := <-deliveries
+	if !ok {
+		t.Fatal("expected the deliveries chan to survive the channel failure")
+	}
+
+	replacement := <-recovered
+	if first.Acknowledger == second.Acknowledger {
+		t.Errorf("expected the second delivery to come from the replacement channel")
+	}
+	if want, got := Acknowledger(replacement), second.Acknowledger; want != got {
+		t.Errorf("expected the delivery to be acknowledged through %v, got %v", want, got)
+	}
+	if want, got := replacement, r.Channel(); want != got {
+		t.Errorf("expected Channel to return the replacement channel")
+	}
+
+	before, after := <-consumes, <-consumes
+	if before.Queue != after.Queue || before.ConsumerTag != after.ConsumerTag || before.Exclusive != after.Exclusive {
+		t.Errorf("expected the consumer to be re-registered with %+v, got %+v", before, after)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("close error: %v", err)
+	}
+
+	if _, ok := <-deliveries; ok {
+		t.Error("expected the deliveries chan to be closed by Close")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestRecoveringChannelErrorPolicy(t *testing.T) {
+	const tag = "policy"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "no exchange"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(2)
+		srv.recv(2, &basicConsume{})
+		srv.send(2, &basicConsumeOk{ConsumerTag: tag})
+
+		srv.send(2, &channelClose{ReplyCode: AccessRefused, ReplyText: "access refused"})
+		srv.recv(2, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	recovered := make(chan struct{}, 1)
+	escalated := make(chan *Error, 1)
+
+	r, err := NewRecoveringChannel(c.Channel, RecoveringChannelOptions{
+		RetryInterval: 10 * time.Millisecond,
+		ErrorPolicy: ErrorPolicyByCode(map[int]ErrorAction{
+			AccessRefused: ErrorEscalate,
+		}, ErrorReopen),
+		OnRecovered: func(_ *Channel, err error) {
+			if err != nil {
+				t.Errorf("unexpected recovery error: %v", err)
+			}
+			recovered <- struct{}{}
+		},
+		OnEscalate: func(err *Error) { escalated <- err },
+	})
+	if err != nil {
+		t.Fatalf("could not open recovering channel: %v", err)
+	}
+
+	deliveries, err := r.Consume("q", tag, true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	<-recovered
+
+	if err := <-escalated; err.Code != AccessRefused {
+		t.Errorf("expected the access refused error to be escalated, got %v", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Error("expected the deliveries chan to be closed once escalated")
+	}
+	select {
+	case <-recovered:
+		t.Error("expected the escalated error not to be recovered from")
+	default:
+	}
+
+	var amqpErr *Error
+	if _, err := r.Consume("q", "", true, false, false, false, nil); !errors.As(err, &amqpErr) || amqpErr.Code != AccessRefused {
+		t.Errorf("expected the escalated error after escalation, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
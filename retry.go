@@ -0,0 +1,128 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RetryLevelHeader is the header holding the retry level of a message
+// republished with Delivery.RetryLater.
+const RetryLevelHeader = "x-retry-level"
+
+// ErrRetryQueueUnknown is returned by Delivery.RetryLater for deliveries that
+// were not received from a consumer of the channel, such as from Channel.Get.
+var ErrRetryQueueUnknown = errors.New("queue of the delivery is unknown")
+
+// RetryQueueName returns the name of the wait queue of queue for a retry level,
+// numbered from 1.
+func RetryQueueName(queue string, level int) string {
+	return queue + ".retry." + strconv.Itoa(level)
+}
+
+/*
+RetryTopology returns the wait queues of the delayed retry pattern for queue,
+one per delay.  The wait queue of level i+1 holds the messages for delays[i],
+then dead-letters them back to queue through the default exchange:
+
+	topo := amqp.RetryTopology("orders", time.Second, 10*time.Second, time.Minute)
+	err := amqp.ApplyTopologyParallel(ctx, conn, topo, 1)
+
+Use DeclareRetryTopology to declare them on a channel, and Delivery.RetryLater
+to retry a delivery of queue.
+*/
+func RetryTopology(queue string, delays ...time.Duration) Topology {
+	var topo Topology
+	for i, delay := range delays {
+		topo.Queues = append(topo.Queues, QueueSpec{
+			Name:    RetryQueueName(queue, i+1),
+			Durable: true,
+			Args: Table{
+				QueueMessageTTLArg:          delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		})
+	}
+	return topo
+}
+
+// DeclareRetryTopology declares the wait queues of RetryTopology on ch.
+func DeclareRetryTopology(ch *Channel, queue string, delays ...time.Duration) error {
+	for _, q := range RetryTopology(queue, delays...).Queues {
+		if _, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetryLevel returns the retry level of the delivery set by RetryLater, 0 when
+// it has not been retried.
+func (d Delivery) RetryLevel() int {
+	switch level := d.Headers[RetryLevelHeader].(type) {
+	case int32:
+		return int(level)
+	case int64:
+		return int(level)
+	case int:
+		return level
+	}
+	return 0
+}
+
+/*
+RetryLater republishes a copy of the delivery to the wait queue of the retry
+level declared with DeclareRetryTopology, from which it returns to the queue
+it was consumed from once its delay expires, and acknowledges the delivery.
+The copy carries the level in RetryLevelHeader, so a handler can retry with
+increasing delays:
+
+	if err := process(d); err != nil {
+		if level := d.RetryLevel() + 1; level <= 3 {
+			return d.RetryLater(level)
+		}
+		return d.Nack(false, false)
+	}
+
+The Expiration of the delivery is not copied, as it would cut the delay
+short.  The copy is published as mandatory, so a level without a wait queue is
+returned to Channel.NotifyReturn listeners rather than dropped silently.  The
+delivery is not acknowledged when the copy cannot be published.  Deliveries
+must come from a consumer of a Channel, otherwise ErrRetryQueueUnknown is
+returned.
+*/
+func (d Delivery) RetryLater(level int) error {
+	if d.Acknowledger == nil {
+		return ErrDeliveryNotInitialized
+	}
+
+	ch, ok := d.Acknowledger.(*Channel)
+	if !ok {
+		return ErrRetryQueueUnknown
+	}
+	opts, ok := ch.consumers.options(d.ConsumerTag)
+	if !ok || d.ConsumerTag == "" {
+		return ErrRetryQueueUnknown
+	}
+
+	msg := d.ToPublishing()
+	if msg.Headers == nil {
+		msg.Headers = make(Table, 1)
+	}
+	msg.Headers[RetryLevelHeader] = int32(level)
+	// The wait queue sets how long the copy waits.
+	msg.Expiration = ""
+
+	if err := ch.PublishWithContext(context.Background(), "", RetryQueueName(opts.queue, level), true, false, msg); err != nil {
+		return fmt.Errorf("retry message %d at level %d: %w", d.DeliveryTag, level, err)
+	}
+
+	return d.Ack(false)
+}
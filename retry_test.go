@@ -0,0 +1,95 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryLater(t *testing.T) {
+	const tag = "retrying"
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	declares := make(chan *queueDeclare, 2)
+	published := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for i := 0; i < 2; i++ {
+			declares <- srv.recv(1, &queueDeclare{}).(*queueDeclare)
+			srv.send(1, &queueDeclareOk{})
+		}
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: tag})
+		srv.send(1, &basicDeliver{ConsumerTag: tag, DeliveryTag: 1,
+			Properties: properties{MessageId: "m-1", Headers: Table{RetryLevelHeader: int32(1)}},
+			Body:       []byte("job"),
+		})
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		srv.recv(1, &basicAck{})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	if err := DeclareRetryTopology(ch, "orders", time.Second, time.Minute); err != nil {
+		t.Fatalf("declare error: %v", err)
+	}
+	for i, ttl := range []int64{1000, 60000} {
+		q := <-declares
+		if want := RetryQueueName("orders", i+1); q.Queue != want || !q.Durable {
+			t.Errorf("expected the durable wait queue %q, got %+v", want, q)
+		}
+		if q.Arguments[QueueMessageTTLArg] != ttl || q.Arguments["x-dead-letter-routing-key"] != "orders" {
+			t.Errorf("expected the wait queue to dead-letter to orders after %dms, got %v", ttl, q.Arguments)
+		}
+	}
+
+	if err := (Delivery{Acknowledger: ch}).RetryLater(1); !errors.Is(err, ErrRetryQueueUnknown) {
+		t.Errorf("expected ErrRetryQueueUnknown for a delivery without consumer, got %v", err)
+	}
+
+	deliveries, err := ch.Consume("orders", tag, false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("consume error: %v", err)
+	}
+
+	d := <-deliveries
+	if err := d.RetryLater(d.RetryLevel() + 1); err != nil {
+		t.Fatalf("retry error: %v", err)
+	}
+
+	pub := <-published
+	if want := "orders.retry.2"; pub.Exchange != "" || pub.RoutingKey != want || !pub.Mandatory {
+		t.Errorf("expected a mandatory publish to %q, got %+v", want, pub)
+	}
+	if retried := (Delivery{Headers: pub.Properties.Headers}); retried.RetryLevel() != 2 {
+		t.Errorf("expected the retry level to be 2, got %v", pub.Properties.Headers)
+	}
+	if pub.Properties.MessageId != "m-1" || string(pub.Body) != "job" {
+		t.Errorf("expected the message to be copied, got %+v", pub)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
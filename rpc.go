@@ -0,0 +1,93 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrNoReplyTo is returned by Channel.PublishReply when the request has no
+// ReplyTo address.
+var ErrNoReplyTo = errors.New("request delivery has no reply-to address")
+
+/*
+Reply returns reply with the metadata of the request delivery d propagated, so
+that the reply honours what the caller expects from it:
+
+  - CorrelationId is copied so the caller can match the reply
+  - Priority is copied so the reply is not overtaken by lower priority traffic
+  - Expiration is set to what remains of the expiration of the request, when
+    the request carries a Timestamp, or copied as is otherwise
+
+Fields already set on reply override the propagated ones.
+*/
+func (d Delivery) Reply(reply Publishing) Publishing {
+	if reply.CorrelationId == "" {
+		reply.CorrelationId = d.CorrelationId
+	}
+	if reply.Priority == 0 {
+		reply.Priority = d.Priority
+	}
+	if reply.Expiration == "" {
+		reply.Expiration = remainingExpiration(d.Expiration, d.Timestamp, d.now())
+	}
+	return reply
+}
+
+// remainingExpiration returns the part of the expiration in milliseconds left
+// at now for a message sent at timestamp.  An expired message has 0 left.
+func remainingExpiration(expiration string, timestamp, now time.Time) string {
+	if expiration == "" || timestamp.IsZero() {
+		return expiration
+	}
+
+	ttl, err := strconv.ParseInt(expiration, 10, 64)
+	if err != nil {
+		return expiration
+	}
+
+	remaining := ttl - now.Sub(timestamp).Milliseconds()
+	if remaining < 0 {
+		remaining = 0
+	}
+	if remaining > ttl {
+		// the clocks disagree, never extend the expiration
+		remaining = ttl
+	}
+
+	return strconv.FormatInt(remaining, 10)
+}
+
+/*
+PublishReply publishes the reply to a request delivery on the default exchange
+with the ReplyTo of the request as routing key, with the metadata of the
+request propagated as described by Delivery.Reply.
+
+When the request advertises encodings with AcceptEncodingHeader and the reply
+is not encoded yet, a reply body of at least 1 KiB is compressed with the
+encoding negotiated by Delivery.AcceptedEncoding.
+
+ErrNoReplyTo is returned when the request has no ReplyTo.
+*/
+func (ch *Channel) PublishReply(ctx context.Context, request Delivery, reply Publishing) error {
+	if request.ReplyTo == "" {
+		return ErrNoReplyTo
+	}
+
+	if reply.ContentEncoding == "" && len(reply.Body) >= replyCompressMinSize {
+		if err := reply.EncodeBody(request.AcceptedEncoding()); err != nil {
+			return err
+		}
+	}
+
+	return ch.PublishWithContext(ctx, "", request.ReplyTo, false, false, request.Reply(reply))
+}
+
+// replyCompressMinSize is the size below which compressing a reply is not
+// worth the overhead.
+const replyCompressMinSize = 1024
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeliveryReplyPropagatesMetadata(t *testing.T) {
+	request := Delivery{
+		CorrelationId: "req-1",
+		Priority:      7,
+		Expiration:    "60000",
+	}
+
+	reply := request.Reply(Publishing{Body: []byte("pong")})
+	if reply.CorrelationId != "req-1" || reply.Priority != 7 || reply.Expiration != "60000" {
+		t.Errorf("expected the request metadata to be propagated, got %+v", reply)
+	}
+
+	reply = request.Reply(Publishing{CorrelationId: "other", Priority: 1, Expiration: "10"})
+	if reply.CorrelationId != "other" || reply.Priority != 1 || reply.Expiration != "10" {
+		t.Errorf("expected the reply fields to override the request, got %+v", reply)
+	}
+}
+
+func TestRemainingExpiration(t *testing.T) {
+	sent := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expiration string
+		timestamp  time.Time
+		now        time.Time
+		want       string
+	}{
+		{"", sent, sent.Add(time.Second), ""},
+		{"5000", time.Time{}, sent, "5000"},
+		{"5000", sent, sent.Add(2 * time.Second), "3000"},
+		{"5000", sent, sent.Add(time.Minute), "0"},
+		{"5000", sent, sent.Add(-time.Minute), "5000"},
+		{"soon", sent, sent, "soon"},
+	}
+
+	for _, tt := range tests {
+		if got := remainingExpiration(tt.expiration, tt.timestamp, tt.now); got != tt.want {
+			t.Errorf("remainingExpiration(%q, +%s): expected %q, got %q", tt.expiration, tt.now.Sub(tt.timestamp), tt.want, got)
+		}
+	}
+}
+
+func TestPublishReplyWithoutReplyTo(t *testing.T) {
+	ch := &Channel{}
+	if err := ch.PublishReply(context.Background(), Delivery{}, Publishing{}); !errors.Is(err, ErrNoReplyTo) {
+		t.Errorf("expected ErrNoReplyTo, got %v", err)
+	}
+}
+
+func TestPublishReplyCompressesForAdvertisedEncodings(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	replies := make(chan *basicPublish, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		replies <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		replies <- srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	payload := strings.Repeat("reply ", 500)
+	request := Delivery{ReplyTo: "caller", Headers: Table{AcceptEncodingHeader: "gzip"}}
+
+	if err := ch.PublishReply(context.Background(), request, Publishing{Body: []byte(payload)}); err != nil {
+		t.Fatalf("publish reply error: %v", err)
+	}
+	if err := ch.PublishReply(context.Background(), request, Publishing{Body: []byte("small")}); err != nil {
+		t.Fatalf("publish reply error: %v", err)
+	}
+
+	large := <-replies
+	if want, got := "gzip", large.Properties.ContentEncoding; want != got {
+		t.Fatalf("expected the large reply to be encoded with %q, got %q", want, got)
+	}
+	body, err := Delivery{ContentEncoding: "gzip", Body: large.Body}.DecodedBody()
+	if err != nil || string(body) != payload {
+		t.Errorf("expected the reply to decode back, got %v", err)
+	}
+
+	if small := <-replies; small.Properties.ContentEncoding != "" {
+		t.Errorf("expected the small reply not to be encoded, got %q", small.Properties.ContentEncoding)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrNoShards is returned by ShardedPublisher.Publish when none of its
+// connections is able to accept publishings.
+var ErrNoShards = errors.New("no open connection to publish on")
+
+// ShardedPublisherOptions configures a ShardedPublisher.
+type ShardedPublisherOptions struct {
+	// MaxOutstanding is the number of unconfirmed publishings above which a
+	// connection is considered degraded and is skipped while another
+	// connection has capacity.  Zero means no limit.
+	MaxOutstanding int
+}
+
+// ShardStats is a snapshot of the publishings sent through one connection of
+// a ShardedPublisher.
+type ShardStats struct {
+	Published   uint64 // publishings sent on the connection
+	Acked       uint64 // publishings confirmed by the server
+	Nacked      uint64 // publishings negatively confirmed by the server
+	Outstanding int    // publishings not confirmed yet
+	Degraded    bool   // true when the connection is skipped by Publish
+}
+
+/*
+ShardedPublisher spreads publishings across several connections, each with its
+own channel in confirm mode.  A single TCP connection is often the throughput
+bottleneck of a publisher, sharding removes it.
+
+Each publishing goes to the healthy connection with the fewest unconfirmed
+publishings, so load automatically shifts away from a connection that slows
+down.  A connection is degraded, and skipped, while it is closed, blocked by
+the server, or above ShardedPublisherOptions.MaxOutstanding.  A publishing
+that fails on one connection is retried on the next best one.
+
+The connections remain owned by the caller.  A ShardedPublisher is safe for
+concurrent use.
+*/
+type ShardedPublisher struct {
+	opts   ShardedPublisherOptions
+	shards []*publisherShard
+}
+
+type publisherShard struct {
+	conn *Connection
+	ch   *Channel
+
+	m     sync.Mutex
+	stats ShardStats
+}
+
+// NewShardedPublisher opens a channel in confirm mode on each connection.
+func NewShardedPublisher(conns []*Connection, opts ShardedPublisherOptions) (*ShardedPublisher, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("sharded publisher needs at least one connection")
+	}
+
+	p := &ShardedPublisher{opts: opts}
+
+	for _, conn := range conns {
+		ch, err := conn.Channel()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+
+		if err := ch.Confirm(false); err != nil {
+			_ = ch.Close()
+			p.Close()
+			return nil, err
+		}
+
+		s := &publisherShard{conn: conn, ch: ch}
+		go s.track(ch.NotifyPublish(make(chan Confirmation, 1)))
+
+		p.shards = append(p.shards, s)
+	}
+
+	return p, nil
+}
+
+// Publish sends the publishing on the best connection and returns its
+// DeferredConfirmation.  See Channel.PublishWithDeferredConfirmWithContext.
+func (p *ShardedPublisher) Publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	tried := make(map[*publisherShard]bool, len(p.shards))
+	lastErr := error(ErrNoShards)
+
+	for len(tried) < len(p.shards) {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+
+		s := p.pick(tried)
+		if s == nil {
+			break
+		}
+		tried[s] = true
+
+		s.m.Lock()
+		s.stats.Outstanding++
+		s.m.Unlock()
+
+		dc, err := s.ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+		if err != nil {
+			s.m.Lock()
+			s.stats.Outstanding--
+			s.m.Unlock()
+
+			lastErr = err
+			continue
+		}
+
+		s.m.Lock()
+		s.stats.Published++
+		s.m.Unlock()
+
+		return dc, nil
+	}
+
+	return nil, lastErr
+}
+
+// Stats returns a snapshot of every connection, in the order they were given
+// to NewShardedPublisher.
+func (p *ShardedPublisher) Stats() []ShardStats {
+	stats := make([]ShardStats, len(p.shards))
+	for i, s := range p.shards {
+		s.m.Lock()
+		stats[i] = s.stats
+		s.m.Unlock()
+		stats[i].Degraded = p.degraded(s, stats[i].Outstanding)
+	}
+	return stats
+}
+
+// Close closes the channels opened by the publisher.  The connections are
+// left open.
+func (p *ShardedPublisher) Close() {
+	for _, s := range p.shards {
+		_ = s.ch.Close()
+	}
+}
+
+// pick returns the untried shard with the fewest outstanding publishings,
+// preferring shards that are not degraded.  Closed shards are never picked.
+func (p *ShardedPublisher) pick(tried map[*publisherShard]bool) *publisherShard {
+	var best *publisherShard
+	var bestOutstanding int
+	var bestDegraded bool
+
+	for _, s := range p.shards {
+		if tried[s] || s.ch.IsClosed() {
+			continue
+		}
+
+		s.m.Lock()
+		outstanding := s.stats.Outstanding
+		s.m.Unlock()
+		degraded := p.degraded(s, outstanding)
+
+		switch {
+		case best == nil,
+			bestDegraded && !degraded,
+			bestDegraded == degraded && outstanding < bestOutstanding:
+			best, bestOutstanding, bestDegraded = s, outstanding, degraded
+		}
+	}
+
+	return best
+}
+
+func (p *ShardedPublisher) degraded(s *publisherShard, outstanding int) bool {
+	if s.ch.IsClosed() || s.conn.isBlocked() {
+		return true
+	}
+	return p.opts.MaxOutstanding > 0 && outstanding >= p.opts.MaxOutstanding
+}
+
+// track counts the confirmations of the shard until its channel closes.
+func (s *publisherShard) track(confirms chan Confirmation) {
+	for c := range confirms {
+		s.m.Lock()
+		s.stats.Outstanding--
+		if c.Ack {
+			s.stats.Acked++
+		} else {
+			s.stats.Nacked++
+		}
+		s.m.Unlock()
+	}
+}
+
+// ShardedConsumerOptions configures a ShardedConsumer.
+type ShardedConsumerOptions struct {
+	// Consumers is the number of consumers to start, spread round robin over
+	// the connections.  Zero starts one consumer per connection.
+	Consumers int
+
+	// PrefetchCount is applied with basic.qos to the channel of each consumer
+	// when positive.
+	PrefetchCount int
+
+	AutoAck   bool
+	Exclusive bool
+	Args      Table
+
+	// ConsumeOptions are passed to every Channel.ConsumeWithOptions.
+	ConsumeOptions []ConsumeOption
+}
+
+/*
+ShardedConsumer consumes from one queue with several consumers spread across
+several connections, so that the dispatch loop of a single connection does not
+become the bottleneck of a very hot queue.
+
+Each consumer has its own channel.  Deliveries of all consumers are merged on
+the channel returned by Deliveries and are acknowledged through their own
+channel as usual with Delivery.Ack, Delivery.Nack or Delivery.Reject.
+
+The connections remain owned by the caller.
+*/
+type ShardedConsumer struct {
+	channels   []*Channel
+	deliveries chan Delivery
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
+}
+
+// ConsumeSharded starts consuming queue on the given connections.
+func ConsumeSharded(conns []*Connection, queue string, opts ShardedConsumerOptions) (*ShardedConsumer, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("sharded consumer needs at least one connection")
+	}
+
+	n := opts.Consumers
+	if n <= 0 {
+		n = len(conns)
+	}
+
+	c := &ShardedConsumer{deliveries: make(chan Delivery), done: make(chan struct{})}
+	sources := make([]<-chan Delivery, 0, n)
+
+	for i := 0; i < n; i++ {
+		msgs, err := c.consume(conns[i%len(conns)], queue, opts)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		sources = append(sources, msgs)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	for _, msgs := range sources {
+		go func(msgs <-chan Delivery) {
+			defer wg.Done()
+			for d := range msgs {
+				select {
+				case c.deliveries <- d:
+				case <-c.done:
+					// Not read anymore, the server requeues d with
+					// the closed channel unless auto-acked.
+					return
+				}
+			}
+		}(msgs)
+	}
+
+	go func() {
+		wg.Wait()
+		close(c.deliveries)
+	}()
+
+	return c, nil
+}
+
+func (c *ShardedConsumer) consume(conn *Connection, queue string, opts ShardedConsumerOptions) (<-chan Delivery, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	c.channels = append(c.channels, ch)
+
+	if opts.PrefetchCount > 0 {
+		if err := ch.Qos(opts.PrefetchCount, 0, false); err != nil {
+			return nil, err
+		}
+	}
+
+	return ch.ConsumeWithOptions(context.Background(), queue, "", opts.AutoAck, opts.Exclusive, false, false, opts.Args, opts.ConsumeOptions...)
+}
+
+// Deliveries returns the merged deliveries of all consumers.  It is closed
+// once every consumer has stopped, or once Close is called even if the
+// deliveries are not read anymore.
+func (c *ShardedConsumer) Deliveries() <-chan Delivery {
+	return c.deliveries
+}
+
+// Close closes the channels of all consumers.  Deliveries not yet
+// acknowledged are requeued by the server.  The connections are left open.
+func (c *ShardedConsumer) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+
+	var firstErr error
+	for _, ch := range c.channels {
+		if err := ch.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShardedPublisherRebalancesToLeastOutstanding(t *testing.T) {
+	rwcA, srvA := newSession(t)
+	t.Cleanup(func() { rwcA.Close() })
+	rwcB, srvB := newSession(t)
+	t.Cleanup(func() { rwcB.Close() })
+
+	ackA := make(chan bool)
+
+	go func() {
+		srvA.connectionOpen()
+		srvA.channelOpen(1)
+		srvA.recv(1, &confirmSelect{})
+		srvA.send(1, &confirmSelectOk{})
+
+		srvA.recv(1, &basicPublish{})
+		<-ackA
+		srvA.send(1, &basicAck{DeliveryTag: 1})
+	}()
+
+	go func() {
+		srvB.connectionOpen()
+		srvB.channelOpen(1)
+		srvB.recv(1, &confirmSelect{})
+		srvB.send(1, &confirmSelectOk{})
+
+		srvB.recv(1, &basicPublish{})
+		srvB.send(1, &basicAck{DeliveryTag: 1})
+		srvB.recv(1, &basicPublish{})
+		srvB.send(1, &basicAck{DeliveryTag: 2})
+	}()
+
+	connA, err := Open(rwcA, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", connA, err)
+	}
+	connB, err := Open(rwcB, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", connB, err)
+	}
+
+	p, err := NewShardedPublisher([]*Connection{connA, connB}, ShardedPublisherOptions{MaxOutstanding: 1})
+	if err != nil {
+		t.Fatalf("could not create sharded publisher: %v", err)
+	}
+
+	ctx := context.Background()
+	var dcs []*DeferredConfirmation
+	for i := 0; i < 3; i++ {
+		dc, err := p.Publish(ctx, "", "q", false, false, Publishing{})
+		if err != nil {
+			t.Fatalf("publish %d failed: %v", i, err)
+		}
+		dcs = append(dcs, dc)
+
+		// Let the second connection confirm before the third publish.
+		if i == 1 {
+			deadline := time.Now().Add(time.Second)
+			for p.Stats()[1].Acked == 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("timeout waiting for the publish to be acked")
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	stats := p.Stats()
+	if want, got := uint64(1), stats[0].Published; want != got {
+		t.Errorf("expected %d publishing on the degraded connection, got %d", want, got)
+	}
+	if !stats[0].Degraded {
+		t.Errorf("expected the connection above MaxOutstanding to be degraded")
+	}
+	if want, got := uint64(2), stats[1].Published; want != got {
+		t.Errorf("expected %d publishings on the healthy connection, got %d", want, got)
+	}
+
+	close(ackA)
+	for i, dc := range dcs {
+		if !dc.Wait() {
+			t.Errorf("expected publish %d to be acked", i)
+		}
+	}
+}
+
+func TestConsumeShardedSpreadsConsumersAcrossConnections(t *testing.T) {
+	serve := func(srv *server, body string) {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicQos{})
+		srv.send(1, &basicQosOk{})
+
+		consume := &basicConsume{}
+		srv.recv(1, consume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 1, Body: []byte(body)})
+	}
+
+	rwcA, srvA := newSession(t)
+	t.Cleanup(func() { rwcA.Close() })
+	rwcB, srvB := newSession(t)
+	t.Cleanup(func() { rwcB.Close() })
+
+	go serve(srvA, "a")
+	go serve(srvB, "b")
+
+	connA, err := Open(rwcA, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", connA, err)
+	}
+	connB, err := Open(rwcB, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", connB, err)
+	}
+
+	c, err := ConsumeSharded([]*Connection{connA, connB}, "q", ShardedConsumerOptions{PrefetchCount: 10})
+	if err != nil {
+		t.Fatalf("could not start sharded consumer: %v", err)
+	}
+
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case d := <-c.Deliveries():
+			got[string(d.Body)] = true
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for deliveries, got %v", got)
+		}
+	}
+
+	if !got["a"] || !got["b"] {
+		t.Errorf("expected deliveries from both connections, got %v", got)
+	}
+}
+
+func TestShardedConsumerCloseStopsUnreadMerge(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		consume := &basicConsume{}
+		srv.recv(1, consume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 1})
+
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+	}()
+
+	conn, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", conn, err)
+	}
+
+	c, err := ConsumeSharded([]*Connection{conn}, "q", ShardedConsumerOptions{})
+	if err != nil {
+		t.Fatalf("could not start sharded consumer: %v", err)
+	}
+
+	// Let the delivery reach the merge without reading it.
+	time.Sleep(20 * time.Millisecond)
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close sharded consumer: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	select {
+	case d, ok := <-c.Deliveries():
+		if ok {
+			t.Errorf("expected the merge to stop on Close, got delivery %d", d.DeliveryTag)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the merged deliveries to be closed after Close")
+	}
+}
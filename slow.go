@@ -0,0 +1,149 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sort"
+	"time"
+)
+
+// SlowConsumerReason tells how a consumer was found slow, see SlowConsumer.
+type SlowConsumerReason int
+
+const (
+	// SlowConsumerBuffered is reported when the oldest delivery buffered for
+	// the consumer has waited longer than the threshold for the application
+	// to receive it from the consumer chan.
+	SlowConsumerBuffered SlowConsumerReason = iota
+
+	// SlowConsumerBlocked is reported when handing a delivery to the full
+	// buffer of the consumer held up the deliveries of every channel of the
+	// connection longer than the threshold, see BufferBlock.
+	SlowConsumerBlocked
+)
+
+func (r SlowConsumerReason) String() string {
+	switch r {
+	case SlowConsumerBuffered:
+		return "buffered"
+	case SlowConsumerBlocked:
+		return "blocked"
+	}
+	return "unknown"
+}
+
+/*
+SlowConsumer is the event of a consumer not keeping up with its deliveries on
+the client side, see Config.OnSlowConsumer.  The broker only sees the
+deliveries as unacknowledged, this tells where they wait.
+
+A consumer reported as SlowConsumerBuffered needs a faster handler, more
+consumers or a lower Channel.Qos prefetch.  One reported as SlowConsumerBlocked
+also slows down the other consumers of the connection, and is better moved to
+a connection of its own.
+*/
+type SlowConsumer struct {
+	Channel     *Channel
+	ConsumerTag string
+	Reason      SlowConsumerReason
+	DeliveryTag uint64        // of the delivery that waited
+	Waited      time.Duration // how long the delivery waited, or the connection was blocked
+	Buffered    int           // deliveries buffered for the consumer
+}
+
+// slowConsumers reports the slow consumers of a channel, see
+// Config.OnSlowConsumer.
+type slowConsumers struct {
+	channel   *Channel
+	threshold time.Duration
+	fn        func(SlowConsumer)
+}
+
+// trackSlowConsumers starts timing the deliveries buffered for consumers, it
+// must be called before the first consumer is added.
+func (subs *consumers) trackSlowConsumers(ch *Channel, threshold time.Duration, fn func(SlowConsumer)) {
+	subs.Lock()
+	defer subs.Unlock()
+
+	subs.slow = &slowConsumers{channel: ch, threshold: threshold, fn: fn}
+}
+
+// blocked reports the consumer whose delivery blocked the dispatcher for
+// waited, when it is over the threshold.  It must not be called while holding
+// the consumers mutex.
+func (s *slowConsumers) blocked(msg *Delivery, waited time.Duration, buffered int) {
+	if s == nil || waited < s.threshold {
+		return
+	}
+	s.fn(SlowConsumer{
+		Channel:     s.channel,
+		ConsumerTag: msg.ConsumerTag,
+		Reason:      SlowConsumerBlocked,
+		DeliveryTag: msg.DeliveryTag,
+		Waited:      waited,
+		Buffered:    buffered,
+	})
+}
+
+// slowReport returns the consumers whose oldest delivery buffered has waited
+// longer than the threshold, ordered by tag.
+func (subs *consumers) slowReport(now time.Time) []SlowConsumer {
+	subs.Lock()
+	defer subs.Unlock()
+
+	var report []SlowConsumer
+	for tag, state := range subs.buffers {
+		head := state.head.Load()
+		if head == nil || head.buffered.IsZero() {
+			continue
+		}
+		if waited := now.Sub(head.buffered); waited > subs.slow.threshold {
+			report = append(report, SlowConsumer{
+				Channel:     subs.slow.channel,
+				ConsumerTag: tag,
+				Reason:      SlowConsumerBuffered,
+				DeliveryTag: head.DeliveryTag,
+				Waited:      waited,
+				Buffered:    int(state.depth.Load()),
+			})
+		}
+	}
+
+	sort.Slice(report, func(i, j int) bool {
+		return report[i].ConsumerTag < report[j].ConsumerTag
+	})
+
+	return report
+}
+
+// reportSlowConsumers checks the deliveries buffered for the consumers of the
+// channel twice per threshold until the channel closes, and reports each
+// delivery that waited longer than the threshold once.
+func (ch *Channel) reportSlowConsumers(threshold time.Duration, fn func(SlowConsumer)) {
+	interval := threshold / 2
+	if interval <= 0 {
+		interval = threshold
+	}
+	ticker := ch.connection.clock().NewTicker(interval)
+	defer ticker.Stop()
+
+	var reported map[string]uint64 // delivery tag reported by consumer tag
+
+	for {
+		select {
+		case <-ch.close:
+			return
+		case now := <-ticker.C():
+			waiting := make(map[string]uint64)
+			for _, s := range ch.consumers.slowReport(now) {
+				if reported[s.ConsumerTag] != s.DeliveryTag {
+					fn(s)
+				}
+				waiting[s.ConsumerTag] = s.DeliveryTag
+			}
+			reported = waiting
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const slowThreshold = 20 * time.Millisecond
+
+// openSlowConsumer opens a connection reporting slow consumers to the returned
+// chan, and consumes with opts the deliveries sent by the server.
+func openSlowConsumer(t *testing.T, deliveries int, opts ...ConsumeOption) (*Connection, <-chan Delivery, <-chan SlowConsumer) {
+	t.Helper()
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: "slow"})
+
+		for tag := 1; tag <= deliveries; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: "slow", DeliveryTag: uint64(tag)})
+		}
+
+		srv.connectionClose()
+	}()
+
+	events := make(chan SlowConsumer, 16)
+
+	config := defaultConfig()
+	config.SlowConsumerThreshold = slowThreshold
+	config.OnSlowConsumer = func(s SlowConsumer) { events <- s }
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	consumer, err := ch.ConsumeWithOptions(context.Background(), "q", "slow", true, false, false, false, nil, opts...)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	return c, consumer, events
+}
+
+func nextSlowConsumer(t *testing.T, events <-chan SlowConsumer, reason SlowConsumerReason) SlowConsumer {
+	t.Helper()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case s := <-events:
+			if s.Reason == reason {
+				return s
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for a slow consumer %s", reason)
+		}
+	}
+}
+
+func TestSlowConsumerBufferedOncePerDelivery(t *testing.T) {
+	c, deliveries, events := openSlowConsumer(t, 2)
+
+	s := nextSlowConsumer(t, events, SlowConsumerBuffered)
+	if s.ConsumerTag != "slow" || s.DeliveryTag != 1 || s.Buffered != 2 || s.Waited <= slowThreshold {
+		t.Errorf("expected delivery 1 reported waiting with 2 buffered, got %+v", s)
+	}
+
+	// Still waiting, but already reported.
+	time.Sleep(2 * slowThreshold)
+	select {
+	case s := <-events:
+		t.Errorf("expected delivery 1 to be reported once, got %+v", s)
+	default:
+	}
+
+	<-deliveries
+	if s := nextSlowConsumer(t, events, SlowConsumerBuffered); s.DeliveryTag != 2 || s.Buffered != 1 {
+		t.Errorf("expected delivery 2 reported waiting with 1 buffered, got %+v", s)
+	}
+	<-deliveries
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestSlowConsumerBlockedTheConnection(t *testing.T) {
+	c, deliveries, events := openSlowConsumer(t, 2, WithDeliveryBuffer(1, BufferBlock))
+
+	time.Sleep(3 * slowThreshold)
+	<-deliveries
+
+	s := nextSlowConsumer(t, events, SlowConsumerBlocked)
+	if s.ConsumerTag != "slow" || s.DeliveryTag != 2 || s.Waited < slowThreshold {
+		t.Errorf("expected delivery 2 reported blocking the connection, got %+v", s)
+	}
+	<-deliveries
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+func TestSlowConsumerNotTimedByDefault(t *testing.T) {
+	subs := makeConsumers()
+	subs.add("fast", make(chan Delivery, 1), consumeOptions{})
+
+	d := &Delivery{ConsumerTag: "fast", DeliveryTag: 1}
+	subs.send("fast", d)
+
+	if !d.buffered.IsZero() {
+		t.Errorf("expected the delivery not to be timed without OnSlowConsumer")
+	}
+	subs.close()
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+/*
+Stamping sets the properties of the publishings that the application left
+unset, for traceability without stamping them at every call site.  Set it for
+every channel of a connection with Config.Stamping, or for a channel with
+Channel.SetStamping.
+
+The properties are set before the publishing is written, after the Publish
+middleware, so that the retries of a publishing keep the same MessageId.
+*/
+type Stamping struct {
+	// Timestamp sets the Timestamp to the time of the publish, in UTC.
+	Timestamp bool
+
+	// MessageId and CorrelationId set these properties to a new id.
+	MessageId     bool
+	CorrelationId bool
+
+	// NewID returns the ids of MessageId and CorrelationId, NewUUIDv7 when
+	// nil.
+	NewID func() string
+}
+
+// enabled reports whether the stamping sets any property.
+func (s *Stamping) enabled() bool {
+	return s != nil && (s.Timestamp || s.MessageId || s.CorrelationId)
+}
+
+// stamp sets the properties of msg left unset, and reports whether it changed
+// msg.
+func (s *Stamping) stamp(msg *Publishing, now time.Time) (changed bool) {
+	newID := s.NewID
+	if newID == nil {
+		newID = NewUUIDv7
+	}
+
+	if s.Timestamp && msg.Timestamp.IsZero() {
+		msg.Timestamp = now.UTC()
+		changed = true
+	}
+	if s.MessageId && msg.MessageId == "" {
+		msg.MessageId = newID()
+		changed = true
+	}
+	if s.CorrelationId && msg.CorrelationId == "" {
+		msg.CorrelationId = newID()
+		changed = true
+	}
+	return changed
+}
+
+/*
+SetStamping replaces, for the publishings of the channel, the Config.Stamping
+of its connection.  A zero Stamping stamps nothing.
+*/
+func (ch *Channel) SetStamping(s Stamping) {
+	ch.stamping.Store(&s)
+}
+
+// stampingOf returns the Stamping of the channel, nil when it stamps nothing.
+func (ch *Channel) stampingOf() *Stamping {
+	s := ch.stamping.Load()
+	if s == nil && ch.connection != nil {
+		s = &ch.connection.Config.Stamping
+	}
+	if !s.enabled() {
+		return nil
+	}
+	return s
+}
+
+/*
+NewUUIDv7 returns a random UUID of version 7, as defined by RFC 9562, in its
+canonical string form.  UUIDv7 start with the time they were generated at, in
+milliseconds, so they sort by creation time.  It panics when the random
+generator of the system fails.
+*/
+func NewUUIDv7() string {
+	return newUUIDv7(time.Now())
+}
+
+func newUUIDv7(now time.Time) string {
+	var u [16]byte
+	if _, err := rand.Read(u[6:]); err != nil {
+		panic(err)
+	}
+
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(now.UnixMilli()))
+	copy(u[0:6], ms[2:8])
+
+	u[6] = (u[6] & 0x0f) | 0x70 // version 7
+	u[8] = (u[8] & 0x3f) | 0x80 // variant 10
+
+	var s [36]byte
+	hex.Encode(s[0:8], u[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], u[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], u[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], u[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:36], u[10:16])
+	return string(s[:])
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNewUUIDv7(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	now := time.UnixMilli(0x0123456789ab)
+	id := newUUIDv7(now)
+	if !format.MatchString(id) {
+		t.Fatalf("expected a UUIDv7, got %q", id)
+	}
+	if want, got := "01234567-89ab", id[:13]; want != got {
+		t.Errorf("expected the UUID to start with the time in milliseconds %q, got %q", want, got)
+	}
+
+	if a, b := NewUUIDv7(), NewUUIDv7(); a == b || !format.MatchString(a) {
+		t.Errorf("expected distinct UUIDv7, got %q and %q", a, b)
+	}
+}
+
+func TestStampingSetsUnsetProperties(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := make(chan *basicPublish, 3)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for i := 0; i < 3; i++ {
+			published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		}
+
+		srv.connectionClose()
+	}()
+
+	var ids int
+	clock := newFakeClock()
+	config := defaultConfig()
+	config.Clock = clock
+	config.Stamping = Stamping{
+		Timestamp:     true,
+		MessageId:     true,
+		CorrelationId: true,
+		NewID: func() string {
+			ids++
+			return "id-" + strconv.Itoa(ids)
+		},
+	}
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	ctx := context.Background()
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	set := time.Unix(1700000000, 0)
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{Timestamp: set, MessageId: "mine", CorrelationId: "request"}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	ch.SetStamping(Stamping{})
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	props := (<-published).Properties
+	if !props.Timestamp.Equal(clock.Now()) || props.MessageId != "id-1" || props.CorrelationId != "id-2" {
+		t.Errorf("expected the unset properties to be stamped, got %+v", props)
+	}
+	props = (<-published).Properties
+	if !props.Timestamp.Equal(set) || props.MessageId != "mine" || props.CorrelationId != "request" {
+		t.Errorf("expected the set properties to be kept, got %+v", props)
+	}
+	props = (<-published).Properties
+	if !props.Timestamp.IsZero() || props.MessageId != "" || props.CorrelationId != "" {
+		t.Errorf("expected the channel to override the stamping of the connection, got %+v", props)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+// ConnectionStats is a snapshot of the traffic counters of a Connection, see
+// Config.EnableStats.
+type ConnectionStats struct {
+	FramesRead    uint64 // frames received from the server, including heartbeats
+	FramesWritten uint64 // frames sent to the server, including heartbeats
+	BytesRead     uint64 // bytes received from the server
+	BytesWritten  uint64 // bytes sent to the server
+	Publishes     uint64 // basic.publish sent on all channels
+	Deliveries    uint64 // basic.deliver and basic.get-ok received on all channels
+	Acks          uint64 // basic.ack, basic.nack and basic.reject sent on all channels
+}
+
+// connStats holds the counters of a Connection.  All fields are accessed
+// atomically.
+type connStats struct {
+	framesRead    uint64
+	framesWritten uint64
+	bytesRead     uint64
+	bytesWritten  uint64
+	publishes     uint64
+	deliveries    uint64
+	acks          uint64
+}
+
+func (s *connStats) frameRead(f frame) {
+	atomic.AddUint64(&s.framesRead, 1)
+
+	if mf, ok := f.(*methodFrame); ok {
+		switch mf.Method.(type) {
+		case *basicDeliver, *basicGetOk:
+			atomic.AddUint64(&s.deliveries, 1)
+		}
+	}
+}
+
+func (s *connStats) frameWritten(f frame) {
+	atomic.AddUint64(&s.framesWritten, 1)
+
+	if mf, ok := f.(*methodFrame); ok {
+		switch mf.Method.(type) {
+		case *basicPublish:
+			atomic.AddUint64(&s.publishes, 1)
+		case *basicAck, *basicNack, *basicReject:
+			atomic.AddUint64(&s.acks, 1)
+		}
+	}
+}
+
+func (s *connStats) snapshot() ConnectionStats {
+	return ConnectionStats{
+		FramesRead:    atomic.LoadUint64(&s.framesRead),
+		FramesWritten: atomic.LoadUint64(&s.framesWritten),
+		BytesRead:     atomic.LoadUint64(&s.bytesRead),
+		BytesWritten:  atomic.LoadUint64(&s.bytesWritten),
+		Publishes:     atomic.LoadUint64(&s.publishes),
+		Deliveries:    atomic.LoadUint64(&s.deliveries),
+		Acks:          atomic.LoadUint64(&s.acks),
+	}
+}
+
+// Stats returns a snapshot of the traffic counters of the connection.  The
+// counters are only maintained when Config.EnableStats is set, otherwise the
+// snapshot is zero.
+func (c *Connection) Stats() ConnectionStats {
+	if c.stats == nil {
+		return ConnectionStats{}
+	}
+	return c.stats.snapshot()
+}
+
+// ChannelState is the state of a Channel, see Channel.Stats.
+type ChannelState int
+
+const (
+	// ChannelOpen is a channel that can publish and consume.
+	ChannelOpen ChannelState = iota
+	// ChannelFlowPaused is an open channel whose publishings the server
+	// paused with channel.flow, see Channel.NotifyFlow.
+	ChannelFlowPaused
+	// ChannelClosing is a channel closed by the client or the server that
+	// has not finished shutting down.
+	ChannelClosing
+	// ChannelClosed is a channel that has shut down.
+	ChannelClosed
+)
+
+func (s ChannelState) String() string {
+	switch s {
+	case ChannelOpen:
+		return "open"
+	case ChannelFlowPaused:
+		return "flow-paused"
+	case ChannelClosing:
+		return "closing"
+	case ChannelClosed:
+		return "closed"
+	}
+	return "ChannelState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ChannelStats is a snapshot of the state and the counters of a Channel.  The
+// counters are only maintained when Config.EnableStats is set, otherwise they
+// are zero.
+type ChannelStats struct {
+	State     ChannelState
+	Published uint64 // basic.publish sent
+	Confirmed uint64 // publishings acknowledged by the server in confirm mode
+	Nacked    uint64 // publishings negatively acknowledged by the server in confirm mode
+	Returned  uint64 // basic.return received
+	Delivered uint64 // basic.deliver and basic.get-ok received
+	Acked     uint64 // basic.ack, basic.nack and basic.reject sent
+}
+
+// channelStats holds the counters of a Channel.  All fields are accessed
+// atomically.
+type channelStats struct {
+	published uint64
+	confirmed uint64
+	nacked    uint64
+	returned  uint64
+	delivered uint64
+	acked     uint64
+}
+
+func (s *channelStats) confirm(ack bool) {
+	if ack {
+		atomic.AddUint64(&s.confirmed, 1)
+	} else {
+		atomic.AddUint64(&s.nacked, 1)
+	}
+}
+
+func (s *channelStats) snapshot() ChannelStats {
+	return ChannelStats{
+		Published: atomic.LoadUint64(&s.published),
+		Confirmed: atomic.LoadUint64(&s.confirmed),
+		Nacked:    atomic.LoadUint64(&s.nacked),
+		Returned:  atomic.LoadUint64(&s.returned),
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Acked:     atomic.LoadUint64(&s.acked),
+	}
+}
+
+/*
+Stats returns the state of the channel and a snapshot of its counters, for
+dashboards and to detect leaked channels, such as channels left open or
+publishers that stopped receiving confirmations.
+*/
+func (ch *Channel) Stats() ChannelStats {
+	var stats ChannelStats
+	if ch.stats != nil {
+		stats = ch.stats.snapshot()
+	}
+
+	select {
+	case <-ch.close:
+		stats.State = ChannelClosed
+		return stats
+	default:
+	}
+
+	ch.notifyM.RLock()
+	paused := ch.resumed != nil
+	ch.notifyM.RUnlock()
+
+	switch {
+	case ch.IsClosed():
+		stats.State = ChannelClosing
+	case paused:
+		stats.State = ChannelFlowPaused
+	default:
+		stats.State = ChannelOpen
+	}
+
+	return stats
+}
+
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
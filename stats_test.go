@@ -0,0 +1,180 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConnectionStats(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	done := make(chan bool)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicPublish{})
+
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetOk{DeliveryTag: 1, Body: []byte("hello")})
+
+		srv.recv(1, &basicAck{})
+		close(done)
+	}()
+
+	cfg := defaultConfig()
+	cfg.EnableStats = true
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.PublishWithContext(context.TODO(), "", "q", false, false, Publishing{Body: []byte("hello")}); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+
+	d, ok, err := ch.Get("q", false)
+	if err != nil || !ok {
+		t.Fatalf("could not get a delivery: %v", err)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("ack error: %v", err)
+	}
+	<-done
+
+	stats := c.Stats()
+	if want, got := uint64(1), stats.Publishes; want != got {
+		t.Errorf("expected %d publishes, got %d", want, got)
+	}
+	if want, got := uint64(1), stats.Deliveries; want != got {
+		t.Errorf("expected %d deliveries, got %d", want, got)
+	}
+	if want, got := uint64(1), stats.Acks; want != got {
+		t.Errorf("expected %d acks, got %d", want, got)
+	}
+	if stats.FramesRead == 0 || stats.FramesWritten == 0 {
+		t.Errorf("expected frames to be counted, got %+v", stats)
+	}
+	if stats.BytesRead == 0 || stats.BytesWritten == 0 {
+		t.Errorf("expected bytes to be counted, got %+v", stats)
+	}
+}
+
+func TestConnectionStatsDisabled(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go srv.connectionOpen()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	if stats := c.Stats(); stats != (ConnectionStats{}) {
+		t.Errorf("expected zero stats when disabled, got %+v", stats)
+	}
+}
+
+func TestChannelStats(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	paused := make(chan struct{})
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicReturn{ReplyCode: NoRoute, ReplyText: "NO_ROUTE"})
+		srv.send(1, &basicNack{DeliveryTag: 2})
+
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetOk{DeliveryTag: 1, Body: []byte("hello")})
+		srv.recv(1, &basicAck{})
+
+		srv.send(1, &channelFlow{Active: false})
+		srv.recv(1, &channelFlowOk{})
+		close(paused)
+
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+
+		srv.connectionClose()
+	}()
+
+	cfg := defaultConfig()
+	cfg.EnableStats = true
+
+	c, err := Open(rwc, cfg)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if want, got := ChannelOpen, ch.Stats().State; want != got {
+		t.Errorf("expected state %s, got %s", want, got)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("confirm error: %v", err)
+	}
+	confirms := ch.NotifyPublish(make(chan Confirmation, 2))
+	returns := ch.NotifyReturn(make(chan Return, 1))
+
+	for i := 0; i < 2; i++ {
+		if err := ch.PublishWithContext(context.TODO(), "", "q", true, false, Publishing{}); err != nil {
+			t.Fatalf("publish error: %v", err)
+		}
+	}
+	<-confirms
+	<-returns
+	<-confirms
+
+	d, ok, err := ch.Get("q", false)
+	if err != nil || !ok {
+		t.Fatalf("could not get a delivery: %v", err)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("ack error: %v", err)
+	}
+
+	<-paused
+	stats := ch.Stats()
+	want := ChannelStats{State: ChannelFlowPaused, Published: 2, Confirmed: 1, Nacked: 1, Returned: 1, Delivered: 1, Acked: 1}
+	if stats != want {
+		t.Errorf("expected stats %+v, got %+v", want, stats)
+	}
+
+	if err := ch.Close(); err != nil {
+		t.Fatalf("channel close error: %v", err)
+	}
+	if want, got := ChannelClosed, ch.Stats().State; want != got {
+		t.Errorf("expected state %s, got %s", want, got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
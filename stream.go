@@ -0,0 +1,108 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// StreamOffsetArg is the consumer argument of basic.consume selecting where
+// the consumer of a stream queue starts reading, and the header of the
+// deliveries of a stream holding their offset.  See StreamOffset.
+const StreamOffsetArg = "x-stream-offset"
+
+// ErrStreamPrefetch is returned by Channel.ConsumeStream when no prefetch
+// count has been set on the channel with Channel.Qos, which RabbitMQ requires
+// to consume a stream queue.
+var ErrStreamPrefetch = errors.New("consuming a stream requires a prefetch count set with Channel.Qos")
+
+/*
+StreamOffset is where a consumer of a stream queue starts reading, given to
+Channel.ConsumeStream.  Use one of StreamOffsetFirst, StreamOffsetLast and
+StreamOffsetNext, or an absolute position with StreamOffsetAt or
+StreamOffsetTimestamp.
+
+See https://www.rabbitmq.com/streams.html#consuming
+*/
+type StreamOffset struct {
+	value interface{} // string, int64 or time.Time
+}
+
+var (
+	// StreamOffsetFirst starts reading from the first message available in
+	// the stream.
+	StreamOffsetFirst = StreamOffset{"first"}
+
+	// StreamOffsetLast starts reading from the last chunk written to the
+	// stream, which may hold several messages.
+	StreamOffsetLast = StreamOffset{"last"}
+
+	// StreamOffsetNext starts reading from the next message written to the
+	// stream, like a consumer of a classic queue.  This is what RabbitMQ does
+	// without an offset.
+	StreamOffsetNext = StreamOffset{"next"}
+)
+
+// StreamOffsetAt starts reading from the message at offset, as found with
+// Delivery.StreamOffset.
+func StreamOffsetAt(offset int64) StreamOffset {
+	return StreamOffset{offset}
+}
+
+// StreamOffsetTimestamp starts reading from the first chunk of messages
+// written to the stream at or after t.  Streams store timestamps with a
+// precision of one second.
+func StreamOffsetTimestamp(t time.Time) StreamOffset {
+	return StreamOffset{t}
+}
+
+// Arg returns the value of StreamOffsetArg selecting the offset in the
+// arguments of basic.consume.
+func (o StreamOffset) Arg() interface{} {
+	if o.value == nil {
+		return StreamOffsetNext.value
+	}
+	return o.value
+}
+
+/*
+ConsumeStream consumes a stream queue starting at offset, like
+ConsumeWithContext with the StreamOffsetArg argument set.  Arguments in args,
+like x-stream-filter, are sent along.
+
+Stream deliveries must be acknowledged, so the consumer is never started with
+autoAck, and RabbitMQ requires a prefetch count: ErrStreamPrefetch is
+returned without consuming when Channel.Qos has not set one on the channel.
+Acknowledging a stream delivery only credits the prefetch, the message stays
+in the stream until its retention expires.
+*/
+func (ch *Channel) ConsumeStream(ctx context.Context, queue, consumer string, offset StreamOffset, args Table, opts ...ConsumeOption) (<-chan Delivery, error) {
+	if ch.prefetchCount.Load() == 0 {
+		return nil, ErrStreamPrefetch
+	}
+
+	streamArgs := make(Table, len(args)+1)
+	for k, v := range args {
+		streamArgs[k] = v
+	}
+	streamArgs[StreamOffsetArg] = offset.Arg()
+
+	return ch.ConsumeWithOptions(ctx, queue, consumer, false, false, false, false, streamArgs, opts...)
+}
+
+// StreamOffset returns the offset of a delivery from a stream queue, false
+// when the delivery does not come from a stream.
+func (d Delivery) StreamOffset() (int64, bool) {
+	switch offset := d.Headers[StreamOffsetArg].(type) {
+	case int64:
+		return offset, true
+	case int32:
+		return int64(offset), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStreamOffsetArg(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		offset StreamOffset
+		want   interface{}
+	}{
+		{StreamOffset{}, "next"},
+		{StreamOffsetFirst, "first"},
+		{StreamOffsetLast, "last"},
+		{StreamOffsetNext, "next"},
+		{StreamOffsetAt(42), int64(42)},
+		{StreamOffsetTimestamp(at), at},
+	} {
+		if got := tc.offset.Arg(); got != tc.want {
+			t.Errorf("expected the offset argument %v, got %v", tc.want, got)
+		}
+	}
+}
+
+func TestDeliveryStreamOffset(t *testing.T) {
+	if offset, ok := (Delivery{Headers: Table{StreamOffsetArg: int64(7)}}).StreamOffset(); !ok || offset != 7 {
+		t.Errorf("expected the stream offset 7, got %d, %v", offset, ok)
+	}
+	if _, ok := (Delivery{}).StreamOffset(); ok {
+		t.Error("expected no stream offset for a delivery without the header")
+	}
+}
+
+func TestConsumeStreamRequiresPrefetch(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	consumes := make(chan *basicConsume, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicQos{})
+		srv.send(1, &basicQosOk{})
+
+		consumes <- srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: "stream"})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if _, err := ch.ConsumeStream(context.Background(), "s", "stream", StreamOffsetFirst, nil); !errors.Is(err, ErrStreamPrefetch) {
+		t.Fatalf("expected ErrStreamPrefetch without a prefetch count, got %v", err)
+	}
+
+	if err := ch.Qos(100, 0, false); err != nil {
+		t.Fatalf("could not set qos: %v", err)
+	}
+
+	args := Table{"x-stream-filter": "eu"}
+	if _, err := ch.ConsumeStream(context.Background(), "s", "stream", StreamOffsetAt(42), args); err != nil {
+		t.Fatalf("could not consume the stream: %v", err)
+	}
+
+	consume := <-consumes
+	if consume.NoAck {
+		t.Error("expected the stream to be consumed with manual acknowledgements")
+	}
+	if want, got := int64(42), consume.Arguments[StreamOffsetArg]; want != got {
+		t.Errorf("expected the offset argument %v, got %v", want, got)
+	}
+	if want, got := "eu", consume.Arguments["x-stream-filter"]; want != got {
+		t.Errorf("expected the filter argument %v, got %v", want, got)
+	}
+	if _, found := args[StreamOffsetArg]; found {
+		t.Error("expected the arguments of the caller to be left unchanged")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ExchangeSpec describes an exchange to declare, see Channel.ExchangeDeclare.
+type ExchangeSpec struct {
+	Name       string
+	Kind       string
+	Durable    bool
+	AutoDelete bool
+	Internal   bool
+	Args       Table
+}
+
+// QueueSpec describes a queue to declare, see Channel.QueueDeclare.
+type QueueSpec struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       Table
+}
+
+// BindingSpec describes a binding of a queue to an exchange, see
+// Channel.QueueBind.
+type BindingSpec struct {
+	Queue    string
+	Exchange string
+	Key      string
+	Args     Table
+}
+
+// Topology is a set of exchanges, queues and bindings declared together.
+type Topology struct {
+	Exchanges []ExchangeSpec
+	Queues    []QueueSpec
+	Bindings  []BindingSpec
+}
+
+type topologyTask func(ch *Channel) error
+
+/*
+ApplyTopologyParallel declares the topology on conn using up to concurrency
+channels at once, which is much faster than declaring thousands of queues one
+by one on a single channel.
+
+Exchanges are declared first, then queues, then bindings, so that bindings
+find what they refer to.  A failed declaration does not stop the others: the
+server closes the channel it failed on and a new channel is opened for the
+next declaration.  All failures are returned joined in a single error.
+
+When ctx is done, no further declaration is started and context.Cause(ctx) is
+included in the returned error.  The channels are closed before returning.
+*/
+func ApplyTopologyParallel(ctx context.Context, conn *Connection, topo Topology, concurrency int) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	exchanges := make([]topologyTask, 0, len(topo.Exchanges))
+	for _, e := range topo.Exchanges {
+		e := e
+		exchanges = append(exchanges, func(ch *Channel) error {
+			if err := ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, false, e.Args); err != nil {
+				return fmt.Errorf("declare exchange %q: %w", e.Name, err)
+			}
+			return nil
+		})
+	}
+
+	queues := make([]topologyTask, 0, len(topo.Queues))
+	for _, q := range topo.Queues {
+		q := q
+		queues = append(queues, func(ch *Channel) error {
+			if _, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args); err != nil {
+				return fmt.Errorf("declare queue %q: %w", q.Name, err)
+			}
+			return nil
+		})
+	}
+
+	bindings := make([]topologyTask, 0, len(topo.Bindings))
+	for _, b := range topo.Bindings {
+		b := b
+		bindings = append(bindings, func(ch *Channel) error {
+			if err := ch.QueueBind(b.Queue, b.Key, b.Exchange, false, b.Args); err != nil {
+				return fmt.Errorf("bind queue %q to exchange %q with key %q: %w", b.Queue, b.Exchange, b.Key, err)
+			}
+			return nil
+		})
+	}
+
+	// Channels are reused across tasks, nil until first needed.
+	pool := make(chan *Channel, concurrency)
+	for i := 0; i < concurrency; i++ {
+		pool <- nil
+	}
+
+	var errs []error
+	for _, tasks := range [][]topologyTask{exchanges, queues, bindings} {
+		errs = append(errs, runTopologyTasks(ctx, conn, pool, tasks)...)
+
+		if ctx.Err() != nil {
+			errs = append(errs, context.Cause(ctx))
+			break
+		}
+	}
+
+	for i := 0; i < concurrency; i++ {
+		if ch := <-pool; ch != nil {
+			_ = ch.Close()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// runTopologyTasks runs each task on a channel taken from the pool, and
+// returns the errors of the failed tasks once all of them have completed.
+func runTopologyTasks(ctx context.Context, conn *Connection, pool chan *Channel, tasks []topologyTask) []error {
+	var (
+		m    sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+
+	record := func(err error) {
+		m.Lock()
+		errs = append(errs, err)
+		m.Unlock()
+	}
+
+feed:
+	for _, task := range tasks {
+		var ch *Channel
+		select {
+		case ch = <-pool:
+		case <-ctx.Done():
+			break feed
+		}
+
+		wg.Add(1)
+		go func(task topologyTask, ch *Channel) {
+			defer wg.Done()
+			defer func() { pool <- ch }()
+
+			// A failed declaration closes the channel.
+			if ch == nil || ch.IsClosed() {
+				var err error
+				if ch, err = conn.Channel(); err != nil {
+					ch = nil
+					record(err)
+					return
+				}
+			}
+
+			if err := task(ch); err != nil {
+				record(err)
+			}
+		}(task, ch)
+	}
+
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestApplyTopologyParallelAggregatesErrors(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &exchangeDeclare{})
+		srv.send(1, &exchangeDeclareOk{})
+
+		// The first queue is rejected, closing the channel
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: PreconditionFailed, ReplyText: "PRECONDITION_FAILED - inequivalent arg"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(2)
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &queueDeclareOk{Queue: "q2"})
+
+		srv.recv(2, &queueBind{})
+		srv.send(2, &queueBindOk{})
+
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	err = ApplyTopologyParallel(context.Background(), c, Topology{
+		Exchanges: []ExchangeSpec{{Name: "events", Kind: ExchangeTopic, Durable: true}},
+		Queues:    []QueueSpec{{Name: "q1", Durable: true}, {Name: "q2", Durable: true}},
+		Bindings:  []BindingSpec{{Queue: "q2", Exchange: "events", Key: "#"}},
+	}, 1)
+
+	if !errors.Is(err, ErrPreconditionFailed) {
+		t.Fatalf("expected the failed declaration in the returned error, got %v", err)
+	}
+}
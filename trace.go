@@ -0,0 +1,210 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// Headers of the W3C Trace Context and Baggage specifications.
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+	BaggageHeader     = "baggage"
+)
+
+// TextMapCarrier is a set of string fields a Propagator reads and writes.  It
+// has the methods of the TextMapCarrier of OpenTelemetry.
+type TextMapCarrier interface {
+	Get(key string) string
+	Set(key, value string)
+	Keys() []string
+}
+
+// HeadersCarrier is the TextMapCarrier of message headers, whose string and
+// []byte values are fields.
+type HeadersCarrier Table
+
+// Get returns the header key when it is a string or []byte, "" otherwise.
+func (c HeadersCarrier) Get(key string) string {
+	switch v := c[key].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// Set sets the header key to value.
+func (c HeadersCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys returns the names of the headers, sorted.
+func (c HeadersCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+Propagator writes the trace of a context to the headers of a publishing, and
+reads it back from the headers of a delivery, so that distributed traces flow
+through queues.  W3CTraceContext is a Propagator; the TextMapPropagator of
+OpenTelemetry is one with an adapter, as HeadersCarrier implements its
+TextMapCarrier:
+
+	type otelPropagator struct{ propagation.TextMapPropagator }
+
+	func (p otelPropagator) Inject(ctx context.Context, c amqp.TextMapCarrier) {
+		p.TextMapPropagator.Inject(ctx, c)
+	}
+
+	func (p otelPropagator) Extract(ctx context.Context, c amqp.TextMapCarrier) context.Context {
+		return p.TextMapPropagator.Extract(ctx, c)
+	}
+*/
+type Propagator interface {
+	Inject(ctx context.Context, carrier TextMapCarrier)
+	Extract(ctx context.Context, carrier TextMapCarrier) context.Context
+}
+
+// TraceContext is the trace propagated by W3CTraceContext, as the values of
+// the traceparent, tracestate and baggage fields.
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+	Baggage     string
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a context carrying tc, for W3CTraceContext
+// to inject.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the TraceContext of ctx, and false when it
+// has none.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+/*
+W3CTraceContext propagates the TraceContext of a context, set with
+ContextWithTraceContext, in the traceparent, tracestate and baggage headers,
+for applications propagating traces without OpenTelemetry.  An invalid
+traceparent is neither injected nor extracted, and the tracestate is dropped
+with it.
+*/
+var W3CTraceContext Propagator = w3cTraceContext{}
+
+type w3cTraceContext struct{}
+
+func (w3cTraceContext) Inject(ctx context.Context, carrier TextMapCarrier) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	if validTraceParent(tc.TraceParent) {
+		carrier.Set(TraceParentHeader, tc.TraceParent)
+		if tc.TraceState != "" {
+			carrier.Set(TraceStateHeader, tc.TraceState)
+		}
+	}
+	if tc.Baggage != "" {
+		carrier.Set(BaggageHeader, tc.Baggage)
+	}
+}
+
+func (w3cTraceContext) Extract(ctx context.Context, carrier TextMapCarrier) context.Context {
+	var tc TraceContext
+	if parent := strings.TrimSpace(carrier.Get(TraceParentHeader)); validTraceParent(parent) {
+		tc.TraceParent = parent
+		tc.TraceState = carrier.Get(TraceStateHeader)
+	}
+	tc.Baggage = carrier.Get(BaggageHeader)
+
+	if tc == (TraceContext{}) {
+		return ctx
+	}
+	return ContextWithTraceContext(ctx, tc)
+}
+
+// validTraceParent reports whether s is a traceparent of version 00, or of a
+// later version starting like one.
+func validTraceParent(s string) bool {
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(s) < 55 || (len(s) > 55 && s[55] != '-') {
+		return false
+	}
+	version, traceID, parentID, flags := s[0:2], s[3:35], s[36:52], s[53:55]
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return false
+	}
+	if version == "ff" || (version == "00" && len(s) != 55) {
+		return false
+	}
+	for _, field := range []string{version, traceID, parentID, flags} {
+		if !lowerHex(field) {
+			return false
+		}
+	}
+	return strings.Trim(traceID, "0") != "" && strings.Trim(parentID, "0") != ""
+}
+
+func lowerHex(s string) bool {
+	if _, err := hex.DecodeString(s); err != nil {
+		return false
+	}
+	return strings.ToLower(s) == s
+}
+
+// InjectTrace writes the trace of ctx to the headers of the publishing with
+// propagator, copying them first.
+func (p *Publishing) InjectTrace(ctx context.Context, propagator Propagator) {
+	headers := make(HeadersCarrier, len(p.Headers)+3)
+	for k, v := range p.Headers {
+		headers[k] = v
+	}
+	propagator.Inject(ctx, headers)
+	p.Headers = Table(headers)
+}
+
+// ExtractTrace returns ctx with the trace read from the headers of the
+// delivery by propagator, for the spans of its handling:
+//
+//	ctx = d.ExtractTrace(ctx, amqp.W3CTraceContext)
+func (d Delivery) ExtractTrace(ctx context.Context, propagator Propagator) context.Context {
+	return propagator.Extract(ctx, HeadersCarrier(d.Headers))
+}
+
+/*
+PropagateTrace returns a Middleware injecting the trace of the context of every
+publishing into its headers with propagator:
+
+	ch.Use(amqp.PropagateTrace(amqp.W3CTraceContext))
+
+	err := ch.PublishWithContext(ctx, "orders", "eu.created", false, false, msg)
+*/
+func PropagateTrace(propagator Propagator) Middleware {
+	return Middleware{
+		Publish: func(next PublishHandler) PublishHandler {
+			return func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+				msg.InjectTrace(ctx, propagator)
+				return next(ctx, exchange, key, mandatory, immediate, msg)
+			}
+		},
+	}
+}
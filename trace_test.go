@@ -0,0 +1,92 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestW3CTraceContextRoundTrip(t *testing.T) {
+	tc := TraceContext{TraceParent: testTraceParent, TraceState: "congo=t61rcWkgMzE", Baggage: "userId=alice"}
+	ctx := ContextWithTraceContext(context.Background(), tc)
+
+	msg := Publishing{Headers: Table{"k": "v"}}
+	original := msg.Headers
+	msg.InjectTrace(ctx, W3CTraceContext)
+
+	if want, got := 1, len(original); want != got {
+		t.Errorf("expected the headers of the publishing to be copied, got %v", original)
+	}
+	want := Table{"k": "v", TraceParentHeader: tc.TraceParent, TraceStateHeader: tc.TraceState, BaggageHeader: tc.Baggage}
+	if !reflect.DeepEqual(want, msg.Headers) {
+		t.Errorf("expected the headers %v, got %v", want, msg.Headers)
+	}
+
+	d := Delivery{Headers: msg.Headers}
+	if got, ok := TraceContextFromContext(d.ExtractTrace(context.Background(), W3CTraceContext)); !ok || got != tc {
+		t.Errorf("expected the trace context %+v, got %+v", tc, got)
+	}
+}
+
+func TestW3CTraceContextIgnoresInvalidTraceParent(t *testing.T) {
+	for _, parent := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		testTraceParent + "-extra",
+	} {
+		carrier := HeadersCarrier{TraceParentHeader: parent, TraceStateHeader: "congo=t61rcWkgMzE"}
+		ctx := W3CTraceContext.Extract(context.Background(), carrier)
+		if tc, ok := TraceContextFromContext(ctx); ok {
+			t.Errorf("expected %q to be ignored, got %+v", parent, tc)
+		}
+	}
+
+	carrier := HeadersCarrier{TraceParentHeader: []byte("01" + testTraceParent[2:] + "-future")}
+	if tc, _ := TraceContextFromContext(W3CTraceContext.Extract(context.Background(), carrier)); tc.TraceParent == "" {
+		t.Error("expected a traceparent of a later version to be extracted")
+	}
+}
+
+func TestPropagateTraceMiddleware(t *testing.T) {
+	var published Publishing
+	publish := PropagateTrace(W3CTraceContext).Publish(func(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+		published = msg
+		return nil, nil
+	})
+
+	ctx := ContextWithTraceContext(context.Background(), TraceContext{TraceParent: testTraceParent})
+	if _, err := publish(ctx, "", "q", false, false, Publishing{}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if want, got := testTraceParent, published.Headers[TraceParentHeader]; want != got {
+		t.Errorf("expected the traceparent %q, got %v", want, got)
+	}
+
+	if _, err := publish(context.Background(), "", "q", false, false, Publishing{}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if len(published.Headers) != 0 {
+		t.Errorf("expected no trace headers without a trace, got %v", published.Headers)
+	}
+}
+
+func TestHeadersCarrierKeys(t *testing.T) {
+	c := HeadersCarrier{"b": "2", "a": int32(1)}
+	c.Set("c", "3")
+	if want, got := []string{"a", "b", "c"}, c.Keys(); !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the keys %v, got %v", want, got)
+	}
+	if got := c.Get("a"); got != "" {
+		t.Errorf("expected non string headers to be ignored, got %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+TypedQueue is a messaging endpoint exchanging values of type T, marshaled with
+the Codec registered for ContentType, so that the message contract of a queue
+is checked at compile time:
+
+	orders := amqp.NewTypedQueue[Order](ch, "orders")
+
+	err := orders.Publish(ctx, Order{ID: 42})
+
+	err = orders.Consume(ctx, func(ctx context.Context, o Order, d amqp.Delivery) error {
+		return ship(ctx, o)
+	})
+*/
+type TypedQueue[T any] struct {
+	Channel *Channel
+
+	// Queue is consumed by Consume.
+	Queue string
+
+	// Exchange and Key are the destination of Publish.  NewTypedQueue
+	// publishes to Queue through the default exchange.
+	Exchange string
+	Key      string
+
+	// ContentType selects the codec of published values, and of consumed
+	// messages without a content type.  NewTypedQueue sets it to
+	// application/json.
+	ContentType string
+
+	// Persistent publishes with DeliveryMode Persistent.
+	Persistent bool
+
+	// RequeueOnError requeues the messages whose handler returned an error,
+	// instead of rejecting them so that they are dropped or dead-lettered.
+	RequeueOnError bool
+}
+
+// NewTypedQueue returns a TypedQueue publishing JSON to and consuming from the
+// queue.
+func NewTypedQueue[T any](ch *Channel, queue string) *TypedQueue[T] {
+	return &TypedQueue[T]{
+		Channel:     ch,
+		Queue:       queue,
+		Key:         queue,
+		ContentType: "application/json",
+	}
+}
+
+// Publish marshals v with the codec of ContentType and publishes it to
+// Exchange with Key.
+func (q *TypedQueue[T]) Publish(ctx context.Context, v T) error {
+	msg := Publishing{ContentType: q.ContentType}
+	if err := msg.Encode(v); err != nil {
+		return err
+	}
+	if q.Persistent {
+		msg.DeliveryMode = Persistent
+	}
+
+	return q.Channel.PublishWithContext(ctx, q.Exchange, q.Key, false, false, msg)
+}
+
+/*
+Consume consumes Queue and calls handler with every message unmarshaled into a
+T, until ctx is done or the consumer stops.  It then returns context.Cause(ctx),
+or the reason the channel was closed or the consumer cancelled.
+
+A message is acknowledged when handler returns nil, and rejected otherwise,
+with requeue when RequeueOnError is set.  A message that cannot be decoded or
+unmarshaled is rejected without requeue and handler is not called.
+*/
+func (q *TypedQueue[T]) Consume(ctx context.Context, handler func(ctx context.Context, v T, d Delivery) error) error {
+	deliveries, err := q.Channel.ConsumeWithContext(ctx, q.Queue, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for d := range deliveries {
+		v, err := q.unmarshal(d)
+		if err != nil {
+			logAt(LevelWarn, "rejecting message", "delivery", d.DeliveryTag, "queue", q.Queue, "error", err)
+			if err := d.Reject(false); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := handler(ctx, v, d); err != nil {
+			if err := d.Reject(q.RequeueOnError); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := d.Ack(false); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if !q.Channel.IsClosed() {
+		return fmt.Errorf("consumer of queue %q cancelled by the server", q.Queue)
+	}
+	return ErrClosed
+}
+
+func (q *TypedQueue[T]) unmarshal(d Delivery) (v T, err error) {
+	if d.ContentType == "" {
+		d.ContentType = q.ContentType
+	}
+	err = d.Decode(&v)
+	return v, err
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type typedOrder struct {
+	ID    int    `json:"id"`
+	Item  string `json:"item"`
+	Valid bool   `json:"valid"`
+}
+
+func TestTypedQueuePublishAndConsume(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	published := make(chan *basicPublish, 1)
+	rejects := make(chan *basicReject, 2)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		consume := srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 1,
+			Properties: properties{ContentType: "application/json"}, Body: []byte(`{"id":1,"item":"book","valid":true}`)})
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 2,
+			Properties: properties{ContentType: "application/json"}, Body: []byte(`{"id":`)})
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 3,
+			Body: []byte(`{"id":3}`)})
+
+		srv.recv(1, &basicAck{})
+		rejects <- srv.recv(1, &basicReject{}).(*basicReject)
+		rejects <- srv.recv(1, &basicReject{}).(*basicReject)
+		cancel()
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: consume.ConsumerTag})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	orders := NewTypedQueue[typedOrder](ch, "orders")
+	orders.RequeueOnError = true
+
+	if err := orders.Publish(ctx, typedOrder{ID: 7, Item: "pen"}); err != nil {
+		t.Fatalf("publish error: %v", err)
+	}
+	pub := <-published
+	if pub.RoutingKey != "orders" || pub.Properties.ContentType != "application/json" || string(pub.Body) != `{"id":7,"item":"pen","valid":false}` {
+		t.Errorf("unexpected publishing %q to %q as %q", pub.Body, pub.RoutingKey, pub.Properties.ContentType)
+	}
+
+	var handled []typedOrder
+	err = orders.Consume(ctx, func(ctx context.Context, o typedOrder, d Delivery) error {
+		handled = append(handled, o)
+		if !o.Valid {
+			return errors.New("invalid order")
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Consume to return the context cause, got %v", err)
+	}
+
+	if want, got := 2, len(handled); want != got {
+		t.Fatalf("expected %d handled orders, got %+v", want, got)
+	}
+	if handled[0].Item != "book" || handled[1].ID != 3 {
+		t.Errorf("unexpected orders %+v", handled)
+	}
+
+	if r := <-rejects; r.DeliveryTag != 2 || r.Requeue {
+		t.Errorf("expected the malformed message to be rejected without requeue, got %+v", r)
+	}
+	if r := <-rejects; r.DeliveryTag != 3 || !r.Requeue {
+		t.Errorf("expected the failed message to be requeued, got %+v", r)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
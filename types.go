@@ -6,8 +6,10 @@
 package amqp091
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"time"
 )
 
@@ -27,6 +29,18 @@ var (
 	// ErrClosed is returned when the channel or connection is not open
 	ErrClosed = &Error{Code: ChannelError, Reason: "channel/connection is not open"}
 
+	// Channel.CloseReason and Connection.CloseReason return one of these once
+	// the channel or connection is closed, so retry logic can tell the
+	// reasons apart.  They all match ErrClosed with errors.Is:
+	//
+	//	ErrChannelClosedByServer: the server closed the channel with an
+	//	exception, the connection is still usable.
+	//	ErrClosedByClient: Close was called on the channel or connection.
+	//	ErrConnectionLost: the connection failed or was closed by the server.
+	ErrChannelClosedByServer = &Error{Code: ChannelError, Reason: "channel closed by server exception"}
+	ErrClosedByClient        = &Error{Code: ChannelError, Reason: "channel/connection closed by client"}
+	ErrConnectionLost        = &Error{Code: ChannelError, Reason: "connection lost"}
+
 	// ErrChannelMax is returned when Connection.Channel has been called enough
 	// times that all channel IDs have been exhausted in the client or the
 	// server.
@@ -63,8 +77,62 @@ var (
 
 	// ErrFieldType is returned when writing a message containing a Go type unsupported by AMQP.
 	ErrFieldType = &Error{Code: SyntaxError, Reason: "unsupported table field type"}
+
+	// ErrPublishBlocked is wrapped by the error returned when a publish waited
+	// longer than Config.BlockedPublishTimeout on a connection blocked by the
+	// server.  The returned error includes the reason sent in
+	// connection.blocked.
+	ErrPublishBlocked = errors.New("publish timed out on a blocked connection")
 )
 
+// Errors matching the AMQP reply codes.  The errors sent by the server when
+// closing a channel or connection wrap the error matching their reply code, so
+// that they can be tested with errors.Is:
+//
+//	if errors.Is(err, amqp.ErrNotFound) {
+//		// declare the missing queue
+//	}
+//
+// Codes 501, 502, 503 and 505 match ErrFrame, ErrSyntax, ErrCommandInvalid and
+// ErrUnexpectedFrame.
+var (
+	ErrContentTooLarge    = &Error{Code: ContentTooLarge, Reason: "content too large", Recover: true}
+	ErrNoRoute            = &Error{Code: NoRoute, Reason: "no route", Recover: true}
+	ErrNoConsumers        = &Error{Code: NoConsumers, Reason: "no consumers", Recover: true}
+	ErrConnectionForced   = &Error{Code: ConnectionForced, Reason: "connection forced"}
+	ErrInvalidPath        = &Error{Code: InvalidPath, Reason: "invalid path"}
+	ErrAccessRefused      = &Error{Code: AccessRefused, Reason: "access refused", Recover: true}
+	ErrNotFound           = &Error{Code: NotFound, Reason: "not found", Recover: true}
+	ErrResourceLocked     = &Error{Code: ResourceLocked, Reason: "resource locked", Recover: true}
+	ErrPreconditionFailed = &Error{Code: PreconditionFailed, Reason: "precondition failed", Recover: true}
+	ErrChannelError       = &Error{Code: ChannelError, Reason: "channel error"}
+	ErrResourceError      = &Error{Code: ResourceError, Reason: "resource error"}
+	ErrNotAllowed         = &Error{Code: NotAllowed, Reason: "not allowed"}
+	ErrNotImplemented     = &Error{Code: NotImplemented, Reason: "not implemented"}
+	ErrInternalError      = &Error{Code: InternalError, Reason: "internal error"}
+)
+
+var replyCodeErrors = map[int]*Error{
+	ContentTooLarge:    ErrContentTooLarge,
+	NoRoute:            ErrNoRoute,
+	NoConsumers:        ErrNoConsumers,
+	ConnectionForced:   ErrConnectionForced,
+	InvalidPath:        ErrInvalidPath,
+	AccessRefused:      ErrAccessRefused,
+	NotFound:           ErrNotFound,
+	ResourceLocked:     ErrResourceLocked,
+	PreconditionFailed: ErrPreconditionFailed,
+	FrameError:         ErrFrame,
+	SyntaxError:        ErrSyntax,
+	CommandInvalid:     ErrCommandInvalid,
+	ChannelError:       ErrChannelError,
+	UnexpectedFrame:    ErrUnexpectedFrame,
+	ResourceError:      ErrResourceError,
+	NotAllowed:         ErrNotAllowed,
+	NotImplemented:     ErrNotImplemented,
+	InternalError:      ErrInternalError,
+}
+
 // internal errors used inside the library
 var (
 	errInvalidTypeAssertion = &Error{Code: InternalError, Reason: "type assertion unsuccessful", Server: false, Recover: true}
@@ -94,6 +162,24 @@ func (e *Error) Error() string {
 	return fmt.Sprintf("Exception (%d) Reason: %q", e.Code, e.Reason)
 }
 
+// Unwrap returns the error matching the reply code of an error sent by the
+// server, such as ErrNotFound for a 404, so that errors.Is can test the code.
+// The errors of this library only unwrap to ErrClosed when they tell why a
+// channel or connection is closed, see Channel.CloseReason.
+func (e *Error) Unwrap() error {
+	switch e {
+	case ErrChannelClosedByServer, ErrClosedByClient, ErrConnectionLost:
+		return ErrClosed
+	}
+	if !e.Server {
+		return nil
+	}
+	if sentinel, ok := replyCodeErrors[e.Code]; ok {
+		return sentinel
+	}
+	return nil
+}
+
 // Recoverable returns true if the error can be recovered by retrying later or with different parameters.
 // Returns the value of the Recover field.
 func (e *Error) Recoverable() bool {
@@ -154,6 +240,8 @@ type properties struct {
 	UserId          string    // application use - creating user id
 	AppId           string    // application use - creating application
 	reserved1       string    // was cluster-id - process for buffer consumption
+
+	encodedHeaders []byte // written instead of Headers, see EncodedTable
 }
 
 // DeliveryMode.  Transient means higher throughput but messages will not be
@@ -220,6 +308,10 @@ type Publishing struct {
 	// the headers exchange will inspect this field.
 	Headers Table
 
+	// EncodedHeaders are headers encoded once for many publishings, see
+	// Table.Freeze.  They are merged with Headers when both are set.
+	EncodedHeaders EncodedTable
+
 	// Properties
 	ContentType     string // MIME content type
 	ContentEncoding string // MIME content encoding
@@ -232,8 +324,9 @@ type Publishing struct {
 	// at its destination and the message is not directly handled by a consumer
 	// that currently has the capacity to do so. If you wish the message to
 	// not expire on its own, set this value to any ttl value, empty string or
-	// use the corresponding constants NeverExpire and ImmediatelyExpire. This
-	// does not influence queue configured TTL values.
+	// use the corresponding constants NeverExpire and ImmediatelyExpire, or
+	// ExpirationFromDuration for a time.Duration. This does not influence
+	// queue configured TTL values.
 	Expiration string
 	MessageId  string    // message identifier
 	Timestamp  time.Time // message timestamp
@@ -259,17 +352,21 @@ type Blocking struct {
 // returned from PublishWithDeferredConfirm on Channels.
 type DeferredConfirmation struct {
 	DeliveryTag uint64
+	Data        interface{} // Attached to the publishing with WithConfirmData
 
-	done chan struct{}
-	ack  bool
+	done  chan struct{}
+	ack   bool
+	err   error         // see Err
+	retry *republishing // see Channel.SetNackRetry
 }
 
 // Confirmation notifies the acknowledgment or negative acknowledgement of a
 // publishing identified by its delivery tag.  Use NotifyPublish on the Channel
 // to consume these events.
 type Confirmation struct {
-	DeliveryTag uint64 // A 1 based counter of publishings from when the channel was put in Confirm mode
-	Ack         bool   // True when the server successfully received the publishing
+	DeliveryTag uint64      // A 1 based counter of publishings from when the channel was put in Confirm mode
+	Ack         bool        // True when the server successfully received the publishing
+	Data        interface{} // Attached to the publishing with WithConfirmData
 }
 
 // Decimal matches the AMQP decimal type.  Scale is the number of decimal
@@ -361,27 +458,33 @@ const (
 	QueueOverflowRejectPublishDLX = "reject-publish-dlx"
 )
 
-// Table stores user supplied fields of the following types:
+// Table stores user supplied fields of the following types, which cover the field
+// types of RabbitMQ, encoded as the field type in parentheses and decoded as the
+// Go type after the arrow when it differs:
 //
-//	bool
-//	byte
-//	int8
-//	float32
-//	float64
-//	int
-//	int16
-//	int32
-//	int64
-//	nil
-//	string
-//	time.Time
-//	amqp.Decimal
-//	amqp.Table
-//	[]byte
-//	[]interface{} - containing above types
+//	bool          (t)
+//	int8          (b)
+//	byte, uint8   (B)
+//	int16         (s)
+//	uint16        (u)
+//	int32         (I)
+//	int           (I) -> int32, must fit in an int32
+//	uint32        (i)
+//	int64         (l)
+//	uint64, uint  (l) -> int64, must fit in an int64
+//	float32       (f)
+//	float64       (d)
+//	amqp.Decimal  (D)
+//	string        (S)
+//	[]byte        (x)
+//	time.Time     (T) -> time.Time of the second, in the local time zone
+//	nil           (V)
+//	amqp.Table    (F)
+//	[]interface{} (A) containing above types
 //
-// Functions taking a table will immediately fail when the table contains a
-// value of an unsupported type.
+// Functions taking a table, and publishings, immediately fail with a
+// *FieldTypeError, before anything is sent to the server, when the table contains
+// a value of an unsupported type or out of the range of its field type.
 //
 // The caller must be specific in which precision of integer it wishes to
 // encode.
@@ -391,32 +494,83 @@ const (
 // RabbitMQ expects int32 for integer values.
 type Table map[string]interface{}
 
+/*
+FieldTypeError is returned for a value of a table that cannot be encoded as a
+field, of an unsupported type or out of the range of its field type, see Table.
+It wraps ErrFieldType.
+*/
+type FieldTypeError struct {
+	Path  string // of the value in the table, such as "x-args[2].ttl"
+	Value interface{}
+}
+
+func (e *FieldTypeError) Error() string {
+	switch e.Value.(type) {
+	case int, uint, uint64:
+		return fmt.Sprintf("table field %s: %T value %v out of the range of its field type", e.Path, e.Value, e.Value)
+	}
+	return fmt.Sprintf("table field %s: value %T not supported", e.Path, e.Value)
+}
+
+func (e *FieldTypeError) Unwrap() error {
+	return ErrFieldType
+}
+
 func validateField(f interface{}) error {
+	return validateFieldAt("", f)
+}
+
+// validateFieldAt validates f found at path in a table.
+func validateFieldAt(path string, f interface{}) error {
 	switch fv := f.(type) {
-	case nil, bool, byte, int8, int, int16, int32, int64, float32, float64, string, []byte, Decimal, time.Time:
+	case nil, bool, byte, int8, int16, uint16, int32, uint32, int64, float32, float64, string, []byte, Decimal, time.Time:
+		return nil
+
+	case int, uint, uint64:
+		if !fieldFits(fv) {
+			return &FieldTypeError{Path: path, Value: f}
+		}
 		return nil
 
 	case []interface{}:
-		for _, v := range fv {
-			if err := validateField(v); err != nil {
-				return fmt.Errorf("in array %s", err)
+		for i, v := range fv {
+			if err := validateFieldAt(fmt.Sprintf("%s[%d]", path, i), v); err != nil {
+				return err
 			}
 		}
 		return nil
 
 	case Table:
 		for k, v := range fv {
-			if err := validateField(v); err != nil {
-				return fmt.Errorf("table field %q %s", k, err)
+			key := k
+			if path != "" {
+				key = path + "." + k
+			}
+			if err := validateFieldAt(key, v); err != nil {
+				return err
 			}
 		}
 		return nil
 	}
 
-	return fmt.Errorf("value %T not supported", f)
+	return &FieldTypeError{Path: path, Value: f}
+}
+
+// fieldFits reports whether an int, uint or uint64 fits its field type.
+func fieldFits(v interface{}) bool {
+	switch v := v.(type) {
+	case int:
+		return v >= math.MinInt32 && v <= math.MaxInt32
+	case uint:
+		return uint64(v) <= math.MaxInt64
+	case uint64:
+		return v <= math.MaxInt64
+	}
+	return true
 }
 
-// Validate returns and error if any Go types in the table are incompatible with AMQP types.
+// Validate returns a *FieldTypeError if any Go types in the table are
+// incompatible with AMQP types.
 func (t Table) Validate() error {
 	return validateField(t)
 }
@@ -478,6 +632,7 @@ connection mutex is held.
 func updateChannel(f frame, channel *Channel) {
 	if mf, isMethodFrame := f.(*methodFrame); isMethodFrame {
 		if _, isChannelClose := mf.Method.(*channelClose); isChannelClose {
+			channel.setClosedBy(ErrChannelClosedByServer)
 			channel.setClosed()
 		}
 	}
@@ -574,6 +729,7 @@ type headerFrame struct {
 	weight     uint16
 	Size       uint64
 	Properties properties
+	encoded    []byte // Properties already written, see BoundPublisher
 }
 
 func (f *headerFrame) channel() uint16 { return f.ChannelId }
@@ -596,6 +752,7 @@ might see something like this:
 type bodyFrame struct {
 	ChannelId uint16
 	Body      []byte
+	slab      slabRef // Body when read from the pool
 }
 
 func (f *bodyFrame) channel() uint16 { return f.ChannelId }
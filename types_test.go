@@ -1,7 +1,12 @@
 package amqp091
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -62,6 +67,41 @@ func TestErrorMessage(t *testing.T) {
 	}
 }
 
+func TestErrorIsReplyCode(t *testing.T) {
+	testCases := []struct {
+		err    error
+		target error
+		is     bool
+	}{
+		{newError(404, "NOT_FOUND - no queue 'q'"), ErrNotFound, true},
+		{newError(406, "PRECONDITION_FAILED - inequivalent arg"), ErrPreconditionFailed, true},
+		{newError(406, "PRECONDITION_FAILED - inequivalent arg"), ErrNotFound, false},
+		{newError(501, "FRAME_ERROR"), ErrFrame, true},
+		{fmt.Errorf("declare: %w", newError(403, "ACCESS_REFUSED")), ErrAccessRefused, true},
+		{ErrNotFound, ErrNotFound, true},
+
+		// Errors of the library only match their reply code as the server
+		// error they are, not as the code they share.
+		{ErrCredentials, ErrAccessRefused, false},
+		{ErrClosed, ErrChannelError, false},
+		{ErrChannelError, ErrClosed, false},
+		{newError(504, "CHANNEL_ERROR"), ErrClosed, false},
+		{ErrClosedByClient, ErrClosed, true},
+		{ErrConnectionLost, ErrChannelError, false},
+	}
+
+	for _, tc := range testCases {
+		if got := errors.Is(tc.err, tc.target); got != tc.is {
+			t.Errorf("expected errors.Is(%v, %v) to be %v", tc.err, tc.target, tc.is)
+		}
+	}
+
+	var aerr *Error
+	if !errors.As(fmt.Errorf("declare: %w", newError(404, "NOT_FOUND")), &aerr) || aerr.Reason != "NOT_FOUND" {
+		t.Errorf("expected errors.As to find the server error, got %v", aerr)
+	}
+}
+
 func TestValidateField(t *testing.T) {
 	// Test case for simple types
 	simpleTypes := []interface{}{
@@ -100,3 +140,88 @@ func TestValidateField(t *testing.T) {
 		t.Error("validateField should fail for unsupported type but it didn't")
 	}
 }
+
+func TestTableRoundTripsEveryFieldType(t *testing.T) {
+	now := time.Unix(time.Now().Unix(), 0)
+
+	table := Table{
+		"bool":      true,
+		"int8":      int8(-8),
+		"byte":      byte(8),
+		"int16":     int16(-16),
+		"uint16":    uint16(16),
+		"int32":     int32(-32),
+		"uint32":    uint32(32),
+		"int64":     int64(-64),
+		"float32":   float32(3.2),
+		"float64":   float64(6.4),
+		"decimal":   Decimal{Scale: 2, Value: 12345},
+		"string":    "string",
+		"bytes":     []byte("bytes"),
+		"timestamp": now,
+		"void":      nil,
+		"table":     Table{"nested": int32(1)},
+		"array":     []interface{}{uint16(1), "two"},
+	}
+
+	// Decoded as other types.
+	converted := map[string][2]interface{}{
+		"int":    {-1, int32(-1)},
+		"uint":   {uint(1), int64(1)},
+		"uint64": {uint64(math.MaxInt64), int64(math.MaxInt64)},
+	}
+
+	want := Table{}
+	for k, v := range table {
+		want[k] = v
+	}
+	for k, v := range converted {
+		table[k] = v[0]
+		want[k] = v[1]
+	}
+
+	var buf bytes.Buffer
+	if err := writeTable(&buf, table); err != nil {
+		t.Fatalf("could not write table: %v", err)
+	}
+	got, err := readTable(&buf)
+	if err != nil {
+		t.Fatalf("could not read table: %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected the table to round trip as %v, got %v", want, got)
+	}
+}
+
+func TestTableRejectsUnencodableValues(t *testing.T) {
+	for _, tc := range []struct {
+		table Table
+		path  string
+	}{
+		{Table{"struct": struct{}{}}, "struct"},
+		{Table{"int": math.MaxInt32 + 1}, "int"},
+		{Table{"uint64": uint64(math.MaxInt64) + 1}, "uint64"},
+		{Table{"args": Table{"ttl": []interface{}{1, uint64(math.MaxUint64)}}}, "args.ttl[1]"},
+		{Table{"x-args": []interface{}{Table{}, []interface{}{Table{"ttl": struct{}{}}}}}, "x-args[1][0].ttl"},
+	} {
+		err := tc.table.Validate()
+
+		var fieldErr *FieldTypeError
+		if !errors.As(err, &fieldErr) || !errors.Is(err, ErrFieldType) {
+			t.Errorf("expected a *FieldTypeError for %v, got %v", tc.table, err)
+			continue
+		}
+		if fieldErr.Path != tc.path {
+			t.Errorf("expected the path %q, got %q", tc.path, fieldErr.Path)
+		}
+
+		err = writeTable(io.Discard, tc.table)
+		if !errors.As(err, &fieldErr) || !errors.Is(err, ErrFieldType) {
+			t.Errorf("expected writing %v to fail with a *FieldTypeError, got %v", tc.table, err)
+			continue
+		}
+		if fieldErr.Path != tc.path {
+			t.Errorf("expected writing to fail at the path %q, got %q", tc.path, fieldErr.Path)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"net"
 	"time"
 )
 
@@ -21,6 +22,14 @@ func (w *writer) WriteFrameNoFlush(frame frame) (err error) {
 	return
 }
 
+// Flush writes the buffered frames, if any.
+func (w *writer) Flush() error {
+	if buf, ok := w.w.(*bufio.Writer); ok {
+		return buf.Flush()
+	}
+	return nil
+}
+
 func (w *writer) WriteFrame(frame frame) (err error) {
 	if err = frame.write(w.w); err != nil {
 		return
@@ -34,23 +43,24 @@ func (w *writer) WriteFrame(frame frame) (err error) {
 }
 
 func (f *methodFrame) write(w io.Writer) (err error) {
-	var payload bytes.Buffer
-
 	if f.Method == nil {
 		return errors.New("malformed frame: missing method")
 	}
 
+	payload := getPayload()
+	defer putPayload(payload)
+
 	class, method := f.Method.id()
 
-	if err = binary.Write(&payload, binary.BigEndian, class); err != nil {
+	if err = binary.Write(payload, binary.BigEndian, class); err != nil {
 		return
 	}
 
-	if err = binary.Write(&payload, binary.BigEndian, method); err != nil {
+	if err = binary.Write(payload, binary.BigEndian, method); err != nil {
 		return
 	}
 
-	if err = f.Method.write(&payload); err != nil {
+	if err = f.Method.write(payload); err != nil {
 		return
 	}
 
@@ -72,136 +82,153 @@ func (f *heartbeatFrame) write(w io.Writer) (err error) {
 //
 //	short     short    long long       short        remainder...
 func (f *headerFrame) write(w io.Writer) (err error) {
-	var payload bytes.Buffer
+	payload := getPayload()
+	defer putPayload(payload)
 
-	if err = binary.Write(&payload, binary.BigEndian, f.ClassId); err != nil {
+	if err = binary.Write(payload, binary.BigEndian, f.ClassId); err != nil {
 		return
 	}
 
-	if err = binary.Write(&payload, binary.BigEndian, f.weight); err != nil {
+	if err = binary.Write(payload, binary.BigEndian, f.weight); err != nil {
 		return
 	}
 
-	if err = binary.Write(&payload, binary.BigEndian, f.Size); err != nil {
+	if err = binary.Write(payload, binary.BigEndian, f.Size); err != nil {
 		return
 	}
 
+	if f.encoded != nil {
+		payload.Write(f.encoded)
+	} else if err = writeProperties(payload, f.Properties); err != nil {
+		return
+	}
+
+	return writeFrame(w, frameHeader, f.ChannelId, payload.Bytes())
+}
+
+// writeProperties writes the property flags and the properties of a content
+// header.
+func writeProperties(w io.Writer, props properties) (err error) {
 	// First pass will build the mask to be serialized, second pass will serialize
 	// each of the fields that appear in the mask.
 
 	var mask uint16
 
-	if f.Properties.ContentType != "" {
+	if props.ContentType != "" {
 		mask |= flagContentType
 	}
-	if f.Properties.ContentEncoding != "" {
+	if props.ContentEncoding != "" {
 		mask |= flagContentEncoding
 	}
-	if len(f.Properties.Headers) > 0 {
+	if len(props.Headers) > 0 || props.encodedHeaders != nil {
 		mask |= flagHeaders
 	}
-	if f.Properties.DeliveryMode > 0 {
+	if props.DeliveryMode > 0 {
 		mask |= flagDeliveryMode
 	}
-	if f.Properties.Priority > 0 {
+	if props.Priority > 0 {
 		mask |= flagPriority
 	}
-	if f.Properties.CorrelationId != "" {
+	if props.CorrelationId != "" {
 		mask |= flagCorrelationId
 	}
-	if f.Properties.ReplyTo != "" {
+	if props.ReplyTo != "" {
 		mask |= flagReplyTo
 	}
-	if f.Properties.Expiration != "" {
+	if props.Expiration != "" {
 		mask |= flagExpiration
 	}
-	if f.Properties.MessageId != "" {
+	if props.MessageId != "" {
 		mask |= flagMessageId
 	}
-	if !f.Properties.Timestamp.IsZero() {
+	if !props.Timestamp.IsZero() {
 		mask |= flagTimestamp
 	}
-	if f.Properties.Type != "" {
+	if props.Type != "" {
 		mask |= flagType
 	}
-	if f.Properties.UserId != "" {
+	if props.UserId != "" {
 		mask |= flagUserId
 	}
-	if f.Properties.AppId != "" {
+	if props.AppId != "" {
 		mask |= flagAppId
 	}
 
-	if err = binary.Write(&payload, binary.BigEndian, mask); err != nil {
+	if err = binary.Write(w, binary.BigEndian, mask); err != nil {
 		return
 	}
 
 	if hasProperty(mask, flagContentType) {
-		if err = writeShortstr(&payload, f.Properties.ContentType); err != nil {
+		if err = writeShortstr(w, props.ContentType); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagContentEncoding) {
-		if err = writeShortstr(&payload, f.Properties.ContentEncoding); err != nil {
+		if err = writeShortstr(w, props.ContentEncoding); err != nil {
 			return
 		}
 	}
-	if hasProperty(mask, flagHeaders) {
-		if err = writeTable(&payload, f.Properties.Headers); err != nil {
+	if hasProperty(mask, flagHeaders) && props.encodedHeaders != nil {
+		if _, err = w.Write(props.encodedHeaders); err != nil {
+			return
+		}
+	} else if hasProperty(mask, flagHeaders) {
+		if err = writeTable(w, props.Headers); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagDeliveryMode) {
-		if err = binary.Write(&payload, binary.BigEndian, f.Properties.DeliveryMode); err != nil {
+		if err = binary.Write(w, binary.BigEndian, props.DeliveryMode); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagPriority) {
-		if err = binary.Write(&payload, binary.BigEndian, f.Properties.Priority); err != nil {
+		if err = binary.Write(w, binary.BigEndian, props.Priority); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagCorrelationId) {
-		if err = writeShortstr(&payload, f.Properties.CorrelationId); err != nil {
+		if err = writeShortstr(w, props.CorrelationId); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagReplyTo) {
-		if err = writeShortstr(&payload, f.Properties.ReplyTo); err != nil {
+		if err = writeShortstr(w, props.ReplyTo); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagExpiration) {
-		if err = writeShortstr(&payload, f.Properties.Expiration); err != nil {
+		if err = writeShortstr(w, props.Expiration); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagMessageId) {
-		if err = writeShortstr(&payload, f.Properties.MessageId); err != nil {
+		if err = writeShortstr(w, props.MessageId); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagTimestamp) {
-		if err = binary.Write(&payload, binary.BigEndian, uint64(f.Properties.Timestamp.Unix())); err != nil {
+		if err = binary.Write(w, binary.BigEndian, uint64(props.Timestamp.Unix())); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagType) {
-		if err = writeShortstr(&payload, f.Properties.Type); err != nil {
+		if err = writeShortstr(w, props.Type); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagUserId) {
-		if err = writeShortstr(&payload, f.Properties.UserId); err != nil {
+		if err = writeShortstr(w, props.UserId); err != nil {
 			return
 		}
 	}
 	if hasProperty(mask, flagAppId) {
-		if err = writeShortstr(&payload, f.Properties.AppId); err != nil {
+		if err = writeShortstr(w, props.AppId); err != nil {
 			return
 		}
 	}
 
-	return writeFrame(w, frameHeader, f.ChannelId, payload.Bytes())
+	return nil
 }
 
 // Body
@@ -212,6 +239,51 @@ func (f *bodyFrame) write(w io.Writer) (err error) {
 	return writeFrame(w, frameBody, f.ChannelId, f.Body)
 }
 
+// inlineBodySize is the size under which body frames are copied with the other
+// frames of a message by contentBuffers, as a separate buffer costs more than
+// the copy.
+const inlineBodySize = 1024
+
+// contentBuffers encodes the frames of a message with content into scratch,
+// and returns the buffers to write them with, in order.  The bodies of body
+// frames of at least inlineBodySize are not copied: the buffers alternate
+// between scratch and these bodies.
+func contentBuffers(scratch *bytes.Buffer, frames []frame) (net.Buffers, error) {
+	type cut struct {
+		at   int // offset in scratch
+		body []byte
+	}
+	var cuts []cut
+
+	for _, f := range frames {
+		bf, ok := f.(*bodyFrame)
+		if !ok || len(bf.Body) < inlineBodySize {
+			if err := f.write(scratch); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var header [7]byte
+		header[0] = frameBody
+		binary.BigEndian.PutUint16(header[1:3], bf.ChannelId)
+		binary.BigEndian.PutUint32(header[3:7], uint32(len(bf.Body)))
+		scratch.Write(header[:])
+
+		cuts = append(cuts, cut{scratch.Len(), bf.Body})
+		scratch.WriteByte(frameEnd)
+	}
+
+	b := scratch.Bytes()
+	bufs := make(net.Buffers, 0, 2*len(cuts)+1)
+	from := 0
+	for _, c := range cuts {
+		bufs = append(bufs, b[from:c.at], c.body)
+		from = c.at
+	}
+	return append(bufs, b[from:]), nil
+}
+
 func writeFrame(w io.Writer, typ uint8, channel uint16, payload []byte) (err error) {
 	end := []byte{frameEnd}
 	size := uint(len(payload))
@@ -318,7 +390,15 @@ func writeField(w io.Writer, value interface{}) (err error) {
 		binary.BigEndian.PutUint16(buf[1:3], uint16(v))
 		enc = buf[:3]
 
+	case uint16:
+		buf[0] = 'u'
+		binary.BigEndian.PutUint16(buf[1:3], v)
+		enc = buf[:3]
+
 	case int:
+		if !fieldFits(v) {
+			return &FieldTypeError{Value: v}
+		}
 		buf[0] = 'I'
 		binary.BigEndian.PutUint32(buf[1:5], uint32(v))
 		enc = buf[:5]
@@ -328,11 +408,29 @@ func writeField(w io.Writer, value interface{}) (err error) {
 		binary.BigEndian.PutUint32(buf[1:5], uint32(v))
 		enc = buf[:5]
 
+	case uint32:
+		buf[0] = 'i'
+		binary.BigEndian.PutUint32(buf[1:5], v)
+		enc = buf[:5]
+
 	case int64:
 		buf[0] = 'l'
 		binary.BigEndian.PutUint64(buf[1:9], uint64(v))
 		enc = buf[:9]
 
+	case uint, uint64:
+		// RabbitMQ has no unsigned 64-bit field type.
+		if !fieldFits(v) {
+			return &FieldTypeError{Value: v}
+		}
+		buf[0] = 'l'
+		if u, ok := v.(uint); ok {
+			binary.BigEndian.PutUint64(buf[1:9], uint64(u))
+		} else {
+			binary.BigEndian.PutUint64(buf[1:9], v.(uint64))
+		}
+		enc = buf[:9]
+
 	case float32:
 		buf[0] = 'f'
 		binary.BigEndian.PutUint32(buf[1:5], math.Float32bits(v))
@@ -358,9 +456,9 @@ func writeField(w io.Writer, value interface{}) (err error) {
 		buf[0] = 'A'
 
 		sec := new(bytes.Buffer)
-		for _, val := range v {
+		for i, val := range v {
 			if err = writeField(sec, val); err != nil {
-				return
+				return atFieldPath(err, fmt.Sprintf("[%d]", i))
 			}
 		}
 
@@ -402,7 +500,7 @@ func writeField(w io.Writer, value interface{}) (err error) {
 		enc = buf[:1]
 
 	default:
-		return ErrFieldType
+		return &FieldTypeError{Value: v}
 	}
 
 	_, err = w.Write(enc)
@@ -410,6 +508,23 @@ func writeField(w io.Writer, value interface{}) (err error) {
 	return
 }
 
+// atFieldPath prefixes the Path of a *FieldTypeError with the key or index at
+// which the value failing to encode is nested.
+func atFieldPath(err error, at string) error {
+	var fieldErr *FieldTypeError
+	if errors.As(err, &fieldErr) {
+		switch {
+		case fieldErr.Path == "":
+			fieldErr.Path = at
+		case fieldErr.Path[0] == '[':
+			fieldErr.Path = at + fieldErr.Path
+		default:
+			fieldErr.Path = at + "." + fieldErr.Path
+		}
+	}
+	return err
+}
+
 // writeTable serializes a Table to the given writer.
 // It writes each key-value pair and returns the serialized data as a longstr.
 func writeTable(w io.Writer, table Table) (err error) {
@@ -420,7 +535,7 @@ func writeTable(w io.Writer, table Table) (err error) {
 			return fmt.Errorf("writing key %q: %w", key, err)
 		}
 		if err = writeField(&buf, val); err != nil {
-			return fmt.Errorf("writing value for key %q: %w", key, err)
+			return fmt.Errorf("writing value for key %q: %w", key, atFieldPath(err, key))
 		}
 	}
 
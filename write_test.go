@@ -0,0 +1,191 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestContentBuffers(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), inlineBodySize)
+
+	frames := []frame{
+		&methodFrame{ChannelId: 1, Method: &basicPublish{Exchange: "e", RoutingKey: "k"}},
+		&headerFrame{ChannelId: 1, ClassId: 60, Size: uint64(2*len(large) + 5)},
+		&bodyFrame{ChannelId: 1, Body: large},
+		&bodyFrame{ChannelId: 1, Body: large},
+		&bodyFrame{ChannelId: 1, Body: []byte("small")},
+	}
+
+	var scratch bytes.Buffer
+	bufs, err := contentBuffers(&scratch, frames)
+	if err != nil {
+		t.Fatalf("could not encode frames: %v", err)
+	}
+
+	// The large bodies are written from their own memory, the rest from
+	// scratch.
+	if want, got := 5, len(bufs); want != got {
+		t.Fatalf("expected %d buffers, got %d", want, got)
+	}
+	if &bufs[1][0] != &large[0] || &bufs[3][0] != &large[0] {
+		t.Errorf("expected the large bodies not to be copied")
+	}
+
+	var wire, want bytes.Buffer
+	if _, err := bufs.WriteTo(&wire); err != nil {
+		t.Fatalf("could not write buffers: %v", err)
+	}
+	for _, f := range frames {
+		if err := f.write(&want); err != nil {
+			t.Fatalf("could not write frame: %v", err)
+		}
+	}
+	if !bytes.Equal(want.Bytes(), wire.Bytes()) {
+		t.Errorf("expected the buffers to hold the frames written one by one")
+	}
+}
+
+func TestPublishVectoredOverTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("tcp loopback not supported: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	conn, ok := <-accepted
+	if !ok {
+		t.Fatalf("could not accept")
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	srv := newServer(t, conn, client)
+
+	// Larger than the frame size negotiated by the server.
+	body := bytes.Repeat([]byte("0123456789"), 5000)
+	published := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		srv.connectionClose()
+	}()
+
+	config := defaultConfig()
+	config.EnableStats = true
+
+	c, err := Open(client, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	if !c.vectored {
+		t.Errorf("expected content to be written with writev on a *net.TCPConn")
+	}
+
+	before := c.Stats().BytesWritten
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: body}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	if got := <-published; !bytes.Equal(body, got.Body) {
+		t.Errorf("expected a body of %d bytes, got %d bytes", len(body), len(got.Body))
+	}
+	if written := c.Stats().BytesWritten - before; written < uint64(len(body)) {
+		t.Errorf("expected the stats to count the %d bytes of the body, got %d", len(body), written)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}
+
+// writeCounter counts the writes to the transport.
+type writeCounter struct {
+	io.ReadWriteCloser
+	writes atomic.Int32
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.writes.Add(1)
+	return w.ReadWriteCloser.Write(p)
+}
+
+func TestPublishBufferedWithoutWritev(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	// Written from its own memory on a transport with writev.
+	body := bytes.Repeat([]byte("x"), 2*inlineBodySize)
+	published := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		srv.connectionClose()
+	}()
+
+	conn := &writeCounter{ReadWriteCloser: rwc}
+	c, err := Open(conn, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v", err)
+	}
+	if c.vectored {
+		t.Errorf("expected a transport without writev, such as a *tls.Conn, not to be written vectored")
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	before := conn.writes.Load()
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: body}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if want, got := int32(1), conn.writes.Load()-before; want != got {
+		t.Errorf("expected the frames of the publishing to be flushed with %d write, got %d", want, got)
+	}
+
+	if got := <-published; !bytes.Equal(body, got.Body) {
+		t.Errorf("expected a body of %d bytes, got %d bytes", len(body), len(got.Body))
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("connection close error: %v", err)
+	}
+}